@@ -0,0 +1,518 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// areaTypes are the configuration types huesync creates areas with, in the
+// order the TUI offers them.
+var areaTypes = []string{"screen", "music", "3dspace"}
+
+// maxAreaNameLength is the longest area name the bridge accepts, in
+// characters.
+const maxAreaNameLength = 32
+
+// describeAreaType explains a configuration type for the TUI.
+func describeAreaType(t string) string {
+	switch t {
+	case "screen":
+		return "Screen: lights around a TV or monitor"
+	case "music":
+		return "Music: lights spread around the room"
+	case "3dspace":
+		return "3D space: lights around you at different heights"
+	}
+	return t
+}
+
+// EntertainmentLight is a light that can take part in entertainment areas.
+type EntertainmentLight struct {
+	// ServiceID is the light's entertainment service, which areas refer to.
+	ServiceID string
+	LightID   string
+	Name      string
+}
+
+// AreaLight places a light in an entertainment area.
+type AreaLight struct {
+	ServiceID string
+	Position  Position
+}
+
+// AreaSpec describes an entertainment area to create, or the changes to one.
+// When updating, empty fields are left unchanged.
+type AreaSpec struct {
+	Name   string
+	Type   string
+	Lights []AreaLight
+}
+
+// FetchEntertainmentLights returns the lights that can take part in
+// entertainment areas, sorted by name.
+func FetchEntertainmentLights(ip net.IP, username string) ([]EntertainmentLight, error) {
+	var services resourceResponse[entertainmentServiceData]
+	if err := getResource(ip, username, "/clip/v2/resource/entertainment", &services); err != nil {
+		return nil, fmt.Errorf("fetching entertainment services: %w", err)
+	}
+	var lights resourceResponse[lightData]
+	if err := getResource(ip, username, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, fmt.Errorf("fetching lights: %w", err)
+	}
+	return entertainmentLights(services.Data, lights.Data), nil
+}
+
+func entertainmentLights(services []entertainmentServiceData, lights []lightData) []EntertainmentLight {
+	names := make(map[string]string, len(lights))
+	for _, l := range lights {
+		names[l.ID] = l.Metadata.Name
+	}
+	var out []EntertainmentLight
+	for _, svc := range services {
+		ref := svc.RendererReference
+		if !svc.Renderer || ref == nil || ref.RType != "light" {
+			continue
+		}
+		name := names[ref.RID]
+		if name == "" {
+			name = ref.RID
+		}
+		out = append(out, EntertainmentLight{ServiceID: svc.ID, LightID: ref.RID, Name: name})
+	}
+	slices.SortStableFunc(out, func(a, b EntertainmentLight) int { return cmp.Compare(a.Name, b.Name) })
+	return out
+}
+
+// CreateArea creates an entertainment area and returns its ID.
+func CreateArea(ip net.IP, username string, spec AreaSpec) (string, error) {
+	if err := validateAreaSpec(spec, true); err != nil {
+		return "", err
+	}
+	req := newAreaRequest(spec)
+	req.Type = "entertainment_configuration"
+	refs, err := postResource(ip, username, "/clip/v2/resource/entertainment_configuration", req)
+	if err != nil {
+		return "", fmt.Errorf("creating area %s: %w", spec.Name, err)
+	}
+	if len(refs) == 0 {
+		return "", fmt.Errorf("creating area %s: no area in the response", spec.Name)
+	}
+	return refs[0].RID, nil
+}
+
+// UpdateArea renames the area, changes its type or replaces its lights, as
+// far as spec sets them.
+func UpdateArea(ip net.IP, username, id string, spec AreaSpec) error {
+	if err := validateAreaSpec(spec, false); err != nil {
+		return err
+	}
+	if err := putResource(ip, username, "/clip/v2/resource/entertainment_configuration/"+id, newAreaRequest(spec)); err != nil {
+		return fmt.Errorf("updating area: %w", err)
+	}
+	return nil
+}
+
+// DeleteArea deletes the area.
+func DeleteArea(ip net.IP, username, id string) error {
+	if err := deleteResource(ip, username, "/clip/v2/resource/entertainment_configuration/"+id); err != nil {
+		return fmt.Errorf("deleting area: %w", err)
+	}
+	return nil
+}
+
+// validateAreaSpec checks spec before it is sent. A new area needs all
+// fields.
+func validateAreaSpec(spec AreaSpec, create bool) error {
+	if utf8.RuneCountInString(spec.Name) > maxAreaNameLength {
+		return fmt.Errorf("area name %q is longer than %d characters", spec.Name, maxAreaNameLength)
+	}
+	if spec.Type != "" && !slices.Contains(areaTypes, spec.Type) {
+		return fmt.Errorf("unknown area type %q (want %s)", spec.Type, strings.Join(areaTypes, ", "))
+	}
+	if create {
+		switch {
+		case strings.TrimSpace(spec.Name) == "":
+			return errors.New("the area needs a name")
+		case spec.Type == "":
+			return errors.New("the area needs a type")
+		case len(spec.Lights) == 0:
+			return errors.New("the area needs at least one light")
+		}
+	}
+	return nil
+}
+
+func newAreaRequest(spec AreaSpec) areaRequest {
+	var req areaRequest
+	if spec.Name != "" {
+		req.Metadata = &entertainmentMeta{Name: spec.Name}
+	}
+	req.ConfigurationType = spec.Type
+	if len(spec.Lights) > 0 {
+		req.Locations = &areaLocations{}
+		for _, l := range spec.Lights {
+			req.Locations.ServiceLocations = append(req.Locations.ServiceLocations, serviceLocation{
+				Service:   resourceRef{RID: l.ServiceID, RType: "entertainment"},
+				Positions: []positionData{{X: l.Position.X, Y: l.Position.Y, Z: l.Position.Z}},
+			})
+		}
+	}
+	return req
+}
+
+// areaLights returns the lights of area with their positions. A light
+// rendering several channels, like a gradient strip, is placed at its first
+// channel.
+func areaLights(area EntertainmentArea) []AreaLight {
+	var out []AreaLight
+	seen := make(map[string]bool)
+	for _, ch := range area.Channels {
+		for _, id := range ch.ServiceIDs {
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, AreaLight{ServiceID: id, Position: ch.Position})
+			}
+		}
+	}
+	return out
+}
+
+// Areas are edited on a square grid of areaGridSize cells per side,
+// covering -1 to 1 on both axes. A screen area is seen from the front, with
+// x across and z (height) up; music and 3D space areas are seen from above,
+// with x across and y, towards the front, up.
+const areaGridSize = 5
+
+// planePosition returns the position of p on the editing plane of an area of
+// type t.
+func planePosition(t string, p Position) (h, v float64) {
+	if t == "screen" {
+		return p.X, p.Z
+	}
+	return p.X, p.Y
+}
+
+// withPlanePosition moves p to (h, v) on the editing plane of an area of
+// type t, keeping the third coordinate.
+func withPlanePosition(t string, p Position, h, v float64) Position {
+	p.X = h
+	if t == "screen" {
+		p.Z = v
+	} else {
+		p.Y = v
+	}
+	return p
+}
+
+// gridCell returns the grid cell nearest to p, with row 0 at the top.
+func gridCell(t string, p Position) (col, row int) {
+	h, v := planePosition(t, p)
+	half := float64(areaGridSize-1) / 2
+	col = int(math.Round((h + 1) * half))
+	row = int(math.Round((1 - v) * half))
+	return min(max(col, 0), areaGridSize-1), min(max(row, 0), areaGridSize-1)
+}
+
+// cellPosition moves p to the center of a grid cell.
+func cellPosition(t string, p Position, col, row int) Position {
+	half := float64(areaGridSize-1) / 2
+	return withPlanePosition(t, p, float64(col)/half-1, 1-float64(row)/half)
+}
+
+// defaultPositions spreads n lights from left to right across the middle of
+// the editing plane.
+func defaultPositions(n int) []Position {
+	positions := make([]Position, n)
+	for i := range positions {
+		if n > 1 {
+			positions[i].X = -1 + 2*float64(i)/float64(n-1)
+		}
+	}
+	return positions
+}
+
+// runArea implements `huesync area`: it lists, creates, edits, renames and
+// deletes entertainment areas.
+func runArea(args []string, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "", 0)
+	usage := "usage: huesync area list | create [flags] <name> | edit [flags] <area> | rename [flags] <area> <name> | delete [flags] <area>"
+	if len(args) == 0 {
+		logger.Print(usage)
+		return exitUsage
+	}
+	command := args[0]
+
+	var typ string
+	var lightSpecs []string
+	var newName string
+	extra := func(fs *flag.FlagSet) {
+		if command != "create" && command != "edit" {
+			return
+		}
+		// The arguments are parsed once per configuration layer.
+		lightSpecs = nil
+		fs.Func("type", "area type: "+strings.Join(areaTypes, ", ")+` (default "screen" for new areas)`, func(s string) error {
+			if !slices.Contains(areaTypes, s) {
+				return fmt.Errorf("unknown area type %q", s)
+			}
+			typ = s
+			return nil
+		})
+		fs.Func("light", "light to include, by name or ID, optionally placed at H,V on the editing grid from -1 to 1, e.g. \"Play left=-1,0\"; repeat for more lights (default: all lights)", func(s string) error {
+			lightSpecs = append(lightSpecs, s)
+			return nil
+		})
+		if command == "edit" {
+			fs.StringVar(&newName, "name", "", "new name of the area")
+		}
+	}
+
+	var want int
+	switch command {
+	case "list":
+		want = 0
+	case "create", "edit", "delete":
+		want = 1
+	case "rename":
+		want = 2
+	default:
+		logger.Print(usage)
+		return exitUsage
+	}
+	parser, opts, err := newOptionParser("huesync area "+command, args[1:], stderr, extra)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		var ue *usageError
+		if !errors.As(err, &ue) {
+			logger.Printf("error: %v", err)
+		}
+		return exitUsage
+	}
+	rest := parser.Args()
+	if len(rest) != want {
+		logger.Print(usage)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bridge, creds, err := resolveBridge(ctx, log.New(io.Discard, "", 0), opts)
+	if err == nil {
+		cmd := areaCommand{stdout: stdout, ip: bridge.IP, username: creds.Username}
+		switch command {
+		case "list":
+			err = cmd.list()
+		case "create":
+			err = cmd.create(rest[0], cmp.Or(typ, "screen"), lightSpecs)
+		case "edit":
+			err = cmd.edit(rest[0], AreaSpec{Name: newName, Type: typ}, lightSpecs)
+		case "rename":
+			err = cmd.edit(rest[0], AreaSpec{Name: rest[1]}, nil)
+		case "delete":
+			err = cmd.delete(rest[0])
+		}
+	}
+	if err != nil {
+		logger.Printf("error: %v", err)
+	}
+	return exitCode(err)
+}
+
+// areaCommand runs the subcommands of `huesync area` against one bridge.
+type areaCommand struct {
+	stdout   io.Writer
+	ip       net.IP
+	username string
+}
+
+func (c areaCommand) list() error {
+	areas, err := c.fetchAreas()
+	if err != nil {
+		return err
+	}
+	lights, err := FetchEntertainmentLights(c.ip, c.username)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(lights))
+	for _, l := range lights {
+		names[l.ServiceID] = l.Name
+	}
+	for _, a := range areas {
+		fmt.Fprintf(c.stdout, "%s (%s, %s) %s\n", a.Name, a.Type, a.Status, a.ID)
+		for _, ch := range a.Channels {
+			var members []string
+			for _, id := range ch.ServiceIDs {
+				members = append(members, cmp.Or(names[id], id))
+			}
+			p := ch.Position
+			fmt.Fprintf(c.stdout, "  channel %d at %+.2f, %+.2f, %+.2f: %s\n", ch.ID, p.X, p.Y, p.Z, strings.Join(members, ", "))
+		}
+	}
+	return nil
+}
+
+func (c areaCommand) create(name, typ string, lightSpecs []string) error {
+	lights, err := c.placeLights(typ, lightSpecs)
+	if err != nil {
+		return err
+	}
+	id, err := CreateArea(c.ip, c.username, AreaSpec{Name: name, Type: typ, Lights: lights})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "created %s with %d lights: %s\n", name, len(lights), id)
+	return nil
+}
+
+func (c areaCommand) edit(query string, spec AreaSpec, lightSpecs []string) error {
+	areas, err := c.fetchAreas()
+	if err != nil {
+		return err
+	}
+	area, err := findArea(areas, query)
+	if err != nil {
+		return err
+	}
+	if len(lightSpecs) > 0 {
+		spec.Lights, err = c.placeLights(cmp.Or(spec.Type, area.Type), lightSpecs)
+		if err != nil {
+			return err
+		}
+	}
+	if spec.Name == "" && spec.Type == "" && len(spec.Lights) == 0 {
+		return cliErrorf(exitUsage, "nothing to change; give --name, --type or --light")
+	}
+	if err := UpdateArea(c.ip, c.username, area.ID, spec); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "updated %s\n", cmp.Or(spec.Name, area.Name))
+	return nil
+}
+
+func (c areaCommand) delete(query string) error {
+	areas, err := c.fetchAreas()
+	if err != nil {
+		return err
+	}
+	area, err := findArea(areas, query)
+	if err != nil {
+		return err
+	}
+	if err := DeleteArea(c.ip, c.username, area.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "deleted %s\n", area.Name)
+	return nil
+}
+
+func (c areaCommand) fetchAreas() ([]EntertainmentArea, error) {
+	areas, err := FetchEntertainmentAreas(c.ip, c.username)
+	if errors.Is(err, ErrUnauthorized) {
+		return nil, cliErrorf(exitUnpaired, "the bridge rejected the stored credentials; run huesync without arguments to pair again")
+	}
+	return areas, certificateHint(err)
+}
+
+// placeLights resolves the --light flags of an area of type t, or takes all
+// lights when there are none. Lights without a position are spread from left
+// to right.
+func (c areaCommand) placeLights(t string, specs []string) ([]AreaLight, error) {
+	lights, err := FetchEntertainmentLights(c.ip, c.username)
+	if err != nil {
+		return nil, err
+	}
+	if len(lights) == 0 {
+		return nil, errors.New("no lights on this bridge can take part in entertainment areas")
+	}
+	if len(specs) == 0 {
+		for _, l := range lights {
+			specs = append(specs, l.ServiceID)
+		}
+	}
+
+	positions := defaultPositions(len(specs))
+	placed := make([]AreaLight, len(specs))
+	for i, spec := range specs {
+		query, at, hasPos := spec, "", false
+		if j := strings.LastIndex(spec, "="); j >= 0 {
+			query, at, hasPos = spec[:j], spec[j+1:], true
+		}
+		light, err := findLight(lights, query)
+		if err != nil {
+			return nil, err
+		}
+		p := positions[i]
+		if hasPos {
+			h, v, err := parsePlanePosition(at)
+			if err != nil {
+				return nil, cliErrorf(exitUsage, "light %s: %w", query, err)
+			}
+			p = withPlanePosition(t, Position{}, h, v)
+		}
+		placed[i] = AreaLight{ServiceID: light.ServiceID, Position: p}
+	}
+	return placed, nil
+}
+
+// findLight returns the light whose name (case-insensitive), light ID or
+// service ID matches query.
+func findLight(lights []EntertainmentLight, query string) (EntertainmentLight, error) {
+	for _, l := range lights {
+		if strings.EqualFold(l.Name, query) || l.LightID == query || l.ServiceID == query {
+			return l, nil
+		}
+	}
+	return EntertainmentLight{}, cliErrorf(exitUsage, "light %q not found or not usable for entertainment", query)
+}
+
+// parsePlanePosition parses "H,V" with both in -1..1.
+func parsePlanePosition(s string) (h, v float64, err error) {
+	hs, vs, ok := strings.Cut(s, ",")
+	if ok {
+		h, err = strconv.ParseFloat(strings.TrimSpace(hs), 64)
+	}
+	if ok && err == nil {
+		v, err = strconv.ParseFloat(strings.TrimSpace(vs), 64)
+	}
+	if !ok || err != nil || math.Abs(h) > 1 || math.Abs(v) > 1 {
+		return 0, 0, fmt.Errorf("invalid position %q: want H,V from -1 to 1", s)
+	}
+	return h, v, nil
+}
+
+// JSON mapping structs
+
+// areaRequest is the body that creates or changes an entertainment_configuration.
+type areaRequest struct {
+	Type              string             `json:"type,omitempty"`
+	Metadata          *entertainmentMeta `json:"metadata,omitempty"`
+	ConfigurationType string             `json:"configuration_type,omitempty"`
+	Locations         *areaLocations     `json:"locations,omitempty"`
+}
+
+type areaLocations struct {
+	ServiceLocations []serviceLocation `json:"service_locations"`
+}
+
+type serviceLocation struct {
+	Service   resourceRef    `json:"service"`
+	Positions []positionData `json:"positions"`
+}
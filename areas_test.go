@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGridCell_RoundTrip(t *testing.T) {
+	for _, typ := range areaTypes {
+		for col := range areaGridSize {
+			for row := range areaGridSize {
+				p := cellPosition(typ, Position{X: 0.3, Y: 0.3, Z: 0.3}, col, row)
+				if c, r := gridCell(typ, p); c != col || r != row {
+					t.Errorf("%s: cell %d,%d came back as %d,%d", typ, col, row, c, r)
+				}
+			}
+		}
+	}
+
+	// The top row is high up on a screen and at the front of a room; the
+	// third coordinate is kept.
+	if p := cellPosition("screen", Position{Y: 0.8}, 0, 0); p != (Position{X: -1, Y: 0.8, Z: 1}) {
+		t.Errorf("screen: unexpected position %+v", p)
+	}
+	if p := cellPosition("music", Position{Z: 0.5}, 4, 0); p != (Position{X: 1, Y: 1, Z: 0.5}) {
+		t.Errorf("music: unexpected position %+v", p)
+	}
+}
+
+func TestParsePlanePosition(t *testing.T) {
+	if h, v, err := parsePlanePosition("-1, 0.5"); err != nil || h != -1 || v != 0.5 {
+		t.Errorf("got %g, %g, %v", h, v, err)
+	}
+	for _, s := range []string{"", "1", "a,b", "1.5,0", "0,-2"} {
+		if _, _, err := parsePlanePosition(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestEntertainmentLights(t *testing.T) {
+	services := []entertainmentServiceData{
+		{ID: "svc-b", Renderer: true, RendererReference: &resourceRef{RID: "light-b", RType: "light"}},
+		{ID: "svc-a", Renderer: true, RendererReference: &resourceRef{RID: "light-a", RType: "light"}},
+		{ID: "svc-proxy", Renderer: false, RendererReference: &resourceRef{RID: "light-c", RType: "light"}},
+		{ID: "svc-device", Renderer: true, RendererReference: &resourceRef{RID: "device", RType: "device"}},
+	}
+	lights := []lightData{
+		{ID: "light-a", Metadata: lightMeta{Name: "Alpha"}},
+		{ID: "light-b", Metadata: lightMeta{Name: "Beta"}},
+	}
+	got := entertainmentLights(services, lights)
+	want := []EntertainmentLight{
+		{ServiceID: "svc-a", LightID: "light-a", Name: "Alpha"},
+		{ServiceID: "svc-b", LightID: "light-b", Name: "Beta"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestAreas_MockBridge(t *testing.T) {
+	b := startMockBridge(t, 3)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+
+	lights, err := FetchEntertainmentLights(ip, "user")
+	if err != nil || len(lights) != 3 {
+		t.Fatalf("FetchEntertainmentLights: %v, %v", lights, err)
+	}
+	if _, err := CreateArea(ip, "user", AreaSpec{Name: "Desk", Type: "music"}); err == nil {
+		t.Error("expected an area without lights to be refused")
+	}
+
+	id, err := CreateArea(ip, "user", AreaSpec{Name: "Desk", Type: "music", Lights: []AreaLight{
+		{ServiceID: lights[0].ServiceID, Position: Position{X: -1, Y: 1}},
+		{ServiceID: lights[2].ServiceID, Position: Position{X: 1, Y: -1}},
+	}})
+	if err != nil {
+		t.Fatalf("CreateArea: %v", err)
+	}
+	area := findTestArea(t, ip, id)
+	if area.Name != "Desk" || area.Type != "music" || len(area.Channels) != 2 || len(area.LightIDs) != 2 {
+		t.Fatalf("unexpected area %+v", area)
+	}
+	if area.Channels[1].Position != (Position{X: 1, Y: -1}) || area.LightIDs[1] != lights[2].LightID {
+		t.Errorf("unexpected second channel %+v of lights %v", area.Channels[1], area.LightIDs)
+	}
+
+	// Renaming leaves the lights alone.
+	if err := UpdateArea(ip, "user", id, AreaSpec{Name: "Office"}); err != nil {
+		t.Fatalf("UpdateArea: %v", err)
+	}
+	if area := findTestArea(t, ip, id); area.Name != "Office" || len(area.Channels) != 2 {
+		t.Errorf("unexpected renamed area %+v", area)
+	}
+	if err := UpdateArea(ip, "user", id, AreaSpec{Type: "3dspace", Lights: []AreaLight{{ServiceID: lights[1].ServiceID}}}); err != nil {
+		t.Fatalf("UpdateArea: %v", err)
+	}
+	if area := findTestArea(t, ip, id); area.Type != "3dspace" || len(area.LightIDs) != 1 || area.LightIDs[0] != lights[1].LightID {
+		t.Errorf("unexpected edited area %+v", area)
+	}
+	err = UpdateArea(ip, "user", id, AreaSpec{Lights: []AreaLight{{ServiceID: "missing"}}})
+	if err == nil || !strings.Contains(err.Error(), "invalid service") {
+		t.Errorf("expected the bridge's error, got %v", err)
+	}
+
+	if err := DeleteArea(ip, "user", id); err != nil {
+		t.Fatalf("DeleteArea: %v", err)
+	}
+	if areas, _ := FetchEntertainmentAreas(ip, "user"); len(areas) != 1 {
+		t.Errorf("expected only the mock's own area to be left, got %+v", areas)
+	}
+	if err := DeleteArea(ip, "user", id); err == nil {
+		t.Error("expected deleting a missing area to fail")
+	}
+}
+
+func findTestArea(t *testing.T, ip net.IP, id string) EntertainmentArea {
+	t.Helper()
+	areas, err := FetchEntertainmentAreas(ip, "user")
+	if err != nil {
+		t.Fatalf("FetchEntertainmentAreas: %v", err)
+	}
+	area, err := findArea(areas, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return area
+}
+
+func TestValidateAreaSpec_NameLength(t *testing.T) {
+	spec := AreaSpec{Name: strings.Repeat("ü", maxAreaNameLength)}
+	if err := validateAreaSpec(spec, false); err != nil {
+		t.Errorf("expected %d two-byte characters to fit, got %v", maxAreaNameLength, err)
+	}
+	spec.Name += "ü"
+	if err := validateAreaSpec(spec, false); err == nil {
+		t.Error("expected a name one character too long to be refused")
+	}
+}
+
+func TestRunArea(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	b := startMockBridge(t, 2)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(b.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	run := func(args ...string) (int, string) {
+		var stdout bytes.Buffer
+		code := runArea(args, &stdout, io.Discard)
+		return code, stdout.String()
+	}
+
+	for _, args := range [][]string{{}, {"move"}, {"create"}, {"rename", "Mock TV"}, {"list", "extra"}, {"create", "--type", "monitor", "X"}} {
+		if code, _ := run(args...); code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+	if code, _ := run("create", "--light", "Nonexistent", "Bar"); code != exitUsage {
+		t.Errorf("expected exit code %d for an unknown light, got %d", exitUsage, code)
+	}
+
+	if code, out := run("create", "--type", "screen", "--light", "mock light 2=-1,1", "--light", "Mock light 1", "Bar"); code != exitOK || !strings.Contains(out, "created Bar with 2 lights") {
+		t.Fatalf("create: exit code %d, output %q", code, out)
+	}
+	area := findTestArea(t, net.IPv4(127, 0, 0, 1), "Bar")
+	lights := b.Lights()
+	if area.Type != "screen" || area.LightIDs[0] != lights[1].ID || area.Channels[0].Position != (Position{X: -1, Z: 1}) {
+		t.Errorf("unexpected area %+v", area)
+	}
+
+	if code, _ := run("edit", "Bar"); code != exitUsage {
+		t.Errorf("expected exit code %d without changes, got %d", exitUsage, code)
+	}
+	if code, _ := run("rename", "bar", "Kitchen"); code != exitOK {
+		t.Errorf("rename: exit code %d", code)
+	}
+	code, out := run("list")
+	if code != exitOK || !strings.Contains(out, "Kitchen (screen, inactive)") || !strings.Contains(out, "Mock TV (screen, inactive)") {
+		t.Errorf("list: exit code %d, output %q", code, out)
+	}
+	if !strings.Contains(out, "channel 0 at -1.00, +0.00, +1.00: Mock light 2") {
+		t.Errorf("expected the channels in the list, got %q", out)
+	}
+
+	if code, _ := run("delete", "Kitchen"); code != exitOK {
+		t.Errorf("delete: exit code %d", code)
+	}
+	if code, _ := run("delete", "Kitchen"); code != exitNoArea {
+		t.Errorf("expected exit code %d for a deleted area, got %d", exitNoArea, code)
+	}
+}
+
+func TestRunArea_BridgeIP(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	b := startMockBridge(t, 1)
+	hideBridges(t)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(b.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runArea(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, _, stderr := run("list"); code != exitNoBridge || !strings.Contains(stderr, "--bridge-ip") {
+		t.Errorf("expected exit code %d with a hint, got %d, %q", exitNoBridge, code, stderr)
+	}
+	if code, out, _ := run("list", "--bridge-ip", "127.0.0.1"); code != exitOK || !strings.Contains(out, "Mock TV") {
+		t.Errorf("list: exit code %d, output %q", code, out)
+	}
+	if code, _, stderr := run("list", "--bridge-ip", "127.0.0.1", "--bridge", "001788fffe000000"); code != exitNoBridge || !strings.Contains(stderr, "not 001788fffe000000") {
+		t.Errorf("expected exit code %d for another bridge, got %d, %q", exitNoBridge, code, stderr)
+	}
+}
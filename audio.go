@@ -0,0 +1,276 @@
+package main
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+	"time"
+)
+
+const (
+	// audioRate is the sample rate audio is captured at.
+	audioRate = 44100
+	// audioWindow is the number of samples analyzed at once, about 23 ms
+	// at audioRate. It must be a power of two.
+	audioWindow = 1024
+)
+
+// Frequency bands in Hz.
+var audioBandLimits = [3][2]float64{
+	{20, 250},     // bass
+	{250, 4000},   // mid
+	{4000, 16000}, // treble
+}
+
+const (
+	// audioFloor is the band amplitude below which a band counts as silent,
+	// about -60 dBFS.
+	audioFloor = 1e-3
+	// audioPeakDecay is the time constant with which the loudness reference
+	// of each band follows the music down after a loud passage.
+	audioPeakDecay = 3 * time.Second
+	// beatAverage is the time constant of the running bass average beats
+	// are compared with.
+	beatAverage = time.Second
+	// beatRefractory is the shortest time between two beats, limiting
+	// detection to 240 bpm.
+	beatRefractory = 250 * time.Millisecond
+)
+
+// audioLevels is the analysis of one audio window.
+type audioLevels struct {
+	// Bass, Mid and Treble are the band energies from 0 to 1, relative to
+	// the recent loudness of each band.
+	Bass, Mid, Treble float64
+	// Beat is true when the window starts a beat.
+	Beat bool
+}
+
+// audioAnalyzer turns successive windows of audio into band levels and
+// beats. Levels adapt to the volume: each band is measured against its own
+// recent peak, so quiet and loud music both use the full range.
+type audioAnalyzer struct {
+	rate int
+	// sensitivity above 1 raises quiet levels and detects weaker beats;
+	// below 1 it does the opposite.
+	sensitivity float64
+
+	hann      []float64
+	peak      [3]float64
+	bassMean  float64
+	sinceBeat time.Duration
+}
+
+func newAudioAnalyzer(rate int, sensitivity float64) *audioAnalyzer {
+	hann := make([]float64, audioWindow)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(audioWindow-1))
+	}
+	return &audioAnalyzer{rate: rate, sensitivity: sensitivity, hann: hann, sinceBeat: beatRefractory}
+}
+
+// Analyze analyzes the latest audioWindow samples, dt after the previous
+// call. Shorter windows are padded with silence.
+func (a *audioAnalyzer) Analyze(samples []float32, dt time.Duration) audioLevels {
+	if len(samples) > audioWindow {
+		samples = samples[len(samples)-audioWindow:]
+	}
+	x := make([]complex128, audioWindow)
+	for i, s := range samples {
+		x[i] = complex(float64(s)*a.hann[i], 0)
+	}
+	fft(x)
+
+	var bands [3]float64
+	for b, limits := range audioBandLimits {
+		bands[b] = a.bandAmplitude(x, limits[0], limits[1])
+	}
+
+	decay := math.Exp(-dt.Seconds() / audioPeakDecay.Seconds())
+	var levels [3]float64
+	for b, e := range bands {
+		a.peak[b] = max(e, a.peak[b]*decay, audioFloor)
+		if e > audioFloor {
+			levels[b] = math.Pow(e/a.peak[b], 1/a.sensitivity)
+		}
+	}
+
+	a.sinceBeat += dt
+	bass := bands[0]
+	beat := false
+	if a.bassMean == 0 {
+		a.bassMean = bass
+	} else if bass > audioFloor && bass > a.bassMean*(1+0.5/a.sensitivity) && a.sinceBeat >= beatRefractory {
+		beat = true
+		a.sinceBeat = 0
+	}
+	a.bassMean += (1 - math.Exp(-dt.Seconds()/beatAverage.Seconds())) * (bass - a.bassMean)
+
+	return audioLevels{Bass: levels[0], Mid: levels[1], Treble: levels[2], Beat: beat}
+}
+
+// bandAmplitude returns the amplitude of the spectrum x between lo and hi
+// Hz, scaled so that a full-scale sine in the band measures about 1.
+func (a *audioAnalyzer) bandAmplitude(x []complex128, lo, hi float64) float64 {
+	binHz := float64(a.rate) / float64(len(x))
+	first := max(int(math.Ceil(lo/binHz)), 1)
+	last := min(int(hi/binHz), len(x)/2-1)
+	var power float64
+	for k := first; k <= last; k++ {
+		m := cmplx.Abs(x[k])
+		power += m * m
+	}
+	// The Hann window halves the amplitude; the FFT of a sine splits it
+	// between the positive and the negative frequency.
+	return 4 * math.Sqrt(power) / float64(len(x))
+}
+
+// fft computes the discrete Fourier transform of x in place. len(x) must be
+// a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
+
+const (
+	// audioRelease is the time constant with which band levels fall, so
+	// the lights pulse instead of flickering at the analysis rate.
+	audioRelease = 250 * time.Millisecond
+	// beatFlash is the time constant with which the flash of a beat fades.
+	beatFlash = 150 * time.Millisecond
+	// beatHueStep is the hue shift per beat, as a fraction of the circle.
+	beatHueStep = 0.13
+	// audioHueDrift is the slow hue drift per second between beats.
+	audioHueDrift = 0.01
+	// audioMinBrightness keeps the lights glowing during quiet passages.
+	audioMinBrightness = 0.1
+)
+
+// audioVisualizer maps audio levels to channel colors. Channels on the left
+// of the area follow the bass, those in the middle the mids and those on the
+// right the treble; the band energy sets the brightness. Hues spread across
+// the area and shift with every beat, and beats flash all channels.
+type audioVisualizer struct {
+	channels []Channel
+	hue      float64
+	flash    float64
+	levels   [3]float64
+}
+
+// Colors returns the channel colors for the given levels, dt after the
+// previous call. If screen is not nil, the hue of each channel is mixed with
+// its screen color, blend being the screen's share from 0 to 1.
+func (v *audioVisualizer) Colors(l audioLevels, dt time.Duration, screen []RGB, blend float64) []RGB {
+	release := math.Exp(-dt.Seconds() / audioRelease.Seconds())
+	for b, level := range []float64{l.Bass, l.Mid, l.Treble} {
+		v.levels[b] = max(level, v.levels[b]*release)
+	}
+	v.flash *= math.Exp(-dt.Seconds() / beatFlash.Seconds())
+	v.hue = frac(v.hue + dt.Seconds()*audioHueDrift)
+	if l.Beat {
+		v.flash = 1
+		v.hue = frac(v.hue + beatHueStep)
+	}
+
+	out := make([]RGB, len(v.channels))
+	for i, ch := range v.channels {
+		pos := min(max((ch.Position.X+1)/2, 0), 1)
+		bass, treble := max(1-2*pos, 0), max(2*pos-1, 0)
+		mid := 1 - bass - treble
+		energy := bass*v.levels[0] + mid*v.levels[1] + treble*v.levels[2]
+
+		c := hueColor(v.hue + pos/3)
+		if i < len(screen) {
+			c = lerpRGB(c, screen[i], blend)
+		}
+		brightness := min(audioMinBrightness+(1-audioMinBrightness)*energy+0.4*v.flash, 1)
+		out[i] = scaleRGB(c, brightness)
+	}
+	return out
+}
+
+// audioSource feeds the stream engine with colors that follow the audio,
+// optionally mixed with the screen colors.
+type audioSource struct {
+	capture  audioCapture
+	analyzer *audioAnalyzer
+	visual   *audioVisualizer
+
+	// screen, if not nil, is captured every screenDelay and mixed in with
+	// a share of blend.
+	screen      colorSource
+	screenDelay time.Duration
+	blend       float64
+
+	last       time.Time
+	lastScreen time.Time
+	colors     []RGB
+	crop       image.Rectangle
+}
+
+func newAudioSource(c audioCapture, channels []Channel, sensitivity float64) *audioSource {
+	return &audioSource{
+		capture:  c,
+		analyzer: newAudioAnalyzer(c.Rate(), sensitivity),
+		visual:   &audioVisualizer{channels: channels},
+	}
+}
+
+// withScreen mixes the colors of screen, captured every delay, into the
+// audio colors with a share of blend from 0 to 1.
+func (s *audioSource) withScreen(screen colorSource, delay time.Duration, blend float64) *audioSource {
+	s.screen, s.screenDelay, s.blend = screen, delay, blend
+	return s
+}
+
+func (s *audioSource) Colors(now time.Time) ([]RGB, image.Rectangle, error) {
+	samples, err := s.capture.Window(audioWindow)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	var dt time.Duration
+	if !s.last.IsZero() {
+		dt = now.Sub(s.last)
+	}
+	s.last = now
+
+	if s.screen != nil && (s.colors == nil || now.Sub(s.lastScreen) >= s.screenDelay) {
+		colors, crop, err := s.screen.Colors(now)
+		switch {
+		case err == nil:
+			s.colors, s.crop = colors, crop
+		case s.colors == nil:
+			return nil, image.Rectangle{}, err
+		}
+		// After a failed capture the audio keeps driving the last screen
+		// colors until the next attempt.
+		s.lastScreen = now
+	}
+
+	levels := s.analyzer.Analyze(samples, dt)
+	return s.visual.Colors(levels, dt, s.colors, s.blend), s.crop, nil
+}
+
+// SnapThreshold is 0: the audio source runs at the send rate, so blending
+// only smooths over one frame.
+func (s *audioSource) SnapThreshold() float64 { return 0 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// audioCapture records system audio and keeps the latest samples.
+type audioCapture interface {
+	// Window returns the latest n samples, mixed to mono and in -1..1.
+	Window(n int) ([]float32, error)
+	// Rate returns the sample rate.
+	Rate() int
+	Close() error
+}
+
+// defaultAudioSource is the PulseAudio name of the monitor of the default
+// output, which PipeWire's PulseAudio server understands as well.
+const defaultAudioSource = "@DEFAULT_MONITOR@"
+
+// audioBackends lists the names accepted by newAudioCaptureByName.
+var audioBackends = []string{"auto", "ffmpeg", "gstreamer"}
+
+// openAudioCapture opens an audio capture backend by name. It is a variable
+// so that tests can substitute a fake capture.
+var openAudioCapture = newAudioCaptureByName
+
+// newAudioCaptureByName records the PulseAudio source with the named
+// backend, or with the first that works for "auto".
+func newAudioCaptureByName(name, source string) (audioCapture, string, error) {
+	switch name {
+	case "auto", "":
+		c, method, err := newAudioCaptureByName("ffmpeg", source)
+		if err == nil {
+			return c, method, nil
+		}
+		c, method, gstErr := newAudioCaptureByName("gstreamer", source)
+		if gstErr == nil {
+			return c, method, nil
+		}
+		return nil, "", errors.Join(err, gstErr)
+	case "ffmpeg":
+		if !hasExecutable("ffmpeg") {
+			return nil, "", fmt.Errorf("ffmpeg not found")
+		}
+		c, err := startAudioCommand("ffmpeg", ffmpegAudioArgs(source)...)
+		return c, "FFmpeg", err
+	case "gstreamer":
+		if !hasExecutable("gst-launch-1.0") {
+			return nil, "", fmt.Errorf("gst-launch-1.0 not found")
+		}
+		c, err := startAudioCommand("gst-launch-1.0", gstAudioArgs(source)...)
+		return c, "GStreamer", err
+	}
+	return nil, "", fmt.Errorf("unknown audio backend %q (want %s)", name, strings.Join(audioBackends, ", "))
+}
+
+// ffmpegAudioArgs builds the ffmpeg arguments that record source as mono
+// 32-bit float samples to stdout.
+func ffmpegAudioArgs(source string) []string {
+	return []string{
+		"-nostdin",
+		"-loglevel", "error",
+		"-f", "pulse",
+		"-fragment_size", "1024",
+		"-i", source,
+		"-ac", "1",
+		"-ar", strconv.Itoa(audioRate),
+		"-f", "f32le",
+		"pipe:1",
+	}
+}
+
+// gstAudioArgs builds the gst-launch-1.0 arguments that record source as
+// mono 32-bit float samples to stdout.
+func gstAudioArgs(source string) []string {
+	return []string{
+		"-q",
+		"pulsesrc", "device=" + source, "!",
+		"audioconvert", "!",
+		"audioresample", "!",
+		fmt.Sprintf("audio/x-raw,format=F32LE,channels=1,rate=%d", audioRate), "!",
+		"fdsink", "fd=1",
+	}
+}
+
+// audioBufferSize is the number of samples kept: one second.
+const audioBufferSize = audioRate
+
+// audioChunk is the number of samples read at once, about 12 ms.
+const audioChunk = 512
+
+// pipeAudioCapture reads mono 32-bit float samples from a recording process.
+type pipeAudioCapture struct {
+	cancel context.CancelFunc
+	cmd    *exec.Cmd
+	done   chan struct{}
+	ready  chan struct{} // closed when the first samples are available
+
+	mu     sync.Mutex
+	ring   []float32
+	pos    int
+	filled int
+}
+
+// startAudioCommand runs the recording command and waits for its first
+// samples.
+func startAudioCommand(name string, args ...string) (*pipeAudioCapture, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%s stdout pipe: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+
+	c := newPipeAudioCapture()
+	c.cancel = cancel
+	c.cmd = cmd
+	go c.readSamples(stdout)
+
+	select {
+	case <-c.ready:
+		return c, nil
+	case <-c.done:
+		cancel()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("%s exited without recording", name)
+	case <-time.After(5 * time.Second):
+		c.Close()
+		return nil, fmt.Errorf("%s: timed out waiting for audio", name)
+	}
+}
+
+func newPipeAudioCapture() *pipeAudioCapture {
+	return &pipeAudioCapture{
+		done:  make(chan struct{}),
+		ready: make(chan struct{}),
+		ring:  make([]float32, audioBufferSize),
+	}
+}
+
+func (c *pipeAudioCapture) readSamples(r io.Reader) {
+	defer close(c.done)
+	buf := make([]byte, audioChunk*4)
+	first := true
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		c.mu.Lock()
+		for i := 0; i < len(buf); i += 4 {
+			c.ring[c.pos] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+			c.pos = (c.pos + 1) % len(c.ring)
+		}
+		c.filled = min(c.filled+audioChunk, len(c.ring))
+		c.mu.Unlock()
+		if first {
+			close(c.ready)
+			first = false
+		}
+	}
+}
+
+func (c *pipeAudioCapture) Window(n int) ([]float32, error) {
+	select {
+	case <-c.done:
+		return nil, errors.New("audio recording stopped")
+	default:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.filled == 0 {
+		return nil, errors.New("no audio recorded yet")
+	}
+	n = min(n, c.filled)
+	out := make([]float32, n)
+	start := (c.pos - n + len(c.ring)) % len(c.ring)
+	for i := range out {
+		out[i] = c.ring[(start+i)%len(c.ring)]
+	}
+	return out, nil
+}
+
+func (c *pipeAudioCapture) Rate() int { return audioRate }
+
+func (c *pipeAudioCapture) Close() error {
+	c.cancel()
+	<-c.done
+	err := c.cmd.Wait()
+	// Being killed by Close is the expected way to end.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"math/cmplx"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata are generated, so the expected results follow
+// from their content:
+//
+//   - kick-120bpm.wav: 3 s of 16-bit mono PCM at 22050 Hz. A kick drum every
+//     0.5 s starting at 0, each a 60 Hz sine at 0.8 of full scale decaying
+//     with a 60 ms time constant.
+//   - treble-8khz.wav: 0.5 s of 32-bit float stereo at 22050 Hz, a steady
+//     8 kHz sine at half scale on both channels. An odd-sized LIST chunk
+//     precedes the data chunk.
+
+func loadWAV(t *testing.T, name string) ([]float32, int) {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples, rate, err := readWAV(f)
+	if err != nil {
+		t.Fatalf("readWAV(%s): %v", name, err)
+	}
+	return samples, rate
+}
+
+// analyzeAll slides the analyzer over samples in hops of dt and returns the
+// levels and the times the windows end at.
+func analyzeAll(a *audioAnalyzer, samples []float32, rate int, dt time.Duration) ([]audioLevels, []time.Duration) {
+	hop := int(dt.Seconds() * float64(rate))
+	var levels []audioLevels
+	var at []time.Duration
+	for end := hop; end <= len(samples); end += hop {
+		levels = append(levels, a.Analyze(samples[max(end-audioWindow, 0):end], dt))
+		at = append(at, time.Duration(end)*time.Second/time.Duration(rate))
+	}
+	return levels, at
+}
+
+func TestFFT_Sine(t *testing.T) {
+	x := make([]complex128, 64)
+	for i := range x {
+		x[i] = complex(math.Cos(2*math.Pi*5*float64(i)/64), 0)
+	}
+	fft(x)
+	for k, v := range x {
+		want := 0.0
+		if k == 5 || k == 59 {
+			want = 32
+		}
+		if math.Abs(cmplx.Abs(v)-want) > 1e-9 {
+			t.Errorf("bin %d: got %g, want %g", k, cmplx.Abs(v), want)
+		}
+	}
+}
+
+func TestAudioAnalyzer_KickBeats(t *testing.T) {
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	levels, at := analyzeAll(newAudioAnalyzer(rate, 1), samples, rate, 20*time.Millisecond)
+
+	var beats []time.Duration
+	for i, l := range levels {
+		if l.Beat {
+			beats = append(beats, at[i])
+		}
+	}
+	if len(beats) != 6 {
+		t.Fatalf("expected 6 beats at 120 bpm in 3 s, got %v", beats)
+	}
+	for i, b := range beats {
+		kick := time.Duration(i) * 500 * time.Millisecond
+		if b < kick || b > kick+60*time.Millisecond {
+			t.Errorf("beat %d at %v, want shortly after the kick at %v", i, b, kick)
+		}
+	}
+
+	for i, l := range levels {
+		if l.Beat && (l.Bass < 0.7 || l.Treble > 0.2) {
+			t.Errorf("at %v: expected a beat to be mostly bass, got %+v", at[i], l)
+		}
+	}
+}
+
+func TestAudioAnalyzer_Treble(t *testing.T) {
+	samples, rate := loadWAV(t, "treble-8khz.wav")
+	levels, at := analyzeAll(newAudioAnalyzer(rate, 1), samples, rate, 20*time.Millisecond)
+	for i, l := range levels {
+		// The tone starting abruptly in the first windows spreads over
+		// all bands.
+		if at[i] < audioWindow*time.Second/time.Duration(rate) {
+			continue
+		}
+		if l.Treble < 0.99 || l.Bass != 0 || l.Mid != 0 || l.Beat {
+			t.Errorf("at %v: expected treble only, got %+v", at[i], l)
+		}
+	}
+}
+
+func TestAudioAnalyzer_Silence(t *testing.T) {
+	a := newAudioAnalyzer(audioRate, 1)
+	for range 10 {
+		if l := a.Analyze(make([]float32, audioWindow), 20*time.Millisecond); l != (audioLevels{}) {
+			t.Fatalf("expected no levels in silence, got %+v", l)
+		}
+	}
+}
+
+func TestAudioAnalyzer_Sensitivity(t *testing.T) {
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	normal, _ := analyzeAll(newAudioAnalyzer(rate, 1), samples, rate, 20*time.Millisecond)
+	high, _ := analyzeAll(newAudioAnalyzer(rate, 2), samples, rate, 20*time.Millisecond)
+	// 200 ms after the first kick the bass has decayed to a low level.
+	if i := 9; high[i].Bass <= normal[i].Bass || normal[i].Bass == 0 {
+		t.Errorf("expected a higher sensitivity to raise the decayed bass, got %g and %g", normal[i].Bass, high[i].Bass)
+	}
+}
+
+func TestAudioVisualizer_Bands(t *testing.T) {
+	v := &audioVisualizer{channels: testChannels(-1, 0, 1)}
+	colors := v.Colors(audioLevels{Bass: 1}, 0, nil, 0)
+	if got := brightness(colors[0]); got != 255 {
+		t.Errorf("expected the left channel at full brightness for bass, got %v", colors[0])
+	}
+	for _, c := range colors[1:] {
+		if got := brightness(c); got > 30 {
+			t.Errorf("expected the other channels dim for bass, got %v", c)
+		}
+	}
+
+	colors = v.Colors(audioLevels{Treble: 1}, time.Second, nil, 0)
+	if brightness(colors[2]) != 255 || brightness(colors[0]) > 30 {
+		t.Errorf("expected only the right channel lit for treble, got %v", colors)
+	}
+}
+
+func TestAudioVisualizer_Beat(t *testing.T) {
+	v := &audioVisualizer{channels: testChannels(0)}
+	before := v.Colors(audioLevels{}, 0, nil, 0)[0]
+	after := v.Colors(audioLevels{Beat: true}, 0, nil, 0)[0]
+	if brightness(after) <= brightness(before) {
+		t.Errorf("expected a beat to flash, got %v then %v", before, after)
+	}
+	if v.hue != beatHueStep {
+		t.Errorf("expected a beat to shift the hue by %g, got %g", beatHueStep, v.hue)
+	}
+	faded := v.Colors(audioLevels{}, time.Second, nil, 0)[0]
+	if brightness(faded) >= brightness(after) {
+		t.Errorf("expected the flash to fade, got %v", faded)
+	}
+}
+
+func TestAudioVisualizer_Blend(t *testing.T) {
+	v := &audioVisualizer{channels: testChannels(-1, 1)}
+	screen := []RGB{{B: 255}, {B: 255}}
+	for i, c := range v.Colors(audioLevels{Bass: 1, Treble: 1}, 0, screen, 1) {
+		if c.R != 0 || c.G != 0 || c.B != 255 {
+			t.Errorf("channel %d: expected the screen color at full blend, got %v", i, c)
+		}
+	}
+	for i, c := range v.Colors(audioLevels{Bass: 1, Treble: 1}, 0, screen, 0.5) {
+		if c.B == 0 || c.B == 255 {
+			t.Errorf("channel %d: expected a mix at half blend, got %v", i, c)
+		}
+	}
+}
+
+func brightness(c RGB) uint8 {
+	return max(c.R, c.G, c.B)
+}
+
+// fakeAudioCapture plays samples in a loop, advancing by hop samples with
+// every window.
+type fakeAudioCapture struct {
+	samples []float32
+	rate    int
+	hop     int
+
+	mu     sync.Mutex
+	pos    int
+	err    error
+	closed bool
+}
+
+func (c *fakeAudioCapture) Window(n int) ([]float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = c.samples[(c.pos+i)%len(c.samples)]
+	}
+	c.pos = (c.pos + c.hop) % len(c.samples)
+	return out, nil
+}
+
+func (c *fakeAudioCapture) Rate() int { return c.rate }
+
+func (c *fakeAudioCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeAudioCapture) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// useAudioCapture makes the commands record audio from c.
+func useAudioCapture(t *testing.T, c audioCapture) {
+	t.Helper()
+	orig := openAudioCapture
+	t.Cleanup(func() { openAudioCapture = orig })
+	openAudioCapture = func(string, string) (audioCapture, string, error) {
+		return c, "fake", nil
+	}
+}
+
+// constSource returns the same colors every time, or err.
+type constSource struct {
+	colors []RGB
+	err    error
+	calls  *int
+}
+
+func (s constSource) Colors(time.Time) ([]RGB, image.Rectangle, error) {
+	*s.calls++
+	return s.colors, image.Rect(0, 0, 1, 1), s.err
+}
+
+func (constSource) SnapThreshold() float64 { return 0 }
+
+func TestAudioSource_Screen(t *testing.T) {
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	c := &fakeAudioCapture{samples: samples, rate: rate, hop: rate / 50}
+	var calls int
+	screen := constSource{colors: []RGB{{G: 255}}, calls: &calls}
+	src := newAudioSource(c, testChannels(0), 1).withScreen(screen, 100*time.Millisecond, 1)
+
+	start := time.Now()
+	for i := range 10 {
+		colors, crop, err := src.Colors(start.Add(time.Duration(i) * 20 * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if colors[0].R != 0 || colors[0].B != 0 || colors[0].G == 0 {
+			t.Errorf("frame %d: expected the green screen color, got %v", i, colors[0])
+		}
+		if crop.Empty() {
+			t.Errorf("frame %d: expected the screen crop", i)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected a screen capture every 100ms over 200ms, got %d", calls)
+	}
+}
+
+func TestAudioSource_ScreenError(t *testing.T) {
+	c := &fakeAudioCapture{samples: make([]float32, audioWindow), rate: audioRate, hop: 1}
+	var calls int
+	screen := constSource{err: errors.New("no frame"), calls: &calls}
+	src := newAudioSource(c, testChannels(0), 1).withScreen(screen, 0, 0.5)
+	if _, _, err := src.Colors(time.Now()); err == nil {
+		t.Fatal("expected the error of the first screen capture")
+	}
+
+	screen.err, screen.colors = nil, []RGB{{R: 255}}
+	src.screen = screen
+	if _, _, err := src.Colors(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Later failures keep the last screen colors.
+	screen.err, screen.colors = errors.New("no frame"), nil
+	src.screen = screen
+	colors, _, err := src.Colors(time.Now())
+	if err != nil || colors[0].R == 0 {
+		t.Errorf("expected the last screen colors after a failed capture, got %v, %v", colors, err)
+	}
+}
+
+func TestPipeAudioCapture_ReadSamples(t *testing.T) {
+	r, w := io.Pipe()
+	c := newPipeAudioCapture()
+	go c.readSamples(r)
+
+	buf := make([]byte, 4*audioChunk)
+	for i := range audioChunk {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(i)/audioChunk))
+	}
+	go w.Write(buf)
+	<-c.ready
+
+	got, err := c.Window(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range got {
+		if want := float32(audioChunk-4+i) / audioChunk; s != want {
+			t.Errorf("sample %d: got %g, want %g", i, s, want)
+		}
+	}
+	if got, _ := c.Window(2 * audioChunk); len(got) != audioChunk {
+		t.Errorf("expected only the %d samples read, got %d", audioChunk, len(got))
+	}
+
+	w.Close()
+	<-c.done
+	if _, err := c.Window(4); err == nil {
+		t.Error("expected an error after the recording stopped")
+	}
+}
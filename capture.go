@@ -3,8 +3,7 @@ package main
 import (
 	"fmt"
 	"os/exec"
-
-	"github.com/kbinani/screenshot"
+	"strings"
 )
 
 const (
@@ -13,58 +12,94 @@ const (
 	frameSize     = captureWidth * captureHeight * 3 // RGB24
 )
 
-// Capturer captures the screen and returns the average color.
+// Frame is a downscaled RGB24 snapshot of the screen.
+type Frame struct {
+	Pix    []byte
+	Width  int
+	Height int
+}
+
+// Capturer captures the screen and returns a downscaled frame.
 type Capturer interface {
-	CaptureColor() (RGB, error)
+	CaptureFrame() (Frame, error)
 	Close() error
 }
 
 // x11Capturer wraps the existing kbinani/screenshot-based capture.
-type x11Capturer struct{}
+type x11Capturer struct {
+	display int
+}
 
-func (x11Capturer) CaptureColor() (RGB, error) {
-	img, err := CaptureScreen()
+func (c x11Capturer) CaptureFrame() (Frame, error) {
+	img, err := CaptureScreen(c.display)
 	if err != nil {
-		return RGB{}, err
+		return Frame{}, err
 	}
-	return AverageColor(img), nil
+	return downscaleRGBA(img, captureWidth, captureHeight), nil
 }
 
 func (x11Capturer) Close() error { return nil }
 
 // NewCapturer tries PipeWire → FFmpeg → X11 and returns the first that works.
-func NewCapturer() (Capturer, string, error) {
-	c, method, err := newPipeWireCapturer()
+// display is a display index from ListDisplays or spanDisplays.
+func NewCapturer(display int) (Capturer, string, error) {
+	c, method, err := newPipeWireCapturer(display)
 	if err == nil {
 		return c, method, nil
 	}
 
-	c, method, err = newFFmpegCapturer()
+	c, method, err = newFFmpegCapturer(display)
 	if err == nil {
 		return c, method, nil
 	}
 
-	return x11Capturer{}, "X11", nil
+	if _, err := displayBounds(display); err != nil {
+		return nil, "", err
+	}
+	return x11Capturer{display: display}, "X11", nil
 }
 
-// averageRGB computes the mean color of a raw RGB24 buffer.
-func averageRGB(buf []byte, pixels int) RGB {
-	if pixels == 0 {
-		return RGB{}
+// newFrame copies a raw captureWidth×captureHeight RGB24 buffer into a Frame.
+func newFrame(buf []byte) Frame {
+	pix := make([]byte, frameSize)
+	copy(pix, buf)
+	return Frame{Pix: pix, Width: captureWidth, Height: captureHeight}
+}
+
+// captureBackends lists the names accepted by newCapturerByName.
+var captureBackends = []string{"auto", "pipewire", "ffmpeg", "x11"}
+
+// openCapturer opens a capture backend by name. It is a variable so that
+// tests can substitute a fake capturer.
+var openCapturer = newCapturerByName
+
+// newCapturerByName opens the named capture backend, or tries them all in
+// NewCapturer's order for "auto".
+func newCapturerByName(name string, display int) (Capturer, string, error) {
+	switch name {
+	case "auto", "":
+		return NewCapturer(display)
+	case "pipewire":
+		return newPipeWireCapturer(display)
+	case "ffmpeg":
+		return newFFmpegCapturer(display)
+	case "x11":
+		if _, err := displayBounds(display); err != nil {
+			return nil, "", err
+		}
+		return x11Capturer{display: display}, "X11", nil
 	}
-	var rSum, gSum, bSum uint64
+	return nil, "", fmt.Errorf("unknown capture backend %q (want %s)", name, strings.Join(captureBackends, ", "))
+}
+
+// averageRGB computes the mean color of a raw RGB24 buffer in linear light.
+func averageRGB(buf []byte, pixels int) RGB {
+	acc := colorAccumulator{space: spaceLinear}
 	for i := 0; i < pixels; i++ {
 		off := i * 3
-		rSum += uint64(buf[off])
-		gSum += uint64(buf[off+1])
-		bSum += uint64(buf[off+2])
-	}
-	n := uint64(pixels)
-	return RGB{
-		R: uint8(rSum / n),
-		G: uint8(gSum / n),
-		B: uint8(bSum / n),
+		acc.Add(buf[off], buf[off+1], buf[off+2])
 	}
+	return acc.Mean()
 }
 
 // hasExecutable reports whether the named program is on PATH.
@@ -72,13 +107,3 @@ func hasExecutable(name string) bool {
 	_, err := exec.LookPath(name)
 	return err == nil
 }
-
-// screenSize returns the dimensions of display 0 using kbinani/screenshot.
-func screenSize() (int, int, error) {
-	n := screenshot.NumActiveDisplays()
-	if n == 0 {
-		return 0, 0, fmt.Errorf("no active displays")
-	}
-	b := screenshot.GetDisplayBounds(0)
-	return b.Dx(), b.Dy(), nil
-}
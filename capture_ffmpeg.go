@@ -20,7 +20,7 @@ type ffmpegCapturer struct {
 	frame []byte
 }
 
-func newFFmpegCapturer() (Capturer, string, error) {
+func newFFmpegCapturer(displayIndex int) (Capturer, string, error) {
 	if !hasExecutable("ffmpeg") {
 		return nil, "", fmt.Errorf("ffmpeg not found")
 	}
@@ -30,7 +30,7 @@ func newFFmpegCapturer() (Capturer, string, error) {
 		return nil, "", fmt.Errorf("DISPLAY not set")
 	}
 
-	w, h, err := screenSize()
+	bounds, err := displayBounds(displayIndex)
 	if err != nil {
 		return nil, "", err
 	}
@@ -42,8 +42,8 @@ func newFFmpegCapturer() (Capturer, string, error) {
 		"-loglevel", "error",
 		"-f", "x11grab",
 		"-framerate", "30",
-		"-video_size", fmt.Sprintf("%dx%d", w, h),
-		"-i", display+".0",
+		"-video_size", fmt.Sprintf("%dx%d", bounds.Dx(), bounds.Dy()),
+		"-i", fmt.Sprintf("%s.0+%d,%d", display, bounds.Min.X, bounds.Min.Y),
 		"-vf", fmt.Sprintf("scale=%d:%d", captureWidth, captureHeight),
 		"-f", "rawvideo",
 		"-pix_fmt", "rgb24",
@@ -70,7 +70,7 @@ func newFFmpegCapturer() (Capturer, string, error) {
 
 	go c.readFrames(stdout)
 
-	// Wait for the first frame so CaptureColor is immediately usable.
+	// Wait for the first frame so CaptureFrame is immediately usable.
 	select {
 	case <-c.ready:
 	case <-time.After(5 * time.Second):
@@ -105,14 +105,13 @@ func (c *ffmpegCapturer) readFrames(r io.Reader) {
 	}
 }
 
-func (c *ffmpegCapturer) CaptureColor() (RGB, error) {
+func (c *ffmpegCapturer) CaptureFrame() (Frame, error) {
 	c.mu.Lock()
-	f := c.frame
-	c.mu.Unlock()
-	if f == nil {
-		return RGB{}, fmt.Errorf("no frame captured yet")
+	defer c.mu.Unlock()
+	if c.frame == nil {
+		return Frame{}, fmt.Errorf("no frame captured yet")
 	}
-	return averageRGB(f, captureWidth*captureHeight), nil
+	return newFrame(c.frame), nil
 }
 
 func (c *ffmpegCapturer) Close() error {
@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"image"
 	"io"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -34,27 +36,50 @@ type pipeWireCapturer struct {
 	frame []byte
 }
 
-func newPipeWireCapturer() (Capturer, string, error) {
+// pipeWireStream is a single monitor stream granted by the ScreenCast portal.
+type pipeWireStream struct {
+	NodeID uint32
+	Bounds image.Rectangle // position and size in the compositor's logical space
+}
+
+// newPipeWireCapturer starts a portal ScreenCast session. The portal lets the
+// user pick the monitor; see streamForDisplay for how it must match display.
+// spanDisplays requests every monitor and composites them into one canvas.
+func newPipeWireCapturer(display int) (Capturer, string, error) {
 	if !hasExecutable("gst-launch-1.0") {
 		return nil, "", fmt.Errorf("gst-launch-1.0 not found")
 	}
 
-	dbConn, nodeID, pwFile, err := acquirePipeWireNode()
+	var bounds image.Rectangle
+	if display != spanDisplays {
+		b, err := displayBounds(display)
+		switch {
+		case err == nil:
+			bounds = b
+		case display != 0:
+			return nil, "", fmt.Errorf("cannot tell where display %d is (%v); leave out --display and pick the monitor when asked", display, err)
+		}
+	}
+
+	dbConn, streams, pwFile, err := acquirePipeWireStreams(display == spanDisplays)
 	if err != nil {
 		return nil, "", fmt.Errorf("pipewire portal: %w", err)
 	}
+	if display != spanDisplays {
+		st, err := streamForDisplay(streams, display, bounds)
+		if err != nil {
+			pwFile.Close()
+			dbConn.Close()
+			return nil, "", err
+		}
+		streams = []pipeWireStream{st}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// GStreamer child process inherits pwFile via ExtraFiles.
 	// ExtraFiles[0] becomes fd 3 in the child.
-	cmd := exec.CommandContext(ctx, "gst-launch-1.0", "-q",
-		"pipewiresrc", fmt.Sprintf("path=%d", nodeID), "fd=3",
-		"!", "videoconvert",
-		"!", "videoscale",
-		"!", fmt.Sprintf("video/x-raw,format=RGB,width=%d,height=%d", captureWidth, captureHeight),
-		"!", "fdsink", "fd=1",
-	)
+	cmd := exec.CommandContext(ctx, "gst-launch-1.0", gstPipelineArgs(streams)...)
 	cmd.ExtraFiles = []*os.File{pwFile}
 
 	stdout, err := cmd.StdoutPipe()
@@ -83,7 +108,7 @@ func newPipeWireCapturer() (Capturer, string, error) {
 
 	go c.readFrames(stdout)
 
-	// Wait for the first frame so CaptureColor is immediately usable.
+	// Wait for the first frame so CaptureFrame is immediately usable.
 	select {
 	case <-c.ready:
 	case <-time.After(5 * time.Second):
@@ -98,6 +123,84 @@ func newPipeWireCapturer() (Capturer, string, error) {
 	return c, "PipeWire", nil
 }
 
+// streamForDisplay picks the stream showing display, whose desktop bounds are
+// bounds, or empty if unknown: the one whose position and size match. Display
+// 0, the default, takes the first monitor picked if none matches. Any other
+// display is refused then, rather than capturing the wrong screen.
+func streamForDisplay(streams []pipeWireStream, display int, bounds image.Rectangle) (pipeWireStream, error) {
+	for _, st := range streams {
+		if !bounds.Empty() && st.Bounds == bounds {
+			return st, nil
+		}
+	}
+	if display == 0 {
+		return streams[0], nil
+	}
+	if !slices.ContainsFunc(streams, func(st pipeWireStream) bool { return !st.Bounds.Empty() }) {
+		return pipeWireStream{}, fmt.Errorf("the screen-sharing portal does not tell which monitor was picked, so display %d cannot be matched; leave out --display", display)
+	}
+	return pipeWireStream{}, fmt.Errorf("the monitor picked is not display %d, the %dx%d one at +%d,+%d; pick that one, or leave out --display",
+		display, bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y)
+}
+
+// gstPipelineArgs builds the gst-launch-1.0 arguments that scale the given
+// streams down to one captureWidth×captureHeight RGB24 canvas on stdout. With
+// several streams each one is scaled into its share of the canvas according
+// to its position, so the canvas mirrors the physical monitor layout.
+func gstPipelineArgs(streams []pipeWireStream) []string {
+	rawCaps := fmt.Sprintf("video/x-raw,format=RGB,width=%d,height=%d", captureWidth, captureHeight)
+
+	if len(streams) == 1 {
+		return []string{"-q",
+			"pipewiresrc", fmt.Sprintf("path=%d", streams[0].NodeID), "fd=3",
+			"!", "videoconvert",
+			"!", "videoscale",
+			"!", rawCaps,
+			"!", "fdsink", "fd=1",
+		}
+	}
+
+	streams = slices.Clone(streams)
+	var canvas image.Rectangle
+	for i, st := range streams {
+		if st.Bounds.Empty() {
+			// The portal did not report a geometry; lay monitors out side by side.
+			streams[i].Bounds = image.Rect(i*captureWidth, 0, (i+1)*captureWidth, captureHeight)
+		}
+		canvas = canvas.Union(streams[i].Bounds)
+	}
+	cw := max(canvas.Dx(), 1)
+	ch := max(canvas.Dy(), 1)
+
+	args := []string{"-q", "compositor", "name=mix", "background=black"}
+	for i, st := range streams {
+		x := (st.Bounds.Min.X - canvas.Min.X) * captureWidth / cw
+		y := (st.Bounds.Min.Y - canvas.Min.Y) * captureHeight / ch
+		args = append(args,
+			fmt.Sprintf("sink_%d::xpos=%d", i, x),
+			fmt.Sprintf("sink_%d::ypos=%d", i, y))
+	}
+	args = append(args,
+		"!", fmt.Sprintf("video/x-raw,width=%d,height=%d", captureWidth, captureHeight),
+		"!", "videoconvert",
+		"!", rawCaps,
+		"!", "fdsink", "fd=1",
+	)
+
+	for i, st := range streams {
+		w := max(st.Bounds.Dx()*captureWidth/cw, 1)
+		h := max(st.Bounds.Dy()*captureHeight/ch, 1)
+		args = append(args,
+			"pipewiresrc", fmt.Sprintf("path=%d", st.NodeID), "fd=3",
+			"!", "videoconvert",
+			"!", "videoscale",
+			"!", fmt.Sprintf("video/x-raw,width=%d,height=%d", w, h),
+			"!", fmt.Sprintf("mix.sink_%d", i),
+		)
+	}
+	return args
+}
+
 func (c *pipeWireCapturer) readFrames(r io.Reader) {
 	defer close(c.done)
 	buf := make([]byte, frameSize)
@@ -120,14 +223,13 @@ func (c *pipeWireCapturer) readFrames(r io.Reader) {
 	}
 }
 
-func (c *pipeWireCapturer) CaptureColor() (RGB, error) {
+func (c *pipeWireCapturer) CaptureFrame() (Frame, error) {
 	c.mu.Lock()
-	f := c.frame
-	c.mu.Unlock()
-	if f == nil {
-		return RGB{}, fmt.Errorf("no frame captured yet")
+	defer c.mu.Unlock()
+	if c.frame == nil {
+		return Frame{}, fmt.Errorf("no frame captured yet")
 	}
-	return averageRGB(f, captureWidth*captureHeight), nil
+	return newFrame(c.frame), nil
 }
 
 func (c *pipeWireCapturer) Close() error {
@@ -139,17 +241,18 @@ func (c *pipeWireCapturer) Close() error {
 	return err
 }
 
-// acquirePipeWireNode negotiates a ScreenCast session via the XDG Desktop Portal
-// and returns the D-Bus connection (must stay open), the PipeWire node ID,
-// and a PipeWire remote file descriptor for GStreamer.
-func acquirePipeWireNode() (*dbus.Conn, uint32, *os.File, error) {
+// acquirePipeWireStreams negotiates a ScreenCast session via the XDG Desktop
+// Portal and returns the D-Bus connection (must stay open), the granted
+// monitor streams, and a PipeWire remote file descriptor for GStreamer. With
+// multiple set the user may share several monitors at once.
+func acquirePipeWireStreams(multiple bool) (*dbus.Conn, []pipeWireStream, *os.File, error) {
 	conn, err := dbus.ConnectSessionBus()
 	if err != nil {
-		return nil, 0, nil, fmt.Errorf("connecting to session bus: %w", err)
+		return nil, nil, nil, fmt.Errorf("connecting to session bus: %w", err)
 	}
 	if !conn.SupportsUnixFDs() {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("D-Bus connection does not support Unix FD passing")
+		return nil, nil, nil, fmt.Errorf("D-Bus connection does not support Unix FD passing")
 	}
 
 	portal := conn.Object(portalDest, dbus.ObjectPath(portalPath))
@@ -169,19 +272,19 @@ func acquirePipeWireNode() (*dbus.Conn, uint32, *os.File, error) {
 	})
 	if call.Err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("CreateSession: %w", call.Err)
+		return nil, nil, nil, fmt.Errorf("CreateSession: %w", call.Err)
 	}
 
 	resp, err := waitForResponse(sigCh, portalTimeout)
 	if err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("CreateSession response: %w", err)
+		return nil, nil, nil, fmt.Errorf("CreateSession response: %w", err)
 	}
 
 	sessionHandle, ok := resp["session_handle"]
 	if !ok {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("CreateSession: no session_handle in response")
+		return nil, nil, nil, fmt.Errorf("CreateSession: no session_handle in response")
 	}
 	sessionPath := dbus.ObjectPath(sessionHandle.Value().(string))
 
@@ -195,16 +298,16 @@ func acquirePipeWireNode() (*dbus.Conn, uint32, *os.File, error) {
 	call = portal.Call(screenCastIface+".SelectSources", 0, sessionPath, map[string]dbus.Variant{
 		"handle_token": dbus.MakeVariant(reqToken),
 		"types":        dbus.MakeVariant(uint32(1)), // 1 = monitor
-		"multiple":     dbus.MakeVariant(false),
+		"multiple":     dbus.MakeVariant(multiple),
 	})
 	if call.Err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("SelectSources: %w", call.Err)
+		return nil, nil, nil, fmt.Errorf("SelectSources: %w", call.Err)
 	}
 
 	if _, err := waitForResponse(sigCh2, portalTimeout); err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("SelectSources response: %w", err)
+		return nil, nil, nil, fmt.Errorf("SelectSources response: %w", err)
 	}
 
 	// --- Start ---
@@ -219,19 +322,19 @@ func acquirePipeWireNode() (*dbus.Conn, uint32, *os.File, error) {
 	})
 	if call.Err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("Start: %w", call.Err)
+		return nil, nil, nil, fmt.Errorf("Start: %w", call.Err)
 	}
 
 	startResp, err := waitForResponse(sigCh3, portalTimeout)
 	if err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("Start response: %w", err)
+		return nil, nil, nil, fmt.Errorf("Start response: %w", err)
 	}
 
-	nodeID, err := extractNodeID(startResp)
+	streams, err := extractStreams(startResp)
 	if err != nil {
 		conn.Close()
-		return nil, 0, nil, err
+		return nil, nil, nil, err
 	}
 
 	// --- OpenPipeWireRemote ---
@@ -241,16 +344,16 @@ func acquirePipeWireNode() (*dbus.Conn, uint32, *os.File, error) {
 	err = portal.Call(screenCastIface+".OpenPipeWireRemote", 0, sessionPath, map[string]dbus.Variant{}).Store(&pwFd)
 	if err != nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("OpenPipeWireRemote: %w", err)
+		return nil, nil, nil, fmt.Errorf("OpenPipeWireRemote: %w", err)
 	}
 
 	pwFile := os.NewFile(uintptr(pwFd), "pipewire-remote")
 	if pwFile == nil {
 		conn.Close()
-		return nil, 0, nil, fmt.Errorf("invalid PipeWire fd")
+		return nil, nil, nil, fmt.Errorf("invalid PipeWire fd")
 	}
 
-	return conn, nodeID, pwFile, nil
+	return conn, streams, pwFile, nil
 }
 
 // subscribeSignal registers a D-Bus signal match for the portal Response signal
@@ -303,45 +406,68 @@ func senderToToken(sender string) string {
 	return strings.ReplaceAll(s, ".", "_")
 }
 
-// extractNodeID pulls the PipeWire node ID from the Start response.
-// The streams field is typed as a(ua{sv}) — an array of (uint32, dict) structs.
-func extractNodeID(resp map[string]dbus.Variant) (uint32, error) {
+// extractStreams pulls the PipeWire streams from the Start response.
+// The streams field is typed as a(ua{sv}) — an array of (uint32, dict) structs
+// where the dict may carry the monitor's "position" and "size" as (ii).
+func extractStreams(resp map[string]dbus.Variant) ([]pipeWireStream, error) {
 	streamsVariant, ok := resp["streams"]
 	if !ok {
-		return 0, fmt.Errorf("no streams in Start response")
+		return nil, fmt.Errorf("no streams in Start response")
 	}
 
 	// The variant wraps [][]interface{} where each inner slice is [uint32, map[string]dbus.Variant].
-	streams, ok := streamsVariant.Value().([][]interface{})
+	entries, ok := streamsVariant.Value().([][]interface{})
 	if !ok {
 		// Some D-Bus libs may present this as []interface{}.
 		rawSlice, ok2 := streamsVariant.Value().([]interface{})
-		if !ok2 || len(rawSlice) == 0 {
-			return 0, fmt.Errorf("unexpected streams type: %T", streamsVariant.Value())
-		}
-		inner, ok2 := rawSlice[0].([]interface{})
-		if !ok2 || len(inner) == 0 {
-			return 0, fmt.Errorf("unexpected stream entry type: %T", rawSlice[0])
-		}
-		nodeID, ok2 := inner[0].(uint32)
 		if !ok2 {
-			return 0, fmt.Errorf("unexpected node ID type: %T", inner[0])
+			return nil, fmt.Errorf("unexpected streams type: %T", streamsVariant.Value())
+		}
+		for _, raw := range rawSlice {
+			inner, ok2 := raw.([]interface{})
+			if !ok2 {
+				return nil, fmt.Errorf("unexpected stream entry type: %T", raw)
+			}
+			entries = append(entries, inner)
 		}
-		return nodeID, nil
 	}
 
-	if len(streams) == 0 {
-		return 0, fmt.Errorf("no streams returned")
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no streams returned")
 	}
 
-	entry := streams[0]
-	if len(entry) == 0 {
-		return 0, fmt.Errorf("empty stream entry")
+	streams := make([]pipeWireStream, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) == 0 {
+			return nil, fmt.Errorf("empty stream entry")
+		}
+		nodeID, ok := entry[0].(uint32)
+		if !ok {
+			return nil, fmt.Errorf("unexpected node ID type: %T", entry[0])
+		}
+		st := pipeWireStream{NodeID: nodeID}
+		if len(entry) > 1 {
+			if props, ok := entry[1].(map[string]dbus.Variant); ok {
+				pos, _ := variantPoint(props["position"])
+				size, _ := variantPoint(props["size"])
+				st.Bounds = image.Rectangle{Min: pos, Max: pos.Add(size)}
+			}
+		}
+		streams = append(streams, st)
 	}
+	return streams, nil
+}
 
-	nodeID, ok := entry[0].(uint32)
-	if !ok {
-		return 0, fmt.Errorf("unexpected node ID type: %T", entry[0])
+// variantPoint decodes an (ii) struct variant into a point.
+func variantPoint(v dbus.Variant) (image.Point, bool) {
+	pair, ok := v.Value().([]interface{})
+	if !ok || len(pair) != 2 {
+		return image.Point{}, false
+	}
+	x, okX := pair[0].(int32)
+	y, okY := pair[1].(int32)
+	if !okX || !okY {
+		return image.Point{}, false
 	}
-	return nodeID, nil
+	return image.Pt(int(x), int(y)), true
 }
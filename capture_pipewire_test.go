@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestExtractStreams_WithGeometry(t *testing.T) {
+	resp := map[string]dbus.Variant{
+		"streams": dbus.MakeVariant([][]interface{}{
+			{uint32(41), map[string]dbus.Variant{
+				"position": dbus.MakeVariant([]interface{}{int32(0), int32(0)}),
+				"size":     dbus.MakeVariant([]interface{}{int32(1920), int32(1080)}),
+			}},
+			{uint32(42), map[string]dbus.Variant{
+				"position": dbus.MakeVariant([]interface{}{int32(1920), int32(0)}),
+				"size":     dbus.MakeVariant([]interface{}{int32(2560), int32(1440)}),
+			}},
+		}),
+	}
+
+	streams, err := extractStreams(resp)
+	if err != nil {
+		t.Fatalf("extractStreams: %v", err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(streams))
+	}
+	if streams[0].NodeID != 41 || streams[0].Bounds != image.Rect(0, 0, 1920, 1080) {
+		t.Errorf("stream 0: got %+v", streams[0])
+	}
+	if streams[1].NodeID != 42 || streams[1].Bounds != image.Rect(1920, 0, 4480, 1440) {
+		t.Errorf("stream 1: got %+v", streams[1])
+	}
+}
+
+func TestExtractStreams_Missing(t *testing.T) {
+	if _, err := extractStreams(map[string]dbus.Variant{}); err == nil {
+		t.Fatal("expected error for missing streams")
+	}
+}
+
+func TestGstPipelineArgs_Single(t *testing.T) {
+	args := gstPipelineArgs([]pipeWireStream{{NodeID: 7}})
+	if slices.Contains(args, "compositor") {
+		t.Errorf("single stream should not use a compositor: %v", args)
+	}
+	if !slices.Contains(args, "path=7") {
+		t.Errorf("expected path=7 in %v", args)
+	}
+}
+
+func TestGstPipelineArgs_SpanLayout(t *testing.T) {
+	streams := []pipeWireStream{
+		{NodeID: 1, Bounds: image.Rect(0, 0, 1920, 1080)},
+		{NodeID: 2, Bounds: image.Rect(1920, 0, 3840, 1080)},
+	}
+	args := strings.Join(gstPipelineArgs(streams), " ")
+
+	for _, want := range []string{
+		"compositor",
+		"sink_0::xpos=0",
+		"sink_1::xpos=32",
+		"path=1 fd=3",
+		"path=2 fd=3",
+		"video/x-raw,width=32,height=36 ! mix.sink_1",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in pipeline %q", want, args)
+		}
+	}
+}
+
+func TestStreamForDisplay(t *testing.T) {
+	left := pipeWireStream{NodeID: 41, Bounds: image.Rect(0, 0, 1920, 1080)}
+	right := pipeWireStream{NodeID: 42, Bounds: image.Rect(1920, 0, 4480, 1440)}
+	rightDisplay := image.Rect(1920, 0, 4480, 1440)
+
+	if st, err := streamForDisplay([]pipeWireStream{left, right}, 1, rightDisplay); err != nil || st.NodeID != 42 {
+		t.Errorf("expected the stream at the display's position, got %+v, %v", st, err)
+	}
+	// The default display takes whichever monitor was picked.
+	if st, err := streamForDisplay([]pipeWireStream{right}, 0, image.Rect(0, 0, 1920, 1080)); err != nil || st.NodeID != 42 {
+		t.Errorf("expected the picked stream for display 0, got %+v, %v", st, err)
+	}
+	if st, err := streamForDisplay([]pipeWireStream{{NodeID: 7}}, 0, image.Rectangle{}); err != nil || st.NodeID != 7 {
+		t.Errorf("expected the picked stream for display 0, got %+v, %v", st, err)
+	}
+
+	// Another display must match.
+	if _, err := streamForDisplay([]pipeWireStream{left}, 1, rightDisplay); err == nil || !strings.Contains(err.Error(), "not display 1, the 2560x1440 one at +1920,+0") {
+		t.Errorf("expected the wrong monitor to be refused, got %v", err)
+	}
+	if _, err := streamForDisplay([]pipeWireStream{{NodeID: 7}}, 1, rightDisplay); err == nil || !strings.Contains(err.Error(), "cannot be matched") {
+		t.Errorf("expected streams without geometry to be refused, got %v", err)
+	}
+}
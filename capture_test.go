@@ -17,14 +17,14 @@ func TestAverageRGB_Uniform(t *testing.T) {
 }
 
 func TestAverageRGB_BlackAndWhite(t *testing.T) {
-	// 2 pixels: black + white → 127,127,127.
+	// 2 pixels: black + white → 50% linear light → 188,188,188 in sRGB.
 	buf := []byte{
 		0, 0, 0,
 		255, 255, 255,
 	}
 	got := averageRGB(buf, 2)
-	if got.R != 127 || got.G != 127 || got.B != 127 {
-		t.Errorf("expected RGB{127, 127, 127}, got %v", got)
+	if got.R != 188 || got.G != 188 || got.B != 188 {
+		t.Errorf("expected RGB{188, 188, 188}, got %v", got)
 	}
 }
 
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed hue-root-ca.pem
+var hueRootCAPEM []byte
+
+// hueRootCAs holds the bundled root CAs that sign bridge certificates. It is
+// a variable so that tests can substitute their own CA.
+var hueRootCAs = loadRootCAs(hueRootCAPEM)
+
+// loadRootCAs parses the bundled CAs. It panics if there are none, as every
+// bridge would otherwise be trusted on first use only.
+func loadRootCAs(pem []byte) *x509.CertPool {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic("hue-root-ca.pem holds no certificate")
+	}
+	return pool
+}
+
+// CertificateChangedError reports that a bridge presented another certificate
+// than the one pinned when huesync first connected to it. Either the bridge
+// was reset or replaced, or something is impersonating it.
+type CertificateChangedError struct {
+	BridgeID string
+	// Pinned and Presented are SHA-256 fingerprints.
+	Pinned, Presented string
+}
+
+func (e *CertificateChangedError) Error() string {
+	return fmt.Sprintf("the certificate of bridge %s changed since it was first used", e.BridgeID)
+}
+
+// certificateHint explains how to trust a changed certificate if err
+// reports one.
+func certificateHint(err error) error {
+	var changed *CertificateChangedError
+	if errors.As(err, &changed) {
+		return fmt.Errorf("%w; if you reset or replaced the bridge, run huesync without arguments to trust its new certificate", changed)
+	}
+	return err
+}
+
+// knownBridges maps the IP address of each bridge found by discovery to its
+// bridge ID, which its certificate must name.
+var knownBridges = struct {
+	sync.Mutex
+	ids map[string]string
+}{ids: make(map[string]string)}
+
+// expectBridge makes connections to b.IP accept only certificates for b.ID.
+func expectBridge(b Bridge) {
+	knownBridges.Lock()
+	defer knownBridges.Unlock()
+	knownBridges.ids[b.IP.String()] = b.ID
+}
+
+// forgetBridge makes connections to ip fail verification again, e.g. because
+// the device there turned out not to be the bridge it claims. Idle
+// connections, verified before, are closed so that none is reused.
+func forgetBridge(ip net.IP) {
+	knownBridges.Lock()
+	delete(knownBridges.ids, ip.String())
+	knownBridges.Unlock()
+	hueClient.CloseIdleConnections()
+}
+
+func expectedBridgeID(host string) (string, bool) {
+	knownBridges.Lock()
+	defer knownBridges.Unlock()
+	id, ok := knownBridges.ids[host]
+	return id, ok
+}
+
+// dialBridgeTLS connects to a bridge's HTTPS API. Bridge certificates name
+// the bridge ID rather than an address, so instead of the usual host name
+// check, verifyBridgeCertificate checks them against the bridge discovered
+// at that address.
+func dialBridgeTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, &tls.Config{
+		InsecureSkipVerify: true, // verified by VerifyConnection
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyBridgeCertificate(host, cs.PeerCertificates)
+		},
+	})
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// verifyBridgeCertificate checks the certificate chain presented by the
+// bridge at host against the bridge discovered there.
+func verifyBridgeCertificate(host string, certs []*x509.Certificate) error {
+	id, ok := expectedBridgeID(host)
+	if !ok {
+		return fmt.Errorf("no bridge was discovered at %s", host)
+	}
+	return verifyCertificateOf(id, certs)
+}
+
+// verifyCertificateOf checks that certs are the chain of the bridge with the
+// given ID: the common name must be the ID. A certificate signed by a bundled
+// root CA is trusted, and the bridge is recorded as having one. A self-signed
+// one, as older bridges have, is pinned on first use and must not change
+// afterwards; it is refused for a bridge recorded with a signed one.
+func verifyCertificateOf(id string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("bridge presented no certificate")
+	}
+	leaf := certs[0]
+	if !strings.EqualFold(leaf.Subject.CommonName, id) {
+		return fmt.Errorf("certificate is for %q, not bridge %s", leaf.Subject.CommonName, id)
+	}
+
+	if !selfSigned(leaf) {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         hueRootCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("verifying the certificate of bridge %s: %w", id, err)
+		}
+		return recordRootCA(id)
+	}
+	return checkPin(id, fingerprint(leaf))
+}
+
+func selfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) &&
+		c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
+
+// fingerprint returns the SHA-256 fingerprint of c as colon-separated hex.
+func fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		parts = append(parts, hexSum[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// pinRootCA is pinned instead of a fingerprint for bridges whose certificate
+// was signed by a bundled root CA.
+const pinRootCA = "signed by root-bridge"
+
+// certificatePinned is called when a bridge's certificate is pinned on first
+// use. The TUI replaces it to show a note instead of logging.
+var certificatePinned = func(bridgeID, fp string) {
+	log.Printf("pinned the certificate of bridge %s on first use (SHA-256 %s)", bridgeID, fp)
+}
+
+// recordRootCA pins pinRootCA for the bridge, so that a self-signed
+// certificate is refused for it from then on.
+func recordRootCA(bridgeID string) error {
+	return updatePins(func(pins map[string]string) (bool, error) {
+		key := strings.ToLower(bridgeID)
+		if pins[key] == pinRootCA {
+			return false, nil
+		}
+		pins[key] = pinRootCA
+		return true, nil
+	})
+}
+
+// checkPin pins fp for the bridge on first use and afterwards returns a
+// *CertificateChangedError if it differs, or if the bridge presented a
+// certificate signed by a root CA before.
+func checkPin(bridgeID, fp string) error {
+	var first bool
+	err := updatePins(func(pins map[string]string) (bool, error) {
+		key := strings.ToLower(bridgeID)
+		switch pinned, ok := pins[key]; {
+		case !ok:
+			pins[key] = fp
+			first = true
+			return true, nil
+		case pinned != fp:
+			return false, &CertificateChangedError{BridgeID: bridgeID, Pinned: pinned, Presented: fp}
+		}
+		return false, nil
+	})
+	if err == nil && first {
+		certificatePinned(bridgeID, fp)
+	}
+	return err
+}
+
+// TrustCertificate pins fp for the bridge, replacing a changed certificate.
+func TrustCertificate(bridgeID, fp string) error {
+	return updatePins(func(pins map[string]string) (bool, error) {
+		pins[strings.ToLower(bridgeID)] = fp
+		return true, nil
+	})
+}
+
+// updatePins runs fn on the pinned fingerprints and writes them back if fn
+// changed them. Like the credentials file, pins.json is changed under a lock,
+// so that concurrent first connections of several huesync instances do not
+// lose each other's pins.
+func updatePins(fn func(pins map[string]string) (changed bool, err error)) error {
+	path, err := pinsPath()
+	if err != nil {
+		return err
+	}
+	return withFileLock(path, true, func() error {
+		pins, err := readPins(path)
+		if err != nil {
+			return fmt.Errorf("reading pinned certificates: %w", err)
+		}
+		changed, err := fn(pins)
+		if err != nil || !changed {
+			return err
+		}
+		return writePins(path, pins)
+	})
+}
+
+// pinsPath returns the path of the pinned certificate fingerprints, which
+// are kept next to the credentials.
+func pinsPath() (string, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "pins.json"), nil
+}
+
+func readPins(path string) (map[string]string, error) {
+	pins := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+func writePins(path string, pins map[string]string) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert creates a certificate for cn, signed by parent or, if parent
+// is nil, by itself.
+func newTestCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// useBridgeAt makes the bridge with the given ID expected at host.
+func useBridgeAt(t *testing.T, host, id string) {
+	t.Helper()
+	knownBridges.Lock()
+	prev, ok := knownBridges.ids[host]
+	knownBridges.ids[host] = id
+	knownBridges.Unlock()
+	t.Cleanup(func() {
+		knownBridges.Lock()
+		defer knownBridges.Unlock()
+		if ok {
+			knownBridges.ids[host] = prev
+		} else {
+			delete(knownBridges.ids, host)
+		}
+	})
+}
+
+// recordPinned collects the certificates pinned on first use, as "ID
+// fingerprint".
+func recordPinned(t *testing.T) *[]string {
+	t.Helper()
+	var pinned []string
+	prev := certificatePinned
+	certificatePinned = func(bridgeID, fp string) { pinned = append(pinned, bridgeID+" "+fp) }
+	t.Cleanup(func() { certificatePinned = prev })
+	return &pinned
+}
+
+func TestVerifyBridgeCertificate_Pinning(t *testing.T) {
+	setupCredentialsDir(t)
+	pinned := recordPinned(t)
+	useBridgeAt(t, "192.0.2.1", "001788FFFE000001")
+	first, _ := newTestCert(t, "001788fffe000001", false, nil, nil)
+	second, _ := newTestCert(t, "001788fffe000001", false, nil, nil)
+
+	if err := verifyBridgeCertificate("192.0.2.2", []*x509.Certificate{first}); err == nil {
+		t.Error("expected a bridge that was not discovered to be refused")
+	}
+	other, _ := newTestCert(t, "001788fffe000002", false, nil, nil)
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{other}); err == nil || !strings.Contains(err.Error(), "not bridge 001788FFFE000001") {
+		t.Errorf("expected a certificate for another bridge to be refused, got %v", err)
+	}
+
+	// The first certificate is pinned, once, and accepted from then on.
+	for range 2 {
+		if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{first}); err != nil {
+			t.Fatalf("verifying the pinned certificate: %v", err)
+		}
+	}
+	if want := "001788FFFE000001 " + fingerprint(first); len(*pinned) != 1 || (*pinned)[0] != want {
+		t.Errorf("expected %q to be reported as pinned, got %q", want, *pinned)
+	}
+	path, _ := pinsPath()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the pins in a private file, got %v, %v", info, err)
+	}
+
+	var changed *CertificateChangedError
+	err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{second})
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected a CertificateChangedError, got %v", err)
+	}
+	if changed.Pinned != fingerprint(first) || changed.Presented != fingerprint(second) {
+		t.Errorf("unexpected fingerprints %+v", changed)
+	}
+
+	if err := TrustCertificate(changed.BridgeID, changed.Presented); err != nil {
+		t.Fatalf("TrustCertificate: %v", err)
+	}
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{second}); err != nil {
+		t.Errorf("expected the trusted certificate to be accepted, got %v", err)
+	}
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{first}); !errors.As(err, &changed) {
+		t.Errorf("expected the old certificate to be refused, got %v", err)
+	}
+}
+
+func TestVerifyBridgeCertificate_RootCA(t *testing.T) {
+	setupCredentialsDir(t)
+	pinned := recordPinned(t)
+	useBridgeAt(t, "192.0.2.1", "001788fffe000001")
+	root, rootKey := newTestCert(t, "Test Hue Root CA", true, nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	prev := hueRootCAs
+	hueRootCAs = pool
+	t.Cleanup(func() { hueRootCAs = prev })
+
+	// A bridge that had a self-signed certificate pinned may get a signed
+	// one with a firmware update.
+	old, _ := newTestCert(t, "001788fffe000001", false, nil, nil)
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{old}); err != nil {
+		t.Fatalf("verifying a self-signed certificate: %v", err)
+	}
+
+	// Signed certificates are trusted, whichever key they hold, and the
+	// bridge is recorded as having one.
+	for range 2 {
+		leaf, _ := newTestCert(t, "001788fffe000001", false, root, rootKey)
+		if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{leaf}); err != nil {
+			t.Fatalf("verifying a signed certificate: %v", err)
+		}
+	}
+	path, _ := pinsPath()
+	if pins, err := readPins(path); err != nil || pins["001788fffe000001"] != pinRootCA {
+		t.Errorf("expected the bridge recorded as having a signed certificate, got %v, %v", pins, err)
+	}
+	if len(*pinned) != 1 {
+		t.Errorf("expected only the self-signed certificate reported as pinned, got %q", *pinned)
+	}
+	wrong, _ := newTestCert(t, "001788fffe000002", false, root, rootKey)
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{wrong}); err == nil {
+		t.Error("expected a signed certificate for another bridge to be refused")
+	}
+
+	otherRoot, otherKey := newTestCert(t, "Other CA", true, nil, nil)
+	leaf, _ := newTestCert(t, "001788fffe000001", false, otherRoot, otherKey)
+	if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{leaf, otherRoot}); err == nil {
+		t.Error("expected a certificate from another CA to be refused")
+	}
+
+	// From then on, a self-signed certificate is refused, even the one
+	// pinned before.
+	var changed *CertificateChangedError
+	self, _ := newTestCert(t, "001788fffe000001", false, nil, nil)
+	for _, c := range []*x509.Certificate{old, self} {
+		err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{c})
+		if !errors.As(err, &changed) || changed.Pinned != pinRootCA || changed.Presented != fingerprint(c) {
+			t.Errorf("expected a self-signed certificate to be refused, got %v", err)
+		}
+	}
+
+	// Self-signed certificates are still pinned for other bridges.
+	useBridgeAt(t, "192.0.2.2", "001788fffe000002")
+	other, _ := newTestCert(t, "001788fffe000002", false, nil, nil)
+	if err := verifyBridgeCertificate("192.0.2.2", []*x509.Certificate{other}); err != nil {
+		t.Fatalf("verifying a self-signed certificate: %v", err)
+	}
+	if pins, _ := readPins(path); pins["001788fffe000002"] != fingerprint(other) {
+		t.Errorf("expected the self-signed certificate to be pinned, got %v", pins)
+	}
+}
+
+func TestHueRootCAs_Bundled(t *testing.T) {
+	setupCredentialsDir(t)
+	useBridgeAt(t, "192.0.2.1", "001788fffe000001")
+	block, _ := pem.Decode(hueRootCAPEM)
+	if block == nil {
+		t.Fatal("expected a certificate in hue-root-ca.pem")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "F0:BD:8E:65:09:E8:2F:77:4D:63:BC:00:9D:53:88:C9:69:FE:3D:CF:7D:6D:54:1D:63:51:B7:2B:89:8D:8A:CF"
+	if ca.Subject.CommonName != "root-bridge" || !ca.IsCA || fingerprint(ca) != want {
+		t.Errorf("unexpected bundled CA %s, %s", ca.Subject, fingerprint(ca))
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a bundle without certificates to panic")
+			}
+		}()
+		loadRootCAs([]byte("no certificate"))
+	}()
+
+	// A CA that only copies Signify's name signs nothing the bridge would
+	// present, whether or not the common name is right.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               ca.Subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cn := range []string{"001788fffe000001", "001788fffe000002"} {
+		leaf, _ := newTestCert(t, cn, false, impostor, key)
+		if err := verifyBridgeCertificate("192.0.2.1", []*x509.Certificate{leaf, impostor}); err == nil {
+			t.Errorf("%s: expected a certificate from an impostor CA to be refused", cn)
+		}
+	}
+	if path, _ := pinsPath(); fileExists(path) {
+		t.Error("expected no pins for certificates from a CA")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestHueClient_MockBridge(t *testing.T) {
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+
+	if _, err := FetchEntertainmentAreas(ip, "user"); err != nil {
+		t.Fatalf("FetchEntertainmentAreas: %v", err)
+	}
+
+	// Another certificate for the same bridge, as after a reset.
+	if err := TrustCertificate(b.ID, "00:11"); err != nil {
+		t.Fatalf("TrustCertificate: %v", err)
+	}
+	hueClient.CloseIdleConnections()
+	var changed *CertificateChangedError
+	_, err := FetchEntertainmentAreas(ip, "user")
+	if !errors.As(err, &changed) || changed.Pinned != "00:11" {
+		t.Fatalf("expected a CertificateChangedError, got %v", err)
+	}
+	if err := certificateHint(err); !strings.Contains(err.Error(), "to trust its new certificate") {
+		t.Errorf("expected a hint, got %v", err)
+	}
+
+	useBridgeAt(t, "127.0.0.1", "001788fffe000002")
+	if _, err := FetchEntertainmentAreas(ip, "user"); err == nil || !strings.Contains(err.Error(), "not bridge 001788fffe000002") {
+		t.Errorf("expected the certificate of another bridge to be refused, got %v", err)
+	}
+}
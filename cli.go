@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the headless commands.
+const (
+	exitOK       = 0
+	exitError    = 1 // streaming or bridge communication failed
+	exitUsage    = 2 // invalid flags or arguments
+	exitNoBridge = 3 // bridge not found
+	exitUnpaired = 4 // no stored credentials, or the bridge rejected them
+	exitNoArea   = 5 // entertainment area not found
+	exitCapture  = 6 // screen capture could not be initialized
+	exitTakeover = 7 // another application took over the entertainment area
+)
+
+// cliError pairs an error with the process exit code it should produce.
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+func cliErrorf(code int, format string, args ...any) error {
+	return &cliError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode maps an error returned by a command to a process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return exitError
+}
+
+// runStream implements `huesync stream`: it streams screen colors to an
+// entertainment area without the TUI until interrupted.
+func runStream(args []string, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	parser, opts, err := newOptionParser("huesync stream", args, stderr, nil)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		var ue *usageError
+		if !errors.As(err, &ue) {
+			logger.Printf("error: %v", err)
+		}
+		return exitUsage
+	}
+	if rest := parser.Args(); len(rest) > 0 {
+		logger.Printf("unexpected arguments: %s", strings.Join(rest, " "))
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = streamHeadless(ctx, logger, opts)
+	if err != nil {
+		logger.Printf("error: %v", err)
+	}
+	return exitCode(err)
+}
+
+func streamHeadless(ctx context.Context, logger *log.Logger, opts options) error {
+	bridge, creds, area, err := resolveArea(ctx, logger, opts)
+	if err != nil {
+		return err
+	}
+
+	var src colorSource
+	delay := opts.delay
+	switch opts.effect {
+	case "", effectScreen:
+		screen, err := openScreenSource(logger, opts, area)
+		if err != nil {
+			return err
+		}
+		defer screen.capturer.Close()
+		src = screen
+		logger.Printf("capturing every %dms", delay.Milliseconds())
+	case effectAudio:
+		capture, method, err := openAudioCapture(opts.audioCapture, opts.audioSource)
+		if err != nil {
+			return cliErrorf(exitCapture, "initializing audio capture: %w", err)
+		}
+		defer capture.Close()
+		logger.Printf("audio: %s (%s), sensitivity %.0f%%", method, opts.audioSource, opts.audioSensitivity*100)
+		audio := newAudioSource(capture, area.Channels, opts.audioSensitivity)
+		if opts.audioBlend > 0 {
+			screen, err := openScreenSource(logger, opts, area)
+			if err != nil {
+				return err
+			}
+			defer screen.capturer.Close()
+			audio.withScreen(screen, opts.delay, opts.audioBlend)
+			logger.Printf("blending %.0f%% screen color, capturing every %dms", opts.audioBlend*100, opts.delay.Milliseconds())
+		}
+		src = audio
+		// Analyze the audio for every frame sent to catch every beat.
+		delay = time.Second / time.Duration(opts.sendRate)
+	default:
+		effect, err := newEffect(opts.effect, area.Channels, opts.effectParams)
+		if err != nil {
+			return cliErrorf(exitUsage, "%w", err)
+		}
+		logger.Printf("effect: %s", describeEffect(opts.effect, opts.effectParams))
+		src = effectSource{effect: effect, start: time.Now()}
+		// Effects are cheap to compute: evaluate them for every frame sent.
+		delay = time.Second / time.Duration(opts.sendRate)
+	}
+
+	var gamuts []Gamut
+	if opts.colorSpace == ColorSpaceXY {
+		gamuts, err = FetchChannelGamuts(bridge.IP, creds.Username, area)
+		if err != nil {
+			return fmt.Errorf("fetching light gamuts: %w", err)
+		}
+	}
+
+	// Deactivate even when the stream gave up reconnecting: the area may
+	// have been re-activated in the meantime. Only an area taken over by
+	// another application is left alone, together with its lights.
+	started, deactivate, err := activateHeadless(logger, bridge, creds, area, opts)
+	if err != nil {
+		return err
+	}
+	var takeover *TakeoverError
+	defer func() {
+		if takeover == nil {
+			deactivate()
+		}
+	}()
+
+	appID, err := FetchApplicationID(bridge.IP, creds.Username)
+	if err != nil {
+		logger.Printf("not watching for other apps taking over the area: %v", err)
+	}
+	streamer, err := NewStreamer(bridge.IP, creds.Username, creds.Clientkey, area.ID, area.ChannelIDs())
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	streamer.SetColorSpace(opts.colorSpace, gamuts)
+	stream := newStreamSupervisor(streamer, func() (*Streamer, error) {
+		logger.Printf("reconnecting")
+		return dialArea(bridge.IP, creds.Username, creds.Clientkey, appID, area, opts.colorSpace, gamuts)
+	}, opts.reconnect)
+	defer func() {
+		if err := stream.Close(); err != nil {
+			logger.Printf("closing stream: %v", err)
+		}
+	}()
+	if appID != "" {
+		w := watchArea(bridge.IP, creds.Username, appID, area)
+		defer w.Stop()
+		go logAreaEvents(logger, w, stream)
+	}
+	if opts.record != "" {
+		rec, err := CreateRecording(opts.record)
+		if err != nil {
+			return err
+		}
+		stream.SetRecorder(rec)
+		logger.Printf("recording to %s", opts.record)
+	}
+
+	logger.Printf("sending %d fps, press Ctrl+C to stop", opts.sendRate)
+	err = streamLoop(ctx, logger, stream, src, delay, opts.sendRate, started)
+	if errors.As(err, &takeover) {
+		logger.Printf("leaving the area and its lights to %s", takeover.Owner)
+		return cliErrorf(exitTakeover, "%w", err)
+	}
+	logger.Printf("stopping")
+	return err
+}
+
+// logAreaEvents logs the changes w notices and puts the stream on hold when
+// another application takes over the area.
+func logAreaEvents(logger *log.Logger, w *areaWatcher, stream *streamSupervisor) {
+	for e := range w.Events() {
+		switch {
+		case e.Takeover != nil:
+			stream.Hold(e.Takeover)
+		case e.Err != nil:
+			logger.Printf("watching the area: %v", e.Err)
+		default:
+			logger.Printf("%s was switched %s", e.Light, onOff(e.On))
+		}
+	}
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// resolveBridge finds the bridge selected by opts and the credentials for it.
+func resolveBridge(ctx context.Context, logger *log.Logger, opts options) (Bridge, BridgeCredentials, error) {
+	if opts.profile != "" {
+		logger.Printf("profile: %s", opts.profile)
+	}
+
+	var bridge Bridge
+	var err error
+	if opts.bridgeIP != "" {
+		bridge, err = probeBridge(ctx, opts.bridgeIP, opts.bridge)
+	} else {
+		bridge, err = findBridge(ctx, opts.bridge, opts.ssdp)
+	}
+	if err != nil {
+		return Bridge{}, BridgeCredentials{}, err
+	}
+	logger.Printf("bridge: %s", bridge)
+	if err := RememberBridge(bridge); err != nil {
+		logger.Printf("remembering the bridge: %v", err)
+	}
+
+	if err := credentialStoreWarning(); err != nil {
+		logger.Printf("credentials: %v", err)
+	}
+	creds, found, err := LoadCredentials(bridge.ID)
+	if err != nil {
+		return Bridge{}, BridgeCredentials{}, fmt.Errorf("loading credentials: %w", err)
+	}
+	if !found {
+		return Bridge{}, BridgeCredentials{}, cliErrorf(exitUnpaired, "not paired with bridge %s; run huesync without arguments to pair", bridge.ID)
+	}
+	return bridge, creds, nil
+}
+
+// resolveArea finds the bridge and entertainment area selected by opts, and
+// the credentials for the bridge.
+func resolveArea(ctx context.Context, logger *log.Logger, opts options) (Bridge, BridgeCredentials, EntertainmentArea, error) {
+	bridge, creds, err := resolveBridge(ctx, logger, opts)
+	if err != nil {
+		return Bridge{}, BridgeCredentials{}, EntertainmentArea{}, err
+	}
+
+	areas, err := FetchEntertainmentAreas(bridge.IP, creds.Username)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return Bridge{}, BridgeCredentials{}, EntertainmentArea{}, cliErrorf(exitUnpaired, "bridge %s rejected the stored credentials; run huesync without arguments to pair again", bridge.ID)
+		}
+		return Bridge{}, BridgeCredentials{}, EntertainmentArea{}, fmt.Errorf("fetching entertainment areas: %w", certificateHint(err))
+	}
+	area, err := findArea(areas, opts.area)
+	if err != nil {
+		return Bridge{}, BridgeCredentials{}, EntertainmentArea{}, err
+	}
+	logger.Printf("area: %s", area)
+	return bridge, creds, area, nil
+}
+
+// activateHeadless snapshots the area's lights if opts.restore is set and
+// activates the area. started, which may be nil, is to be called once the
+// first frame was sent; deactivate deactivates the area and restores the
+// lights, logging any errors.
+func activateHeadless(logger *log.Logger, bridge Bridge, creds BridgeCredentials, area EntertainmentArea, opts options) (started, deactivate func(), err error) {
+	var lights *lightSession
+	if opts.restore {
+		lights, err = snapshotLights(bridge.IP, creds.Username, area, opts.restoreFade)
+		if err != nil {
+			logger.Printf("lights will not be restored: %v", err)
+		}
+	}
+
+	if err := ActivateArea(bridge.IP, creds.Username, area.ID); err != nil {
+		return nil, nil, fmt.Errorf("activating area: %w", err)
+	}
+
+	deactivate = func() {
+		if lights != nil {
+			if err := lights.detectChanges(); err != nil {
+				logger.Printf("checking lights: %v", err)
+			}
+		}
+		if err := DeactivateArea(bridge.IP, creds.Username, area.ID); err != nil {
+			logger.Printf("deactivating area: %v", err)
+		}
+		if lights != nil {
+			skipped, err := lights.restore()
+			if len(skipped) > 0 {
+				logger.Printf("not restored, changed during the session: %s", strings.Join(skipped, ", "))
+			}
+			if err != nil {
+				logger.Printf("restoring lights: %v", err)
+			}
+		}
+	}
+	if lights != nil {
+		started = func() {
+			if err := lights.markStreaming(); err != nil {
+				logger.Printf("checking lights: %v", err)
+			}
+		}
+	}
+	return started, deactivate, nil
+}
+
+// openScreenSource opens the screen capture and the color pipeline for area.
+// The caller closes the capturer.
+func openScreenSource(logger *log.Logger, opts options, area EntertainmentArea) (screenSource, error) {
+	display := opts.display
+	if !opts.displaySet {
+		display = 0
+	}
+	pipeline, err := newColorPipeline(area.Channels, opts)
+	if err != nil {
+		return screenSource{}, cliErrorf(exitUsage, "%w", err)
+	}
+	capturer, method, err := openCapturer(opts.capture, display)
+	if err != nil {
+		return screenSource{}, cliErrorf(exitCapture, "initializing screen capture: %w", err)
+	}
+	logger.Printf("capture: %s (%s)", method, displayLabel(display))
+	return screenSource{capturer: capturer, pipeline: pipeline}, nil
+}
+
+// streamLoop streams with a stream engine until ctx is done, the stream
+// gives up reconnecting, or it is put on hold because another application
+// took over the area; then the error is a *TakeoverError. Errors are logged
+// when they start and when they clear, not on every frame. If started is not
+// nil, it is called once after the first frame was sent.
+func streamLoop(ctx context.Context, logger *log.Logger, s *streamSupervisor, src colorSource, delay time.Duration, rate int, started func()) error {
+	var lastErr string
+	held := make(chan error, 1)
+	e := startStreamEngine(s, src, delay, rate, func(st engineStatus) {
+		if st.Link.Held != nil {
+			select {
+			case held <- st.Link.Held:
+			default:
+			}
+			return
+		}
+		err := st.CaptureErr
+		if st.SendErr != nil {
+			err = st.SendErr
+		}
+		switch {
+		case err != nil && err.Error() != lastErr:
+			logger.Printf("stream error: %v", err)
+			lastErr = err.Error()
+		case err == nil && lastErr != "":
+			logger.Printf("stream recovered")
+			lastErr = ""
+		}
+		if st.Sent > 0 && started != nil {
+			started()
+			started = nil
+		}
+	})
+
+	select {
+	case <-ctx.Done():
+		e.Stop()
+		return nil
+	case err := <-held:
+		e.Stop()
+		return err
+	case <-e.Done():
+		return e.Err()
+	}
+}
+
+// probeBridge returns the bridge at host, which must match query unless
+// that is empty.
+func probeBridge(ctx context.Context, host, query string) (Bridge, error) {
+	b, err := ProbeBridge(ctx, host)
+	if err != nil {
+		return Bridge{}, cliErrorf(exitNoBridge, "no Hue bridge at %s: %w", host, certificateHint(err))
+	}
+	if query != "" && !bridgeMatches(b, query) {
+		return Bridge{}, cliErrorf(exitNoBridge, "the bridge at %s is %s, not %s", host, b.ID, query)
+	}
+	return b, nil
+}
+
+// findBridge returns the bridge whose ID or IP matches query or, with an
+// empty query, the bridge used last. It tries the bridge's last address
+// first; if the bridge is not there, it discovers bridges via mDNS, and SSDP
+// if ssdp is set. Then an empty query selects the only bridge found.
+func findBridge(ctx context.Context, query string, ssdp bool) (Bridge, error) {
+	b, err := probeKnownBridge(ctx, query)
+	if err == nil {
+		return b, nil
+	}
+	if !errors.Is(err, errNoKnownBridge) {
+		return Bridge{}, certificateHint(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	bridgeCh, errCh := discoverAll(ctx, ssdp)
+	var bridges []Bridge
+	for b := range bridgeCh {
+		expectBridge(b)
+		if query != "" && bridgeMatches(b, query) {
+			cancel()
+			for range bridgeCh {
+			}
+			return b, nil
+		}
+		bridges = append(bridges, b)
+	}
+	if err := <-errCh; err != nil {
+		return Bridge{}, cliErrorf(exitNoBridge, "%w", err)
+	}
+
+	switch {
+	case query != "":
+		return Bridge{}, cliErrorf(exitNoBridge, "bridge %q not found on the network", query)
+	case len(bridges) == 0:
+		return Bridge{}, cliErrorf(exitNoBridge, "no Hue bridges found on the network; give the bridge's address with --bridge-ip")
+	case len(bridges) > 1:
+		return Bridge{}, cliErrorf(exitUsage, "found %d bridges, choose one with --bridge", len(bridges))
+	}
+	return bridges[0], nil
+}
+
+func bridgeMatches(b Bridge, query string) bool {
+	if strings.EqualFold(b.ID, query) {
+		return true
+	}
+	ip := net.ParseIP(query)
+	return ip != nil && ip.Equal(b.IP)
+}
+
+// findArea returns the area whose ID or name (case-insensitive) matches
+// query. With an empty query the only area is returned.
+func findArea(areas []EntertainmentArea, query string) (EntertainmentArea, error) {
+	if len(areas) == 0 {
+		return EntertainmentArea{}, cliErrorf(exitNoArea, "no entertainment areas configured on this bridge; create one with huesync area create")
+	}
+	if query == "" {
+		if len(areas) > 1 {
+			return EntertainmentArea{}, cliErrorf(exitUsage, "found %d entertainment areas, choose one with --area", len(areas))
+		}
+		return areas[0], nil
+	}
+	for _, a := range areas {
+		if a.ID == query || strings.EqualFold(a.Name, query) {
+			return a, nil
+		}
+	}
+	return EntertainmentArea{}, cliErrorf(exitNoArea, "entertainment area %q not found", query)
+}
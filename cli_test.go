@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFindArea(t *testing.T) {
+	areas := []EntertainmentArea{
+		{ID: "id-tv", Name: "TV"},
+		{ID: "id-desk", Name: "Desk"},
+	}
+
+	got, err := findArea(areas, "desk")
+	if err != nil || got.ID != "id-desk" {
+		t.Errorf("by name: got %+v, %v", got, err)
+	}
+
+	got, err = findArea(areas, "id-tv")
+	if err != nil || got.ID != "id-tv" {
+		t.Errorf("by ID: got %+v, %v", got, err)
+	}
+
+	if _, err := findArea(areas, "Kitchen"); exitCode(err) != exitNoArea {
+		t.Errorf("unknown area: expected exit code %d, got %d (%v)", exitNoArea, exitCode(err), err)
+	}
+
+	if _, err := findArea(areas, ""); exitCode(err) != exitUsage {
+		t.Errorf("ambiguous area: expected exit code %d, got %d (%v)", exitUsage, exitCode(err), err)
+	}
+
+	if _, err := findArea(nil, ""); exitCode(err) != exitNoArea {
+		t.Errorf("no areas: expected exit code %d, got %d (%v)", exitNoArea, exitCode(err), err)
+	}
+
+	got, err = findArea(areas[:1], "")
+	if err != nil || got.ID != "id-tv" {
+		t.Errorf("single area: got %+v, %v", got, err)
+	}
+}
+
+func TestBridgeMatches(t *testing.T) {
+	b := Bridge{ID: "001788FFFE123456", IP: net.ParseIP("192.168.1.20")}
+	for _, q := range []string{"001788fffe123456", "192.168.1.20"} {
+		if !bridgeMatches(b, q) {
+			t.Errorf("expected %q to match %v", q, b)
+		}
+	}
+	for _, q := range []string{"192.168.1.21", "other"} {
+		if bridgeMatches(b, q) {
+			t.Errorf("expected %q not to match %v", q, b)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if exitCode(nil) != exitOK {
+		t.Error("nil error should exit OK")
+	}
+	if exitCode(errors.New("boom")) != exitError {
+		t.Error("plain error should exit with exitError")
+	}
+	wrapped := cliErrorf(exitCapture, "capture: %w", errors.New("no display"))
+	if exitCode(wrapped) != exitCapture {
+		t.Errorf("expected exit code %d, got %d", exitCapture, exitCode(wrapped))
+	}
+}
+
+func TestRunStream_UsageErrors(t *testing.T) {
+	setupConfigDir(t, "")
+	for _, args := range [][]string{
+		{"--delay", "0"},
+		{"--capture", "vnc"},
+		{"--color", "median"},
+		{"extra"},
+	} {
+		if code := runStream(args, io.Discard); code != exitUsage {
+			t.Errorf("runStream(%v): expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestStreamHeadless_MockBridge(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	logger := log.New(io.Discard, "", 0)
+
+	opts := defaultOptions()
+	opts.delay = 20 * time.Millisecond
+	if err := streamHeadless(context.Background(), logger, opts); exitCode(err) != exitUnpaired {
+		t.Fatalf("expected exit code %d without credentials, got %v", exitUnpaired, err)
+	}
+
+	clientkey := "0123456789abcdef0123456789abcdef"
+	bridge.AddUser("user", clientkey)
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: clientkey}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- streamHeadless(ctx, logger, opts) }()
+	frames := waitForFrames(t, bridge, 5)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("streamHeadless: %v", err)
+	}
+
+	if f := frames[len(frames)-1]; f.AreaID != bridge.Area().ID || len(f.Channels) != 2 {
+		t.Errorf("unexpected frame %+v", f)
+	}
+	if got := bridge.Area().Status; got != "inactive" {
+		t.Errorf("expected the area to be inactive, got %q", got)
+	}
+}
+
+func TestStreamHeadless_Effect(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, nil)
+	clientkey := "0123456789abcdef0123456789abcdef"
+	bridge.AddUser("user", clientkey)
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: clientkey}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.effect = "bias"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- streamHeadless(ctx, log.New(io.Discard, "", 0), opts) }()
+	frames := waitForFrames(t, bridge, 5)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("streamHeadless: %v", err)
+	}
+
+	white := [3]uint16{0xffff, 0xffff, 0xffff}
+	for _, ch := range frames[len(frames)-1].Channels {
+		if ch.Values != white {
+			t.Errorf("expected white on channel %d, got %v", ch.ID, ch.Values)
+		}
+	}
+}
+
+func TestStreamHeadless_AudioBlend(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	audio := &fakeAudioCapture{samples: samples, rate: rate, hop: rate / 50}
+	useAudioCapture(t, audio)
+	clientkey := "0123456789abcdef0123456789abcdef"
+	bridge.AddUser("user", clientkey)
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: clientkey}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.effect = effectAudio
+	opts.audioBlend = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- streamHeadless(ctx, log.New(io.Discard, "", 0), opts) }()
+	frames := waitForFrames(t, bridge, 5)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("streamHeadless: %v", err)
+	}
+	if !audio.Closed() {
+		t.Error("expected the audio capture to be closed")
+	}
+
+	// At full blend the audio sets only the brightness of the red screen.
+	for _, ch := range frames[len(frames)-1].Channels {
+		if ch.Values[0] == 0 || ch.Values[1] != 0 || ch.Values[2] != 0 {
+			t.Errorf("expected red on channel %d, got %v", ch.ID, ch.Values)
+		}
+	}
+}
+
+func TestStreamHeadless_Takeover(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	clientkey := "0123456789abcdef0123456789abcdef"
+	bridge.AddUser("user", clientkey)
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: clientkey}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.delay = 20 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- streamHeadless(context.Background(), log.New(io.Discard, "", 0), opts) }()
+	waitForFrames(t, bridge, 5)
+	bridge.TakeOver("Hue Sync#DESKTOP")
+
+	select {
+	case err := <-done:
+		if exitCode(err) != exitTakeover {
+			t.Fatalf("expected exit code %d, got %v", exitTakeover, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for streamHeadless to give up the area")
+	}
+	// The area is left to the other application.
+	if got := bridge.Area().Status; got != "active" {
+		t.Errorf("expected the area to stay active, got %q", got)
+	}
+}
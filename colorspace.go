@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// averagingSpace selects the color space in which pixel colors are averaged.
+type averagingSpace int
+
+const (
+	// spaceLinear averages physical light intensities: sRGB values are
+	// decoded to linear light, averaged and re-encoded. A half-white,
+	// half-black screen averages to the grey that emits the same light.
+	spaceLinear averagingSpace = iota
+	// spaceOKLab averages in the perceptually uniform OKLab space, so the
+	// result sits halfway between the inputs as the eye sees them.
+	spaceOKLab
+)
+
+func (s averagingSpace) String() string {
+	switch s {
+	case spaceLinear:
+		return "linear"
+	case spaceOKLab:
+		return "oklab"
+	}
+	return fmt.Sprintf("averagingSpace(%d)", int(s))
+}
+
+// parseAveragingSpace parses an averaging space name.
+func parseAveragingSpace(s string) (averagingSpace, error) {
+	switch s {
+	case "linear":
+		return spaceLinear, nil
+	case "oklab":
+		return spaceOKLab, nil
+	}
+	return 0, fmt.Errorf("unknown averaging space %q (want linear, oklab)", s)
+}
+
+var (
+	// srgbToLinear decodes an 8-bit sRGB value to linear light in 0..1.
+	srgbToLinear [256]float64
+	// linearToSRGB encodes linear light, quantized to 16 bits, to 8-bit sRGB.
+	linearToSRGB [1 << 16]uint8
+)
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			srgbToLinear[i] = v / 12.92
+		} else {
+			srgbToLinear[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	for i := range linearToSRGB {
+		v := float64(i) / 65535
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		linearToSRGB[i] = uint8(v*255 + 0.5)
+	}
+}
+
+// encodeSRGB converts linear light in 0..1 to an 8-bit sRGB value.
+func encodeSRGB(v float64) uint8 {
+	return linearToSRGB[uint16(min(max(v, 0), 1)*65535+0.5)]
+}
+
+// colorAccumulator averages pixel colors in an averagingSpace.
+type colorAccumulator struct {
+	space averagingSpace
+	sum   [3]float64
+	n     int
+}
+
+// Add adds one sRGB pixel to the average.
+func (a *colorAccumulator) Add(r, g, b uint8) {
+	lr, lg, lb := srgbToLinear[r], srgbToLinear[g], srgbToLinear[b]
+	if a.space == spaceOKLab {
+		lr, lg, lb = linearToOKLab(lr, lg, lb)
+	}
+	a.sum[0] += lr
+	a.sum[1] += lg
+	a.sum[2] += lb
+	a.n++
+}
+
+// Mean returns the average of the added pixels, or black if there are none.
+func (a *colorAccumulator) Mean() RGB {
+	if a.n == 0 {
+		return RGB{}
+	}
+	n := float64(a.n)
+	x, y, z := a.sum[0]/n, a.sum[1]/n, a.sum[2]/n
+	if a.space == spaceOKLab {
+		x, y, z = okLabToLinear(x, y, z)
+	}
+	return RGB{R: encodeSRGB(x), G: encodeSRGB(y), B: encodeSRGB(z)}
+}
+
+// linearToOKLab converts linear sRGB to OKLab (Björn Ottosson, 2020).
+func linearToOKLab(r, g, b float64) (L, A, B float64) {
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+}
+
+// okLabToLinear converts OKLab back to linear sRGB.
+func okLabToLinear(L, A, B float64) (r, g, b float64) {
+	l := L + 0.3963377774*A + 0.2158037573*B
+	m := L - 0.1055613458*A - 0.0638541728*B
+	s := L - 0.0894841775*A - 1.2914855480*B
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return 4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0042076894*l - 0.7034186147*m + 1.7076147010*s
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestColorAccumulator_RoundTrip(t *testing.T) {
+	// A uniform color must average to itself in every space.
+	for _, space := range []averagingSpace{spaceLinear, spaceOKLab} {
+		for v := 0; v < 256; v++ {
+			for _, c := range []RGB{
+				{R: uint8(v), G: uint8(v), B: uint8(v)},
+				{R: uint8(v)},
+				{G: uint8(v)},
+				{B: uint8(v)},
+			} {
+				acc := colorAccumulator{space: space}
+				acc.Add(c.R, c.G, c.B)
+				acc.Add(c.R, c.G, c.B)
+				if got := acc.Mean(); got != c {
+					t.Fatalf("%s: expected %v to round-trip, got %v", space, c, got)
+				}
+			}
+		}
+	}
+}
+
+func TestColorAccumulator_Golden(t *testing.T) {
+	tests := []struct {
+		name   string
+		space  averagingSpace
+		pixels []RGB
+		want   RGB
+	}{
+		{"linear uniform", spaceLinear, []RGB{{200, 100, 50}, {200, 100, 50}}, RGB{200, 100, 50}},
+		{"linear black and white", spaceLinear, []RGB{{0, 0, 0}, {255, 255, 255}}, RGB{188, 188, 188}},
+		{"linear red and blue", spaceLinear, []RGB{{255, 0, 0}, {0, 0, 255}}, RGB{188, 0, 188}},
+		{"oklab uniform", spaceOKLab, []RGB{{200, 100, 50}, {200, 100, 50}}, RGB{200, 100, 50}},
+		{"oklab black and white", spaceOKLab, []RGB{{0, 0, 0}, {255, 255, 255}}, RGB{99, 99, 99}},
+		{"oklab red and blue", spaceOKLab, []RGB{{255, 0, 0}, {0, 0, 255}}, RGB{140, 83, 162}},
+	}
+	for _, tt := range tests {
+		acc := colorAccumulator{space: tt.space}
+		for _, p := range tt.pixels {
+			acc.Add(p.R, p.G, p.B)
+		}
+		if got := acc.Mean(); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestColorAccumulator_FullFrame(t *testing.T) {
+	// A full 64x36 frame of solid red averages to solid red in every space.
+	for _, space := range []averagingSpace{spaceLinear, spaceOKLab} {
+		acc := colorAccumulator{space: space}
+		for i := 0; i < captureWidth*captureHeight; i++ {
+			acc.Add(255, 0, 0)
+		}
+		if got := acc.Mean(); got != (RGB{R: 255}) {
+			t.Errorf("%s: expected RGB{255, 0, 0}, got %v", space, got)
+		}
+	}
+}
+
+func TestColorAccumulator_Empty(t *testing.T) {
+	acc := colorAccumulator{space: spaceOKLab}
+	if got := acc.Mean(); got != (RGB{}) {
+		t.Errorf("expected RGB{0, 0, 0}, got %v", got)
+	}
+}
+
+func TestParseAveragingSpace(t *testing.T) {
+	for _, space := range []averagingSpace{spaceLinear, spaceOKLab} {
+		got, err := parseAveragingSpace(space.String())
+		if err != nil || got != space {
+			t.Errorf("parseAveragingSpace(%q) = %v, %v", space.String(), got, err)
+		}
+	}
+	if _, err := parseAveragingSpace("srgb"); err == nil {
+		t.Error("expected error for unknown space")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the persistent huesync configuration. It never holds secrets;
+// bridge credentials stay in the credentials file.
+type Config struct {
+	// Profile names the profile used when --profile is not given.
+	Profile string `json:"profile,omitempty"`
+	Settings
+	Profiles map[string]Settings `json:"profiles,omitempty"`
+}
+
+// Settings are the defaults that the config file and each profile can set.
+// Empty fields leave the value from the layer below unchanged.
+type Settings struct {
+	Bridge           string   `json:"bridge,omitempty"`
+	BridgeIP         string   `json:"bridge_ip,omitempty"`
+	SSDP             *bool    `json:"ssdp,omitempty"`
+	Area             string   `json:"area,omitempty"`
+	DelayMS          int      `json:"delay_ms,omitempty"`
+	Capture          string   `json:"capture,omitempty"`
+	Display          string   `json:"display,omitempty"`
+	ColorMode        string   `json:"color_mode,omitempty"`
+	Average          string   `json:"average,omitempty"`
+	ColorSpace       string   `json:"color_space,omitempty"`
+	SmoothingMS      *int     `json:"smoothing_ms,omitempty"`
+	SnapThreshold    *float64 `json:"snap_threshold,omitempty"`
+	Restore          *bool    `json:"restore,omitempty"`
+	RestoreFadeMS    *int     `json:"restore_fade_ms,omitempty"`
+	Reconnect        *int     `json:"reconnect_attempts,omitempty"`
+	SendRate         int      `json:"send_rate_hz,omitempty"`
+	Effect           string   `json:"effect,omitempty"`
+	EffectSpeed      float64  `json:"effect_speed,omitempty"`
+	EffectIntensity  *int     `json:"effect_intensity,omitempty"`
+	EffectPalette    []string `json:"effect_palette,omitempty"`
+	AudioSource      string   `json:"audio_source,omitempty"`
+	AudioCapture     string   `json:"audio_capture,omitempty"`
+	AudioSensitivity int      `json:"audio_sensitivity,omitempty"`
+	AudioBlend       *int     `json:"audio_blend,omitempty"`
+}
+
+// configDir overrides the default config directory for testing.
+// When empty, the user's config directory is used.
+var configDir string
+
+func configPath() (string, error) {
+	if configDir != "" {
+		return filepath.Join(configDir, "config.json"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "huesync", "config.json"), nil
+}
+
+// LoadConfig reads the config file. A missing file yields an empty config.
+func LoadConfig() (Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return Config{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Config{}, nil
+		}
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ProfileNames returns the configured profile names in sorted order.
+func (c Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// apply layers the top-level settings and then the named profile onto o.
+func (c Config) apply(o *options, profile string) error {
+	if err := c.Settings.apply(o); err != nil {
+		return err
+	}
+	if profile == "" {
+		return nil
+	}
+	s, ok := c.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", profile)
+	}
+	if err := s.apply(o); err != nil {
+		return fmt.Errorf("profile %q: %w", profile, err)
+	}
+	o.profile = profile
+	return nil
+}
+
+func (s Settings) apply(o *options) error {
+	if s.Bridge != "" {
+		o.bridge = s.Bridge
+	}
+	if s.BridgeIP != "" {
+		o.bridgeIP = s.BridgeIP
+	}
+	if s.SSDP != nil {
+		o.ssdp = *s.SSDP
+	}
+	if s.Area != "" {
+		o.area = s.Area
+	}
+	if s.DelayMS < 0 {
+		return fmt.Errorf("invalid delay_ms %d", s.DelayMS)
+	}
+	if s.DelayMS > 0 {
+		o.delay = time.Duration(s.DelayMS) * time.Millisecond
+		o.delaySet = true
+	}
+	if s.Capture != "" {
+		if !slices.Contains(captureBackends, s.Capture) {
+			return fmt.Errorf("unknown capture backend %q", s.Capture)
+		}
+		o.capture = s.Capture
+	}
+	if s.Display != "" {
+		d, err := parseDisplay(s.Display)
+		if err != nil {
+			return err
+		}
+		o.display = d
+		o.displaySet = true
+	}
+	if s.Average != "" {
+		space, err := parseAveragingSpace(s.Average)
+		if err != nil {
+			return err
+		}
+		o.average = space
+	}
+	if s.ColorMode != "" {
+		if _, err := newColorExtractor(s.ColorMode, o.average); err != nil {
+			return err
+		}
+		o.colorMode = s.ColorMode
+	}
+	if s.ColorSpace != "" {
+		cs, err := parseColorSpace(s.ColorSpace)
+		if err != nil {
+			return err
+		}
+		o.colorSpace = cs
+	}
+	if s.SmoothingMS != nil {
+		if *s.SmoothingMS < 0 {
+			return fmt.Errorf("invalid smoothing_ms %d", *s.SmoothingMS)
+		}
+		o.smoothing = time.Duration(*s.SmoothingMS) * time.Millisecond
+	}
+	if s.SnapThreshold != nil {
+		o.snapThreshold = *s.SnapThreshold
+	}
+	if s.Restore != nil {
+		o.restore = *s.Restore
+	}
+	if s.RestoreFadeMS != nil {
+		if *s.RestoreFadeMS < 0 {
+			return fmt.Errorf("invalid restore_fade_ms %d", *s.RestoreFadeMS)
+		}
+		o.restoreFade = time.Duration(*s.RestoreFadeMS) * time.Millisecond
+	}
+	if s.SendRate != 0 {
+		if s.SendRate < 1 || s.SendRate > maxSendRate {
+			return fmt.Errorf("invalid send_rate_hz %d", s.SendRate)
+		}
+		o.sendRate = s.SendRate
+	}
+	if s.Reconnect != nil {
+		if *s.Reconnect < 0 {
+			return fmt.Errorf("invalid reconnect_attempts %d", *s.Reconnect)
+		}
+		o.reconnect = *s.Reconnect
+	}
+	if s.Effect != "" {
+		effect, err := parseEffect(s.Effect)
+		if err != nil {
+			return err
+		}
+		o.effect = effect
+	}
+	if s.EffectSpeed < 0 {
+		return fmt.Errorf("invalid effect_speed %g", s.EffectSpeed)
+	}
+	if s.EffectSpeed > 0 {
+		o.effectParams.Speed = s.EffectSpeed
+	}
+	if s.EffectIntensity != nil {
+		if *s.EffectIntensity < 0 || *s.EffectIntensity > 100 {
+			return fmt.Errorf("invalid effect_intensity %d", *s.EffectIntensity)
+		}
+		o.effectParams.Intensity = float64(*s.EffectIntensity) / 100
+	}
+	if len(s.EffectPalette) > 0 {
+		palette, err := parsePalette(strings.Join(s.EffectPalette, ","))
+		if err != nil {
+			return err
+		}
+		o.effectParams.Palette = palette
+	}
+	if s.AudioSource != "" {
+		o.audioSource = s.AudioSource
+	}
+	if s.AudioCapture != "" {
+		if !slices.Contains(audioBackends, s.AudioCapture) {
+			return fmt.Errorf("unknown audio capture backend %q", s.AudioCapture)
+		}
+		o.audioCapture = s.AudioCapture
+	}
+	if s.AudioSensitivity < 0 {
+		return fmt.Errorf("invalid audio_sensitivity %d", s.AudioSensitivity)
+	}
+	if s.AudioSensitivity > 0 {
+		o.audioSensitivity = float64(s.AudioSensitivity) / 100
+	}
+	if s.AudioBlend != nil {
+		if *s.AudioBlend < 0 || *s.AudioBlend > 100 {
+			return fmt.Errorf("invalid audio_blend %d", *s.AudioBlend)
+		}
+		o.audioBlend = float64(*s.AudioBlend) / 100
+	}
+	return nil
+}
+
+// usageError is returned for invalid command-line flags. The flag package has
+// already reported it, together with the usage message.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// optionParser resolves the effective options of a command from, in
+// increasing precedence, the defaults, the config file, a profile and the
+// command-line flags.
+type optionParser struct {
+	name   string
+	args   []string
+	output io.Writer
+	config Config
+	extra  func(fs *flag.FlagSet)
+}
+
+// newOptionParser loads the config file and parses args. extra, if not nil,
+// registers command-specific flags that are not part of options.
+func newOptionParser(name string, args []string, output io.Writer, extra func(fs *flag.FlagSet)) (*optionParser, options, error) {
+	p := &optionParser{name: name, args: args, output: output, extra: extra}
+
+	// A first pass finds --profile and reports usage errors.
+	first := defaultOptions()
+	if err := p.flagSet(&first, output).Parse(args); err != nil {
+		return nil, options{}, &usageError{err: err}
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, options{}, fmt.Errorf("loading config: %w", err)
+	}
+	p.config = cfg
+
+	profile := first.profile
+	if profile == "" {
+		profile = cfg.Profile
+	}
+	opts, err := p.withProfile(profile)
+	if err != nil {
+		return nil, options{}, err
+	}
+	return p, opts, nil
+}
+
+// withProfile resolves the options again for the given profile. Flags given
+// on the command line still take precedence.
+func (p *optionParser) withProfile(profile string) (options, error) {
+	opts := defaultOptions()
+	if err := p.config.apply(&opts, profile); err != nil {
+		return options{}, fmt.Errorf("config: %w", err)
+	}
+	fs := p.flagSet(&opts, io.Discard)
+	if err := fs.Parse(p.args); err != nil {
+		return options{}, err
+	}
+	if opts.profile == "" {
+		opts.profile = profile
+	}
+	return opts, nil
+}
+
+// Args returns the non-flag arguments.
+func (p *optionParser) Args() []string {
+	fs := p.flagSet(new(options), io.Discard)
+	_ = fs.Parse(p.args)
+	return fs.Args()
+}
+
+func (p *optionParser) flagSet(o *options, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(p.name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	o.register(fs)
+	if p.extra != nil {
+		p.extra(fs)
+	}
+	return fs
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func setupConfigDir(t *testing.T, content string) {
+	t.Helper()
+	configDir = t.TempDir()
+	t.Cleanup(func() { configDir = "" })
+	if content != "" {
+		if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(content), 0600); err != nil {
+			t.Fatalf("writing config: %v", err)
+		}
+	}
+}
+
+const testConfig = `{
+	"bridge": "001788fffe123456",
+	"delay_ms": 80,
+	"color_mode": "dominant",
+	"profiles": {
+		"movie": {"area": "TV", "delay_ms": 40, "smoothing_ms": 300, "average": "oklab"},
+		"gaming": {"smoothing_ms": 0, "color_mode": "kmeans"},
+		"desktop": {"display": "span"}
+	}
+}`
+
+func TestLoadConfig_Missing(t *testing.T) {
+	setupConfigDir(t, "")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Profile != "" || len(cfg.Profiles) != 0 {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	setupConfigDir(t, "{not json")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+}
+
+func TestConfig_ProfileNames(t *testing.T) {
+	setupConfigDir(t, testConfig)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := []string{"desktop", "gaming", "movie"}
+	if got := cfg.ProfileNames(); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestOptionParser_Precedence(t *testing.T) {
+	setupConfigDir(t, testConfig)
+
+	// Top-level settings apply without a profile.
+	_, opts, err := newOptionParser("test", nil, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if opts.bridge != "001788fffe123456" || opts.delay != 80*time.Millisecond || opts.colorMode != "dominant" {
+		t.Errorf("top-level settings not applied: %+v", opts)
+	}
+	if !opts.delaySet {
+		t.Error("configured delay should count as set")
+	}
+
+	// A profile overrides the top level, flags override the profile.
+	_, opts, err = newOptionParser("test", []string{"--profile", "movie", "--delay", "25"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if opts.profile != "movie" || opts.area != "TV" {
+		t.Errorf("profile not applied: %+v", opts)
+	}
+	if opts.smoothing != 300*time.Millisecond || opts.average != spaceOKLab {
+		t.Errorf("profile settings not applied: %+v", opts)
+	}
+	if opts.delay != 25*time.Millisecond {
+		t.Errorf("expected --delay to override the profile, got %v", opts.delay)
+	}
+	if opts.colorMode != "dominant" {
+		t.Errorf("expected top-level color mode to remain, got %q", opts.colorMode)
+	}
+}
+
+func TestOptionParser_DefaultProfile(t *testing.T) {
+	setupConfigDir(t, `{"profile": "gaming", "profiles": {"gaming": {"smoothing_ms": 0}}}`)
+	_, opts, err := newOptionParser("test", nil, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if opts.profile != "gaming" || opts.smoothing != 0 {
+		t.Errorf("default profile not applied: %+v", opts)
+	}
+}
+
+func TestOptionParser_Restore(t *testing.T) {
+	setupConfigDir(t, `{"restore_fade_ms": 400, "profiles": {"party": {"restore": false}}}`)
+	_, opts, err := newOptionParser("test", nil, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if !opts.restore || opts.restoreFade != 400*time.Millisecond {
+		t.Errorf("expected restore with 400ms fade, got %+v", opts)
+	}
+
+	_, opts, err = newOptionParser("test", []string{"--profile", "party"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if opts.restore {
+		t.Error("expected profile to disable restore")
+	}
+
+	_, opts, err = newOptionParser("test", []string{"--profile", "party", "--restore"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if !opts.restore {
+		t.Error("expected --restore to override the profile")
+	}
+}
+
+func TestOptionParser_WithProfile(t *testing.T) {
+	setupConfigDir(t, testConfig)
+	p, _, err := newOptionParser("test", []string{"--color", "mean"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+
+	opts, err := p.withProfile("desktop")
+	if err != nil {
+		t.Fatalf("withProfile: %v", err)
+	}
+	if opts.display != spanDisplays || !opts.displaySet {
+		t.Errorf("expected span display from profile, got %+v", opts)
+	}
+	if opts.colorMode != "mean" {
+		t.Errorf("expected --color to still apply, got %q", opts.colorMode)
+	}
+}
+
+func TestOptionParser_Errors(t *testing.T) {
+	setupConfigDir(t, testConfig)
+	if _, _, err := newOptionParser("test", []string{"--profile", "party"}, io.Discard, nil); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+
+	var ue *usageError
+	if _, _, err := newOptionParser("test", []string{"--delay", "x"}, io.Discard, nil); !errors.As(err, &ue) {
+		t.Errorf("expected usage error for bad flag, got %v", err)
+	}
+
+	setupConfigDir(t, `{"capture": "vnc"}`)
+	if _, _, err := newOptionParser("test", nil, io.Discard, nil); err == nil {
+		t.Error("expected error for invalid config value")
+	}
+}
+
+func TestOptionParser_Effect(t *testing.T) {
+	setupConfigDir(t, `{"effect": "candle", "effect_intensity": 40, "effect_palette": ["#ff0000", "#00ff00"]}`)
+	_, opts, err := newOptionParser("test", []string{"--effect-speed", "0.5"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	p := opts.effectParams
+	if opts.effect != "candle" || p.Speed != 0.5 || p.Intensity != 0.4 || len(p.Palette) != 2 {
+		t.Errorf("unexpected effect options %q %+v", opts.effect, p)
+	}
+
+	for _, args := range [][]string{
+		{"--effect", "strobe"},
+		{"--effect-speed", "0"},
+		{"--effect-intensity", "101"},
+		{"--effect-palette", "#12"},
+	} {
+		if _, _, err := newOptionParser("test", args, io.Discard, nil); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestOptionParser_Audio(t *testing.T) {
+	setupConfigDir(t, `{"effect": "audio", "audio_source": "alsa_output.monitor", "audio_sensitivity": 150, "audio_blend": 30}`)
+	_, opts, err := newOptionParser("test", []string{"--audio-capture", "gstreamer"}, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("newOptionParser: %v", err)
+	}
+	if opts.effect != effectAudio || opts.audioSource != "alsa_output.monitor" || opts.audioCapture != "gstreamer" ||
+		opts.audioSensitivity != 1.5 || opts.audioBlend != 0.3 {
+		t.Errorf("unexpected audio options %+v", opts)
+	}
+
+	for _, args := range [][]string{
+		{"--audio-capture", "alsa"},
+		{"--audio-sensitivity", "0"},
+		{"--audio-blend", "101"},
+	} {
+		if _, _, err := newOptionParser("test", args, io.Discard, nil); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // BridgeCredentials holds the API credentials for a paired Hue bridge.
@@ -12,99 +15,291 @@ type BridgeCredentials struct {
 	Clientkey string `json:"clientkey"`
 }
 
-// credentialsDir overrides the default credentials directory for testing.
-// When empty, the user's home directory is used.
-var credentialsDir string
+// credentialStore keeps the credentials of paired bridges by bridge ID.
+type credentialStore interface {
+	// Load returns false with no error if there are no credentials for the
+	// bridge.
+	Load(bridgeID string) (BridgeCredentials, bool, error)
+	Save(bridgeID string, creds BridgeCredentials) error
+	Delete(bridgeID string) error
+	// All returns the credentials of every bridge.
+	All() (map[string]BridgeCredentials, error)
+	Close() error
+}
 
-func credentialsPath() (string, error) {
-	if credentialsDir != "" {
-		return filepath.Join(credentialsDir, "credentials.json"), nil
+// openCredentialStore opens the desktop keyring, moving the credentials of
+// the credentials file into it. Without a keyring, as on headless machines,
+// or while the file cannot be moved, the file is used, and warning says why.
+// warning also reports a damaged credentials file that was moved aside.
+func openCredentialStore() (store credentialStore, warning, err error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, nil, err
 	}
-	home, err := os.UserHomeDir()
+	file := &fileStore{path: path}
+
+	keyring, err := openSecretService()
 	if err != nil {
-		return "", err
+		return file, fmt.Errorf("the desktop keyring is not available (%v); credentials are kept unencrypted in %s", err, path), nil
 	}
-	return filepath.Join(home, ".huesync", "credentials.json"), nil
+	err = migrateCredentials(file, keyring)
+	var corrupt *corruptFileError
+	switch {
+	case errors.As(err, &corrupt):
+		return keyring, err, nil
+	case err != nil:
+		keyring.Close()
+		return file, fmt.Errorf("the credentials in %s could not be moved to the desktop keyring (%v); they are kept there unencrypted", path, err), nil
+	}
+	return keyring, nil, nil
 }
 
-func readAllCredentials(path string) (map[string]BridgeCredentials, error) {
-	data, err := os.ReadFile(path)
+// credentialStoreWarning tells why credentials are kept in the plaintext
+// file rather than the keyring, and about a damaged credentials file that
+// was moved aside. It returns nil if all is well.
+func credentialStoreWarning() error {
+	store, warning, err := openCredentialStore()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var creds map[string]BridgeCredentials
-	if err := json.Unmarshal(data, &creds); err != nil {
-		return nil, err
+	defer store.Close()
+	var corrupt *corruptFileError
+	if _, err := store.All(); errors.As(err, &corrupt) {
+		warning = errors.Join(warning, err)
+	}
+	return warning
+}
+
+// migrateCredentials copies the credentials in the file to the keyring and
+// then removes the file. A damaged file is only moved aside and reported as
+// a *corruptFileError.
+func migrateCredentials(file *fileStore, keyring credentialStore) error {
+	err := file.locked(false, func() error {
+		all, err := file.read()
+		if err != nil || len(all) == 0 {
+			return err
+		}
+		for id, creds := range all {
+			if err := keyring.Save(id, creds); err != nil {
+				return fmt.Errorf("moving the credentials of bridge %s to the keyring: %w", id, err)
+			}
+		}
+		return os.Remove(file.path)
+	})
+	if os.IsNotExist(err) {
+		return nil
 	}
-	return creds, nil
+	return err
 }
 
 // LoadCredentials loads the stored credentials for the given bridge ID.
 // Returns false with no error if no credentials are found.
 func LoadCredentials(bridgeID string) (BridgeCredentials, bool, error) {
-	path, err := credentialsPath()
+	store, _, err := openCredentialStore()
 	if err != nil {
 		return BridgeCredentials{}, false, err
 	}
+	defer store.Close()
+	return store.Load(bridgeID)
+}
 
-	creds, err := readAllCredentials(path)
+// SaveCredentials persists the credentials for the given bridge ID.
+func SaveCredentials(bridgeID string, creds BridgeCredentials) error {
+	store, _, err := openCredentialStore()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return BridgeCredentials{}, false, nil
-		}
-		return BridgeCredentials{}, false, err
+		return err
 	}
+	defer store.Close()
+	return store.Save(bridgeID, creds)
+}
 
-	bc, ok := creds[bridgeID]
-	if !ok {
-		return BridgeCredentials{}, false, nil
+// DeleteCredentials removes the stored credentials for the given bridge ID.
+func DeleteCredentials(bridgeID string) error {
+	store, _, err := openCredentialStore()
+	if err != nil {
+		return err
 	}
-	return bc, true, nil
+	defer store.Close()
+	return store.Delete(bridgeID)
 }
 
-// SaveCredentials persists the credentials for the given bridge ID.
-// Creates the credentials directory with 0700 if needed.
-func SaveCredentials(bridgeID string, creds BridgeCredentials) error {
-	path, err := credentialsPath()
+// credentialsDir overrides the default credentials directory for testing.
+// When empty, the user's home directory is used.
+var credentialsDir string
+
+func credentialsPath() (string, error) {
+	if credentialsDir != "" {
+		return filepath.Join(credentialsDir, "credentials.json"), nil
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return "", err
 	}
+	return filepath.Join(home, ".huesync", "credentials.json"), nil
+}
+
+// fileStore keeps credentials in a JSON file readable only by the user.
+// Every access holds a lock on a file next to it, so that several huesync
+// instances can change it at once, and the file is replaced atomically.
+type fileStore struct {
+	path string
+}
+
+// corruptFileError reports a credentials file that could not be parsed and
+// was moved aside.
+type corruptFileError struct {
+	path, backup string
+	err          error
+}
+
+func (e *corruptFileError) Error() string {
+	return fmt.Sprintf("%s is damaged (%v); it was moved to %s", e.path, e.err, e.backup)
+}
+
+func (e *corruptFileError) Unwrap() error { return e.err }
+
+// locked runs fn while holding the lock; see withFileLock.
+func (s *fileStore) locked(create bool, fn func() error) error {
+	return withFileLock(s.path, create, fn)
+}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+// withFileLock runs fn while holding the lock on path, a file next to it
+// with ".lock" appended, for changes that read and replace the file. The
+// lock file is only created along with its directory if create is set;
+// otherwise a missing directory is reported as os.ErrNotExist.
+func withFileLock(path string, create bool, fn func() error) error {
+	if create {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
 		return err
 	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking %s: %w", path, err)
+	}
+	return fn()
+}
 
-	all, err := readAllCredentials(path)
-	if err != nil || all == nil {
+// read returns the stored credentials, or an empty map if there is no file.
+// A file that cannot be parsed is moved aside, so that it is neither lost
+// nor stands in the way, and reported as a *corruptFileError. The lock must
+// be held.
+func (s *fileStore) read() (map[string]BridgeCredentials, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return make(map[string]BridgeCredentials), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]BridgeCredentials
+	if err := json.Unmarshal(data, &all); err != nil {
+		backup := s.path + ".damaged-" + time.Now().Format("20060102-150405.000000000")
+		if rerr := os.Rename(s.path, backup); rerr != nil {
+			return nil, fmt.Errorf("%s is damaged (%v) and could not be moved aside: %w", s.path, err, rerr)
+		}
+		return nil, &corruptFileError{path: s.path, backup: backup, err: err}
+	}
+	if all == nil {
 		all = make(map[string]BridgeCredentials)
 	}
+	return all, nil
+}
 
-	all[bridgeID] = creds
-
+// write replaces the file with all. The lock must be held.
+func (s *fileStore) write(all map[string]BridgeCredentials) error {
 	data, err := json.MarshalIndent(all, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0600)
+	return writeFileAtomic(s.path, data)
 }
 
-// DeleteCredentials removes the stored credentials for the given bridge ID.
-func DeleteCredentials(bridgeID string) error {
-	path, err := credentialsPath()
+// update applies fn to the stored credentials and writes them back. A
+// damaged file, once moved aside, counts as empty.
+func (s *fileStore) update(fn func(map[string]BridgeCredentials)) error {
+	return s.locked(true, func() error {
+		all, err := s.read()
+		var corrupt *corruptFileError
+		if errors.As(err, &corrupt) {
+			all, err = make(map[string]BridgeCredentials), nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(all)
+		return s.write(all)
+	})
+}
+
+func (s *fileStore) Load(bridgeID string) (BridgeCredentials, bool, error) {
+	all, err := s.All()
 	if err != nil {
-		return err
+		return BridgeCredentials{}, false, err
 	}
+	bc, ok := all[bridgeID]
+	return bc, ok, nil
+}
 
-	all, err := readAllCredentials(path)
-	if err != nil {
+// Save creates the credentials directory with 0700 if needed.
+func (s *fileStore) Save(bridgeID string, creds BridgeCredentials) error {
+	return s.update(func(all map[string]BridgeCredentials) {
+		all[bridgeID] = creds
+	})
+}
+
+func (s *fileStore) Delete(bridgeID string) error {
+	return s.update(func(all map[string]BridgeCredentials) {
+		delete(all, bridgeID)
+	})
+}
+
+func (s *fileStore) All() (map[string]BridgeCredentials, error) {
+	var all map[string]BridgeCredentials
+	err := s.locked(false, func() error {
+		var err error
+		all, err = s.read()
 		return err
+	})
+	if os.IsNotExist(err) {
+		return make(map[string]BridgeCredentials), nil
 	}
+	return all, err
+}
 
-	delete(all, bridgeID)
+func (s *fileStore) Close() error { return nil }
 
-	data, err := json.MarshalIndent(all, "", "  ")
+// writeFileAtomic replaces the file at path with data, readable only by the
+// user. The data goes to a temporary file that is renamed over path, so a
+// crash leaves either the old or the new file, never a truncated one.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0600)
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	// Make sure the data is on disk before the rename makes it the file.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
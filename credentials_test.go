@@ -1,11 +1,25 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	// Tests never touch the desktop keyring; see useFakeKeyring.
+	connectSecretService = func() (secretServiceBus, error) {
+		return nil, errors.New("no keyring in tests")
+	}
+	os.Exit(m.Run())
+}
+
 func setupCredentialsDir(t *testing.T) {
 	t.Helper()
 	credentialsDir = t.TempDir()
@@ -146,3 +160,180 @@ func TestSaveCreatesDirectory(t *testing.T) {
 		t.Fatalf("file permissions: got %o, want 0600", info.Mode().Perm())
 	}
 }
+
+func TestFileStore_Concurrent(t *testing.T) {
+	setupCredentialsDir(t)
+	path, _ := credentialsPath()
+
+	// Each writer stands for a huesync instance with its own store, pairing
+	// bridges and forgetting some again, while others read.
+	const writers, bridges = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*bridges*2)
+	for w := range writers {
+		wg.Go(func() {
+			s := &fileStore{path: path}
+			for b := range bridges {
+				id := fmt.Sprintf("bridge-%d-%d", w, b)
+				if err := s.Save(id, BridgeCredentials{Username: id, Clientkey: "key"}); err != nil {
+					errs <- err
+				}
+				if b%2 == 1 {
+					if err := s.Delete(id); err != nil {
+						errs <- err
+					}
+				}
+			}
+		})
+		wg.Go(func() {
+			s := &fileStore{path: path}
+			for range bridges {
+				if _, err := s.All(); err != nil {
+					errs <- err
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	all, err := (&fileStore{path: path}).All()
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(all) != writers*bridges/2 {
+		t.Errorf("expected %d bridges, got %d", writers*bridges/2, len(all))
+	}
+	for w := range writers {
+		for b := 0; b < bridges; b += 2 {
+			if id := fmt.Sprintf("bridge-%d-%d", w, b); all[id].Username != id {
+				t.Errorf("lost the credentials of %s", id)
+			}
+		}
+	}
+}
+
+func TestPinsAndKnownBridges_Concurrent(t *testing.T) {
+	setupCredentialsDir(t)
+	prev := certificatePinned
+	certificatePinned = func(string, string) {}
+	t.Cleanup(func() { certificatePinned = prev })
+
+	// Each writer stands for a huesync instance using bridges of its own.
+	const writers, bridges = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*bridges*2)
+	for w := range writers {
+		wg.Go(func() {
+			for b := range bridges {
+				id := fmt.Sprintf("001788fffe%02x%04x", w, b)
+				if err := RememberBridge(Bridge{ID: id, IP: net.IPv4(192, 0, 2, byte(w))}); err != nil {
+					errs <- err
+				}
+				if err := checkPin(id, "fp-"+id); err != nil {
+					errs <- err
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	known, err := LoadKnownBridges()
+	if err != nil {
+		t.Fatalf("LoadKnownBridges: %v", err)
+	}
+	path, _ := pinsPath()
+	pins, err := readPins(path)
+	if err != nil {
+		t.Fatalf("readPins: %v", err)
+	}
+	for w := range writers {
+		for b := range bridges {
+			id := fmt.Sprintf("001788fffe%02x%04x", w, b)
+			if _, ok := known[id]; !ok {
+				t.Errorf("lost the address of %s", id)
+			}
+			if pins[id] != "fp-"+id {
+				t.Errorf("lost the pin of %s", id)
+			}
+		}
+	}
+}
+
+func TestFileStore_Corrupt(t *testing.T) {
+	setupCredentialsDir(t)
+	path, _ := credentialsPath()
+	garbage := []byte(`{"bridge-1": {"username": "u`)
+	if err := os.WriteFile(path, garbage, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The damaged file is reported and kept, not silently replaced.
+	var corrupt *corruptFileError
+	if _, _, err := LoadCredentials("bridge-1"); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a corruptFileError, got %v", err)
+	}
+	if data, err := os.ReadFile(corrupt.backup); err != nil || string(data) != string(garbage) {
+		t.Errorf("expected the damaged file in %s, got %q, %v", corrupt.backup, data, err)
+	}
+	if _, found, err := LoadCredentials("bridge-1"); err != nil || found {
+		t.Errorf("expected no credentials once moved aside, got %v, %v", found, err)
+	}
+
+	// Saving over a damaged file moves it aside too.
+	if err := os.WriteFile(path, garbage, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCredentials("bridge-2", BridgeCredentials{Username: "u", Clientkey: "k"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if _, found, err := LoadCredentials("bridge-2"); err != nil || !found {
+		t.Errorf("expected the saved credentials, got %v, %v", found, err)
+	}
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(credentialsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	var backups int
+	for _, name := range names {
+		if matched, _ := filepath.Match("credentials.json.damaged-*", name); matched {
+			backups++
+		}
+	}
+	if backups != 2 || len(names) != 4 || !slices.Contains(names, "credentials.json.lock") {
+		t.Errorf("expected the file, its lock and two backups, got %v", names)
+	}
+}
+
+func TestCredentialStoreWarning(t *testing.T) {
+	setupCredentialsDir(t)
+	path, _ := credentialsPath()
+
+	// Without a keyring, the user learns where the credentials are kept.
+	err := credentialStoreWarning()
+	if err == nil || !strings.Contains(err.Error(), "keyring is not available") || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected a warning about the plaintext file, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *corruptFileError
+	err = credentialStoreWarning()
+	if !errors.As(err, &corrupt) || !strings.Contains(err.Error(), corrupt.backup) || !fileExists(corrupt.backup) {
+		t.Errorf("expected a warning naming the backup of the damaged file, got %v", err)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const (
+	bundleFormat = "huesync-credentials"
+	bundleKDF    = "pbkdf2-sha256"
+	// maxBundleIterations keeps a crafted bundle from stalling the import.
+	maxBundleIterations = 10_000_000
+	minPassphraseLength = 8
+)
+
+// bundleIterations is the PBKDF2 iteration count of exported bundles. It is
+// a variable so that tests need not wait for it.
+var bundleIterations = 600_000
+
+// errWrongPassphrase is returned by openCredentials when the passphrase does
+// not decrypt the bundle.
+var errWrongPassphrase = errors.New("wrong passphrase, or the bundle is damaged")
+
+// credentialBundle is the file that credentials are exported to. They are
+// encrypted with AES-256-GCM under a key derived from a passphrase with
+// PBKDF2-HMAC-SHA256.
+type credentialBundle struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// sealCredentials encrypts creds, by bridge ID, into a bundle.
+func sealCredentials(creds map[string]BridgeCredentials, passphrase string) ([]byte, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	b := credentialBundle{
+		Format:     bundleFormat,
+		Version:    1,
+		KDF:        bundleKDF,
+		Iterations: bundleIterations,
+		Salt:       make([]byte, 16),
+	}
+	_, _ = rand.Read(b.Salt)
+	aead, err := bundleCipher(passphrase, b.Salt, b.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	b.Nonce = make([]byte, aead.NonceSize())
+	_, _ = rand.Read(b.Nonce)
+	b.Ciphertext = aead.Seal(nil, b.Nonce, plaintext, []byte(bundleFormat))
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// openCredentials decrypts a bundle made by sealCredentials.
+func openCredentials(data []byte, passphrase string) (map[string]BridgeCredentials, error) {
+	var b credentialBundle
+	if err := json.Unmarshal(data, &b); err != nil || b.Format != bundleFormat {
+		return nil, errors.New("not a huesync credentials bundle")
+	}
+	if b.Version != 1 || b.KDF != bundleKDF {
+		return nil, fmt.Errorf("unsupported bundle version %d (%s); update huesync", b.Version, b.KDF)
+	}
+	if b.Iterations < 1 || b.Iterations > maxBundleIterations {
+		return nil, fmt.Errorf("invalid iteration count %d", b.Iterations)
+	}
+	aead, err := bundleCipher(passphrase, b.Salt, b.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Nonce) != aead.NonceSize() {
+		return nil, errWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, b.Nonce, b.Ciphertext, []byte(bundleFormat))
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	var creds map[string]BridgeCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("decoding credentials: %w", err)
+	}
+	return creds, nil
+}
+
+func bundleCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pairedBridge is a bridge that huesync has credentials for.
+type pairedBridge struct {
+	ID    string
+	Creds BridgeCredentials
+	// Known is where the bridge was last used, or nil if that is unknown,
+	// e.g. for credentials imported from another computer.
+	Known *knownBridge
+}
+
+func (p pairedBridge) String() string {
+	if p.Known == nil {
+		return p.ID + " — address unknown"
+	}
+	label := p.ID
+	if p.Known.Name != "" {
+		label = fmt.Sprintf("%s (%s)", p.Known.Name, p.ID)
+	}
+	return fmt.Sprintf("%s — %s, last used %s", label, p.Known.IP, p.Known.LastUsed.Format(time.DateOnly))
+}
+
+// listPairedBridges returns the bridges with stored credentials, the one used
+// last first.
+func listPairedBridges() ([]pairedBridge, error) {
+	store, _, err := openCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	all, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+	// Without the addresses, the bridges are still listed.
+	known, _ := LoadKnownBridges()
+
+	var paired []pairedBridge
+	for id, creds := range all {
+		p := pairedBridge{ID: id, Creds: creds}
+		for kid, k := range known {
+			if strings.EqualFold(kid, id) {
+				p.Known = &k
+			}
+		}
+		paired = append(paired, p)
+	}
+	slices.SortFunc(paired, func(a, b pairedBridge) int {
+		switch {
+		case a.Known != nil && b.Known != nil:
+			if c := b.Known.LastUsed.Compare(a.Known.LastUsed); c != 0 {
+				return c
+			}
+		case a.Known != nil:
+			return -1
+		case b.Known != nil:
+			return 1
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return paired, nil
+}
+
+// findPaired returns the paired bridge whose ID, last address or name
+// (case-insensitive) matches query.
+func findPaired(paired []pairedBridge, query string) (pairedBridge, error) {
+	for _, p := range paired {
+		if strings.EqualFold(p.ID, query) {
+			return p, nil
+		}
+		if p.Known != nil && (p.Known.IP == query || (p.Known.Name != "" && strings.EqualFold(p.Known.Name, query))) {
+			return p, nil
+		}
+	}
+	return pairedBridge{}, cliErrorf(exitUnpaired, "not paired with bridge %q", query)
+}
+
+// revokeCredentials removes huesync's key for p from the bridge, which it
+// finds as opts tell. A key the bridge no longer knows counts as removed; one
+// the bridge refuses to remove does not (see ErrUnpairRefused).
+func revokeCredentials(ctx context.Context, p pairedBridge, opts options) error {
+	var b Bridge
+	var err error
+	if opts.bridgeIP != "" {
+		b, err = probeBridge(ctx, opts.bridgeIP, p.ID)
+	} else {
+		b, err = findBridge(ctx, p.ID, opts.ssdp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := UnpairBridge(b.IP, p.Creds.Username); err != nil && !errors.Is(err, ErrUnauthorized) {
+		return fmt.Errorf("removing huesync's key from the bridge: %w", certificateHint(err))
+	}
+	return nil
+}
+
+// exportCredentials writes the credentials of the paired bridges matching
+// queries, or of all paired bridges, to a new bundle at path. It returns the
+// IDs of the bridges exported.
+func exportCredentials(path, passphrase string, queries []string) ([]string, error) {
+	paired, err := listPairedBridges()
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) > 0 {
+		var chosen []pairedBridge
+		for _, q := range queries {
+			p, err := findPaired(paired, q)
+			if err != nil {
+				return nil, err
+			}
+			chosen = append(chosen, p)
+		}
+		paired = chosen
+	}
+	if len(paired) == 0 {
+		return nil, cliErrorf(exitUnpaired, "not paired with any bridge; nothing to export")
+	}
+
+	creds := make(map[string]BridgeCredentials, len(paired))
+	var ids []string
+	for _, p := range paired {
+		creds[p.ID] = p.Creds
+		ids = append(ids, p.ID)
+	}
+	data, err := sealCredentials(creds, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ids, f.Close()
+}
+
+// importCredentials stores the credentials in the bundle at path, replacing
+// any stored for the same bridges. It returns the IDs of the bridges
+// imported.
+func importCredentials(path, passphrase string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := openCredentials(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	store, _, err := openCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	var ids []string
+	for id, c := range creds {
+		if err := store.Save(id, c); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// checkPassphrase refuses passphrases too short to protect an export.
+func checkPassphrase(passphrase string) error {
+	if len([]rune(passphrase)) < minPassphraseLength {
+		return cliErrorf(exitUsage, "the passphrase must have at least %d characters", minPassphraseLength)
+	}
+	return nil
+}
+
+// runCreds implements `huesync creds`: it lists and forgets the paired
+// bridges, and moves their credentials between computers.
+func runCreds(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "", 0)
+	usage := "usage: huesync creds list | forget [flags] <bridge> | export [flags] <file> [<bridge>...] | import [flags] <file>"
+	if len(args) == 0 {
+		logger.Print(usage)
+		return exitUsage
+	}
+	command := args[0]
+
+	var local bool
+	extra := func(fs *flag.FlagSet) {
+		if command == "forget" {
+			fs.BoolVar(&local, "local", false, "forget the credentials only on this computer, leaving huesync's key on the bridge")
+		}
+	}
+
+	var minArgs, maxArgs int
+	switch command {
+	case "list":
+	case "forget", "import":
+		minArgs, maxArgs = 1, 1
+	case "export":
+		minArgs, maxArgs = 1, -1
+	default:
+		logger.Print(usage)
+		return exitUsage
+	}
+	parser, opts, err := newOptionParser("huesync creds "+command, args[1:], stderr, extra)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		var ue *usageError
+		if !errors.As(err, &ue) {
+			logger.Printf("error: %v", err)
+		}
+		return exitUsage
+	}
+	rest := parser.Args()
+	if len(rest) < minArgs || (maxArgs >= 0 && len(rest) > maxArgs) {
+		logger.Print(usage)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := credentialStoreWarning(); err != nil {
+		logger.Printf("credentials: %v", err)
+	}
+	cmd := credsCommand{stdin: stdin, stdout: stdout, stderr: stderr}
+	switch command {
+	case "list":
+		err = cmd.list()
+	case "forget":
+		err = cmd.forget(ctx, rest[0], opts, local)
+	case "export":
+		err = cmd.export(rest[0], rest[1:])
+	case "import":
+		err = cmd.importBundle(rest[0])
+	}
+	if err != nil {
+		logger.Printf("error: %v", err)
+	}
+	return exitCode(err)
+}
+
+// credsCommand runs the subcommands of `huesync creds`.
+type credsCommand struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func (c credsCommand) list() error {
+	paired, err := listPairedBridges()
+	if err != nil {
+		return err
+	}
+	if len(paired) == 0 {
+		fmt.Fprintln(c.stdout, "not paired with any bridge")
+	}
+	for _, p := range paired {
+		fmt.Fprintln(c.stdout, p)
+	}
+	return nil
+}
+
+func (c credsCommand) forget(ctx context.Context, query string, opts options, local bool) error {
+	paired, err := listPairedBridges()
+	if err != nil {
+		return err
+	}
+	p, err := findPaired(paired, query)
+	if err != nil {
+		return err
+	}
+	if !local {
+		if err := revokeCredentials(ctx, p, opts); err != nil {
+			return cliErrorf(exitCode(err), "%w; give --local to forget the credentials only on this computer", err)
+		}
+	}
+	if err := DeleteCredentials(p.ID); err != nil {
+		return err
+	}
+	if local {
+		fmt.Fprintf(c.stdout, "forgot bridge %s on this computer; huesync's key stays on the bridge\n", p.ID)
+	} else {
+		fmt.Fprintf(c.stdout, "forgot bridge %s and removed huesync's key from it\n", p.ID)
+	}
+	return nil
+}
+
+func (c credsCommand) export(path string, queries []string) error {
+	passphrase, err := c.readPassphrase(true)
+	if err != nil {
+		return err
+	}
+	if err := checkPassphrase(passphrase); err != nil {
+		return err
+	}
+	ids, err := exportCredentials(path, passphrase, queries)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "exported the credentials of %s to %s\n", strings.Join(ids, ", "), path)
+	return nil
+}
+
+func (c credsCommand) importBundle(path string) error {
+	passphrase, err := c.readPassphrase(false)
+	if err != nil {
+		return err
+	}
+	ids, err := importCredentials(path, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "imported the credentials of %s\n", strings.Join(ids, ", "))
+	return nil
+}
+
+// readPassphrase reads the bundle's passphrase from the terminal without
+// echoing it, asking twice if confirm is set, or else takes the first line
+// of stdin.
+func (c credsCommand) readPassphrase(confirm bool) (string, error) {
+	f, ok := c.stdin.(*os.File)
+	if !ok || !term.IsTerminal(f.Fd()) {
+		// The last line need not end in a newline.
+		line, err := bufio.NewReader(c.stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			return "", cliErrorf(exitUsage, "no passphrase given on stdin")
+		}
+		return line, nil
+	}
+
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(c.stderr, prompt)
+		p, err := term.ReadPassword(f.Fd())
+		fmt.Fprintln(c.stderr)
+		return string(p), err
+	}
+	passphrase, err := read("Passphrase: ")
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+	repeat, err := read("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if repeat != passphrase {
+		return "", cliErrorf(exitUsage, "the passphrases do not match")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fastBundles makes exports derive their key quickly.
+func fastBundles(t *testing.T) {
+	t.Helper()
+	orig := bundleIterations
+	t.Cleanup(func() { bundleIterations = orig })
+	bundleIterations = 1000
+}
+
+func TestCredentialBundle(t *testing.T) {
+	fastBundles(t)
+	creds := map[string]BridgeCredentials{
+		"001788fffe000001": {Username: "user1", Clientkey: "key1"},
+		"001788fffe000002": {Username: "user2", Clientkey: "key2"},
+	}
+	data, err := sealCredentials(creds, "correct horse")
+	if err != nil {
+		t.Fatalf("sealCredentials: %v", err)
+	}
+	if bytes.Contains(data, []byte("user1")) || bytes.Contains(data, []byte("key2")) {
+		t.Error("expected the credentials to be encrypted")
+	}
+
+	got, err := openCredentials(data, "correct horse")
+	if err != nil {
+		t.Fatalf("openCredentials: %v", err)
+	}
+	if len(got) != 2 || got["001788fffe000002"] != creds["001788fffe000002"] {
+		t.Errorf("got %+v, want %+v", got, creds)
+	}
+
+	if _, err := openCredentials(data, "wrong horse"); !errors.Is(err, errWrongPassphrase) {
+		t.Errorf("expected errWrongPassphrase, got %v", err)
+	}
+	tampered := bytes.Replace(data, []byte(`"ciphertext": "`), []byte(`"ciphertext": "AAAA`), 1)
+	if _, err := openCredentials(tampered, "correct horse"); !errors.Is(err, errWrongPassphrase) {
+		t.Errorf("expected a tampered bundle to be refused, got %v", err)
+	}
+	if _, err := openCredentials([]byte(`{"001788fffe000001":{}}`), "correct horse"); err == nil {
+		t.Error("expected a credentials file to be refused as a bundle")
+	}
+}
+
+func TestUnpairBridge_MockBridge(t *testing.T) {
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	b.AddUser("other", "0123456789abcdef0123456789abcdef")
+
+	// Current firmware keeps the key.
+	if err := UnpairBridge(ip, "user"); !errors.Is(err, ErrUnpairRefused) {
+		t.Errorf("expected ErrUnpairRefused, got %v", err)
+	}
+	if _, err := FetchApplicationID(ip, "user"); err != nil {
+		t.Errorf("expected the key to stay, got %v", err)
+	}
+	if err := UnpairBridge(ip, "unknown"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for an unknown key, got %v", err)
+	}
+
+	b.AllowUnpair()
+	if err := UnpairBridge(ip, "user"); err != nil {
+		t.Fatalf("UnpairBridge: %v", err)
+	}
+	if _, err := FetchApplicationID(ip, "user"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected the key to be gone, got %v", err)
+	}
+	if _, err := FetchApplicationID(ip, "other"); err != nil {
+		t.Errorf("expected other keys to stay, got %v", err)
+	}
+	if err := UnpairBridge(ip, "user"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for a removed key, got %v", err)
+	}
+}
+
+func TestRunCreds(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	fastBundles(t)
+	b := startMockBridge(t, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	const other = "001788fffe000002"
+	for id, creds := range map[string]BridgeCredentials{
+		b.ID:  {Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"},
+		other: {Username: "gone", Clientkey: "fedcba9876543210fedcba9876543210"},
+	} {
+		if err := SaveCredentials(id, creds); err != nil {
+			t.Fatalf("SaveCredentials: %v", err)
+		}
+	}
+	if err := RememberBridge(Bridge{ID: b.ID, Name: "Mock", IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	run := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCreds(args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	for _, args := range [][]string{{}, {"show"}, {"forget"}, {"list", "extra"}, {"import", "a", "b"}} {
+		if code, _, _ := run("", args...); code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+
+	code, out, stderr := run("", "list")
+	if code != exitOK || !strings.HasPrefix(out, "Mock (001788fffe4d4f43) — 127.0.0.1, last used ") || !strings.Contains(out, other+" — address unknown") {
+		t.Errorf("list: exit code %d, output %q", code, out)
+	}
+	// Tests have no keyring, so the credentials are in the plaintext file.
+	if !strings.Contains(stderr, "credentials: the desktop keyring is not available") {
+		t.Errorf("expected a warning about the plaintext file, got %q", stderr)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "creds.huesync")
+	if code, _, stderr := run("short\n", "export", bundle); code != exitUsage || !strings.Contains(stderr, "at least 8") {
+		t.Errorf("expected a short passphrase to be refused, got %d, %q", code, stderr)
+	}
+	if code, _, _ := run("", "export", bundle); code != exitUsage {
+		t.Errorf("expected exit code %d without a passphrase, got %d", exitUsage, code)
+	}
+	if code, out, _ := run("correct horse\n", "export", bundle); code != exitOK || !strings.Contains(out, other) {
+		t.Fatalf("export: exit code %d, output %q", code, out)
+	}
+	if info, err := os.Stat(bundle); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a private bundle, got %v, %v", info, err)
+	}
+	if code, _, _ := run("correct horse\n", "export", bundle); code != exitError {
+		t.Errorf("expected the bundle not to be overwritten, got exit code %d", code)
+	}
+
+	// The other bridge cannot be found, so its key cannot be removed.
+	if code, _, stderr := run("", "forget", other); code != exitNoBridge || !strings.Contains(stderr, "--local") {
+		t.Errorf("expected exit code %d with a hint, got %d, %q", exitNoBridge, code, stderr)
+	}
+	if code, _, _ := run("", "forget", "--local", other); code != exitOK {
+		t.Errorf("forget --local: exit code %d", code)
+	}
+	if _, found, _ := LoadCredentials(other); found {
+		t.Error("expected the other bridge to be forgotten")
+	}
+
+	// The bridge keeps the key, so the credentials stay too.
+	if code, _, stderr := run("", "forget", "mock"); code != exitError || !strings.Contains(stderr, "account.meethue.com") || !strings.Contains(stderr, "--local") {
+		t.Errorf("expected the refusal explained, got %d, %q", code, stderr)
+	}
+	if _, found, _ := LoadCredentials(b.ID); !found {
+		t.Error("expected the credentials to stay while the bridge keeps the key")
+	}
+
+	b.AllowUnpair()
+	if code, out, _ := run("", "forget", "mock"); code != exitOK || !strings.Contains(out, "removed huesync's key") {
+		t.Errorf("forget: exit code %d, output %q", code, out)
+	}
+	if _, err := FetchApplicationID(net.IPv4(127, 0, 0, 1), "user"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected the key to be removed from the bridge, got %v", err)
+	}
+	if code, _, _ := run("", "forget", "mock"); code != exitUnpaired {
+		t.Errorf("expected exit code %d for a forgotten bridge, got %d", exitUnpaired, code)
+	}
+
+	// On another computer, the bundle brings both pairings back.
+	credentialsDir = t.TempDir()
+	if code, _, stderr := run("wrong horse\n", "import", bundle); code != exitError || !strings.Contains(stderr, "wrong passphrase") {
+		t.Errorf("expected a wrong passphrase to be refused, got %d, %q", code, stderr)
+	}
+	if code, out, _ := run("correct horse\n", "import", bundle); code != exitOK || !strings.Contains(out, other+", "+b.ID) {
+		t.Errorf("import: exit code %d, output %q", code, out)
+	}
+	// A passphrase piped without a final newline is read as well.
+	credentialsDir = t.TempDir()
+	if code, out, stderr := run("correct horse", "import", bundle); code != exitOK || !strings.Contains(out, other+", "+b.ID) {
+		t.Errorf("import without a newline: exit code %d, %q, %q", code, out, stderr)
+	}
+	if creds, found, _ := LoadCredentials(other); !found || creds.Username != "gone" {
+		t.Errorf("expected the imported credentials, got %+v, %v", creds, found)
+	}
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/grandcat/zeroconf"
 )
@@ -71,6 +80,10 @@ func DiscoverBridges(ctx context.Context) (<-chan Bridge, <-chan error) {
 	return bridges, errs
 }
 
+// discoverBridges is the discovery used by the commands. It is a variable
+// so that tests can substitute a mock bridge.
+var discoverBridges = DiscoverBridges
+
 func parseBridge(entry *zeroconf.ServiceEntry) Bridge {
 	b := Bridge{
 		Name:     entry.Instance,
@@ -99,3 +112,226 @@ func parseBridge(entry *zeroconf.ServiceEntry) Bridge {
 
 	return b
 }
+
+// discoverAll runs mDNS discovery and, if ssdp is set, SSDP discovery
+// alongside it. A bridge found by both is reported once. An error is only
+// reported if no bridge was found.
+func discoverAll(ctx context.Context, ssdp bool) (<-chan Bridge, <-chan error) {
+	if !ssdp {
+		return discoverBridges(ctx)
+	}
+	bridges := make(chan Bridge)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(bridges)
+		defer close(errs)
+
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			seen     = make(map[string]bool)
+			found    bool
+			firstErr error
+		)
+		forward := func(bridgeCh <-chan Bridge, errCh <-chan error) {
+			defer wg.Done()
+			for b := range bridgeCh {
+				mu.Lock()
+				id := strings.ToLower(b.ID)
+				dup := id != "" && seen[id]
+				seen[id] = true
+				found = true
+				mu.Unlock()
+				if !dup {
+					bridges <- b
+				}
+			}
+			if err := <-errCh; err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}
+		wg.Add(2)
+		go forward(discoverBridges(ctx))
+		go forward(DiscoverBridgesSSDP(ctx))
+		wg.Wait()
+
+		if !found && firstErr != nil {
+			errs <- firstErr
+		}
+	}()
+
+	return bridges, errs
+}
+
+// ssdpAddr is where SSDP M-SEARCH requests are sent. It is a variable so
+// that tests can answer them.
+var ssdpAddr = "239.255.255.250:1900"
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: upnp:rootdevice\r\n\r\n"
+
+// DiscoverBridgesSSDP searches for Hue bridges with an SSDP (UPnP) M-SEARCH,
+// for networks that pass SSDP but not mDNS. Bridges answer with their ID in a
+// hue-bridgeid header; each one is probed for its name and model before it is
+// sent on the bridges channel. Both channels are closed when the context is
+// cancelled.
+func DiscoverBridgesSSDP(ctx context.Context) (<-chan Bridge, <-chan error) {
+	bridges := make(chan Bridge)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(bridges)
+		defer close(errs)
+
+		dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+		if err != nil {
+			errs <- fmt.Errorf("resolving SSDP address: %w", err)
+			return
+		}
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			errs <- fmt.Errorf("opening SSDP socket: %w", err)
+			return
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer func() {
+			if stop() {
+				conn.Close()
+			}
+		}()
+
+		if _, err := conn.WriteToUDP([]byte(ssdpSearch), dst); err != nil {
+			errs <- fmt.Errorf("sending SSDP search: %w", err)
+			return
+		}
+
+		seen := make(map[string]bool)
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- fmt.Errorf("reading SSDP responses: %w", err)
+				}
+				return
+			}
+			id, ip, ok := parseSSDPResponse(buf[:n], from.IP)
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+			b, err := ProbeBridge(ctx, ip.String())
+			if err != nil || b.ID != id {
+				continue
+			}
+			select {
+			case bridges <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return bridges, errs
+}
+
+// parseSSDPResponse returns the bridge ID and address from an SSDP response
+// of a Hue bridge. The address is taken from the LOCATION header, or else is
+// the sender's.
+func parseSSDPResponse(data []byte, from net.IP) (id string, ip net.IP, ok bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return "", nil, false
+	}
+	resp.Body.Close()
+	id = strings.ToLower(resp.Header.Get("hue-bridgeid"))
+	if id == "" {
+		return "", nil, false
+	}
+	ip = from
+	if u, err := url.Parse(resp.Header.Get("Location")); err == nil {
+		if locIP := net.ParseIP(u.Hostname()); locIP != nil {
+			ip = locIP
+		}
+	}
+	return id, ip, true
+}
+
+// probeTimeout bounds ProbeBridge.
+const probeTimeout = 5 * time.Second
+
+// ProbeBridge asks the device at host, an IP address or host name, for its
+// bridge ID, name and model via the unauthenticated /api/config endpoint.
+// Like discovery, it lets hueClient connect to the bridge, but only once its
+// certificate passed verification. If it fails, the bridge is returned with
+// the error, and connections to host are refused until it is trusted.
+func ProbeBridge(ctx context.Context, host string) (Bridge, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	b := Bridge{IP: net.ParseIP(host), Port: bridgeHTTPSPort}
+	if b.IP == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return Bridge{}, fmt.Errorf("resolving %s: %w", host, err)
+		}
+		b.IP = ips[0]
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				b.IP = ip
+				break
+			}
+		}
+		b.Hostname = host
+	}
+
+	// The certificate must name the bridge ID, which only the response
+	// tells, so it is verified afterwards.
+	var certs []*x509.Certificate
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				certs = cs.PeerCertificates
+				return nil
+			},
+		},
+		DisableKeepAlives: true,
+	}}
+	req, err := http.NewRequestWithContext(ctx, "GET", bridgeURL(b.IP, "/api/config"), nil)
+	if err != nil {
+		return Bridge{}, fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Bridge{}, err
+	}
+	defer resp.Body.Close()
+
+	var config struct {
+		Name     string `json:"name"`
+		BridgeID string `json:"bridgeid"`
+		ModelID  string `json:"modelid"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&config) != nil || config.BridgeID == "" {
+		return Bridge{}, fmt.Errorf("%s is not a Hue bridge", host)
+	}
+	b.ID = strings.ToLower(config.BridgeID)
+	b.Name = config.Name
+	b.Model = config.ModelID
+
+	if err := verifyCertificateOf(b.ID, certs); err != nil {
+		forgetBridge(b.IP)
+		return b, err
+	}
+	expectBridge(b)
+	return b, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hideBridges makes discovery find no bridges, as on networks that filter
+// multicast.
+func hideBridges(t *testing.T) {
+	t.Helper()
+	discover := discoverBridges
+	t.Cleanup(func() { discoverBridges = discover })
+	discoverBridges = func(ctx context.Context) (<-chan Bridge, <-chan error) {
+		bridges := make(chan Bridge)
+		errs := make(chan error)
+		close(bridges)
+		close(errs)
+		return bridges, errs
+	}
+}
+
+func TestProbeBridge_MockBridge(t *testing.T) {
+	b := startMockBridge(t, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	// Whatever was known about the address, the probe learns the ID anew.
+	useBridgeAt(t, "127.0.0.1", "unknown")
+
+	bridge, err := ProbeBridge(context.Background(), "localhost")
+	if err != nil {
+		t.Fatalf("ProbeBridge: %v", err)
+	}
+	want := Bridge{ID: b.ID, Model: "BSB002", Name: "Mock Hue Bridge", IP: net.IPv4(127, 0, 0, 1), Port: b.HTTPSPort(), Hostname: "localhost"}
+	if bridge.String() != want.String() || bridge.Model != want.Model || bridge.Hostname != want.Hostname || !bridge.IP.Equal(want.IP) {
+		t.Errorf("got %+v, want %+v", bridge, want)
+	}
+	// The probe lets the API reach the bridge.
+	if _, err := FetchEntertainmentAreas(bridge.IP, "user"); err != nil {
+		t.Errorf("FetchEntertainmentAreas: %v", err)
+	}
+
+	// A bridge whose certificate changed is returned with the error, and
+	// the API no longer reaches it.
+	if err := TrustCertificate(b.ID, "00:11"); err != nil {
+		t.Fatalf("TrustCertificate: %v", err)
+	}
+	var changed *CertificateChangedError
+	if bridge, err := ProbeBridge(context.Background(), "127.0.0.1"); !errors.As(err, &changed) || bridge.ID != b.ID {
+		t.Errorf("expected the bridge and a CertificateChangedError, got %+v, %v", bridge, err)
+	}
+	if _, ok := expectedBridgeID("127.0.0.1"); ok {
+		t.Error("expected the address to be forgotten")
+	}
+	if _, err := FetchEntertainmentAreas(bridge.IP, "user"); err == nil {
+		t.Error("expected the API to refuse the bridge")
+	}
+}
+
+func TestProbeBridge_NotABridge(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	port := bridgeHTTPSPort
+	t.Cleanup(func() { bridgeHTTPSPort = port })
+	bridgeHTTPSPort = srv.Listener.Addr().(*net.TCPAddr).Port
+
+	if _, err := ProbeBridge(context.Background(), "127.0.0.1"); err == nil || !strings.Contains(err.Error(), "not a Hue bridge") {
+		t.Errorf("expected a device that is no bridge to be refused, got %v", err)
+	}
+	if _, err := ProbeBridge(context.Background(), "bridge.invalid"); err == nil {
+		t.Error("expected an unresolvable host name to fail")
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	from := net.IPv4(192, 0, 2, 9)
+	resp := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"LOCATION: http://192.0.2.10:80/description.xml\r\n" +
+		"ST: upnp:rootdevice\r\n" +
+		"hue-bridgeid: 001788FFFE4D4F43\r\n\r\n"
+	id, ip, ok := parseSSDPResponse([]byte(resp), from)
+	if !ok || id != "001788fffe4d4f43" || !ip.Equal(net.IPv4(192, 0, 2, 10)) {
+		t.Errorf("got %q, %v, %v", id, ip, ok)
+	}
+
+	// Without a usable location, the sender's address is taken.
+	resp = strings.Replace(resp, "http://192.0.2.10:80", "http://bridge.local", 1)
+	if _, ip, _ := parseSSDPResponse([]byte(resp), from); !ip.Equal(from) {
+		t.Errorf("expected the sender's address, got %v", ip)
+	}
+
+	for _, resp := range []string{"garbage", "HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\n\r\n"} {
+		if _, _, ok := parseSSDPResponse([]byte(resp), from); ok {
+			t.Errorf("%q: expected no bridge", resp)
+		}
+	}
+}
+
+// answerSSDP answers M-SEARCH requests sent to ssdpAddr with responses.
+func answerSSDP(t *testing.T, responses ...string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening for SSDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	addr := ssdpAddr
+	t.Cleanup(func() { ssdpAddr = addr })
+	ssdpAddr = conn.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1\r\n") {
+				continue
+			}
+			for _, r := range responses {
+				_, _ = conn.WriteToUDP([]byte(r), from)
+			}
+		}
+	}()
+}
+
+func TestDiscoverBridgesSSDP(t *testing.T) {
+	b := startMockBridge(t, 1)
+	hue := "HTTP/1.1 200 OK\r\nLOCATION: http://127.0.0.1:80/description.xml\r\nhue-bridgeid: " + strings.ToUpper(b.ID) + "\r\n\r\n"
+	answerSSDP(t,
+		"HTTP/1.1 200 OK\r\nLOCATION: http://127.0.0.1:8080/tv.xml\r\nST: upnp:rootdevice\r\n\r\n",
+		hue,
+		hue,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	bridgeCh, errCh := DiscoverBridgesSSDP(ctx)
+	var bridges []Bridge
+	for b := range bridgeCh {
+		bridges = append(bridges, b)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("DiscoverBridgesSSDP: %v", err)
+	}
+	if len(bridges) != 1 || bridges[0].ID != b.ID || bridges[0].Name != "Mock Hue Bridge" {
+		t.Fatalf("expected the mock bridge once, got %+v", bridges)
+	}
+
+	// Found by mDNS too, it is reported once.
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	bridgeCh, errCh = discoverAll(ctx, true)
+	bridges = nil
+	for b := range bridgeCh {
+		bridges = append(bridges, b)
+	}
+	if err := <-errCh; err != nil || len(bridges) != 1 {
+		t.Errorf("expected one bridge, got %+v, %v", bridges, err)
+	}
+}
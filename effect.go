@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Effect animates the channels of an entertainment area without capturing
+// the screen.
+type Effect interface {
+	// Colors returns one color per channel at time t after the effect
+	// started.
+	Colors(t time.Duration) []RGB
+}
+
+// effectParams tunes an effect.
+type effectParams struct {
+	// Speed scales the pace of the animation; 1 is the effect's natural pace.
+	Speed float64
+	// Intensity, from 0 to 1, is the depth of the flicker or breathing for
+	// candle, fireplace and breathing, and the brightness for the others.
+	Intensity float64
+	// Palette replaces the effect's own colors when not empty.
+	Palette []RGB
+}
+
+// effectNames lists the available effects in the order the TUI shows them.
+var effectNames = []string{"candle", "fireplace", "rainbow", "breathing", "colorloop", "bias"}
+
+// effectDescriptions describes each effect for the TUI.
+var effectDescriptions = map[string]string{
+	"candle":    "warm, gently flickering light",
+	"fireplace": "deep orange, lively flames",
+	"rainbow":   "hues drifting across the area",
+	"breathing": "slow fade in and out",
+	"colorloop": "all lights cycling through the hues",
+	"bias":      "static white behind the screen",
+}
+
+// effectScreen is the name that selects screen sync instead of an effect,
+// and effectAudio the one that selects the audio-reactive mode.
+const (
+	effectScreen = "screen"
+	effectAudio  = "audio"
+)
+
+// Default palettes. Candle and fireplace colors follow the black-body curve
+// from about 1500 K to 2200 K.
+var (
+	candlePalette    = []RGB{{R: 255, G: 147, B: 41}, {R: 255, G: 120, B: 20}}
+	firePalette      = []RGB{{R: 255, G: 56}, {R: 255, G: 110, B: 5}, {R: 255, G: 165, B: 40}}
+	breathingPalette = []RGB{{R: 90, G: 140, B: 255}}
+	biasPalette      = []RGB{{R: 255, G: 255, B: 255}}
+)
+
+// newEffect returns the effect with the given name for the given channels.
+func newEffect(name string, channels []Channel, p effectParams) (Effect, error) {
+	switch name {
+	case "candle":
+		return flickerEffect{channels: len(channels), params: withPalette(p, candlePalette), rate: 2.5, depth: 0.5}, nil
+	case "fireplace":
+		return flickerEffect{channels: len(channels), params: withPalette(p, firePalette), rate: 5, depth: 0.8}, nil
+	case "rainbow":
+		return rainbowEffect{channels: channels, params: p}, nil
+	case "breathing":
+		return breathingEffect{channels: len(channels), params: withPalette(p, breathingPalette)}, nil
+	case "colorloop":
+		return colorloopEffect{channels: len(channels), params: p}, nil
+	case "bias":
+		return biasEffect{channels: len(channels), params: withPalette(p, biasPalette)}, nil
+	}
+	return nil, fmt.Errorf("unknown effect %q (want %s)", name, strings.Join(effectNames, ", "))
+}
+
+// parseEffect checks an --effect value: an effect name, "screen" or "audio".
+func parseEffect(s string) (string, error) {
+	if s == effectScreen || s == effectAudio {
+		return s, nil
+	}
+	if _, err := newEffect(s, nil, effectParams{}); err != nil {
+		return "", fmt.Errorf("unknown effect %q (want %s, %s or %s)", s, strings.Join(effectNames, ", "), effectScreen, effectAudio)
+	}
+	return s, nil
+}
+
+// describeEffect summarizes an effect and its parameters for display.
+func describeEffect(name string, p effectParams) string {
+	s := fmt.Sprintf("%s, speed %g×, intensity %.0f%%", name, p.Speed, p.Intensity*100)
+	if len(p.Palette) > 0 {
+		colors := make([]string, len(p.Palette))
+		for i, c := range p.Palette {
+			colors[i] = c.String()
+		}
+		s += ", palette " + strings.Join(colors, " ")
+	}
+	return s
+}
+
+func withPalette(p effectParams, palette []RGB) effectParams {
+	if len(p.Palette) == 0 {
+		p.Palette = palette
+	}
+	return p
+}
+
+// flickerEffect drives each channel with its own smooth noise: brightness
+// dips by up to depth×Intensity and the color wanders through the palette.
+// candle and fireplace differ in rate, depth and palette.
+type flickerEffect struct {
+	channels int
+	params   effectParams
+	// rate is the number of noise features per second at speed 1.
+	rate  float64
+	depth float64
+}
+
+func (e flickerEffect) Colors(t time.Duration) []RGB {
+	x := t.Seconds() * e.rate * e.params.Speed
+	out := make([]RGB, e.channels)
+	for i := range out {
+		seed := uint32(i) * 2
+		// A slow and a fast octave: lazy swaying with quick flutters.
+		n := 0.65*valueNoise(seed, x) + 0.35*valueNoise(seed, 3.7*x)
+		c := paletteAt(e.params.Palette, valueNoise(seed+1, 0.5*x))
+		out[i] = scaleRGB(c, 1-e.depth*e.params.Intensity*n)
+	}
+	return out
+}
+
+// rainbowPeriod is the time the rainbow takes to shift by a full cycle at
+// speed 1.
+const rainbowPeriod = 20 * time.Second
+
+// rainbowEffect spreads the hues over the area from left to right and lets
+// them drift.
+type rainbowEffect struct {
+	channels []Channel
+	params   effectParams
+}
+
+func (e rainbowEffect) Colors(t time.Duration) []RGB {
+	phase := t.Seconds() * e.params.Speed / rainbowPeriod.Seconds()
+	out := make([]RGB, len(e.channels))
+	for i, ch := range e.channels {
+		// X runs from -1 to 1: the area spans half a cycle.
+		pos := frac(phase + (ch.Position.X+1)/4)
+		out[i] = scaleRGB(cycleColor(e.params.Palette, pos), e.params.Intensity)
+	}
+	return out
+}
+
+// breathingPeriod is the duration of one breath at speed 1.
+const breathingPeriod = 6 * time.Second
+
+// breathingEffect fades all channels in and out, moving to the next palette
+// color with each breath.
+type breathingEffect struct {
+	channels int
+	params   effectParams
+}
+
+func (e breathingEffect) Colors(t time.Duration) []RGB {
+	breaths := t.Seconds() * e.params.Speed / breathingPeriod.Seconds()
+	level := (1 - math.Cos(2*math.Pi*breaths)) / 2
+	c := e.params.Palette[int(breaths)%len(e.params.Palette)]
+	return fill(e.channels, scaleRGB(c, 1-e.params.Intensity*(1-level)))
+}
+
+// colorloopPeriod is the duration of one loop at speed 1.
+const colorloopPeriod = time.Minute
+
+// colorloopEffect cycles all channels together through the hues, or through
+// the palette.
+type colorloopEffect struct {
+	channels int
+	params   effectParams
+}
+
+func (e colorloopEffect) Colors(t time.Duration) []RGB {
+	pos := frac(t.Seconds() * e.params.Speed / colorloopPeriod.Seconds())
+	return fill(e.channels, scaleRGB(cycleColor(e.params.Palette, pos), e.params.Intensity))
+}
+
+// biasEffect lights all channels with the first palette color, white by
+// default, at Intensity brightness.
+type biasEffect struct {
+	channels int
+	params   effectParams
+}
+
+func (e biasEffect) Colors(time.Duration) []RGB {
+	return fill(e.channels, scaleRGB(e.params.Palette[0], e.params.Intensity))
+}
+
+// effectSource feeds an effect to the stream engine.
+type effectSource struct {
+	effect Effect
+	start  time.Time
+}
+
+func (s effectSource) Colors(now time.Time) ([]RGB, image.Rectangle, error) {
+	return s.effect.Colors(now.Sub(s.start)), image.Rectangle{}, nil
+}
+
+// SnapThreshold is 0: effects move continuously and are always blended.
+func (effectSource) SnapThreshold() float64 { return 0 }
+
+// cycleColor returns the color at pos (0 to 1) of a cycle through the
+// palette, or through the hues if the palette is empty.
+func cycleColor(palette []RGB, pos float64) RGB {
+	if len(palette) == 0 {
+		return hueColor(pos)
+	}
+	return paletteAt(palette, pos)
+}
+
+// paletteAt blends cyclically through the palette: pos 0 and 1 are the first
+// color, and the others are evenly spaced in between.
+func paletteAt(palette []RGB, pos float64) RGB {
+	if len(palette) == 1 {
+		return palette[0]
+	}
+	x := frac(pos) * float64(len(palette))
+	i := int(x)
+	return lerpRGB(palette[i%len(palette)], palette[(i+1)%len(palette)], x-float64(i))
+}
+
+// hueColor returns the fully saturated color of hue h (0 to 1).
+func hueColor(h float64) RGB {
+	h = frac(h) * 6
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return floatToRGB([3]float64{r * 255, g * 255, b * 255})
+}
+
+// valueNoise returns smooth pseudo-random noise in [0, 1] at x. Each seed
+// gives an independent sequence; the value changes about once per unit of x.
+func valueNoise(seed uint32, x float64) float64 {
+	i := math.Floor(x)
+	t := x - i
+	t = t * t * (3 - 2*t)
+	a, b := latticeValue(seed, int64(i)), latticeValue(seed, int64(i)+1)
+	return a + t*(b-a)
+}
+
+// latticeValue hashes seed and i to a value in [0, 1].
+func latticeValue(seed uint32, i int64) float64 {
+	h := uint64(i)*0x9e3779b97f4a7c15 ^ uint64(seed)*0xbf58476d1ce4e5b9
+	h ^= h >> 31
+	h *= 0x94d049bb133111eb
+	h ^= h >> 29
+	return float64(h>>11) / float64(1<<53)
+}
+
+func scaleRGB(c RGB, f float64) RGB {
+	f = min(max(f, 0), 1)
+	return floatToRGB([3]float64{float64(c.R) * f, float64(c.G) * f, float64(c.B) * f})
+}
+
+func lerpRGB(a, b RGB, t float64) RGB {
+	x, y := rgbToFloat(a), rgbToFloat(b)
+	for j := range x {
+		x[j] += t * (y[j] - x[j])
+	}
+	return floatToRGB(x)
+}
+
+func fill(n int, c RGB) []RGB {
+	out := make([]RGB, n)
+	for i := range out {
+		out[i] = c
+	}
+	return out
+}
+
+func frac(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+// parsePalette parses a comma-separated list of colors in #rrggbb notation;
+// the # is optional.
+func parsePalette(s string) ([]RGB, error) {
+	var palette []RGB
+	for _, part := range strings.Split(s, ",") {
+		c, err := parseRGB(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	return palette, nil
+}
+
+func parseRGB(s string) (RGB, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return RGB{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+	return RGB{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testChannels(xs ...float64) []Channel {
+	channels := make([]Channel, len(xs))
+	for i, x := range xs {
+		channels[i] = Channel{ID: uint8(i), Position: Position{X: x}}
+	}
+	return channels
+}
+
+func TestNewEffect_All(t *testing.T) {
+	channels := testChannels(-1, 0, 1)
+	for _, name := range effectNames {
+		e, err := newEffect(name, channels, effectParams{Speed: 1, Intensity: 1})
+		if err != nil {
+			t.Fatalf("newEffect(%q): %v", name, err)
+		}
+		for _, at := range []time.Duration{0, 1234 * time.Millisecond, time.Hour} {
+			colors := e.Colors(at)
+			if len(colors) != len(channels) {
+				t.Fatalf("%s: got %d colors for %d channels", name, len(colors), len(channels))
+			}
+			again := e.Colors(at)
+			for i := range colors {
+				if colors[i] != again[i] {
+					t.Errorf("%s: colors at %v are not deterministic", name, at)
+				}
+			}
+		}
+	}
+}
+
+func TestNewEffect_Unknown(t *testing.T) {
+	if _, err := newEffect("strobe", nil, effectParams{}); err == nil {
+		t.Error("expected an error for an unknown effect")
+	}
+	if _, err := parseEffect("screen"); err != nil {
+		t.Errorf("parseEffect(screen): %v", err)
+	}
+}
+
+func TestFlickerEffect_Intensity(t *testing.T) {
+	channels := testChannels(0, 0)
+	steady, _ := newEffect("candle", channels, effectParams{Speed: 1, Intensity: 0})
+	lively, _ := newEffect("candle", channels, effectParams{Speed: 1, Intensity: 1})
+
+	var dimmest uint8 = 255
+	for at := time.Duration(0); at < 10*time.Second; at += 50 * time.Millisecond {
+		if c := steady.Colors(at)[0]; c.R != 255 {
+			t.Fatalf("expected no flicker at intensity 0, got %v at %v", c, at)
+		}
+		dimmest = min(dimmest, lively.Colors(at)[0].R)
+	}
+	if dimmest > 200 {
+		t.Errorf("expected flicker at intensity 1, dimmest red was %d", dimmest)
+	}
+
+	// Channels flicker independently.
+	if c := lively.Colors(3 * time.Second); c[0] == c[1] {
+		t.Errorf("expected channels to differ, got %v", c)
+	}
+}
+
+func TestRainbowEffect_SpreadsAndDrifts(t *testing.T) {
+	e, _ := newEffect("rainbow", testChannels(-1, 1), effectParams{Speed: 1, Intensity: 1})
+	start := e.Colors(0)
+	if start[0] != (RGB{R: 255}) || start[1] != (RGB{G: 255, B: 255}) {
+		t.Errorf("expected red and cyan at the edges, got %v", start)
+	}
+	if later := e.Colors(rainbowPeriod / 3); later[0] != (RGB{G: 255}) {
+		t.Errorf("expected the left edge to drift to green, got %v", later[0])
+	}
+
+	fast, _ := newEffect("rainbow", testChannels(-1, 1), effectParams{Speed: 2, Intensity: 1})
+	if fast.Colors(rainbowPeriod / 6)[0] != e.Colors(rainbowPeriod / 3)[0] {
+		t.Error("expected speed 2 to drift twice as fast")
+	}
+}
+
+func TestBreathingEffect_Cycle(t *testing.T) {
+	palette := []RGB{{R: 200}, {G: 200}}
+	e, _ := newEffect("breathing", testChannels(0), effectParams{Speed: 1, Intensity: 1, Palette: palette})
+	if c := e.Colors(0)[0]; c != (RGB{}) {
+		t.Errorf("expected darkness at the start of a breath, got %v", c)
+	}
+	if c := e.Colors(breathingPeriod / 2)[0]; c != palette[0] {
+		t.Errorf("expected the first color at the peak, got %v", c)
+	}
+	if c := e.Colors(breathingPeriod * 3 / 2)[0]; c != palette[1] {
+		t.Errorf("expected the second color at the next peak, got %v", c)
+	}
+
+	half, _ := newEffect("breathing", testChannels(0), effectParams{Speed: 1, Intensity: 0.5, Palette: palette})
+	if c := half.Colors(0)[0]; c != (RGB{R: 100}) {
+		t.Errorf("expected half brightness at the low point, got %v", c)
+	}
+}
+
+func TestColorloopEffect_Loops(t *testing.T) {
+	e, _ := newEffect("colorloop", testChannels(-1, 1), effectParams{Speed: 1, Intensity: 1})
+	a, b := e.Colors(10*time.Second), e.Colors(10*time.Second+colorloopPeriod)
+	if a[0] != b[0] || a[0] != a[1] {
+		t.Errorf("expected the same color on all channels every period, got %v and %v", a, b)
+	}
+}
+
+func TestBiasEffect(t *testing.T) {
+	e, _ := newEffect("bias", testChannels(0), effectParams{Speed: 1, Intensity: 0.5})
+	if c := e.Colors(time.Minute)[0]; c != (RGB{R: 128, G: 128, B: 128}) {
+		t.Errorf("expected half-bright white, got %v", c)
+	}
+	e, _ = newEffect("bias", testChannels(0), effectParams{Speed: 1, Intensity: 1, Palette: []RGB{{R: 255, G: 200, B: 150}}})
+	if c := e.Colors(0)[0]; c != (RGB{R: 255, G: 200, B: 150}) {
+		t.Errorf("expected the palette color, got %v", c)
+	}
+}
+
+func TestValueNoise_SmoothAndBounded(t *testing.T) {
+	prev := valueNoise(7, 0)
+	for x := 0.01; x < 50; x += 0.01 {
+		v := valueNoise(7, x)
+		if v < 0 || v > 1 {
+			t.Fatalf("noise %v out of range at %v", v, x)
+		}
+		if math.Abs(v-prev) > 0.02 {
+			t.Fatalf("noise jumps by %v at %v", v-prev, x)
+		}
+		prev = v
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	p, err := parsePalette("#ff8000, 0080ff")
+	if err != nil {
+		t.Fatalf("parsePalette: %v", err)
+	}
+	if len(p) != 2 || p[0] != (RGB{R: 255, G: 128}) || p[1] != (RGB{G: 128, B: 255}) {
+		t.Errorf("unexpected palette %v", p)
+	}
+	for _, s := range []string{"", "#ff80", "red", "#ff8000,"} {
+		if _, err := parsePalette(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
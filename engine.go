@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"time"
+)
+
+// engineStatus is a snapshot of a running stream engine.
+type engineStatus struct {
+	// Colors are the last colors sent, Crop that of the last captured frame.
+	Colors []RGB
+	Crop   image.Rectangle
+	Link   linkStatus
+	// CaptureErr is the error of the last capture, if it failed; the
+	// previous frame keeps being sent meanwhile.
+	CaptureErr error
+	// SendErr is the error of the last send, if it failed.
+	SendErr error
+	// Sent counts the frames sent successfully.
+	Sent int
+}
+
+// colorSource produces one color per channel for the stream engine.
+type colorSource interface {
+	// Colors returns the channel colors at now, and the crop of the
+	// captured frame if the colors come from the screen.
+	Colors(now time.Time) ([]RGB, image.Rectangle, error)
+	// SnapThreshold is the mean color change above which new colors are
+	// sent at once instead of blended; 0 always blends.
+	SnapThreshold() float64
+}
+
+// screenSource captures the screen and turns each frame into channel colors.
+type screenSource struct {
+	capturer Capturer
+	pipeline *colorPipeline
+}
+
+func (s screenSource) Colors(now time.Time) ([]RGB, image.Rectangle, error) {
+	frame, err := s.capturer.CaptureFrame()
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	colors, crop := s.pipeline.Process(frame, now)
+	return colors, crop, nil
+}
+
+func (s screenSource) SnapThreshold() float64 {
+	_, snap := s.pipeline.Smoothing()
+	return snap
+}
+
+type capturedFrame struct {
+	colors []RGB
+	crop   image.Rectangle
+	at     time.Time
+	err    error
+}
+
+// streamEngine captures and sends frames in the background, in two
+// independent loops. Capturing from the source runs at the capture delay, or
+// as fast as the source allows if that is slower. Sending runs at a fixed rate, blending
+// between the two latest captures, so motion stays smooth when capture is
+// slow, bandwidth stays flat when it is fast, and the session never times
+// out on capture hiccups: the bridge ends it after about 10 seconds without
+// packets. Until the first capture succeeds, black is sent.
+type streamEngine struct {
+	stream   *streamSupervisor
+	source   colorSource
+	delay    time.Duration
+	interval time.Duration
+	onStatus func(engineStatus)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// startStreamEngine starts streaming, capturing from src every delay and
+// sending rate times per second. onStatus, if not nil, is called from the
+// engine's goroutine after every send and failed capture.
+func startStreamEngine(s *streamSupervisor, src colorSource, delay time.Duration, rate int, onStatus func(engineStatus)) *streamEngine {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &streamEngine{
+		stream:   s,
+		source:   src,
+		delay:    delay,
+		interval: time.Second / time.Duration(rate),
+		onStatus: onStatus,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		e.err = e.run(ctx)
+		close(e.done)
+	}()
+	return e
+}
+
+// Done is closed when the engine has stopped.
+func (e *streamEngine) Done() <-chan struct{} { return e.done }
+
+// Err returns the reason the engine stopped on its own: an error wrapping
+// ErrReconnectFailed. It is nil after Stop. Call it only after Done is closed.
+func (e *streamEngine) Err() error { return e.err }
+
+// Stop stops the engine and waits until it no longer uses the stream or the
+// source.
+func (e *streamEngine) Stop() {
+	e.cancel()
+	<-e.done
+}
+
+func (e *streamEngine) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	frames := make(chan capturedFrame, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.captureLoop(ctx, frames)
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var status engineStatus
+	var interp interpolator
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return nil
+		case f := <-frames:
+			status.CaptureErr = f.err
+			if f.err != nil {
+				e.publish(status)
+				continue
+			}
+			interp.Push(f.colors, f.at, e.source.SnapThreshold())
+			status.Crop = f.crop
+			continue
+		case now = <-ticker.C:
+		}
+
+		colors := interp.At(now)
+		if colors == nil {
+			colors = make([]RGB, e.stream.Channels())
+		}
+		err := e.stream.SendFrame(colors, now)
+		if errors.Is(err, ErrReconnectFailed) {
+			return err
+		}
+		status.Colors = colors
+		status.SendErr = err
+		if err == nil {
+			status.Sent++
+		}
+		status.Link = e.stream.Status()
+		e.publish(status)
+	}
+}
+
+// captureLoop captures a frame every delay and hands it to frames, replacing
+// a frame that was not picked up yet.
+func (e *streamEngine) captureLoop(ctx context.Context, frames chan capturedFrame) {
+	ticker := time.NewTicker(e.delay)
+	defer ticker.Stop()
+	for {
+		f := capturedFrame{at: time.Now()}
+		f.colors, f.crop, f.err = e.source.Colors(f.at)
+
+		select {
+		case <-frames:
+		default:
+		}
+		frames <- f
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *streamEngine) publish(status engineStatus) {
+	if e.onStatus != nil {
+		e.onStatus(status)
+	}
+}
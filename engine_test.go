@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// scriptedCapturer returns good frames until frames runs out, then err. A
+// negative frames never runs out.
+type scriptedCapturer struct {
+	frames   int
+	err      error
+	captures int
+}
+
+func (c *scriptedCapturer) CaptureFrame() (Frame, error) {
+	c.captures++
+	if c.frames == 0 {
+		return Frame{}, c.err
+	}
+	c.frames--
+	return splitFrame(RGB{R: 200}, RGB{R: 200}, 1), nil
+}
+
+func (c *scriptedCapturer) Close() error { return nil }
+
+func testEngine(t *testing.T, conn *fakeConn, c Capturer, maxAttempts int) *streamEngine {
+	t.Helper()
+	p, err := newColorPipeline([]Channel{{ID: 0}}, defaultOptions())
+	if err != nil {
+		t.Fatalf("newColorPipeline: %v", err)
+	}
+	sv := newStreamSupervisor(fakeStreamer(conn), func() (*Streamer, error) {
+		return nil, errors.New("unreachable")
+	}, maxAttempts)
+	return &streamEngine{
+		stream:   sv,
+		source:   screenSource{capturer: c, pipeline: p},
+		delay:    time.Hour,
+		interval: 10 * time.Millisecond,
+	}
+}
+
+func TestStreamEngine_RepeatsLastFrame(t *testing.T) {
+	conn := &fakeConn{}
+	e := testEngine(t, conn, &scriptedCapturer{frames: 1, err: errors.New("no frame")}, 3)
+
+	var last engineStatus
+	e.onStatus = func(st engineStatus) { last = st }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := e.run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// One capture in the hour-long delay, yet frames kept flowing.
+	if conn.writes < 5 {
+		t.Errorf("expected frames at the send rate, got %d writes", conn.writes)
+	}
+	if last.Sent != conn.writes || len(last.Colors) != 1 || last.Colors[0].R == 0 {
+		t.Errorf("unexpected final status %+v", last)
+	}
+}
+
+func TestStreamEngine_FastCaptureKeepsSendRate(t *testing.T) {
+	conn := &fakeConn{}
+	c := &scriptedCapturer{frames: -1}
+	e := testEngine(t, conn, c, 3)
+	e.delay = time.Millisecond
+	e.interval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 220*time.Millisecond)
+	defer cancel()
+	if err := e.run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if c.captures < 20 {
+		t.Errorf("expected fast captures, got %d", c.captures)
+	}
+	if conn.writes == 0 || conn.writes > 5 {
+		t.Errorf("expected at most 4 frames at 20 fps, got %d", conn.writes)
+	}
+}
+
+func TestStreamEngine_KeepaliveBeforeFirstCapture(t *testing.T) {
+	conn := &fakeConn{}
+	e := testEngine(t, conn, &scriptedCapturer{err: errors.New("no frame")}, 3)
+
+	var last engineStatus
+	e.onStatus = func(st engineStatus) { last = st }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// Black keeps the session alive until a capture succeeds.
+	if conn.writes < 3 {
+		t.Errorf("expected frames at the send rate before the first capture, got %d writes", conn.writes)
+	}
+	if last.CaptureErr == nil || len(last.Colors) != 1 || last.Colors[0] != (RGB{}) {
+		t.Errorf("expected black and the capture error in the status, got %+v", last)
+	}
+}
+
+func TestStreamEngine_StopsWhenReconnectFails(t *testing.T) {
+	e := testEngine(t, &fakeConn{err: dtls.ErrConnClosed}, &scriptedCapturer{frames: 1}, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.run(ctx); !errors.Is(err, ErrReconnectFailed) {
+		t.Fatalf("expected ErrReconnectFailed, got %v", err)
+	}
+}
+
+func TestStreamEngine_Stop(t *testing.T) {
+	conn := &fakeConn{}
+	p, err := newColorPipeline([]Channel{{ID: 0}}, defaultOptions())
+	if err != nil {
+		t.Fatalf("newColorPipeline: %v", err)
+	}
+	sv := newStreamSupervisor(fakeStreamer(conn), nil, 0)
+	e := startStreamEngine(sv, screenSource{capturer: &scriptedCapturer{frames: 1}, pipeline: p}, time.Hour, 50, nil)
+	e.Stop()
+	if e.Err() != nil {
+		t.Errorf("expected no error after Stop, got %v", e.Err())
+	}
+}
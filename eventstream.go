@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	eventRetryInitial = time.Second
+	eventRetryMax     = 30 * time.Second
+)
+
+// TakeoverError reports that another application started streaming to the
+// entertainment area.
+type TakeoverError struct {
+	// Owner describes the application, e.g. "Hue Sync (DESKTOP)".
+	Owner string
+}
+
+func (e *TakeoverError) Error() string { return "area taken over by " + e.Owner }
+
+// FetchApplicationID returns the application ID the bridge assigned to
+// username. The bridge names it as the active streamer of the areas that
+// application streams to.
+func FetchApplicationID(ip net.IP, username string) (string, error) {
+	req, err := newHueRequest("GET", bridgeURL(ip, "/auth/v1"), nil, username)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := hueClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 403 {
+		return "", ErrUnauthorized
+	}
+	id := resp.Header.Get("hue-application-id")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || id == "" {
+		return "", fmt.Errorf("no application ID (HTTP %d)", resp.StatusCode)
+	}
+	return id, nil
+}
+
+// clipEvent is one event of the CLIP v2 event stream.
+type clipEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"` // add, update, delete or error
+	Data []eventResource `json:"data"`
+}
+
+// eventResource holds the fields of a changed resource that huesync
+// follows. Update events only carry the fields that changed.
+type eventResource struct {
+	ID             string       `json:"id"`
+	Type           string       `json:"type"`
+	Status         string       `json:"status"`
+	ActiveStreamer *resourceRef `json:"active_streamer"`
+	On             *onData      `json:"on"`
+}
+
+// eventStream reads the server-sent events of the bridge's CLIP v2 event
+// stream.
+type eventStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// openEventStream connects to the event stream. Closing ctx ends it.
+func openEventStream(ctx context.Context, ip net.IP, username string) (*eventStream, error) {
+	req, err := newHueRequest("GET", bridgeURL(ip, "/eventstream/clip/v2"), nil, username)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := hueClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 403 {
+		resp.Body.Close()
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("event stream: HTTP %d", resp.StatusCode)
+	}
+	return &eventStream{body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next returns the events of the next message. Comments, such as the
+// bridge's greeting, are skipped.
+func (s *eventStream) Next() ([]clipEvent, error) {
+	var data bytes.Buffer
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			var events []clipEvent
+			if err := json.Unmarshal(data.Bytes(), &events); err != nil {
+				return nil, fmt.Errorf("decoding event: %w", err)
+			}
+			return events, nil
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Other fields (id, event, retry) and comments are not needed.
+	}
+}
+
+func (s *eventStream) Close() error { return s.body.Close() }
+
+// areaEvent is a change to a streamed entertainment area noticed by an
+// areaWatcher.
+type areaEvent struct {
+	// Takeover is set when another application started streaming to the
+	// area.
+	Takeover *TakeoverError
+	// Light names a light of the area that was switched on or off, as On
+	// tells.
+	Light string
+	On    bool
+	// Err is set when the event stream failed; the watcher reconnects.
+	Err error
+}
+
+// areaWatcher follows the bridge's event stream for another application
+// taking over an entertainment area and for its lights being switched on or
+// off, so that huesync need not learn about either from failing writes.
+type areaWatcher struct {
+	ip       net.IP
+	username string
+	appID    string
+	area     EntertainmentArea
+
+	events chan areaEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	names    map[string]string
+	streamer string // the active streamer last reported
+}
+
+// watchArea starts watching area. appID is huesync's own application ID,
+// from FetchApplicationID.
+func watchArea(ip net.IP, username, appID string, area EntertainmentArea) *areaWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &areaWatcher{
+		ip:       ip,
+		username: username,
+		appID:    appID,
+		area:     area,
+		events:   make(chan areaEvent, 16),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		names:    make(map[string]string),
+	}
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		w.run(ctx)
+	}()
+	return w
+}
+
+// Events delivers the changes. It is closed when the watcher has stopped.
+func (w *areaWatcher) Events() <-chan areaEvent { return w.events }
+
+// Stop stops watching and waits for the watcher to finish.
+func (w *areaWatcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *areaWatcher) run(ctx context.Context) {
+	for _, id := range w.area.LightIDs {
+		w.names[id] = id
+	}
+	if states, err := FetchLightStates(w.ip, w.username, w.area.LightIDs); err == nil {
+		for _, s := range states {
+			w.names[s.ID] = s.Name
+		}
+	}
+
+	retry := eventRetryInitial
+	for {
+		err := w.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			retry = eventRetryInitial
+			continue
+		}
+		if !w.send(ctx, areaEvent{Err: err}) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(2*retry, eventRetryMax)
+	}
+}
+
+// follow reads the event stream until it fails. It returns nil if the stream
+// delivered events before it ended, so the watcher reconnects at once.
+func (w *areaWatcher) follow(ctx context.Context) error {
+	s, err := openEventStream(ctx, w.ip, w.username)
+	if err != nil {
+		return fmt.Errorf("opening event stream: %w", err)
+	}
+	defer s.Close()
+
+	// Catch up with a takeover missed while the stream was down.
+	var resp resourceResponse[entertainmentData]
+	if err := getResource(w.ip, w.username, "/clip/v2/resource/entertainment_configuration/"+w.area.ID, &resp); err == nil {
+		for _, d := range resp.Data {
+			r := eventResource{ID: d.ID, Type: "entertainment_configuration", Status: d.Status, ActiveStreamer: d.ActiveStreamer}
+			if e, ok := w.handle(r); ok && !w.send(ctx, e) {
+				return nil
+			}
+		}
+	}
+
+	received := false
+	for {
+		events, err := s.Next()
+		if err != nil {
+			if received {
+				return nil
+			}
+			return fmt.Errorf("event stream: %w", err)
+		}
+		received = true
+		for _, ev := range events {
+			if ev.Type != "update" && ev.Type != "add" {
+				continue
+			}
+			for _, r := range ev.Data {
+				if e, ok := w.handle(r); ok && !w.send(ctx, e) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// handle turns a changed resource into an areaEvent if it concerns the area.
+func (w *areaWatcher) handle(r eventResource) (areaEvent, bool) {
+	switch r.Type {
+	case "entertainment_configuration":
+		if r.ID != w.area.ID {
+			return areaEvent{}, false
+		}
+		if r.Status == "inactive" {
+			w.streamer = ""
+			return areaEvent{}, false
+		}
+		if r.ActiveStreamer == nil || r.ActiveStreamer.RID == w.streamer {
+			return areaEvent{}, false
+		}
+		w.streamer = r.ActiveStreamer.RID
+		if w.streamer == w.appID {
+			return areaEvent{}, false
+		}
+		return areaEvent{Takeover: &TakeoverError{Owner: streamOwner(w.ip, w.username, w.area.ID)}}, true
+	case "light":
+		name, ok := w.names[r.ID]
+		if !ok || r.On == nil {
+			return areaEvent{}, false
+		}
+		return areaEvent{Light: name, On: r.On.On}, true
+	}
+	return areaEvent{}, false
+}
+
+func (w *areaWatcher) send(ctx context.Context, e areaEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkStreamer returns a *TakeoverError if an application other than appID
+// streams to the area.
+func checkStreamer(ip net.IP, username, appID, areaID string) error {
+	var resp resourceResponse[entertainmentData]
+	if err := getResource(ip, username, "/clip/v2/resource/entertainment_configuration/"+areaID, &resp); err != nil {
+		return err
+	}
+	for _, d := range resp.Data {
+		if d.Status == "active" && d.ActiveStreamer != nil && d.ActiveStreamer.RID != appID {
+			return &TakeoverError{Owner: streamOwner(ip, username, areaID)}
+		}
+	}
+	return nil
+}
+
+// streamOwner names the application streaming to the area. CLIP v2 only
+// knows its application ID; the name comes from the v1 API, where the area's
+// group names the streaming user, and the whitelist that user's device type.
+func streamOwner(ip net.IP, username, areaID string) string {
+	const unknown = "another app"
+
+	var resp resourceResponse[entertainmentData]
+	if err := getResource(ip, username, "/clip/v2/resource/entertainment_configuration/"+areaID, &resp); err != nil || len(resp.Data) == 0 {
+		return unknown
+	}
+	groupPath, ok := strings.CutPrefix(resp.Data[0].IDV1, "/groups/")
+	if !ok {
+		return unknown
+	}
+
+	var group struct {
+		Stream struct {
+			Owner string `json:"owner"`
+		} `json:"stream"`
+	}
+	if err := getV1(ip, "/api/"+username+"/groups/"+groupPath, &group); err != nil || group.Stream.Owner == "" {
+		return unknown
+	}
+	var config struct {
+		Whitelist map[string]struct {
+			Name string `json:"name"`
+		} `json:"whitelist"`
+	}
+	if err := getV1(ip, "/api/"+username+"/config", &config); err != nil {
+		return unknown
+	}
+	name := config.Whitelist[group.Stream.Owner].Name
+	if name == "" {
+		return unknown
+	}
+	// Device types read "application#device".
+	if app, device, ok := strings.Cut(name, "#"); ok {
+		return fmt.Sprintf("%s (%s)", app, device)
+	}
+	return name
+}
+
+// getV1 GETs a resource of the v1 API, which takes the username in the path.
+// Errors come back as a JSON array, which fails to decode into v.
+func getV1(ip net.IP, path string, v any) error {
+	resp, err := hueClient.Get(bridgeURL(ip, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.New("unexpected v1 response")
+	}
+	return nil
+}
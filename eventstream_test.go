@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream_Next(t *testing.T) {
+	input := ": hi\n\n" +
+		"id: 1:0\r\n" +
+		"data: [{\"id\":\"e1\",\"type\":\"update\",\r\n" +
+		"data: \"data\":[{\"id\":\"l1\",\"type\":\"light\",\"on\":{\"on\":false}}]}]\r\n\r\n" +
+		"id: 2:0\n"
+	s := &eventStream{body: io.NopCloser(strings.NewReader(input)), r: bufio.NewReader(strings.NewReader(input))}
+
+	events, err := s.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != "update" || len(events[0].Data) != 1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if r := events[0].Data[0]; r.ID != "l1" || r.On == nil || r.On.On {
+		t.Errorf("unexpected resource %+v", r)
+	}
+
+	// The stream ending inside a message is an error.
+	if _, err := s.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// nextAreaEvent waits for the watcher's next event that is not a stream
+// error.
+func nextAreaEvent(t *testing.T, w *areaWatcher) areaEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-w.Events():
+			if !ok {
+				t.Fatal("watcher stopped")
+			}
+			if e.Err == nil {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for an area event")
+		}
+	}
+}
+
+func TestAreaWatcher_MockBridge(t *testing.T) {
+	b := startMockBridge(t, 2)
+	ip := net.IPv4(127, 0, 0, 1)
+	clientkey := "0123456789abcdef0123456789abcdef"
+	b.AddUser("user", clientkey)
+	area := b.Area()
+
+	appID, err := FetchApplicationID(ip, "user")
+	if err != nil {
+		t.Fatalf("FetchApplicationID: %v", err)
+	}
+	if _, err := FetchApplicationID(ip, "stranger"); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+
+	s, err := dialArea(ip, "user", clientkey, appID, area, ColorSpaceRGB, nil)
+	if err != nil {
+		t.Fatalf("dialArea: %v", err)
+	}
+	defer s.Close()
+
+	w := watchArea(ip, "user", appID, area)
+	defer w.Stop()
+
+	// Streaming ourselves is no takeover, but a light switched off is
+	// reported by name.
+	light := b.Lights()[1]
+	deadline := time.Now().Add(5 * time.Second)
+	var e areaEvent
+	for e.Light == "" {
+		// The watcher may not have subscribed yet.
+		b.SetLightOn(light.ID, false)
+		select {
+		case e = <-w.Events():
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the light event")
+		}
+	}
+	if e.Takeover != nil || e.Light != light.Name || e.On {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	b.TakeOver("Hue Sync#DESKTOP")
+	e = nextAreaEvent(t, w)
+	if e.Takeover == nil || e.Takeover.Owner != "Hue Sync (DESKTOP)" {
+		t.Fatalf("expected a takeover by Hue Sync (DESKTOP), got %+v", e)
+	}
+
+	// Reconnecting would now steal the area back.
+	var takeover *TakeoverError
+	if _, err := dialArea(ip, "user", clientkey, appID, area, ColorSpaceRGB, nil); !errors.As(err, &takeover) {
+		t.Errorf("expected dialArea to refuse with a TakeoverError, got %v", err)
+	}
+	if got := b.Area().Status; got != "active" {
+		t.Errorf("expected the area to stay active, got %q", got)
+	}
+}
+
+func TestAreaWatcher_Reconnect(t *testing.T) {
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	appID, err := FetchApplicationID(ip, "user")
+	if err != nil {
+		t.Fatalf("FetchApplicationID: %v", err)
+	}
+	w := watchArea(ip, "user", appID, b.Area())
+	defer w.Stop()
+	light := b.Lights()[0]
+
+	// lightEvent switches the light until the watcher reports it.
+	on := true
+	lightEvent := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			on = !on
+			b.SetLightOn(light.ID, on)
+			select {
+			case e := <-w.Events():
+				if e.Err != nil {
+					t.Fatalf("unexpected error %v", e.Err)
+				}
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the light event")
+			}
+		}
+	}
+
+	// A stream that delivered events is reconnected without waiting.
+	lightEvent()
+	start := time.Now()
+	b.DropEventStreams()
+	lightEvent()
+	if d := time.Since(start); d >= eventRetryInitial {
+		t.Errorf("expected to reconnect at once, took %s", d)
+	}
+}
+
+func TestStreamOwner_Unknown(t *testing.T) {
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+
+	// Nobody streams, so the v1 group names no owner.
+	if got := streamOwner(ip, "user", b.Area().ID); got != "another app" {
+		t.Errorf("expected another app, got %q", got)
+	}
+	if got := streamOwner(ip, "user", "missing"); got != "another app" {
+		t.Errorf("expected another app for a missing area, got %q", got)
+	}
+}
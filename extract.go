@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// ColorExtractor picks a representative color for a region of a frame.
+type ColorExtractor interface {
+	Extract(f Frame, r image.Rectangle) RGB
+}
+
+// extractorNames lists the available color extraction strategies in the
+// order the TUI cycles through them.
+var extractorNames = []string{"mean", "dominant", "kmeans"}
+
+// newColorExtractor returns the color extraction strategy with the given name.
+// space selects how the mean strategy averages pixels.
+func newColorExtractor(name string, space averagingSpace) (ColorExtractor, error) {
+	switch name {
+	case "mean":
+		return meanExtractor{space: space}, nil
+	case "dominant":
+		return dominantExtractor{}, nil
+	case "kmeans":
+		return kmeansExtractor{k: 4, iterations: 8, minShare: 0.15}, nil
+	}
+	return nil, fmt.Errorf("unknown color mode %q (want %s)", name, strings.Join(extractorNames, ", "))
+}
+
+// nextExtractorName returns the strategy after name in extractorNames.
+func nextExtractorName(name string) string {
+	for i, n := range extractorNames {
+		if n == name {
+			return extractorNames[(i+1)%len(extractorNames)]
+		}
+	}
+	return extractorNames[0]
+}
+
+// meanExtractor returns the mean color of the region.
+type meanExtractor struct {
+	space averagingSpace
+}
+
+func (e meanExtractor) Extract(f Frame, r image.Rectangle) RGB {
+	return averageRegion(f, r, e.space)
+}
+
+// dominantBits is the number of bits per channel kept when quantizing colors
+// into histogram bins.
+const dominantBits = 4
+
+// dominantExtractor returns the most common color of the region. Pixels are
+// quantized into a coarse histogram; the result is the mean of the pixels in
+// the fullest bin, so it is a color that actually appears on screen.
+type dominantExtractor struct{}
+
+func (dominantExtractor) Extract(f Frame, r image.Rectangle) RGB {
+	r = r.Intersect(image.Rect(0, 0, f.Width, f.Height))
+	if r.Empty() {
+		return RGB{}
+	}
+
+	const shift = 8 - dominantBits
+	const bins = 1 << (3 * dominantBits)
+	var counts [bins]uint32
+	var sums [bins][3]uint32
+
+	best := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := (y*f.Width + r.Min.X) * 3
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb := f.Pix[off], f.Pix[off+1], f.Pix[off+2]
+			bin := int(cr>>shift)<<(2*dominantBits) | int(cg>>shift)<<dominantBits | int(cb>>shift)
+			counts[bin]++
+			sums[bin][0] += uint32(cr)
+			sums[bin][1] += uint32(cg)
+			sums[bin][2] += uint32(cb)
+			if counts[bin] > counts[best] {
+				best = bin
+			}
+			off += 3
+		}
+	}
+
+	n := counts[best]
+	return RGB{
+		R: uint8(sums[best][0] / n),
+		G: uint8(sums[best][1] / n),
+		B: uint8(sums[best][2] / n),
+	}
+}
+
+// kmeansExtractor clusters the region's pixels with k-means and returns the
+// most saturated cluster that covers at least minShare of the region. A bright
+// object on a dull background wins over the background, but isolated specks
+// of color do not.
+type kmeansExtractor struct {
+	k          int
+	iterations int
+	minShare   float64
+}
+
+func (e kmeansExtractor) Extract(f Frame, r image.Rectangle) RGB {
+	r = r.Intersect(image.Rect(0, 0, f.Width, f.Height))
+	if r.Empty() {
+		return RGB{}
+	}
+
+	pixels := make([][3]float64, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := (y*f.Width + r.Min.X) * 3
+		for x := r.Min.X; x < r.Max.X; x++ {
+			pixels = append(pixels, [3]float64{float64(f.Pix[off]), float64(f.Pix[off+1]), float64(f.Pix[off+2])})
+			off += 3
+		}
+	}
+
+	k := min(e.k, len(pixels))
+	// Seed deterministically with pixels spread evenly across the region.
+	centers := make([][3]float64, k)
+	for i := range centers {
+		centers[i] = pixels[i*len(pixels)/k]
+	}
+
+	assign := make([]int, len(pixels))
+	counts := make([]int, k)
+	for iter := 0; iter < e.iterations; iter++ {
+		sums := make([][3]float64, k)
+		clear(counts)
+		for i, p := range pixels {
+			best, bestDist := 0, -1.0
+			for c, center := range centers {
+				d := sqDist(p, center)
+				if bestDist < 0 || d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			assign[i] = best
+			counts[best]++
+			sums[best][0] += p[0]
+			sums[best][1] += p[1]
+			sums[best][2] += p[2]
+		}
+		for c := range centers {
+			if counts[c] > 0 {
+				n := float64(counts[c])
+				centers[c] = [3]float64{sums[c][0] / n, sums[c][1] / n, sums[c][2] / n}
+			}
+		}
+	}
+
+	best, bestSat := -1, -1.0
+	for c, center := range centers {
+		if float64(counts[c]) < e.minShare*float64(len(pixels)) {
+			continue
+		}
+		s := saturation(center)
+		if s > bestSat || (s == bestSat && counts[c] > counts[best]) {
+			best, bestSat = c, s
+		}
+	}
+	if best < 0 {
+		return averageRegion(f, r, spaceLinear)
+	}
+	return RGB{
+		R: uint8(centers[best][0] + 0.5),
+		G: uint8(centers[best][1] + 0.5),
+		B: uint8(centers[best][2] + 0.5),
+	}
+}
+
+func sqDist(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// saturation returns the HSV saturation of an RGB triple in 0..1.
+func saturation(c [3]float64) float64 {
+	hi := max(c[0], c[1], c[2])
+	lo := min(c[0], c[1], c[2])
+	if hi == 0 {
+		return 0
+	}
+	return (hi - lo) / hi
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// splitFrame returns a capture-sized frame whose leftmost share of columns is
+// object and the rest background.
+func splitFrame(object, background RGB, share float64) Frame {
+	f := Frame{Pix: make([]byte, frameSize), Width: captureWidth, Height: captureHeight}
+	split := int(share * float64(captureWidth))
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			c := background
+			if x < split {
+				c = object
+			}
+			off := (y*f.Width + x) * 3
+			f.Pix[off] = c.R
+			f.Pix[off+1] = c.G
+			f.Pix[off+2] = c.B
+		}
+	}
+	return f
+}
+
+var fullFrame = image.Rect(0, 0, captureWidth, captureHeight)
+
+func TestMeanExtractor_Mixes(t *testing.T) {
+	f := splitFrame(RGB{R: 255}, RGB{B: 255}, 0.5)
+	got := meanExtractor{space: spaceLinear}.Extract(f, fullFrame)
+	if got.R != 188 || got.G != 0 || got.B != 188 {
+		t.Errorf("expected RGB{188, 0, 188}, got %v", got)
+	}
+}
+
+func TestDominantExtractor_PicksMajority(t *testing.T) {
+	f := splitFrame(RGB{R: 255}, RGB{R: 10, G: 40, B: 200}, 0.25)
+	got := dominantExtractor{}.Extract(f, fullFrame)
+	if got != (RGB{R: 10, G: 40, B: 200}) {
+		t.Errorf("expected background RGB{10, 40, 200}, got %v", got)
+	}
+}
+
+func TestDominantExtractor_Empty(t *testing.T) {
+	f := splitFrame(RGB{}, RGB{}, 0)
+	if got := (dominantExtractor{}).Extract(f, image.Rectangle{}); got != (RGB{}) {
+		t.Errorf("expected RGB{0, 0, 0}, got %v", got)
+	}
+}
+
+func TestKmeansExtractor_PicksSaturatedObject(t *testing.T) {
+	ex, err := newColorExtractor("kmeans", spaceLinear)
+	if err != nil {
+		t.Fatalf("newColorExtractor: %v", err)
+	}
+	// Bright red object on a dull grey-blue background.
+	f := splitFrame(RGB{R: 230, G: 20, B: 20}, RGB{R: 90, G: 95, B: 110}, 0.3)
+	got := ex.Extract(f, fullFrame)
+	if got != (RGB{R: 230, G: 20, B: 20}) {
+		t.Errorf("expected red object RGB{230, 20, 20}, got %v", got)
+	}
+}
+
+func TestKmeansExtractor_IgnoresSpecks(t *testing.T) {
+	ex, _ := newColorExtractor("kmeans", spaceLinear)
+	// A 5% sliver of saturated red is below the significance threshold.
+	f := splitFrame(RGB{R: 255}, RGB{R: 90, G: 95, B: 110}, 0.05)
+	got := ex.Extract(f, fullFrame)
+	if got != (RGB{R: 90, G: 95, B: 110}) {
+		t.Errorf("expected background RGB{90, 95, 110}, got %v", got)
+	}
+}
+
+func TestNewColorExtractor_Unknown(t *testing.T) {
+	if _, err := newColorExtractor("median", spaceLinear); err == nil {
+		t.Fatal("expected error for unknown color mode")
+	}
+}
+
+func TestNextExtractorName_Cycles(t *testing.T) {
+	name := extractorNames[0]
+	for range extractorNames {
+		name = nextExtractorName(name)
+	}
+	if name != extractorNames[0] {
+		t.Errorf("expected cycle back to %q, got %q", extractorNames[0], name)
+	}
+}
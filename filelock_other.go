@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile does nothing where flock is unavailable; writes are still atomic,
+// but concurrent changes may be lost.
+func lockFile(f *os.File) error { return nil }
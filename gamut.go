@@ -0,0 +1,113 @@
+package main
+
+import "fmt"
+
+// XY is a CIE 1931 chromaticity coordinate.
+type XY struct {
+	X, Y float64
+}
+
+// Gamut is the triangle of chromaticities a light can reproduce.
+type Gamut struct {
+	Red, Green, Blue XY
+}
+
+// Standard Hue gamuts, from the Philips Hue developer documentation.
+var (
+	gamutA = Gamut{Red: XY{0.704, 0.296}, Green: XY{0.2151, 0.7106}, Blue: XY{0.138, 0.08}}
+	gamutB = Gamut{Red: XY{0.675, 0.322}, Green: XY{0.409, 0.518}, Blue: XY{0.167, 0.04}}
+	gamutC = Gamut{Red: XY{0.6915, 0.3083}, Green: XY{0.17, 0.7}, Blue: XY{0.1532, 0.0475}}
+)
+
+// whitePoint is the D65 white point, used for black where chromaticity is
+// undefined.
+var whitePoint = XY{0.3127, 0.3290}
+
+// gamutByType returns the standard gamut for a CLIP v2 gamut_type.
+func gamutByType(t string) (Gamut, bool) {
+	switch t {
+	case "A":
+		return gamutA, true
+	case "B":
+		return gamutB, true
+	case "C":
+		return gamutC, true
+	}
+	return Gamut{}, false
+}
+
+// IsZero reports whether g is unknown.
+func (g Gamut) IsZero() bool {
+	return g == Gamut{}
+}
+
+// Contains reports whether p lies inside the gamut triangle.
+func (g Gamut) Contains(p XY) bool {
+	d1 := cross(g.Red, g.Green, p)
+	d2 := cross(g.Green, g.Blue, p)
+	d3 := cross(g.Blue, g.Red, p)
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNeg && hasPos)
+}
+
+// Clamp returns p if the light can reproduce it, otherwise the closest point
+// on the edge of the gamut triangle. A zero gamut leaves p unchanged.
+func (g Gamut) Clamp(p XY) XY {
+	if g.IsZero() || g.Contains(p) {
+		return p
+	}
+	best := closestOnSegment(g.Red, g.Green, p)
+	for _, q := range []XY{closestOnSegment(g.Green, g.Blue, p), closestOnSegment(g.Blue, g.Red, p)} {
+		if xyDist(q, p) < xyDist(best, p) {
+			best = q
+		}
+	}
+	return best
+}
+
+func (g Gamut) String() string {
+	for _, t := range []string{"A", "B", "C"} {
+		if std, _ := gamutByType(t); std == g {
+			return "gamut " + t
+		}
+	}
+	if g.IsZero() {
+		return "unknown gamut"
+	}
+	return fmt.Sprintf("gamut R%v G%v B%v", g.Red, g.Green, g.Blue)
+}
+
+// cross returns the z component of (b-a)×(p-a).
+func cross(a, b, p XY) float64 {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+func closestOnSegment(a, b, p XY) XY {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	t = min(max(t, 0), 1)
+	return XY{a.X + t*dx, a.Y + t*dy}
+}
+
+func xyDist(a, b XY) float64 {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	return dx*dx + dy*dy
+}
+
+// rgbToXYBrightness converts an sRGB color to CIE xy and a brightness in
+// 0..1. The brightness is the largest linear channel, so saturated colors are
+// driven as hard as they would be in RGB mode.
+func rgbToXYBrightness(c RGB) (XY, float64) {
+	r, g, b := srgbToLinear[c.R], srgbToLinear[c.G], srgbToLinear[c.B]
+	bri := max(r, g, b)
+
+	x := 0.4124*r + 0.3576*g + 0.1805*b
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := 0.0193*r + 0.1192*g + 0.9505*b
+	sum := x + y + z
+	if sum == 0 {
+		return whitePoint, 0
+	}
+	return XY{x / sum, y / sum}, bri
+}
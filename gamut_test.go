@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRGBToXYBrightness_White(t *testing.T) {
+	xy, bri := rgbToXYBrightness(RGB{R: 255, G: 255, B: 255})
+	if math.Abs(xy.X-whitePoint.X) > 0.001 || math.Abs(xy.Y-whitePoint.Y) > 0.001 {
+		t.Errorf("expected D65 white point %v, got %v", whitePoint, xy)
+	}
+	if bri != 1 {
+		t.Errorf("expected brightness 1, got %v", bri)
+	}
+}
+
+func TestRGBToXYBrightness_Black(t *testing.T) {
+	xy, bri := rgbToXYBrightness(RGB{})
+	if xy != whitePoint || bri != 0 {
+		t.Errorf("expected white point at brightness 0, got %v at %v", xy, bri)
+	}
+}
+
+func TestRGBToXYBrightness_SaturatedKeepsBrightness(t *testing.T) {
+	_, bri := rgbToXYBrightness(RGB{B: 255})
+	if bri != 1 {
+		t.Errorf("expected full brightness for pure blue, got %v", bri)
+	}
+}
+
+func TestGamut_ContainsWhite(t *testing.T) {
+	// Gamut B famously stops just short of D65, so only A and C are checked.
+	for _, g := range []Gamut{gamutA, gamutC} {
+		if !g.Contains(whitePoint) {
+			t.Errorf("%v should contain the white point", g)
+		}
+		if got := g.Clamp(whitePoint); got != whitePoint {
+			t.Errorf("%v: white point should not move, got %v", g, got)
+		}
+	}
+}
+
+func TestGamut_ClampOutside(t *testing.T) {
+	// sRGB pure green lies outside gamut B.
+	green, _ := rgbToXYBrightness(RGB{G: 255})
+	if gamutB.Contains(green) {
+		t.Fatalf("expected %v outside gamut B", green)
+	}
+	got := gamutB.Clamp(green)
+	onEdge := false
+	for _, q := range []XY{
+		closestOnSegment(gamutB.Red, gamutB.Green, got),
+		closestOnSegment(gamutB.Green, gamutB.Blue, got),
+		closestOnSegment(gamutB.Blue, gamutB.Red, got),
+	} {
+		if xyDist(q, got) < 1e-12 {
+			onEdge = true
+		}
+	}
+	if !onEdge {
+		t.Errorf("clamped point %v not on the edge of gamut B", got)
+	}
+	for _, corner := range []XY{gamutB.Red, gamutB.Green, gamutB.Blue} {
+		if xyDist(got, green) > xyDist(corner, green)+1e-12 {
+			t.Errorf("clamped point %v farther from %v than corner %v", got, green, corner)
+		}
+	}
+}
+
+func TestGamut_ClampToCorner(t *testing.T) {
+	// A point far beyond the red corner clamps onto the corner.
+	got := gamutC.Clamp(XY{0.9, 0.2})
+	if math.Abs(got.X-gamutC.Red.X) > 0.02 || math.Abs(got.Y-gamutC.Red.Y) > 0.02 {
+		t.Errorf("expected a point near the red corner %v, got %v", gamutC.Red, got)
+	}
+}
+
+func TestGamut_ZeroLeavesUnchanged(t *testing.T) {
+	p := XY{0.9, 0.9}
+	if got := (Gamut{}).Clamp(p); got != p {
+		t.Errorf("expected %v unchanged, got %v", p, got)
+	}
+}
+
+func TestGamutByType(t *testing.T) {
+	if g, ok := gamutByType("C"); !ok || g != gamutC {
+		t.Errorf("expected gamut C, got %v (%v)", g, ok)
+	}
+	if _, ok := gamutByType("other"); ok {
+		t.Error("expected unknown gamut type")
+	}
+}
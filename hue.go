@@ -1,19 +1,28 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+// Bridge ports. They are variables so that tests can point huesync at a
+// mock bridge.
+var (
+	bridgeHTTPSPort  = 443
+	bridgeStreamPort = 2100
+)
+
+// hueClient talks to the bridges' HTTPS API. Only bridges found by
+// discovery can be reached; see verifyBridgeCertificate.
 var hueClient = &http.Client{
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	},
+	Transport: &http.Transport{DialTLSContext: dialBridgeTLS},
 }
 
 // ErrLinkButtonNotPressed is returned by PairBridge when the user has not yet
@@ -23,6 +32,11 @@ var ErrLinkButtonNotPressed = errors.New("link button not pressed")
 // ErrUnauthorized is returned when the bridge rejects the API credentials.
 var ErrUnauthorized = errors.New("unauthorized")
 
+// ErrUnpairRefused is returned by UnpairBridge when the bridge keeps the key.
+// Since firmware 1.31, bridges no longer let applications remove keys from
+// their whitelist; only the Hue account portal can.
+var ErrUnpairRefused = errors.New("the bridge does not let applications remove their key; revoke huesync's key in your Hue account at account.meethue.com under Apps")
+
 // PairBridge registers a new application with the Hue bridge at the given IP.
 // The user must press the link button on the bridge before calling this.
 func PairBridge(ip net.IP) (username, clientkey string, err error) {
@@ -59,18 +73,88 @@ func PairBridge(ip net.IP) (username, clientkey string, err error) {
 	return r.Success.Username, r.Success.Clientkey, nil
 }
 
+// UnpairBridge removes username from the whitelist of the bridge at the given
+// IP, so that its credentials no longer work anywhere. It returns
+// ErrUnauthorized if the bridge does not know username, e.g. because it was
+// removed already, and ErrUnpairRefused if the bridge keeps it.
+func UnpairBridge(ip net.IP, username string) error {
+	req, err := http.NewRequest("DELETE", bridgeURL(ip, "/api/"+username+"/config/whitelist/"+username), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := hueClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Unlike when pairing, success is a string here.
+	var result []struct {
+		Success json.RawMessage `json:"success"`
+		Error   *pairError      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding unpair response: %w", err)
+	}
+	if len(result) == 0 {
+		return fmt.Errorf("empty unpair response")
+	}
+	if e := result[0].Error; e != nil {
+		if e.Type == 1 {
+			// Newer bridges refuse with the same error as for an unknown
+			// key, so ask whether the key still works.
+			switch _, err := FetchApplicationID(ip, username); {
+			case err == nil:
+				return ErrUnpairRefused
+			case errors.Is(err, ErrUnauthorized):
+				return ErrUnauthorized
+			default:
+				return fmt.Errorf("checking whether the bridge kept the key: %w", err)
+			}
+		}
+		return fmt.Errorf("bridge error %d: %s", e.Type, e.Description)
+	}
+	return nil
+}
+
+// Position is the location of a channel within an entertainment area. Each
+// axis ranges from -1 to 1: x runs from left to right, y from back to front
+// and z from bottom to top.
+type Position struct {
+	X, Y, Z float64
+}
+
+// Channel is a single streamable light segment of an entertainment area.
+type Channel struct {
+	ID       uint8
+	Position Position
+	// ServiceIDs are the entertainment services (one per light segment)
+	// that render this channel.
+	ServiceIDs []string
+}
+
 // EntertainmentArea represents a Hue entertainment configuration.
 type EntertainmentArea struct {
-	ID         string
-	Name       string
-	Type       string
-	Status     string
-	ChannelIDs []uint8
-	Lights     int
+	ID       string
+	Name     string
+	Type     string
+	Status   string
+	Channels []Channel
+	// LightIDs are the light resources that take part in the area.
+	LightIDs []string
+}
+
+// ChannelIDs returns the IDs of the area's channels in bridge order.
+func (a EntertainmentArea) ChannelIDs() []uint8 {
+	ids := make([]uint8, len(a.Channels))
+	for i, ch := range a.Channels {
+		ids[i] = ch.ID
+	}
+	return ids
 }
 
 func (a EntertainmentArea) String() string {
-	return fmt.Sprintf("%s (%d channels, %d lights)", a.Name, len(a.ChannelIDs), a.Lights)
+	return fmt.Sprintf("%s (%d channels, %d lights)", a.Name, len(a.Channels), len(a.LightIDs))
 }
 
 // FetchEntertainmentAreas retrieves entertainment configurations from the bridge.
@@ -103,26 +187,190 @@ func FetchEntertainmentAreas(ip net.IP, username string) ([]EntertainmentArea, e
 
 	areas := make([]EntertainmentArea, len(result.Data))
 	for i, d := range result.Data {
-		channelIDs := make([]uint8, len(d.Channels))
-		for j, ch := range d.Channels {
-			channelIDs[j] = ch.ChannelID
+		areas[i] = d.area()
+	}
+
+	return areas, nil
+}
+
+func (d entertainmentData) area() EntertainmentArea {
+	channels := make([]Channel, len(d.Channels))
+	for i, ch := range d.Channels {
+		serviceIDs := make([]string, len(ch.Members))
+		for j, member := range ch.Members {
+			serviceIDs[j] = member.Service.RID
 		}
-		areas[i] = EntertainmentArea{
-			ID:         d.ID,
-			Name:       d.Metadata.Name,
-			Type:       d.ConfigurationType,
-			Status:     d.Status,
-			ChannelIDs: channelIDs,
-			Lights:     len(d.LightServices),
+		channels[i] = Channel{
+			ID:         ch.ChannelID,
+			Position:   Position{X: ch.Position.X, Y: ch.Position.Y, Z: ch.Position.Z},
+			ServiceIDs: serviceIDs,
 		}
 	}
+	lightIDs := make([]string, 0, len(d.LightServices))
+	for _, ref := range d.LightServices {
+		if ref.RType == "light" {
+			lightIDs = append(lightIDs, ref.RID)
+		}
+	}
+	return EntertainmentArea{
+		ID:       d.ID,
+		Name:     d.Metadata.Name,
+		Type:     d.ConfigurationType,
+		Status:   d.Status,
+		Channels: channels,
+		LightIDs: lightIDs,
+	}
+}
 
-	return areas, nil
+// FetchChannelGamuts looks up the color gamut of the light behind each channel
+// of the area via the CLIP v2 entertainment and light resources. The result is
+// indexed like area.Channels; channels whose gamut is unknown get a zero Gamut.
+func FetchChannelGamuts(ip net.IP, username string, area EntertainmentArea) ([]Gamut, error) {
+	var services resourceResponse[entertainmentServiceData]
+	if err := getResource(ip, username, "/clip/v2/resource/entertainment", &services); err != nil {
+		return nil, fmt.Errorf("fetching entertainment services: %w", err)
+	}
+
+	var lights resourceResponse[lightData]
+	if err := getResource(ip, username, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, fmt.Errorf("fetching lights: %w", err)
+	}
+
+	return channelGamuts(area.Channels, services.Data, lights.Data), nil
+}
+
+// channelGamuts maps each channel to the gamut of the light rendering it.
+// A channel rendered by several lights uses the first one with a known gamut.
+func channelGamuts(channels []Channel, services []entertainmentServiceData, lights []lightData) []Gamut {
+	lightGamuts := make(map[string]Gamut, len(lights))
+	for _, l := range lights {
+		if l.Color == nil {
+			continue
+		}
+		if l.Color.Gamut != nil {
+			lightGamuts[l.ID] = Gamut{
+				Red:   XY{l.Color.Gamut.Red.X, l.Color.Gamut.Red.Y},
+				Green: XY{l.Color.Gamut.Green.X, l.Color.Gamut.Green.Y},
+				Blue:  XY{l.Color.Gamut.Blue.X, l.Color.Gamut.Blue.Y},
+			}
+		} else if g, ok := gamutByType(l.Color.GamutType); ok {
+			lightGamuts[l.ID] = g
+		}
+	}
+
+	serviceLights := make(map[string]string, len(services))
+	for _, svc := range services {
+		if svc.RendererReference != nil && svc.RendererReference.RType == "light" {
+			serviceLights[svc.ID] = svc.RendererReference.RID
+		}
+	}
+
+	gamuts := make([]Gamut, len(channels))
+	for i, ch := range channels {
+		for _, svcID := range ch.ServiceIDs {
+			if g, ok := lightGamuts[serviceLights[svcID]]; ok {
+				gamuts[i] = g
+				break
+			}
+		}
+	}
+	return gamuts
+}
+
+// getResource GETs a CLIP v2 resource and decodes the JSON response into v.
+func getResource(ip net.IP, username, path string, v any) error {
+	req, err := newHueRequest("GET", bridgeURL(ip, path), nil, username)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := hueClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 403 {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// putResource PUTs v as JSON to a CLIP v2 resource.
+func putResource(ip net.IP, username, path string, v any) error {
+	_, err := sendResource("PUT", ip, username, path, v)
+	return err
+}
+
+// postResource POSTs v as JSON to a CLIP v2 resource type and returns the
+// references of the resources the bridge created.
+func postResource(ip net.IP, username, path string, v any) ([]resourceRef, error) {
+	return sendResource("POST", ip, username, path, v)
+}
+
+// deleteResource DELETEs a CLIP v2 resource.
+func deleteResource(ip net.IP, username, path string) error {
+	_, err := sendResource("DELETE", ip, username, path, nil)
+	return err
+}
+
+// sendResource sends v, unless it is nil, as JSON to a CLIP v2 resource and
+// returns the references in the response. Errors carry the bridge's
+// descriptions.
+func sendResource(method string, ip net.IP, username, path string, v any) ([]resourceRef, error) {
+	var body io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := newHueRequest(method, bridgeURL(ip, path), body, username)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := hueClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 403 {
+		return nil, ErrUnauthorized
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	var result struct {
+		Errors []clipError   `json:"errors"`
+		Data   []resourceRef `json:"data"`
+	}
+	decodeErr := json.Unmarshal(respBody, &result)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if decodeErr == nil && len(result.Errors) > 0 {
+			descriptions := make([]string, len(result.Errors))
+			for i, e := range result.Errors {
+				descriptions[i] = e.Description
+			}
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.Join(descriptions, "; "))
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, respBody)
+	}
+	return result.Data, nil
 }
 
 func bridgeURL(ip net.IP, path string) string {
 	host := ip.String()
-	if ip.To4() == nil {
+	if bridgeHTTPSPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(bridgeHTTPSPort))
+	} else if ip.To4() == nil {
 		host = "[" + host + "]"
 	}
 	return "https://" + host + path
@@ -145,17 +393,23 @@ type pairError struct {
 	Description string `json:"description"`
 }
 
+type clipError struct {
+	Description string `json:"description"`
+}
+
 type entertainmentResponse struct {
 	Data []entertainmentData `json:"data"`
 }
 
 type entertainmentData struct {
 	ID                string            `json:"id"`
+	IDV1              string            `json:"id_v1,omitempty"`
 	Metadata          entertainmentMeta `json:"metadata"`
 	ConfigurationType string            `json:"configuration_type"`
 	Status            string            `json:"status"`
 	Channels          []channelData     `json:"channels"`
-	LightServices     []json.RawMessage `json:"light_services"`
+	LightServices     []resourceRef     `json:"light_services"`
+	ActiveStreamer    *resourceRef      `json:"active_streamer,omitempty"`
 }
 
 type entertainmentMeta struct {
@@ -163,5 +417,76 @@ type entertainmentMeta struct {
 }
 
 type channelData struct {
-	ChannelID uint8 `json:"channel_id"`
+	ChannelID uint8           `json:"channel_id"`
+	Position  positionData    `json:"position"`
+	Members   []channelMember `json:"members"`
+}
+
+type channelMember struct {
+	Service resourceRef `json:"service"`
+	Index   int         `json:"index"`
+}
+
+type resourceRef struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+type positionData struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+type resourceResponse[T any] struct {
+	Data []T `json:"data"`
+}
+
+type entertainmentServiceData struct {
+	ID                string       `json:"id"`
+	Renderer          bool         `json:"renderer"`
+	RendererReference *resourceRef `json:"renderer_reference"`
+}
+
+type lightData struct {
+	ID               string            `json:"id"`
+	Metadata         lightMeta         `json:"metadata"`
+	On               *onData           `json:"on"`
+	Dimming          *dimmingData      `json:"dimming"`
+	Color            *lightColor       `json:"color"`
+	ColorTemperature *colorTemperature `json:"color_temperature"`
+}
+
+type lightMeta struct {
+	Name string `json:"name"`
+}
+
+type lightColor struct {
+	XY        xyData     `json:"xy"`
+	Gamut     *gamutData `json:"gamut"`
+	GamutType string     `json:"gamut_type"`
+}
+
+type onData struct {
+	On bool `json:"on"`
+}
+
+type dimmingData struct {
+	Brightness float64 `json:"brightness"`
+}
+
+type colorTemperature struct {
+	Mirek      *int `json:"mirek"`
+	MirekValid bool `json:"mirek_valid"`
+}
+
+type gamutData struct {
+	Red   xyData `json:"red"`
+	Green xyData `json:"green"`
+	Blue  xyData `json:"blue"`
+}
+
+type xyData struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
 }
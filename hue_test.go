@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChannelGamuts(t *testing.T) {
+	const servicesJSON = `{"data":[
+		{"id":"svc-1","renderer":true,"renderer_reference":{"rid":"light-1","rtype":"light"}},
+		{"id":"svc-2","renderer":true,"renderer_reference":{"rid":"light-2","rtype":"light"}},
+		{"id":"svc-3","renderer":false}
+	]}`
+	const lightsJSON = `{"data":[
+		{"id":"light-1","color":{"gamut_type":"A"}},
+		{"id":"light-2","color":{"gamut":{
+			"red":{"x":0.6915,"y":0.3083},
+			"green":{"x":0.17,"y":0.7},
+			"blue":{"x":0.1532,"y":0.0475}
+		},"gamut_type":"C"}}
+	]}`
+
+	var services resourceResponse[entertainmentServiceData]
+	if err := json.Unmarshal([]byte(servicesJSON), &services); err != nil {
+		t.Fatalf("decoding services: %v", err)
+	}
+	var lights resourceResponse[lightData]
+	if err := json.Unmarshal([]byte(lightsJSON), &lights); err != nil {
+		t.Fatalf("decoding lights: %v", err)
+	}
+
+	channels := []Channel{
+		{ID: 0, ServiceIDs: []string{"svc-1"}},
+		{ID: 1, ServiceIDs: []string{"svc-3", "svc-2"}},
+		{ID: 2, ServiceIDs: []string{"svc-3"}},
+	}
+	got := channelGamuts(channels, services.Data, lights.Data)
+
+	if got[0] != gamutA {
+		t.Errorf("channel 0: expected gamut A, got %v", got[0])
+	}
+	if got[1] != gamutC {
+		t.Errorf("channel 1: expected gamut C, got %v", got[1])
+	}
+	if !got[2].IsZero() {
+		t.Errorf("channel 2: expected unknown gamut, got %v", got[2])
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
@@ -8,7 +10,39 @@ import (
 )
 
 func main() {
-	p := tea.NewProgram(newModel())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "stream":
+			os.Exit(runStream(os.Args[2:], os.Stderr))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:], os.Stderr))
+		case "area":
+			os.Exit(runArea(os.Args[2:], os.Stdout, os.Stderr))
+		case "creds":
+			os.Exit(runCreds(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "mock-bridge":
+			os.Exit(runMockBridge(os.Args[2:], os.Stdin, os.Stderr))
+		}
+	}
+
+	parser, opts, err := newOptionParser("huesync", os.Args[1:], os.Stderr, nil)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		var ue *usageError
+		if !errors.As(err, &ue) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(exitUsage)
+	}
+
+	p := tea.NewProgram(newModel(opts, parser))
+	// Certificates are pinned in the middle of a request, which must not
+	// wait for the program to take the message.
+	certificatePinned = func(bridgeID, fp string) {
+		go p.Send(certPinnedMsg{bridgeID: bridgeID, fp: fp})
+	}
 	result, err := p.Run()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
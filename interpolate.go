@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// interpolator turns colors captured at irregular, possibly slow intervals
+// into a smooth signal for the fixed send rate. Each new capture becomes the
+// target of a linear blend from the currently output colors, spread over the
+// interval since the previous capture. Output therefore lags by one capture
+// interval but moves without steps.
+type interpolator struct {
+	from, to []RGB
+	start    time.Time
+	span     time.Duration
+}
+
+// Push adds colors captured at time at. If their mean distance to the current
+// output exceeds snapThreshold (in RGB units, 0 disables), the change is a
+// scene cut and is output at once instead of blended.
+func (ip *interpolator) Push(colors []RGB, at time.Time, snapThreshold float64) {
+	current := ip.At(at)
+	span := at.Sub(ip.start)
+	if len(current) != len(colors) || span <= 0 ||
+		(snapThreshold > 0 && meanColorDistance(current, colors) > snapThreshold) {
+		current = colors
+		span = 0
+	}
+	ip.from, ip.to = current, colors
+	ip.start, ip.span = at, span
+}
+
+// At returns the colors to output at now, or nil before the first Push.
+func (ip *interpolator) At(now time.Time) []RGB {
+	t := 1.0
+	if ip.span > 0 {
+		t = min(max(now.Sub(ip.start).Seconds()/ip.span.Seconds(), 0), 1)
+	}
+	if t == 1 {
+		return ip.to
+	}
+	out := make([]RGB, len(ip.to))
+	for i := range ip.to {
+		a, b := rgbToFloat(ip.from[i]), rgbToFloat(ip.to[i])
+		for j := range a {
+			a[j] += t * (b[j] - a[j])
+		}
+		out[i] = floatToRGB(a)
+	}
+	return out
+}
+
+// meanColorDistance returns the mean Euclidean RGB distance between a[i] and
+// b[i].
+func meanColorDistance(a, b []RGB) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	var dist float64
+	for i := range a {
+		dist += math.Sqrt(sqDist(rgbToFloat(a[i]), rgbToFloat(b[i])))
+	}
+	return dist / float64(len(a))
+}
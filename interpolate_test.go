@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterpolator_BlendsOverCaptureInterval(t *testing.T) {
+	var ip interpolator
+	t0 := time.Now()
+	if ip.At(t0) != nil {
+		t.Fatal("expected no colors before the first capture")
+	}
+
+	ip.Push([]RGB{{R: 0}}, t0, 0)
+	if got := ip.At(t0); got[0].R != 0 {
+		t.Fatalf("expected the first capture as is, got %v", got)
+	}
+
+	// The next capture arrives 100ms later and is blended in over 100ms.
+	t1 := t0.Add(100 * time.Millisecond)
+	ip.Push([]RGB{{R: 200}}, t1, 0)
+	tests := []struct {
+		after time.Duration
+		want  uint8
+	}{
+		{0, 0},
+		{25 * time.Millisecond, 50},
+		{50 * time.Millisecond, 100},
+		{100 * time.Millisecond, 200},
+		{time.Second, 200},
+	}
+	for _, tt := range tests {
+		if got := ip.At(t1.Add(tt.after)); got[0].R != tt.want {
+			t.Errorf("after %s: expected R=%d, got %v", tt.after, tt.want, got[0])
+		}
+	}
+}
+
+func TestInterpolator_NewCaptureStartsFromOutput(t *testing.T) {
+	var ip interpolator
+	t0 := time.Now()
+	ip.Push([]RGB{{R: 0}}, t0, 0)
+	ip.Push([]RGB{{R: 200}}, t0.Add(100*time.Millisecond), 0)
+
+	// Halfway through, a capture arrives: the blend continues from the
+	// current output instead of jumping.
+	t2 := t0.Add(150 * time.Millisecond)
+	ip.Push([]RGB{{R: 0}}, t2, 0)
+	if got := ip.At(t2); got[0].R != 100 {
+		t.Errorf("expected R=100 at the new capture, got %v", got[0])
+	}
+}
+
+func TestInterpolator_SnapsOnSceneCut(t *testing.T) {
+	var ip interpolator
+	t0 := time.Now()
+	ip.Push([]RGB{{}}, t0, 120)
+
+	t1 := t0.Add(100 * time.Millisecond)
+	ip.Push([]RGB{{R: 255, G: 255, B: 255}}, t1, 120)
+	if got := ip.At(t1); got[0] != (RGB{255, 255, 255}) {
+		t.Errorf("expected a scene cut to apply at once, got %v", got[0])
+	}
+}
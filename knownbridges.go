@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// knownBridgeTimeout bounds the attempt to reach a remembered bridge before
+// falling back to discovery.
+const knownBridgeTimeout = 2 * time.Second
+
+// knownBridge is the last address a bridge was used at.
+type knownBridge struct {
+	IP       string    `json:"ip"`
+	Name     string    `json:"name,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// errNoKnownBridge is returned by probeKnownBridge when no remembered bridge
+// matches.
+var errNoKnownBridge = errors.New("no known bridge")
+
+// knownBridgesPath returns the path of the remembered bridge addresses, which
+// are kept next to the credentials.
+func knownBridgesPath() (string, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "bridges.json"), nil
+}
+
+// LoadKnownBridges returns the remembered bridges by bridge ID.
+func LoadKnownBridges() (map[string]knownBridge, error) {
+	path, err := knownBridgesPath()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]knownBridge)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return known, nil
+}
+
+// RememberBridge stores the address of b as the last one it was used at.
+// The file is changed under a lock, so that instances using other bridges do
+// not lose each other's addresses.
+func RememberBridge(b Bridge) error {
+	if b.ID == "" || b.IP == nil {
+		return nil
+	}
+	path, err := knownBridgesPath()
+	if err != nil {
+		return err
+	}
+	return withFileLock(path, true, func() error {
+		known, err := LoadKnownBridges()
+		if err != nil {
+			known = make(map[string]knownBridge)
+		}
+		known[b.ID] = knownBridge{IP: b.IP.String(), Name: b.Name, Model: b.Model, LastUsed: time.Now()}
+
+		data, err := json.MarshalIndent(known, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data)
+	})
+}
+
+// lastKnownBridge returns the remembered bridge whose ID or address matches
+// query or, with an empty query, the one used last.
+func lastKnownBridge(query string) (Bridge, bool) {
+	known, err := LoadKnownBridges()
+	if err != nil {
+		return Bridge{}, false
+	}
+	var found Bridge
+	var lastUsed time.Time
+	for id, k := range known {
+		b := Bridge{ID: id, Name: k.Name, Model: k.Model, IP: net.ParseIP(k.IP), Port: bridgeHTTPSPort}
+		if b.IP == nil || (query != "" && !bridgeMatches(b, query)) {
+			continue
+		}
+		if found.ID == "" || k.LastUsed.After(lastUsed) {
+			found, lastUsed = b, k.LastUsed
+		}
+	}
+	return found, found.ID != ""
+}
+
+// probeKnownBridge connects to the remembered bridge that matches query
+// directly, as discovery takes seconds. It fails with errNoKnownBridge if
+// there is none, and if the bridge cannot be reached at its last address or
+// another bridge answers there. If the bridge's certificate changed, the
+// bridge is returned with the error.
+func probeKnownBridge(ctx context.Context, query string) (Bridge, error) {
+	known, ok := lastKnownBridge(query)
+	if !ok {
+		return Bridge{}, errNoKnownBridge
+	}
+	ctx, cancel := context.WithTimeout(ctx, knownBridgeTimeout)
+	defer cancel()
+
+	b, err := ProbeBridge(ctx, known.IP.String())
+	if b.ID != "" && !strings.EqualFold(b.ID, known.ID) {
+		return Bridge{}, fmt.Errorf("%w: %s now answers at %s", errNoKnownBridge, b.ID, known.IP)
+	}
+	if err != nil {
+		var changed *CertificateChangedError
+		if errors.As(err, &changed) {
+			return b, err
+		}
+		return Bridge{}, fmt.Errorf("%w: %v", errNoKnownBridge, err)
+	}
+	// Keep the ID as it was remembered, which the credentials are stored
+	// under.
+	b.ID = known.ID
+	return b, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRememberBridge(t *testing.T) {
+	setupCredentialsDir(t)
+	if _, ok := lastKnownBridge(""); ok {
+		t.Fatal("expected no known bridge")
+	}
+	office := Bridge{ID: "001788fffe000001", Name: "Office", IP: net.IPv4(192, 0, 2, 1)}
+	living := Bridge{ID: "001788fffe000002", Name: "Living room", Model: "BSB002", IP: net.IPv4(192, 0, 2, 2)}
+	for _, b := range []Bridge{office, living} {
+		if err := RememberBridge(b); err != nil {
+			t.Fatalf("RememberBridge: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if b, ok := lastKnownBridge(""); !ok || b.ID != living.ID || b.Name != living.Name || b.Model != "BSB002" || !b.IP.Equal(living.IP) {
+		t.Errorf("expected the bridge used last, got %+v", b)
+	}
+	if b, ok := lastKnownBridge("192.0.2.1"); !ok || b.ID != office.ID {
+		t.Errorf("expected the bridge at 192.0.2.1, got %+v", b)
+	}
+	if b, ok := lastKnownBridge("001788FFFE000001"); !ok || b.ID != office.ID {
+		t.Errorf("expected the bridge by ID, got %+v", b)
+	}
+	if _, ok := lastKnownBridge("001788fffe000003"); ok {
+		t.Error("expected no bridge for an unknown ID")
+	}
+
+	// A bridge that moved is remembered at its new address.
+	office.IP = net.IPv4(192, 0, 2, 3)
+	if err := RememberBridge(office); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	if b, _ := lastKnownBridge(""); b.ID != office.ID || !b.IP.Equal(office.IP) {
+		t.Errorf("expected the moved bridge, got %+v", b)
+	}
+}
+
+func TestProbeKnownBridge_MockBridge(t *testing.T) {
+	setupCredentialsDir(t)
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	ctx := context.Background()
+
+	if _, err := probeKnownBridge(ctx, ""); !errors.Is(err, errNoKnownBridge) {
+		t.Errorf("expected errNoKnownBridge, got %v", err)
+	}
+
+	if err := RememberBridge(Bridge{ID: b.ID, Name: "Mock", IP: ip}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	if bridge, err := probeKnownBridge(ctx, ""); err != nil || bridge.ID != b.ID || bridge.Name != "Mock Hue Bridge" {
+		t.Errorf("expected the mock bridge, got %+v, %v", bridge, err)
+	}
+
+	// Another bridge answers at the address.
+	if err := RememberBridge(Bridge{ID: "001788fffe000002", IP: ip}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	if _, err := probeKnownBridge(ctx, "001788fffe000002"); !errors.Is(err, errNoKnownBridge) {
+		t.Errorf("expected errNoKnownBridge for another bridge, got %v", err)
+	}
+
+	// Nothing answers at the address; discovery finds the bridge instead.
+	if err := RememberBridge(Bridge{ID: b.ID, IP: net.IPv4(127, 0, 0, 2)}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	if _, err := probeKnownBridge(ctx, b.ID); !errors.Is(err, errNoKnownBridge) {
+		t.Errorf("expected errNoKnownBridge for an unreachable bridge, got %v", err)
+	}
+	if bridge, err := findBridge(ctx, b.ID, false); err != nil || !bridge.IP.Equal(ip) {
+		t.Errorf("expected discovery to find the bridge, got %+v, %v", bridge, err)
+	}
+
+	// Found at its last address, the bridge needs no discovery.
+	if err := RememberBridge(Bridge{ID: b.ID, IP: ip}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+	hideBridges(t)
+	if bridge, err := findBridge(ctx, "", false); err != nil || bridge.ID != b.ID {
+		t.Errorf("expected the known bridge, got %+v, %v", bridge, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "image"
+
+const (
+	// barThreshold is the brightest channel value still considered black.
+	// Encoded video rarely produces a true 0 in its bars.
+	barThreshold = 24
+
+	// cropHistory is the number of recent frames a border must be black in
+	// before it is cropped. New content expands the crop immediately.
+	cropHistory = 50
+)
+
+// cropDetector finds static black borders (letterbox and pillarbox bars) in
+// recent frames so they can be excluded from sampling.
+type cropDetector struct {
+	history []image.Rectangle
+	next    int
+}
+
+// Update records the content bounds of f and returns the region that is not
+// covered by black bars in any recent frame. Completely dark frames (fades,
+// scene transitions) carry no information and leave the crop unchanged.
+func (d *cropDetector) Update(f Frame) image.Rectangle {
+	full := image.Rect(0, 0, f.Width, f.Height)
+	if content, ok := contentBounds(f); ok {
+		if len(d.history) < cropHistory {
+			d.history = append(d.history, content)
+		} else {
+			d.history[d.next] = content
+			d.next = (d.next + 1) % cropHistory
+		}
+	}
+	if len(d.history) == 0 {
+		return full
+	}
+
+	crop := d.history[0]
+	for _, r := range d.history[1:] {
+		crop = crop.Union(r)
+	}
+	return symmetricCrop(crop, full)
+}
+
+// contentBounds returns the smallest rectangle containing every pixel of f
+// brighter than barThreshold. It reports false for an all-dark frame.
+func contentBounds(f Frame) (image.Rectangle, bool) {
+	minX, minY := f.Width, f.Height
+	maxX, maxY := -1, -1
+	for y := 0; y < f.Height; y++ {
+		off := y * f.Width * 3
+		for x := 0; x < f.Width; x++ {
+			if f.Pix[off] > barThreshold || f.Pix[off+1] > barThreshold || f.Pix[off+2] > barThreshold {
+				minX = min(minX, x)
+				maxX = max(maxX, x)
+				minY = min(minY, y)
+				maxY = max(maxY, y)
+			}
+			off += 3
+		}
+	}
+	if maxX < 0 {
+		return image.Rectangle{}, false
+	}
+	return image.Rect(minX, minY, maxX+1, maxY+1), true
+}
+
+// symmetricCrop shrinks full by the smaller of each pair of opposing borders.
+// Letterbox and pillarbox bars are always symmetric, so a dark area on one
+// side only (a night sky, a dark corner) is never mistaken for a bar.
+func symmetricCrop(content, full image.Rectangle) image.Rectangle {
+	v := min(content.Min.Y-full.Min.Y, full.Max.Y-content.Max.Y)
+	h := min(content.Min.X-full.Min.X, full.Max.X-content.Max.X)
+	return image.Rect(full.Min.X+h, full.Min.Y+v, full.Max.X-h, full.Max.Y-v)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// barFrame returns a capture-sized frame that is grey inside content and black
+// everywhere else.
+func barFrame(content image.Rectangle) Frame {
+	f := Frame{Pix: make([]byte, frameSize), Width: captureWidth, Height: captureHeight}
+	for y := content.Min.Y; y < content.Max.Y; y++ {
+		for x := content.Min.X; x < content.Max.X; x++ {
+			off := (y*f.Width + x) * 3
+			f.Pix[off] = 180
+			f.Pix[off+1] = 160
+			f.Pix[off+2] = 140
+		}
+	}
+	return f
+}
+
+func TestCropDetector_Letterbox(t *testing.T) {
+	var d cropDetector
+	content := image.Rect(0, 5, captureWidth, captureHeight-5)
+	var crop image.Rectangle
+	for i := 0; i < 3; i++ {
+		crop = d.Update(barFrame(content))
+	}
+	if crop != content {
+		t.Errorf("expected crop %v, got %v", content, crop)
+	}
+}
+
+func TestCropDetector_Pillarbox(t *testing.T) {
+	var d cropDetector
+	content := image.Rect(8, 0, captureWidth-8, captureHeight)
+	crop := d.Update(barFrame(content))
+	if crop != content {
+		t.Errorf("expected crop %v, got %v", content, crop)
+	}
+}
+
+func TestCropDetector_DarkFrameKeepsCrop(t *testing.T) {
+	var d cropDetector
+	content := image.Rect(0, 5, captureWidth, captureHeight-5)
+	d.Update(barFrame(content))
+
+	crop := d.Update(barFrame(image.Rectangle{}))
+	if crop != content {
+		t.Errorf("expected dark frame to keep crop %v, got %v", content, crop)
+	}
+}
+
+func TestCropDetector_NoHistoryIsFullFrame(t *testing.T) {
+	var d cropDetector
+	full := image.Rect(0, 0, captureWidth, captureHeight)
+	if crop := d.Update(barFrame(image.Rectangle{})); crop != full {
+		t.Errorf("expected full frame %v, got %v", full, crop)
+	}
+}
+
+func TestCropDetector_OneSidedDarkIsNotABar(t *testing.T) {
+	var d cropDetector
+	// Dark sky: the top 10 rows are black, the bottom is content.
+	full := image.Rect(0, 0, captureWidth, captureHeight)
+	crop := d.Update(barFrame(image.Rect(0, 10, captureWidth, captureHeight)))
+	if crop != full {
+		t.Errorf("expected full frame %v, got %v", full, crop)
+	}
+}
+
+func TestCropDetector_AdaptsToAspectChange(t *testing.T) {
+	var d cropDetector
+	wide := image.Rect(0, 5, captureWidth, captureHeight-5)
+	full := image.Rect(0, 0, captureWidth, captureHeight)
+
+	for i := 0; i < cropHistory; i++ {
+		d.Update(barFrame(wide))
+	}
+
+	// Full-screen content appears: the crop expands immediately.
+	if crop := d.Update(barFrame(full)); crop != full {
+		t.Fatalf("expected crop to expand to %v, got %v", full, crop)
+	}
+
+	// Bars come back: the crop only shrinks once they are static again.
+	crop := d.Update(barFrame(wide))
+	if crop != full {
+		t.Errorf("expected crop to stay %v right after bars return, got %v", full, crop)
+	}
+	for i := 0; i < cropHistory; i++ {
+		crop = d.Update(barFrame(wide))
+	}
+	if crop != wide {
+		t.Errorf("expected crop %v after bars were static, got %v", wide, crop)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// LightState is the part of a light's state that streaming overrides and
+// huesync puts back afterwards.
+type LightState struct {
+	ID   string
+	Name string
+	On   bool
+	// Brightness is in percent; nil when the light is not dimmable.
+	Brightness *float64
+	// XY is the color of a color light; nil for white-only lights.
+	XY *XY
+	// Mirek is the color temperature. It is set only when the light was
+	// showing white in color temperature mode, and then takes precedence
+	// over XY.
+	Mirek *int
+}
+
+// FetchLightStates reads the current state of the given lights via CLIP v2.
+// Lights unknown to the bridge are left out of the result.
+func FetchLightStates(ip net.IP, username string, ids []string) ([]LightState, error) {
+	var lights resourceResponse[lightData]
+	if err := getResource(ip, username, "/clip/v2/resource/light", &lights); err != nil {
+		return nil, fmt.Errorf("fetching lights: %w", err)
+	}
+	return lightStates(lights.Data, ids), nil
+}
+
+// lightStates picks the lights with the given IDs, in that order.
+func lightStates(lights []lightData, ids []string) []LightState {
+	byID := make(map[string]lightData, len(lights))
+	for _, l := range lights {
+		byID[l.ID] = l
+	}
+
+	states := make([]LightState, 0, len(ids))
+	for _, id := range ids {
+		l, ok := byID[id]
+		if !ok {
+			continue
+		}
+		s := LightState{ID: l.ID, Name: l.Metadata.Name}
+		if l.On != nil {
+			s.On = l.On.On
+		}
+		if l.Dimming != nil {
+			b := l.Dimming.Brightness
+			s.Brightness = &b
+		}
+		if ct := l.ColorTemperature; ct != nil && ct.MirekValid && ct.Mirek != nil {
+			mirek := *ct.Mirek
+			s.Mirek = &mirek
+		} else if l.Color != nil {
+			s.XY = &XY{l.Color.XY.X, l.Color.XY.Y}
+		}
+		states = append(states, s)
+	}
+	return states
+}
+
+// SetLightState applies s to its light, fading over fade. A light that was
+// off is only switched off; its color is left as it is.
+func SetLightState(ip net.IP, username string, s LightState, fade time.Duration) error {
+	if err := putResource(ip, username, "/clip/v2/resource/light/"+s.ID, newLightUpdate(s, fade)); err != nil {
+		return fmt.Errorf("restoring light %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+func newLightUpdate(s LightState, fade time.Duration) lightUpdate {
+	u := lightUpdate{On: &onData{On: s.On}}
+	if fade > 0 {
+		u.Dynamics = &dynamicsData{Duration: int(fade.Milliseconds())}
+	}
+	if !s.On {
+		return u
+	}
+	if s.Brightness != nil {
+		u.Dimming = &dimmingData{Brightness: *s.Brightness}
+	}
+	switch {
+	case s.Mirek != nil:
+		u.ColorTemperature = &mirekData{Mirek: *s.Mirek}
+	case s.XY != nil:
+		u.Color = &colorData{XY: xyData{X: s.XY.X, Y: s.XY.Y}}
+	}
+	return u
+}
+
+// lightSession puts an area's lights back the way they were before
+// streaming. Besides the snapshot taken before the area is activated, it
+// records which lights were on while streaming, so that lights someone
+// switched on or off during the session are left alone.
+type lightSession struct {
+	ip       net.IP
+	username string
+	fade     time.Duration
+
+	saved []LightState
+
+	mu        sync.Mutex
+	streaming map[string]bool
+	changed   map[string]bool
+}
+
+// snapshotLights records the state of the area's lights. It must be called
+// before the area is activated.
+func snapshotLights(ip net.IP, username string, area EntertainmentArea, fade time.Duration) (*lightSession, error) {
+	saved, err := FetchLightStates(ip, username, area.LightIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &lightSession{ip: ip, username: username, fade: fade, saved: saved}, nil
+}
+
+// markStreaming records which lights are on now that streaming runs. Call
+// it once the first frame has been sent, as the bridge may only switch the
+// lights on then.
+func (ls *lightSession) markStreaming() error {
+	states, err := ls.fetch()
+	if err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	ls.streaming = onStates(states)
+	ls.mu.Unlock()
+	return nil
+}
+
+// detectChanges finds the lights switched on or off since markStreaming. It
+// must be called before the area is deactivated, while the bridge still
+// reports the streaming state.
+func (ls *lightSession) detectChanges() error {
+	ls.mu.Lock()
+	streaming := ls.streaming
+	ls.mu.Unlock()
+	if streaming == nil {
+		return nil
+	}
+	states, err := ls.fetch()
+	if err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	ls.changed = changedLights(streaming, onStates(states))
+	ls.mu.Unlock()
+	return nil
+}
+
+// restore puts back the snapshot, skipping the lights found by
+// detectChanges. It returns the names of the skipped lights.
+func (ls *lightSession) restore() (skipped []string, err error) {
+	ls.mu.Lock()
+	changed := ls.changed
+	ls.mu.Unlock()
+
+	var errs []error
+	for _, s := range ls.saved {
+		if changed[s.ID] {
+			skipped = append(skipped, s.Name)
+			continue
+		}
+		if err := SetLightState(ls.ip, ls.username, s, ls.fade); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return skipped, errors.Join(errs...)
+}
+
+func (ls *lightSession) fetch() ([]LightState, error) {
+	ids := make([]string, len(ls.saved))
+	for i, s := range ls.saved {
+		ids[i] = s.ID
+	}
+	return FetchLightStates(ls.ip, ls.username, ids)
+}
+
+func onStates(states []LightState) map[string]bool {
+	on := make(map[string]bool, len(states))
+	for _, s := range states {
+		on[s.ID] = s.On
+	}
+	return on
+}
+
+// changedLights returns the lights whose on state differs between before and
+// after. Lights missing from either are not considered changed.
+func changedLights(before, after map[string]bool) map[string]bool {
+	changed := make(map[string]bool)
+	for id, was := range before {
+		if now, ok := after[id]; ok && now != was {
+			changed[id] = true
+		}
+	}
+	return changed
+}
+
+type lightUpdate struct {
+	On               *onData       `json:"on,omitempty"`
+	Dimming          *dimmingData  `json:"dimming,omitempty"`
+	Color            *colorData    `json:"color,omitempty"`
+	ColorTemperature *mirekData    `json:"color_temperature,omitempty"`
+	Dynamics         *dynamicsData `json:"dynamics,omitempty"`
+}
+
+type colorData struct {
+	XY xyData `json:"xy"`
+}
+
+type mirekData struct {
+	Mirek int `json:"mirek"`
+}
+
+type dynamicsData struct {
+	Duration int `json:"duration"`
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLightStates(t *testing.T) {
+	const lightsJSON = `{"data":[
+		{"id":"light-1","metadata":{"name":"Lamp"},"on":{"on":true},"dimming":{"brightness":42.5},
+		 "color":{"xy":{"x":0.3,"y":0.4}},"color_temperature":{"mirek":null,"mirek_valid":false}},
+		{"id":"light-2","metadata":{"name":"Strip"},"on":{"on":false},"dimming":{"brightness":80},
+		 "color":{"xy":{"x":0.45,"y":0.41}},"color_temperature":{"mirek":366,"mirek_valid":true}},
+		{"id":"light-3","metadata":{"name":"Other"},"on":{"on":true}}
+	]}`
+	var lights resourceResponse[lightData]
+	if err := json.Unmarshal([]byte(lightsJSON), &lights); err != nil {
+		t.Fatalf("decoding lights: %v", err)
+	}
+
+	got := lightStates(lights.Data, []string{"light-2", "light-1", "missing"})
+	if len(got) != 2 {
+		t.Fatalf("expected 2 states, got %d", len(got))
+	}
+
+	strip, lamp := got[0], got[1]
+	if strip.ID != "light-2" || strip.On || strip.Mirek == nil || *strip.Mirek != 366 || strip.XY != nil {
+		t.Errorf("unexpected state for color temperature light: %+v", strip)
+	}
+	if lamp.ID != "light-1" || !lamp.On || lamp.Brightness == nil || *lamp.Brightness != 42.5 {
+		t.Errorf("unexpected state for color light: %+v", lamp)
+	}
+	if lamp.XY == nil || *lamp.XY != (XY{0.3, 0.4}) || lamp.Mirek != nil {
+		t.Errorf("expected xy color for color light, got %+v", lamp)
+	}
+}
+
+func TestNewLightUpdate(t *testing.T) {
+	brightness := 60.0
+	mirek := 250
+	tests := []struct {
+		name  string
+		state LightState
+		fade  time.Duration
+		want  string
+	}{
+		{
+			name:  "color",
+			state: LightState{On: true, Brightness: &brightness, XY: &XY{0.3, 0.4}},
+			want:  `{"on":{"on":true},"dimming":{"brightness":60},"color":{"xy":{"x":0.3,"y":0.4}}}`,
+		},
+		{
+			name:  "color temperature with fade",
+			state: LightState{On: true, Brightness: &brightness, XY: &XY{0.3, 0.4}, Mirek: &mirek},
+			fade:  400 * time.Millisecond,
+			want:  `{"on":{"on":true},"dimming":{"brightness":60},"color_temperature":{"mirek":250},"dynamics":{"duration":400}}`,
+		},
+		{
+			name:  "off",
+			state: LightState{On: false, Brightness: &brightness, XY: &XY{0.3, 0.4}},
+			want:  `{"on":{"on":false}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(newLightUpdate(tt.state, tt.fade))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestChangedLights(t *testing.T) {
+	before := map[string]bool{"a": true, "b": true, "c": false, "d": true}
+	after := map[string]bool{"a": true, "b": false, "c": true}
+
+	got := changedLights(before, after)
+	if len(got) != 2 || !got["b"] || !got["c"] {
+		t.Errorf("expected b and c changed, got %v", got)
+	}
+}
+
+func TestLightSession_RestoreSkipsChanged(t *testing.T) {
+	ls := &lightSession{
+		saved:   []LightState{{ID: "a", Name: "Lamp"}},
+		changed: map[string]bool{"a": true},
+	}
+	skipped, err := ls.restore()
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0] != "Lamp" {
+		t.Errorf("expected Lamp skipped, got %v", skipped)
+	}
+}
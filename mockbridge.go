@@ -0,0 +1,968 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/pion/dtls/v2"
+)
+
+const (
+	// linkButtonWindow is how long pairing is allowed after the link button
+	// was pressed, as on a real bridge.
+	linkButtonWindow = 30 * time.Second
+
+	// mockFrameHistory is the number of received frames a MockBridge keeps.
+	mockFrameHistory = 1000
+)
+
+// mockApp is an application registered with a MockBridge.
+type mockApp struct {
+	id         string // the CLIP v2 application ID
+	deviceType string
+}
+
+// MockBridge emulates the parts of a Hue bridge that huesync uses: pairing
+// via /api, the public configuration at /api/config, the CLIP v2
+// entertainment_configuration resource, which areas can be created, changed
+// and deleted through, the entertainment and light resources, the CLIP v2
+// event stream, the v1 group and whitelist lookups that name an area's
+// streaming application, removing applications from the whitelist (refused,
+// as by current firmware, unless allowed with AllowUnpair), and a
+// DTLS-PSK listener that decodes HueStream frames. It starts with one
+// entertainment area whose channels are each rendered by their own color
+// light.
+type MockBridge struct {
+	ID string
+
+	mu        sync.Mutex
+	linkUntil time.Time
+	users     map[string]string // username → clientkey
+	unpair    bool              // whether applications can be removed
+	apps      map[string]mockApp
+	streamers map[string]string // area ID → username of the active streamer
+	events    map[chan []byte]bool
+	eventID   int
+	groupID   int // v1 ID of the last area
+	areas     []entertainmentData
+	services  []entertainmentServiceData
+	lights    []lightData
+	frames    []HueStreamFrame
+	received  int
+	streams   map[net.Conn]bool
+
+	https     *http.Server
+	httpsAddr *net.TCPAddr
+	dtls      net.Listener
+	closing   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewMockBridge creates a mock bridge with the given ID and an entertainment
+// area of n channels spread from left to right.
+func NewMockBridge(id string, n int) *MockBridge {
+	b := &MockBridge{
+		ID:        id,
+		users:     make(map[string]string),
+		apps:      make(map[string]mockApp),
+		streamers: make(map[string]string),
+		events:    make(map[chan []byte]bool),
+		streams:   make(map[net.Conn]bool),
+		closing:   make(chan struct{}),
+		groupID:   200,
+	}
+
+	area := entertainmentData{
+		ID:                newUUID(),
+		IDV1:              "/groups/200",
+		Metadata:          entertainmentMeta{Name: "Mock TV"},
+		ConfigurationType: "screen",
+		Status:            "inactive",
+	}
+	for i := range n {
+		light := lightData{
+			ID:       newUUID(),
+			Metadata: lightMeta{Name: fmt.Sprintf("Mock light %d", i+1)},
+			On:       &onData{On: true},
+			Dimming:  &dimmingData{Brightness: 75},
+			Color: &lightColor{
+				XY:        xyData{X: 0.4573, Y: 0.41},
+				GamutType: "C",
+			},
+			ColorTemperature: &colorTemperature{},
+		}
+		svc := entertainmentServiceData{
+			ID:                newUUID(),
+			Renderer:          true,
+			RendererReference: &resourceRef{RID: light.ID, RType: "light"},
+		}
+		x := 0.0
+		if n > 1 {
+			x = -1 + 2*float64(i)/float64(n-1)
+		}
+		area.Channels = append(area.Channels, channelData{
+			ChannelID: uint8(i),
+			Position:  positionData{X: x, Y: 0.8, Z: 0},
+			Members:   []channelMember{{Service: resourceRef{RID: svc.ID, RType: "entertainment"}}},
+		})
+		area.LightServices = append(area.LightServices, resourceRef{RID: light.ID, RType: "light"})
+		b.lights = append(b.lights, light)
+		b.services = append(b.services, svc)
+	}
+	b.areas = []entertainmentData{area}
+	return b
+}
+
+// Start serves the CLIP API over HTTPS on httpsPort and HueStream over DTLS on
+// streamPort, both on ip. A port of 0 picks a free one.
+func (b *MockBridge) Start(ip net.IP, httpsPort, streamPort int) error {
+	cert, err := bridgeCert(strings.ToLower(b.ID))
+	if err != nil {
+		return fmt.Errorf("creating certificate: %w", err)
+	}
+
+	ln, err := tls.Listen("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(httpsPort)), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		return err
+	}
+	b.httpsAddr = ln.Addr().(*net.TCPAddr)
+
+	b.dtls, err = dtls.Listen("udp", &net.UDPAddr{IP: ip, Port: streamPort}, &dtls.Config{
+		PSK: func(identity []byte) ([]byte, error) {
+			b.mu.Lock()
+			clientkey, ok := b.users[string(identity)]
+			b.mu.Unlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown PSK identity %q", identity)
+			}
+			return hex.DecodeString(clientkey)
+		},
+		CipherSuites: []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
+	})
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	b.https = &http.Server{Handler: b.handler()}
+	b.wg.Add(2)
+	go func() {
+		defer b.wg.Done()
+		_ = b.https.Serve(ln)
+	}()
+	go func() {
+		defer b.wg.Done()
+		b.acceptStreams()
+	}()
+	return nil
+}
+
+// HTTPSPort returns the port the CLIP API is served on.
+func (b *MockBridge) HTTPSPort() int { return b.httpsAddr.Port }
+
+// StreamPort returns the port HueStream is received on.
+func (b *MockBridge) StreamPort() int { return b.dtls.Addr().(*net.UDPAddr).Port }
+
+// Close stops the bridge and closes all connections.
+func (b *MockBridge) Close() error {
+	close(b.closing)
+	err := b.https.Close()
+	if derr := b.dtls.Close(); err == nil {
+		err = derr
+	}
+	b.closeStreams()
+	b.wg.Wait()
+	return err
+}
+
+// PressLinkButton allows pairing for the next 30 seconds.
+func (b *MockBridge) PressLinkButton() {
+	b.mu.Lock()
+	b.linkUntil = time.Now().Add(linkButtonWindow)
+	b.mu.Unlock()
+}
+
+// AddUser registers credentials, as if paired.
+func (b *MockBridge) AddUser(username, clientkey string) {
+	b.mu.Lock()
+	b.addUser(username, clientkey, "huesync#mock")
+	b.mu.Unlock()
+}
+
+func (b *MockBridge) addUser(username, clientkey, deviceType string) {
+	b.users[username] = clientkey
+	b.apps[username] = mockApp{id: newUUID(), deviceType: deviceType}
+}
+
+// TakeOver lets another application, registered with the given device type,
+// start streaming to the area, as Hue Sync or the Hue app would. Like a real
+// bridge, it ends the current stream.
+func (b *MockBridge) TakeOver(deviceType string) {
+	b.mu.Lock()
+	username := randomHex(20)
+	b.addUser(username, randomHex(16), deviceType)
+	area := &b.areas[0]
+	b.startStreaming(area, username)
+	b.mu.Unlock()
+	b.closeStreams()
+}
+
+// Area returns the bridge's entertainment area.
+func (b *MockBridge) Area() EntertainmentArea {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.areas[0].area()
+}
+
+// Lights returns the current state of the bridge's lights.
+func (b *MockBridge) Lights() []LightState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, len(b.lights))
+	for i, l := range b.lights {
+		ids[i] = l.ID
+	}
+	return lightStates(b.lights, ids)
+}
+
+// AllowUnpair lets applications remove keys from the whitelist, as bridges
+// did before firmware 1.31.
+func (b *MockBridge) AllowUnpair() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unpair = true
+}
+
+// SetLightOn switches a light on or off, as another app would.
+func (b *MockBridge) SetLightOn(id string, on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l := b.light(id); l != nil {
+		l.On = &onData{On: on}
+		b.publish(eventResource{ID: id, Type: "light", On: l.On})
+	}
+}
+
+// Frames returns the most recent frames received for an active area, oldest
+// first, and the total number received.
+func (b *MockBridge) Frames() ([]HueStreamFrame, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]HueStreamFrame(nil), b.frames...), b.received
+}
+
+func (b *MockBridge) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api", b.handlePair)
+	mux.HandleFunc("GET /clip/v2/resource/entertainment_configuration", b.auth(b.handleAreas))
+	mux.HandleFunc("GET /clip/v2/resource/entertainment_configuration/{id}", b.auth(b.handleAreas))
+	mux.HandleFunc("POST /clip/v2/resource/entertainment_configuration", b.auth(b.handleAreaCreate))
+	mux.HandleFunc("PUT /clip/v2/resource/entertainment_configuration/{id}", b.auth(b.handleAreaUpdate))
+	mux.HandleFunc("DELETE /clip/v2/resource/entertainment_configuration/{id}", b.auth(b.handleAreaDelete))
+	mux.HandleFunc("GET /clip/v2/resource/entertainment", b.auth(b.handleServices))
+	mux.HandleFunc("GET /clip/v2/resource/light", b.auth(b.handleLights))
+	mux.HandleFunc("PUT /clip/v2/resource/light/{id}", b.auth(b.handleLightUpdate))
+	mux.HandleFunc("GET /auth/v1", b.auth(b.handleAuth))
+	mux.HandleFunc("GET /eventstream/clip/v2", b.auth(b.handleEvents))
+	mux.HandleFunc("GET /api/{user}/groups/{id}", b.handleV1Group)
+	mux.HandleFunc("GET /api/config", b.handlePublicConfig)
+	mux.HandleFunc("GET /api/{user}/config", b.handleV1Config)
+	mux.HandleFunc("DELETE /api/{user}/config/whitelist/{key}", b.handleV1Unpair)
+	return mux
+}
+
+func (b *MockBridge) handlePair(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceType        string `json:"devicetype"`
+		GenerateClientKey bool   `json:"generateclientkey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceType == "" {
+		writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 2, Description: "body contains invalid JSON"}}})
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().After(b.linkUntil) {
+		writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 101, Description: "link button not pressed"}}})
+		return
+	}
+	username := randomHex(20)
+	clientkey := randomHex(16)
+	b.addUser(username, clientkey, req.DeviceType)
+	success := &pairSuccess{Username: username}
+	if req.GenerateClientKey {
+		success.Clientkey = strings.ToUpper(clientkey)
+	}
+	writeJSON(w, http.StatusOK, []pairResponse{{Success: success}})
+}
+
+// auth rejects CLIP v2 requests without a known application key.
+func (b *MockBridge) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		_, ok := b.users[r.Header.Get("hue-application-key")]
+		b.mu.Unlock()
+		if !ok {
+			writeCLIPError(w, http.StatusForbidden, "unauthorized user")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (b *MockBridge) handleAreas(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := r.PathValue("id")
+	if id == "" {
+		writeCLIPData(w, b.areas)
+		return
+	}
+	area := b.area(id)
+	if area == nil {
+		writeCLIPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeCLIPData(w, []entertainmentData{*area})
+}
+
+// handleAreaCreate creates an entertainment area with one channel per
+// service location.
+func (b *MockBridge) handleAreaCreate(w http.ResponseWriter, r *http.Request) {
+	var req areaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeCLIPError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if req.Metadata == nil || req.Metadata.Name == "" || req.ConfigurationType == "" || req.Locations == nil {
+		writeCLIPError(w, http.StatusBadRequest, "metadata, configuration_type and locations are required")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.groupID++
+	area := entertainmentData{
+		ID:     newUUID(),
+		IDV1:   fmt.Sprintf("/groups/%d", b.groupID),
+		Status: "inactive",
+	}
+	if err := b.updateArea(&area, req); err != nil {
+		writeCLIPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	b.areas = append(b.areas, area)
+	writeCLIPData(w, []resourceRef{{RID: area.ID, RType: "entertainment_configuration"}})
+}
+
+// handleAreaUpdate starts or stops streaming to an area, or changes its
+// name, type or lights.
+func (b *MockBridge) handleAreaUpdate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Action string `json:"action"`
+		areaRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeCLIPError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	b.mu.Lock()
+	area := b.area(r.PathValue("id"))
+	if area == nil {
+		b.mu.Unlock()
+		writeCLIPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch req.Action {
+	case "start":
+		b.startStreaming(area, r.Header.Get("hue-application-key"))
+	case "stop":
+		area.Status = "inactive"
+		area.ActiveStreamer = nil
+		delete(b.streamers, area.ID)
+		b.publish(eventResource{ID: area.ID, Type: "entertainment_configuration", Status: area.Status})
+	case "":
+		if err := b.updateArea(area, req.areaRequest); err != nil {
+			b.mu.Unlock()
+			writeCLIPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		b.mu.Unlock()
+		writeCLIPError(w, http.StatusBadRequest, fmt.Sprintf("invalid action %q", req.Action))
+		return
+	}
+	ref := resourceRef{RID: area.ID, RType: "entertainment_configuration"}
+	b.mu.Unlock()
+
+	if req.Action == "stop" {
+		// Like a real bridge, end the stream of a stopped area.
+		b.closeStreams()
+	}
+	writeCLIPData(w, []resourceRef{ref})
+}
+
+// updateArea applies the fields req sets to area. b.mu must be held.
+func (b *MockBridge) updateArea(area *entertainmentData, req areaRequest) error {
+	var channels []channelData
+	var lights []resourceRef
+	if req.Locations != nil {
+		if len(req.Locations.ServiceLocations) == 0 {
+			return errors.New("locations: at least one service is required")
+		}
+		for i, loc := range req.Locations.ServiceLocations {
+			svc := b.service(loc.Service.RID)
+			if svc == nil || len(loc.Positions) == 0 {
+				return fmt.Errorf("locations: invalid service %q", loc.Service.RID)
+			}
+			channels = append(channels, channelData{
+				ChannelID: uint8(i),
+				Position:  loc.Positions[0],
+				Members:   []channelMember{{Service: resourceRef{RID: svc.ID, RType: "entertainment"}}},
+			})
+			lights = append(lights, *svc.RendererReference)
+		}
+	}
+	if req.ConfigurationType != "" && !slices.Contains([]string{"screen", "monitor", "music", "3dspace", "other"}, req.ConfigurationType) {
+		return fmt.Errorf("configuration_type: invalid value %q", req.ConfigurationType)
+	}
+
+	if req.Metadata != nil {
+		area.Metadata = *req.Metadata
+	}
+	if req.ConfigurationType != "" {
+		area.ConfigurationType = req.ConfigurationType
+	}
+	if req.Locations != nil {
+		area.Channels = channels
+		area.LightServices = lights
+	}
+	return nil
+}
+
+func (b *MockBridge) handleAreaDelete(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := r.PathValue("id")
+	i := slices.IndexFunc(b.areas, func(a entertainmentData) bool { return a.ID == id })
+	if i < 0 {
+		writeCLIPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	b.areas = slices.Delete(b.areas, i, i+1)
+	delete(b.streamers, id)
+	writeCLIPData(w, []resourceRef{{RID: id, RType: "entertainment_configuration"}})
+}
+
+func (b *MockBridge) handleServices(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writeCLIPData(w, b.services)
+}
+
+func (b *MockBridge) handleLights(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writeCLIPData(w, b.lights)
+}
+
+func (b *MockBridge) handleLightUpdate(w http.ResponseWriter, r *http.Request) {
+	var u lightUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeCLIPError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.light(r.PathValue("id"))
+	if l == nil {
+		writeCLIPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if u.On != nil && l.On.On != u.On.On {
+		l.On = &onData{On: u.On.On}
+		b.publish(eventResource{ID: l.ID, Type: "light", On: l.On})
+	}
+	if u.Dimming != nil {
+		l.Dimming = &dimmingData{Brightness: u.Dimming.Brightness}
+	}
+	if u.Color != nil && l.Color != nil {
+		l.Color.XY = u.Color.XY
+		l.ColorTemperature = &colorTemperature{}
+	}
+	if u.ColorTemperature != nil {
+		mirek := u.ColorTemperature.Mirek
+		l.ColorTemperature = &colorTemperature{Mirek: &mirek, MirekValid: true}
+	}
+	writeCLIPData(w, []resourceRef{{RID: l.ID, RType: "light"}})
+}
+
+// startStreaming makes username the active streamer of area. b.mu must be
+// held.
+func (b *MockBridge) startStreaming(area *entertainmentData, username string) {
+	area.Status = "active"
+	area.ActiveStreamer = &resourceRef{RID: b.apps[username].id, RType: "auth_v1"}
+	b.streamers[area.ID] = username
+	b.publish(eventResource{ID: area.ID, Type: "entertainment_configuration", Status: area.Status, ActiveStreamer: area.ActiveStreamer})
+}
+
+// publish sends an update event for r to the event stream subscribers.
+// b.mu must be held.
+func (b *MockBridge) publish(r eventResource) {
+	b.eventID++
+	data, _ := json.Marshal([]clipEvent{{ID: newUUID(), Type: "update", Data: []eventResource{r}}})
+	msg := []byte(fmt.Sprintf("id: %d:0\ndata: %s\n\n", b.eventID, data))
+	for ch := range b.events {
+		select {
+		case ch <- msg:
+		default:
+			// A real bridge drops slow subscribers' events too.
+		}
+	}
+}
+
+func (b *MockBridge) handleAuth(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	app := b.apps[r.Header.Get("hue-application-key")]
+	b.mu.Unlock()
+	w.Header().Set("hue-application-id", app.id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DropEventStreams ends the open event streams, as a bridge does now and
+// then.
+func (b *MockBridge) DropEventStreams() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.events {
+		delete(b.events, ch)
+		close(ch)
+	}
+}
+
+// handleEvents serves the event stream as server-sent events until the client
+// or the bridge goes away.
+func (b *MockBridge) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeCLIPError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	ch := make(chan []byte, 64)
+	b.mu.Lock()
+	b.events[ch] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.events, ch)
+		b.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, ": hi\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-b.closing:
+			return
+		}
+	}
+}
+
+// v1Auth returns whether the v1 request's username is known, and otherwise
+// writes the v1 error. b.mu must be held.
+func (b *MockBridge) v1Auth(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := b.users[r.PathValue("user")]; ok {
+		return true
+	}
+	writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 1, Description: "unauthorized user"}}})
+	return false
+}
+
+func (b *MockBridge) handleV1Group(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.v1Auth(w, r) {
+		return
+	}
+	for _, area := range b.areas {
+		if area.IDV1 != "/groups/"+r.PathValue("id") {
+			continue
+		}
+		var owner *string
+		if username, ok := b.streamers[area.ID]; ok {
+			owner = &username
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"name": area.Metadata.Name,
+			"type": "Entertainment",
+			"stream": map[string]any{
+				"proxymode": "auto",
+				"active":    area.Status == "active",
+				"owner":     owner,
+			},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 3, Description: "resource not available"}}})
+}
+
+// handlePublicConfig serves the short configuration that bridges give
+// without a username.
+func (b *MockBridge) handlePublicConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":       "Mock Hue Bridge",
+		"bridgeid":   strings.ToUpper(b.ID),
+		"modelid":    "BSB002",
+		"apiversion": "1.66.0",
+	})
+}
+
+func (b *MockBridge) handleV1Config(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.v1Auth(w, r) {
+		return
+	}
+	whitelist := make(map[string]any, len(b.apps))
+	for username, app := range b.apps {
+		whitelist[username] = map[string]string{"name": app.deviceType}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":      "Mock Hue Bridge",
+		"bridgeid":  strings.ToUpper(b.ID),
+		"modelid":   "BSB002",
+		"whitelist": whitelist,
+	})
+}
+
+func (b *MockBridge) handleV1Unpair(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.v1Auth(w, r) {
+		return
+	}
+	if !b.unpair {
+		writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 1, Description: "unauthorized user"}}})
+		return
+	}
+	key := r.PathValue("key")
+	if _, ok := b.users[key]; !ok {
+		writeJSON(w, http.StatusOK, []pairResponse{{Error: &pairError{Type: 3, Description: "resource, /config/whitelist/" + key + ", not available"}}})
+		return
+	}
+	delete(b.users, key)
+	delete(b.apps, key)
+	writeJSON(w, http.StatusOK, []map[string]string{{"success": "/config/whitelist/" + key + " deleted"}})
+}
+
+func (b *MockBridge) area(id string) *entertainmentData {
+	for i := range b.areas {
+		if b.areas[i].ID == id {
+			return &b.areas[i]
+		}
+	}
+	return nil
+}
+
+func (b *MockBridge) service(id string) *entertainmentServiceData {
+	for i := range b.services {
+		if b.services[i].ID == id {
+			return &b.services[i]
+		}
+	}
+	return nil
+}
+
+func (b *MockBridge) light(id string) *lightData {
+	for i := range b.lights {
+		if b.lights[i].ID == id {
+			return &b.lights[i]
+		}
+	}
+	return nil
+}
+
+func (b *MockBridge) acceptStreams() {
+	for {
+		conn, err := b.dtls.Accept()
+		if err != nil {
+			select {
+			case <-b.closing:
+				return
+			default:
+				// A failed handshake, e.g. an unknown PSK identity.
+				continue
+			}
+		}
+		b.mu.Lock()
+		b.streams[conn] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.readStream(conn)
+		}()
+	}
+}
+
+// readStream records the frames received on conn that target an active area.
+func (b *MockBridge) readStream(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.streams, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		f, err := ParseHueStreamMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		b.mu.Lock()
+		if area := b.area(f.AreaID); area != nil && area.Status == "active" {
+			b.received++
+			b.frames = append(b.frames, f)
+			if len(b.frames) > mockFrameHistory {
+				b.frames = b.frames[len(b.frames)-mockFrameHistory:]
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *MockBridge) closeStreams() {
+	b.mu.Lock()
+	conns := make([]net.Conn, 0, len(b.streams))
+	for conn := range b.streams {
+		conns = append(conns, conn)
+	}
+	b.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeCLIPData[T any](w http.ResponseWriter, data []T) {
+	writeJSON(w, http.StatusOK, struct {
+		Errors []clipError `json:"errors"`
+		Data   []T         `json:"data"`
+	}{Errors: []clipError{}, Data: data})
+}
+
+func writeCLIPError(w http.ResponseWriter, status int, description string) {
+	writeJSON(w, status, struct {
+		Errors []clipError `json:"errors"`
+		Data   []any       `json:"data"`
+	}{Errors: []clipError{{Description: description}}, Data: []any{}})
+}
+
+// mockCerts holds the certificate of each bridge ID served so far.
+var mockCerts = struct {
+	sync.Mutex
+	m map[string]tls.Certificate
+}{m: make(map[string]tls.Certificate)}
+
+// bridgeCert returns the certificate for bridge ID cn, the same for every mock
+// bridge with that ID, as a real bridge keeps its certificate across restarts.
+// Otherwise a client still talking to an earlier mock would pin the wrong one.
+func bridgeCert(cn string) (tls.Certificate, error) {
+	mockCerts.Lock()
+	defer mockCerts.Unlock()
+	if cert, ok := mockCerts.m[cn]; ok {
+		return cert, nil
+	}
+	cert, err := selfSignedCert(cn)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	mockCerts.m[cn] = cert
+	return cert, nil
+}
+
+// selfSignedCert creates a certificate for the mock bridge's HTTPS server.
+// Like a real bridge's, its common name is the bridge ID.
+func selfSignedCert(cn string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newUUID returns a random version 4 UUID, the ID format of CLIP v2.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// runMockBridge implements `huesync mock-bridge`: it runs a mock bridge for
+// manual testing until interrupted. Each line read from stdin presses the
+// link button.
+func runMockBridge(args []string, stdin io.Reader, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	fs := flag.NewFlagSet("huesync mock-bridge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	listen := fs.String("listen", "127.0.0.1", "IP address to listen on")
+	httpsPort := fs.Int("https-port", bridgeHTTPSPort, "port of the CLIP API")
+	streamPort := fs.Int("stream-port", bridgeStreamPort, "port of the HueStream listener")
+	id := fs.String("id", "001788fffe4d4f43", "bridge ID")
+	channels := fs.Int("channels", 3, "channels of the entertainment area")
+	announce := fs.Bool("mdns", true, "announce the bridge via mDNS")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	ip := net.ParseIP(*listen)
+	if ip == nil || *channels < 1 || *channels > 20 {
+		logger.Printf("invalid -listen address or -channels count (want 1-20)")
+		return exitUsage
+	}
+
+	b := NewMockBridge(*id, *channels)
+	if err := b.Start(ip, *httpsPort, *streamPort); err != nil {
+		logger.Printf("error: %v", err)
+		return exitError
+	}
+	defer b.Close()
+	logger.Printf("mock bridge %s: CLIP API on https://%s, HueStream on udp/%d",
+		b.ID, net.JoinHostPort(ip.String(), fmt.Sprint(b.HTTPSPort())), b.StreamPort())
+	logger.Printf("area %q with %d channels", b.Area().Name, *channels)
+
+	if *announce {
+		server, err := zeroconf.RegisterProxy("Mock Hue Bridge", "_hue._tcp", "local.", b.HTTPSPort(),
+			"huesync-mock", []string{ip.String()}, []string{"bridgeid=" + b.ID, "modelid=BSB002"}, nil)
+		if err != nil {
+			logger.Printf("mDNS announcement failed: %v", err)
+		} else {
+			defer server.Shutdown()
+		}
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			b.PressLinkButton()
+			logger.Printf("link button pressed, pairing allowed for %s", linkButtonWindow)
+		}
+	}()
+	logger.Printf("press Enter to press the link button, Ctrl+C to stop")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last int
+	for {
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-ticker.C:
+		}
+		frames, received := b.Frames()
+		if received == last {
+			continue
+		}
+		logger.Printf("%d frames/s, last: %s", received-last, describeFrame(frames[len(frames)-1]))
+		last = received
+	}
+}
+
+// describeFrame summarizes a received frame for the mock bridge's log.
+func describeFrame(f HueStreamFrame) string {
+	parts := make([]string, len(f.Channels))
+	for i, ch := range f.Channels {
+		v := ch.Values
+		if f.ColorSpace == ColorSpaceXY {
+			parts[i] = fmt.Sprintf("%d=xy(%.3f,%.3f)@%.0f%%", ch.ID,
+				float64(v[0])/0xffff, float64(v[1])/0xffff, float64(v[2])/0xffff*100)
+		} else {
+			parts[i] = fmt.Sprintf("%d=%s", ch.ID, RGB{uint8(v[0] >> 8), uint8(v[1] >> 8), uint8(v[2] >> 8)})
+		}
+	}
+	return strings.Join(parts, " ")
+}
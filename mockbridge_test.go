@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startMockBridge starts a mock bridge on the loopback interface and points
+// the bridge ports and discovery at it.
+func startMockBridge(t *testing.T, channels int) *MockBridge {
+	t.Helper()
+	b := NewMockBridge("001788fffe4d4f43", channels)
+	ip := net.IPv4(127, 0, 0, 1)
+	if err := b.Start(ip, 0, 0); err != nil {
+		t.Fatalf("starting mock bridge: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	httpsPort, streamPort, discover := bridgeHTTPSPort, bridgeStreamPort, discoverBridges
+	t.Cleanup(func() {
+		bridgeHTTPSPort, bridgeStreamPort, discoverBridges = httpsPort, streamPort, discover
+	})
+	bridgeHTTPSPort, bridgeStreamPort = b.HTTPSPort(), b.StreamPort()
+	expectBridge(Bridge{ID: b.ID, IP: ip})
+	if credentialsDir == "" {
+		// Keep the pinned certificate out of the home directory.
+		setupCredentialsDir(t)
+	}
+	discoverBridges = func(ctx context.Context) (<-chan Bridge, <-chan error) {
+		bridges := make(chan Bridge, 1)
+		errs := make(chan error)
+		bridges <- Bridge{ID: b.ID, Model: "BSB002", Name: "Mock", IP: ip, Port: b.HTTPSPort()}
+		close(bridges)
+		close(errs)
+		return bridges, errs
+	}
+	return b
+}
+
+// waitForFrames waits until the bridge has received at least n frames.
+func waitForFrames(t *testing.T, b *MockBridge, n int) []HueStreamFrame {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		frames, received := b.Frames()
+		if received >= n {
+			return frames
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d frames, got %d", n, received)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMockBridge_Pairing(t *testing.T) {
+	b := startMockBridge(t, 2)
+	ip := net.IPv4(127, 0, 0, 1)
+
+	if _, _, err := PairBridge(ip); !errors.Is(err, ErrLinkButtonNotPressed) {
+		t.Fatalf("expected ErrLinkButtonNotPressed, got %v", err)
+	}
+
+	b.PressLinkButton()
+	username, clientkey, err := PairBridge(ip)
+	if err != nil {
+		t.Fatalf("PairBridge: %v", err)
+	}
+	if username == "" || len(clientkey) != 32 {
+		t.Errorf("unexpected credentials %q, %q", username, clientkey)
+	}
+
+	if _, err := FetchEntertainmentAreas(ip, "stranger"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for unknown user, got %v", err)
+	}
+	areas, err := FetchEntertainmentAreas(ip, username)
+	if err != nil {
+		t.Fatalf("FetchEntertainmentAreas: %v", err)
+	}
+	if len(areas) != 1 || len(areas[0].Channels) != 2 || len(areas[0].LightIDs) != 2 {
+		t.Errorf("unexpected areas %+v", areas)
+	}
+}
+
+func TestMockBridge_Streaming(t *testing.T) {
+	b := startMockBridge(t, 2)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	area := b.Area()
+
+	gamuts, err := FetchChannelGamuts(ip, "user", area)
+	if err != nil {
+		t.Fatalf("FetchChannelGamuts: %v", err)
+	}
+	if len(gamuts) != 2 || gamuts[0] != gamutC {
+		t.Errorf("expected gamut C for each channel, got %v", gamuts)
+	}
+
+	s, err := dialArea(ip, "user", "0123456789abcdef0123456789abcdef", "", area, ColorSpaceRGB, nil)
+	if err != nil {
+		t.Fatalf("dialArea: %v", err)
+	}
+	defer s.Close()
+	if b.Area().Status != "active" {
+		t.Error("expected the area to be active")
+	}
+
+	if err := s.SendFrame([]RGB{{R: 255}, {B: 255}}); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	f := waitForFrames(t, b, 1)[0]
+	if f.AreaID != area.ID || len(f.Channels) != 2 {
+		t.Fatalf("unexpected frame %+v", f)
+	}
+	if f.Channels[0].Values != [3]uint16{0xffff, 0, 0} || f.Channels[1].Values != [3]uint16{0, 0, 0xffff} {
+		t.Errorf("unexpected channel values %v", f.Channels)
+	}
+
+	// Stopping the area ends the stream, as on a real bridge.
+	if err := DeactivateArea(ip, "user", area.ID); err != nil {
+		t.Fatalf("DeactivateArea: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.SendFrame([]RGB{{}, {}}) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stream to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMockBridge_LightState(t *testing.T) {
+	b := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	b.AddUser("user", "0123456789abcdef0123456789abcdef")
+	id := b.Area().LightIDs[0]
+
+	mirek := 300
+	if err := SetLightState(ip, "user", LightState{ID: id, On: true, Mirek: &mirek}, 0); err != nil {
+		t.Fatalf("SetLightState: %v", err)
+	}
+	states, err := FetchLightStates(ip, "user", []string{id})
+	if err != nil {
+		t.Fatalf("FetchLightStates: %v", err)
+	}
+	if len(states) != 1 || states[0].Mirek == nil || *states[0].Mirek != 300 {
+		t.Errorf("expected color temperature 300, got %+v", states)
+	}
+}
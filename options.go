@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options holds the settings that can be given on the command line or in the
+// config file.
+type options struct {
+	profile       string
+	bridge        string
+	bridgeIP      string
+	ssdp          bool
+	area          string
+	delay         time.Duration
+	delaySet      bool
+	capture       string
+	display       int
+	displaySet    bool
+	colorMode     string
+	average       averagingSpace
+	colorSpace    ColorSpace
+	smoothing     time.Duration
+	snapThreshold float64
+	restore       bool
+	restoreFade   time.Duration
+	reconnect     int
+	sendRate      int
+	record        string
+	// effect is an effect name, effectScreen to sync to the screen,
+	// effectAudio to follow the audio, or empty to let the TUI ask.
+	effect       string
+	effectParams effectParams
+	audioSource  string
+	audioCapture string
+	// audioSensitivity scales the audio response; 1 is neutral.
+	audioSensitivity float64
+	// audioBlend is the share of the screen colors in audio mode, 0 to 1.
+	audioBlend float64
+}
+
+// maxSendRate is the highest send rate in frames per second. The bridge
+// renders at most about 60 updates per second.
+const maxSendRate = 60
+
+func defaultOptions() options {
+	return options{
+		delay:            100 * time.Millisecond,
+		capture:          "auto",
+		colorMode:        "mean",
+		smoothing:        150 * time.Millisecond,
+		snapThreshold:    120,
+		restore:          true,
+		reconnect:        10,
+		sendRate:         50,
+		effectParams:     effectParams{Speed: 1, Intensity: 1},
+		audioSource:      defaultAudioSource,
+		audioCapture:     "auto",
+		audioSensitivity: 1,
+	}
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.profile, "profile", o.profile, "config profile to use")
+	fs.StringVar(&o.bridge, "bridge", o.bridge, "bridge ID or IP address (default: the only bridge found)")
+	fs.StringVar(&o.bridgeIP, "bridge-ip", o.bridgeIP, "IP address or host name of the bridge, for networks where discovery finds nothing")
+	fs.BoolVar(&o.ssdp, "ssdp", o.ssdp, "also search for bridges with SSDP (UPnP)")
+	fs.StringVar(&o.area, "area", o.area, "entertainment area name or ID (default: the only area)")
+	fs.Func("delay", "capture delay in milliseconds (default 100)", func(s string) error {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid delay %q: want a positive number of milliseconds", s)
+		}
+		o.delay = time.Duration(ms) * time.Millisecond
+		o.delaySet = true
+		return nil
+	})
+	fs.Func("capture", "capture backend: "+strings.Join(captureBackends, ", ")+` (default "auto")`, func(s string) error {
+		if !slices.Contains(captureBackends, s) {
+			return fmt.Errorf("unknown capture backend %q", s)
+		}
+		o.capture = s
+		return nil
+	})
+	fs.Func("display", `display to capture: an index starting at 0, or "span" for all displays`, func(s string) error {
+		d, err := parseDisplay(s)
+		if err != nil {
+			return err
+		}
+		o.display = d
+		o.displaySet = true
+		return nil
+	})
+	fs.Func("color", "color extraction: "+strings.Join(extractorNames, ", ")+` (default "mean")`, func(s string) error {
+		if _, err := newColorExtractor(s, o.average); err != nil {
+			return err
+		}
+		o.colorMode = s
+		return nil
+	})
+	fs.Func("average", `averaging space for the mean color mode: linear or oklab (default "linear")`, func(s string) error {
+		space, err := parseAveragingSpace(s)
+		if err != nil {
+			return err
+		}
+		o.average = space
+		return nil
+	})
+	fs.Func("color-space", `color space sent to the bridge: rgb, or xy for CIE xy+brightness clamped to each light's gamut (default "rgb")`, func(s string) error {
+		cs, err := parseColorSpace(s)
+		if err != nil {
+			return err
+		}
+		o.colorSpace = cs
+		return nil
+	})
+	fs.Func("smoothing", "smoothing time constant in milliseconds, 0 to disable (default 150)", func(s string) error {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms < 0 {
+			return fmt.Errorf("invalid smoothing %q: want milliseconds", s)
+		}
+		o.smoothing = time.Duration(ms) * time.Millisecond
+		return nil
+	})
+	fs.Float64Var(&o.snapThreshold, "snap", o.snapThreshold, "color change (0-441 RGB distance) treated as a scene cut and applied instantly, 0 to disable")
+	fs.BoolVar(&o.restore, "restore", o.restore, "restore the lights' previous state when streaming stops")
+	fs.Func("restore-fade", "fade time in milliseconds when restoring the lights (default 0)", func(s string) error {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms < 0 {
+			return fmt.Errorf("invalid restore fade %q: want milliseconds", s)
+		}
+		o.restoreFade = time.Duration(ms) * time.Millisecond
+		return nil
+	})
+	fs.Func("send-rate", "frames sent to the bridge per second, 1-60 (default 50)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSendRate {
+			return fmt.Errorf("invalid send rate %q: want 1-%d", s, maxSendRate)
+		}
+		o.sendRate = n
+		return nil
+	})
+	fs.Func("reconnect", "reconnect attempts after the stream connection is lost, 0 to disable (default 10)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid reconnect attempts %q", s)
+		}
+		o.reconnect = n
+		return nil
+	})
+	fs.StringVar(&o.record, "record", o.record, "record the HueStream messages sent to this file, for huesync replay")
+	fs.Func("effect", "effect to show instead of syncing to the screen: "+strings.Join(effectNames, ", ")+", "+effectScreen+", or "+effectAudio+" to follow the system audio", func(s string) error {
+		effect, err := parseEffect(s)
+		if err != nil {
+			return err
+		}
+		o.effect = effect
+		return nil
+	})
+	fs.Func("effect-speed", "effect speed, e.g. 2 for twice as fast (default 1)", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid effect speed %q: want a positive number", s)
+		}
+		o.effectParams.Speed = v
+		return nil
+	})
+	fs.Func("effect-intensity", "effect intensity in percent, 0-100 (default 100)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 100 {
+			return fmt.Errorf("invalid effect intensity %q: want 0-100", s)
+		}
+		o.effectParams.Intensity = float64(n) / 100
+		return nil
+	})
+	fs.Func("effect-palette", "comma-separated #rrggbb colors replacing the effect's own", func(s string) error {
+		palette, err := parsePalette(s)
+		if err != nil {
+			return err
+		}
+		o.effectParams.Palette = palette
+		return nil
+	})
+	fs.StringVar(&o.audioSource, "audio-source", o.audioSource, "PulseAudio or PipeWire source recorded in audio mode")
+	fs.Func("audio-capture", "audio capture backend: "+strings.Join(audioBackends, ", ")+` (default "auto")`, func(s string) error {
+		if !slices.Contains(audioBackends, s) {
+			return fmt.Errorf("unknown audio capture backend %q", s)
+		}
+		o.audioCapture = s
+		return nil
+	})
+	fs.Func("audio-sensitivity", "audio sensitivity in percent; higher values react to quieter sounds (default 100)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid audio sensitivity %q: want a positive percentage", s)
+		}
+		o.audioSensitivity = float64(n) / 100
+		return nil
+	})
+	fs.Func("audio-blend", "share of the screen colors in audio mode in percent, 0-100 (default 0)", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 100 {
+			return fmt.Errorf("invalid audio blend %q: want 0-100", s)
+		}
+		o.audioBlend = float64(n) / 100
+		return nil
+	})
+}
+
+// parseDisplay parses a display index or "span" into a value for NewCapturer.
+func parseDisplay(s string) (int, error) {
+	if s == "span" {
+		return spanDisplays, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid display %q: want an index or \"span\"", s)
+	}
+	return n, nil
+}
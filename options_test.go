@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"2", 2, false},
+		{"span", spanDisplays, false},
+		{"-1", 0, true},
+		{"left", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDisplay(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDisplay(%q): err=%v, wantErr=%v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDisplay(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
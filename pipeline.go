@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// colorPipeline turns captured frames into per-channel colors. It keeps the
+// state that spans frames, such as the detected black bars, and the settings
+// that can be changed while streaming.
+type colorPipeline struct {
+	channels []Channel
+	crop     cropDetector
+	smooth   smoother
+
+	mu            sync.Mutex
+	space         averagingSpace
+	extractor     ColorExtractor
+	extractorName string
+	smoothing     time.Duration
+	snapThreshold float64
+}
+
+func newColorPipeline(channels []Channel, opts options) (*colorPipeline, error) {
+	p := &colorPipeline{
+		channels:      channels,
+		space:         opts.average,
+		smoothing:     opts.smoothing,
+		snapThreshold: opts.snapThreshold,
+	}
+	if err := p.SetColorMode(opts.colorMode); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetColorMode switches the color extraction strategy.
+func (p *colorPipeline) SetColorMode(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ex, err := newColorExtractor(name, p.space)
+	if err != nil {
+		return err
+	}
+	p.extractor = ex
+	p.extractorName = name
+	return nil
+}
+
+// ColorMode returns the name of the current color extraction strategy.
+func (p *colorPipeline) ColorMode() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.extractorName
+}
+
+// SetSmoothing sets the smoothing time constant (0 disables smoothing) and
+// the scene-cut threshold in RGB units (0 disables snapping).
+func (p *colorPipeline) SetSmoothing(timeConstant time.Duration, snapThreshold float64) {
+	p.mu.Lock()
+	p.smoothing = max(timeConstant, 0)
+	p.snapThreshold = max(snapThreshold, 0)
+	p.mu.Unlock()
+}
+
+// Smoothing returns the smoothing time constant and scene-cut threshold.
+func (p *colorPipeline) Smoothing() (time.Duration, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.smoothing, p.snapThreshold
+}
+
+// Process samples one color per channel from f captured at now, ignoring
+// black bars, and smooths the result over time. It returns the colors and the
+// crop they were sampled from.
+func (p *colorPipeline) Process(f Frame, now time.Time) ([]RGB, image.Rectangle) {
+	p.mu.Lock()
+	ex := p.extractor
+	tau, snap := p.smoothing, p.snapThreshold
+	p.mu.Unlock()
+
+	crop := p.crop.Update(f)
+	colors := sampleChannels(f, crop, p.channels, ex)
+	return p.smooth.Apply(colors, now, tau, snap), crop
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recording stores the HueStream messages of a session with their send
+// times, so the session can be replayed later. The format, version 1, is:
+//
+//	header:  "HueStreamRec" (12 bytes), version (uint16)
+//	records: time (uint64), length (uint16), message (length bytes)
+//
+// Integers are big-endian. time is the number of nanoseconds since the
+// recording started, taken from the monotonic clock, so records are in
+// non-decreasing time order. The records run to the end of the file; a
+// truncated last record, left by a crash, is ignored when replaying.
+const (
+	recordingMagic   = "HueStreamRec"
+	recordingVersion = 1
+)
+
+// Recorder appends HueStream messages to a recording. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	start  time.Time
+	err    error
+}
+
+// CreateRecording creates the recording file at path, replacing any existing
+// file. Times are relative to now.
+func CreateRecording(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+	r, err := newRecorder(f, time.Now())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// newRecorder writes a recording header to w. Times are relative to start.
+func newRecorder(w io.Writer, start time.Time) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), start: start}
+	header := make([]byte, len(recordingMagic)+2)
+	copy(header, recordingMagic)
+	binary.BigEndian.PutUint16(header[len(recordingMagic):], recordingVersion)
+	if _, err := r.w.Write(header); err != nil {
+		return nil, fmt.Errorf("writing recording header: %w", err)
+	}
+	return r, nil
+}
+
+// Record appends msg, sent at the given time. After the first write error
+// Record does nothing and returns that error, which Close returns as well.
+func (r *Recorder) Record(at time.Time, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if len(msg) > 0xffff {
+		return fmt.Errorf("message of %d bytes is too long to record", len(msg))
+	}
+
+	var head [10]byte
+	binary.BigEndian.PutUint64(head[0:8], uint64(max(at.Sub(r.start), 0)))
+	binary.BigEndian.PutUint16(head[8:10], uint16(len(msg)))
+	if _, err := r.w.Write(head[:]); err != nil {
+		r.err = fmt.Errorf("writing recording: %w", err)
+		return r.err
+	}
+	if _, err := r.w.Write(msg); err != nil {
+		r.err = fmt.Errorf("writing recording: %w", err)
+		return r.err
+	}
+	return nil
+}
+
+// Close flushes the recording and closes its file. It returns the first
+// error encountered while recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		if err := r.w.Flush(); err != nil {
+			r.err = fmt.Errorf("writing recording: %w", err)
+		}
+	}
+	if r.closer != nil {
+		if err := r.closer.Close(); err != nil && r.err == nil {
+			r.err = fmt.Errorf("closing recording: %w", err)
+		}
+		r.closer = nil
+	}
+	return r.err
+}
+
+// RecordedMessage is one record of a recording.
+type RecordedMessage struct {
+	// At is the time since the recording started.
+	At  time.Duration
+	Msg []byte
+}
+
+// errTruncatedRecording is returned by RecordingReader.Next for a record
+// cut short by the end of the file.
+var errTruncatedRecording = errors.New("recording ends with a truncated record")
+
+// RecordingReader reads the records of a recording.
+type RecordingReader struct {
+	r *bufio.Reader
+}
+
+// newRecordingReader checks the recording header and returns a reader
+// positioned at the first record.
+func newRecordingReader(r io.Reader) (*RecordingReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(recordingMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(recordingMagic)]) != recordingMagic {
+		return nil, errors.New("not a huesync recording")
+	}
+	if v := binary.BigEndian.Uint16(header[len(recordingMagic):]); v != recordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d", v)
+	}
+	return &RecordingReader{r: br}, nil
+}
+
+// Next returns the next record. It returns io.EOF after the last record, and
+// errTruncatedRecording if the file ends within a record.
+func (rr *RecordingReader) Next() (RecordedMessage, error) {
+	var head [10]byte
+	if _, err := io.ReadFull(rr.r, head[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return RecordedMessage{}, errTruncatedRecording
+		}
+		return RecordedMessage{}, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(head[8:10]))
+	if _, err := io.ReadFull(rr.r, msg); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return RecordedMessage{}, errTruncatedRecording
+		}
+		return RecordedMessage{}, err
+	}
+	return RecordedMessage{
+		At:  time.Duration(binary.BigEndian.Uint64(head[0:8])),
+		Msg: msg,
+	}, nil
+}
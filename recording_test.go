@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRecording_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now()
+	r, err := newRecorder(&buf, start)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	msgs := [][]byte{
+		BuildHueStreamMessage("area", []uint8{0, 1}, []RGB{{R: 255}, {B: 255}}, 0),
+		BuildHueStreamMessage("area", []uint8{0, 1}, []RGB{{G: 255}, {}}, 1),
+	}
+	for i, msg := range msgs {
+		if err := r.Record(start.Add(time.Duration(i)*20*time.Millisecond), msg); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rr, err := newRecordingReader(&buf)
+	if err != nil {
+		t.Fatalf("newRecordingReader: %v", err)
+	}
+	for i, want := range msgs {
+		rec, err := rr.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if rec.At != time.Duration(i)*20*time.Millisecond || !bytes.Equal(rec.Msg, want) {
+			t.Errorf("record %d: got %v %x", i, rec.At, rec.Msg)
+		}
+	}
+	if _, err := rr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF after the last record, got %v", err)
+	}
+}
+
+func TestRecording_Truncated(t *testing.T) {
+	var buf bytes.Buffer
+	r, _ := newRecorder(&buf, time.Now())
+	_ = r.Record(time.Now(), BuildHueStreamMessage("area", []uint8{0}, []RGB{{}}, 0))
+	_ = r.Close()
+
+	rr, err := newRecordingReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != nil {
+		t.Fatalf("newRecordingReader: %v", err)
+	}
+	if _, err := rr.Next(); !errors.Is(err, errTruncatedRecording) {
+		t.Errorf("expected errTruncatedRecording, got %v", err)
+	}
+}
+
+func TestRecording_InvalidHeader(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"HueStream\x02\x00",
+		"HueStreamRec\x00\x02",
+	} {
+		if _, err := newRecordingReader(bytes.NewReader([]byte(data))); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runReplay implements `huesync replay`: it resends a recording made with
+// --record to an entertainment area.
+func runReplay(args []string, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	speed := 1.0
+	loop := false
+	parser, opts, err := newOptionParser("huesync replay", args, stderr, func(fs *flag.FlagSet) {
+		fs.Func("speed", "playback speed, e.g. 2 for twice as fast (default 1)", func(s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v <= 0 {
+				return fmt.Errorf("invalid speed %q: want a positive number", s)
+			}
+			speed = v
+			return nil
+		})
+		fs.BoolVar(&loop, "loop", loop, "start over at the end of the recording")
+	})
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		var ue *usageError
+		if !errors.As(err, &ue) {
+			logger.Printf("error: %v", err)
+		}
+		return exitUsage
+	}
+	rest := parser.Args()
+	if len(rest) != 1 {
+		logger.Printf("usage: huesync replay [flags] <recording>")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = replayHeadless(ctx, logger, opts, rest[0], speed, loop)
+	if err != nil {
+		logger.Printf("error: %v", err)
+	}
+	return exitCode(err)
+}
+
+// replayHeadless replays the recording at path to the area selected by opts
+// until it ends or ctx is done.
+func replayHeadless(ctx context.Context, logger *log.Logger, opts options, path string, speed float64, loop bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return cliErrorf(exitUsage, "%w", err)
+	}
+	defer f.Close()
+	rr, err := newRecordingReader(f)
+	if err != nil {
+		return cliErrorf(exitUsage, "%s: %w", path, err)
+	}
+	desc, err := describeRecording(rr)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	logger.Printf("recording: %s", desc)
+
+	bridge, creds, area, err := resolveArea(ctx, logger, opts)
+	if err != nil {
+		return err
+	}
+	started, deactivate, err := activateHeadless(logger, bridge, creds, area, opts)
+	if err != nil {
+		return err
+	}
+	defer deactivate()
+
+	streamer, err := NewStreamer(bridge.IP, creds.Username, creds.Clientkey, area.ID, area.ChannelIDs())
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	defer streamer.Close()
+	if opts.record != "" {
+		rec, err := CreateRecording(opts.record)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rec.Close(); err != nil {
+				logger.Printf("closing recording: %v", err)
+			}
+		}()
+		streamer.SetRecorder(rec)
+		logger.Printf("recording to %s", opts.record)
+	}
+
+	logger.Printf("replaying %s at %gx speed, press Ctrl+C to stop", path, speed)
+	for {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewinding recording: %w", err)
+		}
+		rr, err := newRecordingReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		sent, err := replay(ctx, rr, streamer, area, speed)
+		if sent > 0 && started != nil {
+			started()
+			started = nil
+		}
+		switch {
+		case errors.Is(err, errTruncatedRecording):
+			logger.Printf("%v", err)
+		case err != nil:
+			return err
+		}
+		if ctx.Err() != nil || !loop || sent == 0 {
+			logger.Printf("stopping")
+			return nil
+		}
+	}
+}
+
+// replay sends the records of rr to area, each at its recorded time divided
+// by speed, and returns the number of messages sent. It returns early with a
+// nil error when ctx is done.
+func replay(ctx context.Context, rr *RecordingReader, s *Streamer, area EntertainmentArea, speed float64) (int, error) {
+	channelIDs := area.ChannelIDs()
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	sent := 0
+	for {
+		rec, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+		msg, err := remapHueStreamMessage(rec.Msg, area.ID, channelIDs)
+		if err != nil {
+			return sent, fmt.Errorf("record at %v: %w", rec.At, err)
+		}
+
+		timer.Reset(time.Until(start.Add(time.Duration(float64(rec.At) / speed))))
+		select {
+		case <-ctx.Done():
+			return sent, nil
+		case <-timer.C:
+		}
+		if err := s.sendMessage(msg); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+}
+
+// remapHueStreamMessage rewrites a recorded HueStream message for another
+// area: the i-th channel of channelIDs gets the values of the i-th recorded
+// channel, starting over with the first when the area has more channels than
+// the recording.
+func remapHueStreamMessage(msg []byte, areaID string, channelIDs []uint8) ([]byte, error) {
+	f, err := ParseHueStreamMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Channels) == 0 {
+		return nil, fmt.Errorf("message has no channels")
+	}
+
+	out := hueStreamHeader(areaID, f.ColorSpace, len(channelIDs), f.Seq)
+	offset := 52
+	for i, ch := range channelIDs {
+		v := f.Channels[i%len(f.Channels)].Values
+		putChannel(out[offset:], ch, v[0], v[1], v[2])
+		offset += 7
+	}
+	return out, nil
+}
+
+// describeRecording summarizes a recording for the log.
+func describeRecording(rr *RecordingReader) (string, error) {
+	var n int
+	var last time.Duration
+	areas := map[string]bool{}
+	for {
+		rec, err := rr.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, errTruncatedRecording) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		n++
+		last = rec.At
+		if f, err := ParseHueStreamMessage(rec.Msg); err == nil {
+			areas[f.AreaID] = true
+		}
+	}
+	return fmt.Sprintf("%d messages over %v from area %s", n, last.Round(time.Millisecond), strings.Join(slices.Sorted(maps.Keys(areas)), ", ")), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemapHueStreamMessage(t *testing.T) {
+	msg := BuildHueStreamMessage("recorded", []uint8{4, 7}, []RGB{{R: 255}, {B: 255}}, 9)
+	out, err := remapHueStreamMessage(msg, "target", []uint8{0, 1, 2})
+	if err != nil {
+		t.Fatalf("remapHueStreamMessage: %v", err)
+	}
+	f, err := ParseHueStreamMessage(out)
+	if err != nil {
+		t.Fatalf("ParseHueStreamMessage: %v", err)
+	}
+	if f.AreaID != "target" || f.Seq != 9 || len(f.Channels) != 3 {
+		t.Fatalf("unexpected frame %+v", f)
+	}
+	red, blue := [3]uint16{0xffff, 0, 0}, [3]uint16{0, 0, 0xffff}
+	for i, want := range [][3]uint16{red, blue, red} {
+		if f.Channels[i].ID != uint8(i) || f.Channels[i].Values != want {
+			t.Errorf("channel %d: got %+v, want %v", i, f.Channels[i], want)
+		}
+	}
+
+	if _, err := remapHueStreamMessage(msg[:40], "target", []uint8{0}); err == nil {
+		t.Error("expected an error for a truncated message")
+	}
+}
+
+func TestReplayHeadless_MockBridge(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	logger := log.New(io.Discard, "", 0)
+
+	clientkey := "0123456789abcdef0123456789abcdef"
+	bridge.AddUser("user", clientkey)
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: clientkey}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.delay = 20 * time.Millisecond
+	opts.record = filepath.Join(t.TempDir(), "session.hsr")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- streamHeadless(ctx, logger, opts) }()
+	waitForFrames(t, bridge, 10)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("streamHeadless: %v", err)
+	}
+	recorded, streamed := bridge.Frames()
+
+	// Replaying at four times the speed resends every recorded frame.
+	path := opts.record
+	opts.record = ""
+	start := time.Now()
+	if err := replayHeadless(context.Background(), logger, opts, path, 4, false); err != nil {
+		t.Fatalf("replayHeadless: %v", err)
+	}
+	frames, received := bridge.Frames()
+	if received != 2*streamed {
+		t.Fatalf("expected %d replayed frames, got %d", streamed, received-streamed)
+	}
+	for i, f := range frames[len(recorded):] {
+		if f.Channels[0].Values != recorded[i].Channels[0].Values {
+			t.Errorf("frame %d: got %v, want %v", i, f.Channels[0].Values, recorded[i].Channels[0].Values)
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("replay took %v", d)
+	}
+}
@@ -16,13 +16,57 @@ func (c RGB) String() string {
 	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
 }
 
-// CaptureScreen captures display 0 and returns the image.
-func CaptureScreen() (*image.RGBA, error) {
+// spanDisplays selects every active display as one virtual canvas.
+const spanDisplays = -1
+
+// Display is an active display and its position on the desktop.
+type Display struct {
+	Index  int
+	Bounds image.Rectangle
+}
+
+func (d Display) String() string {
+	return fmt.Sprintf("Display %d: %dx%d at +%d,+%d",
+		d.Index, d.Bounds.Dx(), d.Bounds.Dy(), d.Bounds.Min.X, d.Bounds.Min.Y)
+}
+
+// ListDisplays returns all active displays.
+func ListDisplays() []Display {
 	n := screenshot.NumActiveDisplays()
-	if n == 0 {
-		return nil, fmt.Errorf("no active displays found")
+	displays := make([]Display, n)
+	for i := range displays {
+		displays[i] = Display{Index: i, Bounds: screenshot.GetDisplayBounds(i)}
+	}
+	return displays
+}
+
+// displayBounds returns the desktop rectangle covered by the given display, or
+// the union of all active displays for spanDisplays.
+func displayBounds(display int) (image.Rectangle, error) {
+	displays := ListDisplays()
+	if len(displays) == 0 {
+		return image.Rectangle{}, fmt.Errorf("no active displays found")
+	}
+	if display == spanDisplays {
+		var r image.Rectangle
+		for _, d := range displays {
+			r = r.Union(d.Bounds)
+		}
+		return r, nil
+	}
+	if display < 0 || display >= len(displays) {
+		return image.Rectangle{}, fmt.Errorf("display %d not found (%d active)", display, len(displays))
+	}
+	return displays[display].Bounds, nil
+}
+
+// CaptureScreen captures the given display (or all displays for spanDisplays)
+// and returns the image.
+func CaptureScreen(display int) (*image.RGBA, error) {
+	bounds, err := displayBounds(display)
+	if err != nil {
+		return nil, err
 	}
-	bounds := screenshot.GetDisplayBounds(0)
 	img, err := screenshot.CaptureRect(bounds)
 	if err != nil {
 		return nil, fmt.Errorf("capturing screen: %w", err)
@@ -30,7 +74,7 @@ func CaptureScreen() (*image.RGBA, error) {
 	return img, nil
 }
 
-// AverageColor computes the mean RGB of an RGBA image.
+// AverageColor computes the mean RGB of an RGBA image in linear light.
 // It samples every Nth pixel to keep computation fast on large displays.
 func AverageColor(img *image.RGBA) RGB {
 	w := img.Rect.Dx()
@@ -46,22 +90,48 @@ func AverageColor(img *image.RGBA) RGB {
 		step = 1
 	}
 
-	var rSum, gSum, bSum uint64
-	var count uint64
-
+	acc := colorAccumulator{space: spaceLinear}
 	pix := img.Pix
 	stride := img.Stride
 	for i := 0; i < pixels; i += step {
 		off := (i/w)*stride + (i%w)*4
-		rSum += uint64(pix[off])
-		gSum += uint64(pix[off+1])
-		bSum += uint64(pix[off+2])
-		count++
+		acc.Add(pix[off], pix[off+1], pix[off+2])
 	}
+	return acc.Mean()
+}
+
+// downscaleRGBA box-filters an RGBA image down to a w×h RGB24 frame,
+// averaging each box in linear light.
+func downscaleRGBA(img *image.RGBA, w, h int) Frame {
+	f := Frame{Pix: make([]byte, w*h*3), Width: w, Height: h}
+	srcW := img.Rect.Dx()
+	srcH := img.Rect.Dy()
+	if srcW == 0 || srcH == 0 {
+		return f
+	}
+
+	for y := 0; y < h; y++ {
+		y0 := y * srcH / h
+		y1 := max((y+1)*srcH/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * srcW / w
+			x1 := max((x+1)*srcW/w, x0+1)
+
+			acc := colorAccumulator{space: spaceLinear}
+			for sy := y0; sy < y1; sy++ {
+				off := sy*img.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					acc.Add(img.Pix[off], img.Pix[off+1], img.Pix[off+2])
+					off += 4
+				}
+			}
 
-	return RGB{
-		R: uint8(rSum / count),
-		G: uint8(gSum / count),
-		B: uint8(bSum / count),
+			c := acc.Mean()
+			dst := (y*w + x) * 3
+			f.Pix[dst] = c.R
+			f.Pix[dst+1] = c.G
+			f.Pix[dst+2] = c.B
+		}
 	}
+	return f
 }
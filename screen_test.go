@@ -21,7 +21,7 @@ func TestAverageColor_Uniform(t *testing.T) {
 }
 
 func TestAverageColor_BlackAndWhite(t *testing.T) {
-	// 2x1 image: one black pixel, one white pixel.
+	// 2x1 image: one black pixel, one white pixel → 50% linear light.
 	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
 	img.Pix[0] = 0
 	img.Pix[1] = 0
@@ -33,8 +33,8 @@ func TestAverageColor_BlackAndWhite(t *testing.T) {
 	img.Pix[7] = 255
 
 	got := AverageColor(img)
-	if got.R != 127 || got.G != 127 || got.B != 127 {
-		t.Errorf("expected RGB{127, 127, 127}, got RGB{%d, %d, %d}", got.R, got.G, got.B)
+	if got.R != 188 || got.G != 188 || got.B != 188 {
+		t.Errorf("expected RGB{188, 188, 188}, got RGB{%d, %d, %d}", got.R, got.G, got.B)
 	}
 }
 
@@ -45,3 +45,27 @@ func TestAverageColor_Empty(t *testing.T) {
 		t.Errorf("expected RGB{0, 0, 0}, got RGB{%d, %d, %d}", got.R, got.G, got.B)
 	}
 }
+
+func TestDownscaleRGBA_Quadrants(t *testing.T) {
+	// 4x2 image: left half white, right half black → 2x1 frame.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			off := y*img.Stride + x*4
+			img.Pix[off] = 255
+			img.Pix[off+1] = 255
+			img.Pix[off+2] = 255
+		}
+	}
+
+	f := downscaleRGBA(img, 2, 1)
+	if f.Width != 2 || f.Height != 1 || len(f.Pix) != 6 {
+		t.Fatalf("unexpected frame dimensions %dx%d (%d bytes)", f.Width, f.Height, len(f.Pix))
+	}
+	if f.Pix[0] != 255 || f.Pix[1] != 255 || f.Pix[2] != 255 {
+		t.Errorf("expected left pixel white, got %v", f.Pix[0:3])
+	}
+	if f.Pix[3] != 0 || f.Pix[4] != 0 || f.Pix[5] != 0 {
+		t.Errorf("expected right pixel black, got %v", f.Pix[3:6])
+	}
+}
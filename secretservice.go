@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretsDest           = "org.freedesktop.secrets"
+	secretsPath           = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretServiceIface    = "org.freedesktop.Secret.Service"
+	secretCollectionIface = "org.freedesktop.Secret.Collection"
+	secretItemIface       = "org.freedesktop.Secret.Item"
+	secretSessionIface    = "org.freedesktop.Secret.Session"
+	secretPromptIface     = "org.freedesktop.Secret.Prompt"
+
+	// noPrompt is the object path returned when an operation needs no
+	// prompt; the empty path "/" also stands for no object.
+	noPrompt = dbus.ObjectPath("/")
+)
+
+// promptTimeout bounds the wait for the user to answer the keyring's
+// unlock prompt.
+const promptTimeout = 2 * time.Minute
+
+// errKeyringLocked is returned when the keyring is locked and the user
+// dismissed the prompt to unlock it, or did not answer it.
+var errKeyringLocked = errors.New("the keyring is locked; unlock it and try again")
+
+// secretServiceBus is a connection to the bus the Secret Service is on.
+type secretServiceBus interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	AddMatchSignal(options ...dbus.MatchOption) error
+	RemoveMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+	RemoveSignal(ch chan<- *dbus.Signal)
+	Close() error
+}
+
+// connectSecretService connects to the session bus without launching one,
+// so that machines without a desktop session fall back to the credentials
+// file quickly. It is a variable so that tests can substitute a fake Secret
+// Service.
+var connectSecretService = func() (secretServiceBus, error) {
+	conn, err := dbus.SessionBusPrivateNoAutoStartup()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// secret is the Secret struct of the Secret Service API.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// secretServiceStore keeps credentials in the desktop keyring, such as GNOME
+// Keyring or KWallet, through the freedesktop Secret Service API. Each
+// bridge's credentials are one item of the default collection, found by
+// the attributes application=huesync and bridge=<bridge ID>.
+type secretServiceStore struct {
+	bus        secretServiceBus
+	session    dbus.ObjectPath
+	collection dbus.ObjectPath
+}
+
+// openSecretService opens a session with the Secret Service. Secrets are
+// exchanged unencrypted ("plain"), as the session bus is private to the
+// user.
+func openSecretService() (*secretServiceStore, error) {
+	bus, err := connectSecretService()
+	if err != nil {
+		return nil, err
+	}
+	s := &secretServiceStore{bus: bus}
+
+	var output dbus.Variant
+	if err := s.service().Call(secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &s.session); err != nil {
+		bus.Close()
+		return nil, fmt.Errorf("opening a Secret Service session: %w", err)
+	}
+	if err := s.service().Call(secretServiceIface+".ReadAlias", 0, "default").Store(&s.collection); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("finding the default keyring: %w", err)
+	}
+	if s.collection == noPrompt {
+		s.Close()
+		return nil, errors.New("there is no default keyring")
+	}
+	return s, nil
+}
+
+func (s *secretServiceStore) service() dbus.BusObject {
+	return s.bus.Object(secretsDest, secretsPath)
+}
+
+func (s *secretServiceStore) object(path dbus.ObjectPath) dbus.BusObject {
+	return s.bus.Object(secretsDest, path)
+}
+
+func credentialAttributes(bridgeID string) map[string]string {
+	attrs := map[string]string{"application": "huesync"}
+	if bridgeID != "" {
+		attrs["bridge"] = bridgeID
+	}
+	return attrs
+}
+
+// prompt shows the prompt at path, such as the keyring's password dialog,
+// and waits for the user to answer it, as libsecret does. It returns the
+// result of the operation that needed the prompt, or errKeyringLocked if the
+// prompt was dismissed or not answered within promptTimeout.
+func (s *secretServiceStore) prompt(path dbus.ObjectPath) (dbus.Variant, error) {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(secretPromptIface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := s.bus.AddMatchSignal(match...); err != nil {
+		return dbus.Variant{}, fmt.Errorf("waiting for the keyring prompt: %w", err)
+	}
+	defer s.bus.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 1)
+	s.bus.Signal(signals)
+	defer s.bus.RemoveSignal(signals)
+
+	// The window ID is empty: huesync has no window to put the prompt on.
+	if err := s.object(path).Call(secretPromptIface+".Prompt", 0, "").Err; err != nil {
+		return dbus.Variant{}, fmt.Errorf("showing the keyring prompt: %w", err)
+	}
+	timeout := time.NewTimer(promptTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return dbus.Variant{}, errors.New("the session bus closed while the keyring prompt was shown")
+			}
+			if sig.Path != path || sig.Name != secretPromptIface+".Completed" || len(sig.Body) != 2 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return dbus.Variant{}, errKeyringLocked
+			}
+			result, _ := sig.Body[1].(dbus.Variant)
+			return result, nil
+		case <-timeout.C:
+			s.object(path).Call(secretPromptIface+".Dismiss", 0)
+			return dbus.Variant{}, errKeyringLocked
+		}
+	}
+}
+
+// items returns the items matching attrs, unlocking those that are locked.
+func (s *secretServiceStore) items(attrs map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := s.service().Call(secretServiceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("searching the keyring: %w", err)
+	}
+	if len(locked) == 0 {
+		return unlocked, nil
+	}
+	var more []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := s.service().Call(secretServiceIface+".Unlock", 0, locked).Store(&more, &prompt); err != nil {
+		return nil, fmt.Errorf("unlocking the keyring: %w", err)
+	}
+	if prompt != noPrompt {
+		result, err := s.prompt(prompt)
+		if err != nil {
+			return nil, err
+		}
+		// The result lists the objects unlocked.
+		if err := result.Store(&more); err != nil {
+			return nil, fmt.Errorf("unlocking the keyring: %w", err)
+		}
+	}
+	return append(unlocked, more...), nil
+}
+
+func (s *secretServiceStore) credentials(item dbus.ObjectPath) (BridgeCredentials, error) {
+	var sec secret
+	if err := s.object(item).Call(secretItemIface+".GetSecret", 0, s.session).Store(&sec); err != nil {
+		return BridgeCredentials{}, fmt.Errorf("reading the keyring: %w", err)
+	}
+	var creds BridgeCredentials
+	if err := json.Unmarshal(sec.Value, &creds); err != nil {
+		return BridgeCredentials{}, fmt.Errorf("decoding the keyring item: %w", err)
+	}
+	return creds, nil
+}
+
+func (s *secretServiceStore) Load(bridgeID string) (BridgeCredentials, bool, error) {
+	items, err := s.items(credentialAttributes(bridgeID))
+	if err != nil || len(items) == 0 {
+		return BridgeCredentials{}, false, err
+	}
+	creds, err := s.credentials(items[0])
+	if err != nil {
+		return BridgeCredentials{}, false, err
+	}
+	return creds, true, nil
+}
+
+func (s *secretServiceStore) Save(bridgeID string, creds BridgeCredentials) error {
+	value, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	props := map[string]dbus.Variant{
+		secretItemIface + ".Label":      dbus.MakeVariant("Hue bridge " + bridgeID + " (huesync)"),
+		secretItemIface + ".Attributes": dbus.MakeVariant(credentialAttributes(bridgeID)),
+	}
+	sec := secret{Session: s.session, Parameters: []byte{}, Value: value, ContentType: "application/json"}
+
+	// An item with the same attributes is replaced.
+	var item, prompt dbus.ObjectPath
+	if err := s.object(s.collection).Call(secretCollectionIface+".CreateItem", 0, props, sec, true).Store(&item, &prompt); err != nil {
+		return fmt.Errorf("writing to the keyring: %w", err)
+	}
+	if prompt != noPrompt {
+		// The collection is locked; the item is created once it is
+		// unlocked.
+		if _, err := s.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *secretServiceStore) Delete(bridgeID string) error {
+	items, err := s.items(credentialAttributes(bridgeID))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := s.object(item).Call(secretItemIface+".Delete", 0).Store(&prompt); err != nil {
+			return fmt.Errorf("deleting from the keyring: %w", err)
+		}
+		if prompt != noPrompt {
+			if _, err := s.prompt(prompt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *secretServiceStore) All() (map[string]BridgeCredentials, error) {
+	items, err := s.items(credentialAttributes(""))
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]BridgeCredentials, len(items))
+	for _, item := range items {
+		var attrs map[string]string
+		if err := s.object(item).StoreProperty(secretItemIface+".Attributes", &attrs); err != nil {
+			return nil, fmt.Errorf("reading the keyring: %w", err)
+		}
+		if attrs["bridge"] == "" {
+			continue
+		}
+		creds, err := s.credentials(item)
+		if err != nil {
+			return nil, err
+		}
+		all[attrs["bridge"]] = creds
+	}
+	return all, nil
+}
+
+// Close ends the session and disconnects.
+func (s *secretServiceStore) Close() error {
+	s.object(s.session).Call(secretSessionIface+".Close", 0)
+	return s.bus.Close()
+}
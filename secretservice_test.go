@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeSecretService implements the parts of the Secret Service API that
+// secretServiceStore uses, with one default collection.
+type fakeSecretService struct {
+	mu       sync.Mutex
+	items    map[dbus.ObjectPath]*fakeSecretItem
+	sessions map[dbus.ObjectPath]bool
+	nextID   int
+	// locked makes the collection locked; unlocking it needs a prompt,
+	// which the user dismisses if dismiss is set and otherwise answers.
+	locked, dismiss bool
+	// prompts holds what each prompt not yet shown does once answered,
+	// and prompted counts the prompts shown.
+	prompts  map[dbus.ObjectPath]func() dbus.Variant
+	prompted int
+	// signals are the channels signals are delivered to, and matches counts
+	// the match rules added.
+	signals []chan<- *dbus.Signal
+	matches int
+	// connections counts the open connections.
+	connections int
+}
+
+type fakeSecretItem struct {
+	label  string
+	attrs  map[string]string
+	secret secret
+}
+
+const fakeCollection = dbus.ObjectPath("/org/freedesktop/secrets/collection/login")
+
+func newFakeSecretService() *fakeSecretService {
+	return &fakeSecretService{
+		items:    make(map[dbus.ObjectPath]*fakeSecretItem),
+		sessions: make(map[dbus.ObjectPath]bool),
+		prompts:  make(map[dbus.ObjectPath]func() dbus.Variant),
+	}
+}
+
+// useFakeKeyring makes huesync use s as the desktop keyring.
+func useFakeKeyring(t *testing.T, s *fakeSecretService) {
+	t.Helper()
+	connect := connectSecretService
+	t.Cleanup(func() { connectSecretService = connect })
+	connectSecretService = func() (secretServiceBus, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.connections++
+		return fakeSecretBus{s}, nil
+	}
+}
+
+type fakeSecretBus struct {
+	s *fakeSecretService
+}
+
+func (b fakeSecretBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &fakeSecretObject{s: b.s, path: path}
+}
+
+func (b fakeSecretBus) AddMatchSignal(options ...dbus.MatchOption) error {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	b.s.matches++
+	return nil
+}
+
+func (b fakeSecretBus) RemoveMatchSignal(options ...dbus.MatchOption) error {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	b.s.matches--
+	return nil
+}
+
+func (b fakeSecretBus) Signal(ch chan<- *dbus.Signal) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	b.s.signals = append(b.s.signals, ch)
+}
+
+func (b fakeSecretBus) RemoveSignal(ch chan<- *dbus.Signal) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	b.s.signals = slices.DeleteFunc(b.s.signals, func(c chan<- *dbus.Signal) bool { return c == ch })
+}
+
+func (b fakeSecretBus) Close() error {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	b.s.connections--
+	return nil
+}
+
+// fakeSecretObject is an object of the fake service. The methods of
+// dbus.BusObject that huesync does not use are left unimplemented.
+type fakeSecretObject struct {
+	dbus.BusObject
+	s    *fakeSecretService
+	path dbus.ObjectPath
+}
+
+func (o *fakeSecretObject) Path() dbus.ObjectPath { return o.path }
+
+func (o *fakeSecretObject) Call(method string, flags dbus.Flags, args ...any) *dbus.Call {
+	body, err := o.s.call(o.path, method, args)
+	return &dbus.Call{Method: method, Args: args, Body: body, Err: err}
+}
+
+func (o *fakeSecretObject) GetProperty(p string) (dbus.Variant, error) {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	item, ok := o.s.items[o.path]
+	if !ok || p != secretItemIface+".Attributes" {
+		return dbus.Variant{}, fmt.Errorf("no property %s on %s", p, o.path)
+	}
+	return dbus.MakeVariant(item.attrs), nil
+}
+
+func (o *fakeSecretObject) StoreProperty(p string, value any) error {
+	v, err := o.GetProperty(p)
+	if err != nil {
+		return err
+	}
+	return dbus.Store([]any{v.Value()}, value)
+}
+
+func (s *fakeSecretService) call(path dbus.ObjectPath, method string, args []any) ([]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case path == secretsPath && method == secretServiceIface+".OpenSession":
+		if args[0] != "plain" {
+			return nil, errors.New("org.freedesktop.DBus.Error.NotSupported")
+		}
+		s.nextID++
+		session := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/session/s%d", s.nextID))
+		s.sessions[session] = true
+		return []any{dbus.MakeVariant(""), session}, nil
+
+	case path == secretsPath && method == secretServiceIface+".ReadAlias":
+		if args[0] != "default" {
+			return []any{noPrompt}, nil
+		}
+		return []any{fakeCollection}, nil
+
+	case path == secretsPath && method == secretServiceIface+".SearchItems":
+		attrs := args[0].(map[string]string)
+		var found []dbus.ObjectPath
+		for p, item := range s.items {
+			if matchAttributes(item.attrs, attrs) {
+				found = append(found, p)
+			}
+		}
+		slices.Sort(found)
+		if s.locked {
+			return []any{[]dbus.ObjectPath{}, found}, nil
+		}
+		return []any{found, []dbus.ObjectPath{}}, nil
+
+	case path == secretsPath && method == secretServiceIface+".Unlock":
+		if s.locked {
+			objects := args[0].([]dbus.ObjectPath)
+			return []any{[]dbus.ObjectPath{}, s.newPrompt(func() dbus.Variant {
+				s.locked = false
+				return dbus.MakeVariant(objects)
+			})}, nil
+		}
+		return []any{args[0], noPrompt}, nil
+
+	case path == fakeCollection && method == secretCollectionIface+".CreateItem":
+		props := args[0].(map[string]dbus.Variant)
+		sec := args[1].(secret)
+		if !s.sessions[sec.Session] {
+			return nil, errors.New("org.freedesktop.Secret.Error.NoSession")
+		}
+		item := &fakeSecretItem{
+			label:  props[secretItemIface+".Label"].Value().(string),
+			attrs:  props[secretItemIface+".Attributes"].Value().(map[string]string),
+			secret: sec,
+		}
+		create := func() dbus.ObjectPath {
+			if args[2].(bool) {
+				for p, old := range s.items {
+					if maps.Equal(old.attrs, item.attrs) {
+						s.items[p] = item
+						return p
+					}
+				}
+			}
+			s.nextID++
+			p := dbus.ObjectPath(fmt.Sprintf("%s/i%d", fakeCollection, s.nextID))
+			s.items[p] = item
+			return p
+		}
+		if s.locked {
+			return []any{noPrompt, s.newPrompt(func() dbus.Variant {
+				s.locked = false
+				return dbus.MakeVariant(create())
+			})}, nil
+		}
+		return []any{create(), noPrompt}, nil
+
+	case method == secretPromptIface+".Prompt":
+		answer, ok := s.prompts[path]
+		if !ok {
+			return nil, errors.New("org.freedesktop.Secret.Error.NoSuchObject")
+		}
+		delete(s.prompts, path)
+		s.prompted++
+		completed := &dbus.Signal{Path: path, Name: secretPromptIface + ".Completed", Body: []any{true, dbus.MakeVariant("")}}
+		if !s.dismiss {
+			completed.Body = []any{false, answer()}
+		}
+		// The signal arrives after the reply, as on the bus.
+		for _, ch := range s.signals {
+			go func() { ch <- completed }()
+		}
+		return nil, nil
+
+	case method == secretItemIface+".GetSecret":
+		item, ok := s.items[path]
+		if !ok {
+			return nil, errors.New("org.freedesktop.Secret.Error.NoSuchObject")
+		}
+		session := args[0].(dbus.ObjectPath)
+		if !s.sessions[session] {
+			return nil, errors.New("org.freedesktop.Secret.Error.NoSession")
+		}
+		sec := item.secret
+		sec.Session = session
+		return []any{sec}, nil
+
+	case method == secretItemIface+".Delete":
+		if _, ok := s.items[path]; !ok {
+			return nil, errors.New("org.freedesktop.Secret.Error.NoSuchObject")
+		}
+		delete(s.items, path)
+		return []any{noPrompt}, nil
+
+	case method == secretSessionIface+".Close":
+		delete(s.sessions, path)
+		return nil, nil
+	}
+	return nil, fmt.Errorf("org.freedesktop.DBus.Error.UnknownMethod: %s on %s", method, path)
+}
+
+// newPrompt returns the path of a prompt that runs answer once the user
+// answers it. s.mu must be held.
+func (s *fakeSecretService) newPrompt(answer func() dbus.Variant) dbus.ObjectPath {
+	s.nextID++
+	p := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/prompt/p%d", s.nextID))
+	s.prompts[p] = answer
+	return p
+}
+
+func matchAttributes(attrs, query map[string]string) bool {
+	for k, v := range query {
+		if attrs[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSecretServiceStore(t *testing.T) {
+	setupCredentialsDir(t)
+	s := newFakeSecretService()
+	useFakeKeyring(t, s)
+
+	if _, found, err := LoadCredentials("bridge-1"); err != nil || found {
+		t.Fatalf("expected no credentials, got %v, %v", found, err)
+	}
+	c1 := BridgeCredentials{Username: "user1", Clientkey: "key1"}
+	c2 := BridgeCredentials{Username: "user2", Clientkey: "key2"}
+	for id, c := range map[string]BridgeCredentials{"bridge-1": c1, "bridge-2": {Username: "old"}} {
+		if err := SaveCredentials(id, c); err != nil {
+			t.Fatalf("SaveCredentials: %v", err)
+		}
+	}
+	// Saving again replaces the item.
+	if err := SaveCredentials("bridge-2", c2); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if len(s.items) != 2 {
+		t.Fatalf("expected two keyring items, got %d", len(s.items))
+	}
+	for _, item := range s.items {
+		if item.attrs["application"] != "huesync" || item.secret.ContentType != "application/json" || item.label != "Hue bridge "+item.attrs["bridge"]+" (huesync)" {
+			t.Errorf("unexpected item %+v", item)
+		}
+	}
+
+	if got, found, err := LoadCredentials("bridge-2"); err != nil || !found || got != c2 {
+		t.Errorf("LoadCredentials: got %+v, %v, %v", got, found, err)
+	}
+	store, warning, err := openCredentialStore()
+	if err != nil || warning != nil {
+		t.Fatalf("openCredentialStore: %v, %v", warning, err)
+	}
+	all, err := store.All()
+	store.Close()
+	if err != nil || len(all) != 2 || all["bridge-1"] != c1 || all["bridge-2"] != c2 {
+		t.Errorf("All: got %+v, %v", all, err)
+	}
+
+	if err := DeleteCredentials("bridge-1"); err != nil {
+		t.Fatalf("DeleteCredentials: %v", err)
+	}
+	if _, found, _ := LoadCredentials("bridge-1"); found {
+		t.Error("expected the credentials to be deleted")
+	}
+	if _, found, _ := LoadCredentials("bridge-2"); !found {
+		t.Error("expected the other bridge's credentials to stay")
+	}
+
+	// Nothing is written to disk, and every session and connection is
+	// closed again.
+	if path, _ := credentialsPath(); fileExists(path) {
+		t.Error("expected no credentials file")
+	}
+	if len(s.sessions) != 0 || s.connections != 0 {
+		t.Errorf("expected no open sessions and connections, got %d and %d", len(s.sessions), s.connections)
+	}
+}
+
+func TestSecretServiceStore_Migration(t *testing.T) {
+	setupCredentialsDir(t)
+	c1 := BridgeCredentials{Username: "user1", Clientkey: "key1"}
+	c2 := BridgeCredentials{Username: "user2", Clientkey: "key2"}
+	for id, c := range map[string]BridgeCredentials{"bridge-1": c1, "bridge-2": c2} {
+		if err := SaveCredentials(id, c); err != nil {
+			t.Fatalf("SaveCredentials: %v", err)
+		}
+	}
+	path, _ := credentialsPath()
+
+	// While the keyring is locked and its prompt dismissed, the file stays
+	// in use.
+	s := newFakeSecretService()
+	s.locked, s.dismiss = true, true
+	useFakeKeyring(t, s)
+	if got, found, err := LoadCredentials("bridge-1"); err != nil || !found || got != c1 {
+		t.Fatalf("LoadCredentials: got %+v, %v, %v", got, found, err)
+	}
+	if !fileExists(path) || len(s.items) != 0 {
+		t.Fatal("expected the credentials to stay in the file")
+	}
+	if err := credentialStoreWarning(); err == nil || !strings.Contains(err.Error(), "could not be moved") || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected a warning that the file stays in use, got %v", err)
+	}
+
+	// Once the prompt is answered, the credentials move into the keyring.
+	s.dismiss = false
+	if got, found, err := LoadCredentials("bridge-2"); err != nil || !found || got != c2 {
+		t.Fatalf("LoadCredentials: got %+v, %v, %v", got, found, err)
+	}
+	if fileExists(path) {
+		t.Error("expected the credentials file to be removed")
+	}
+	if len(s.items) != 2 {
+		t.Errorf("expected both bridges in the keyring, got %d items", len(s.items))
+	}
+	if err := credentialStoreWarning(); err != nil {
+		t.Errorf("expected no warning with the keyring in use, got %v", err)
+	}
+
+	// A damaged file cannot be moved, but is put aside.
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *corruptFileError
+	if err := credentialStoreWarning(); !errors.As(err, &corrupt) || !fileExists(corrupt.backup) {
+		t.Errorf("expected a warning about the damaged file, got %v", err)
+	}
+	if err := credentialStoreWarning(); err != nil {
+		t.Errorf("expected the damaged file reported once, got %v", err)
+	}
+}
+
+func TestSecretServiceStore_Locked(t *testing.T) {
+	setupCredentialsDir(t)
+	s := newFakeSecretService()
+	useFakeKeyring(t, s)
+	if err := SaveCredentials("bridge-1", BridgeCredentials{Username: "u", Clientkey: "k"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	// The user dismisses the prompt to unlock the keyring.
+	s.locked, s.dismiss = true, true
+	if _, _, err := LoadCredentials("bridge-1"); !errors.Is(err, errKeyringLocked) {
+		t.Errorf("expected errKeyringLocked, got %v", err)
+	}
+	if err := SaveCredentials("bridge-2", BridgeCredentials{}); !errors.Is(err, errKeyringLocked) {
+		t.Errorf("expected errKeyringLocked, got %v", err)
+	}
+	if path, _ := credentialsPath(); fileExists(path) {
+		t.Error("expected no credentials to be written to the file")
+	}
+	if s.prompted != 2 {
+		t.Errorf("expected two prompts, got %d", s.prompted)
+	}
+
+	// Answering the prompt unlocks the keyring, as after logging in with
+	// auto-login.
+	s.dismiss = false
+	if got, found, err := LoadCredentials("bridge-1"); err != nil || !found || got.Username != "u" {
+		t.Errorf("LoadCredentials: got %+v, %v, %v", got, found, err)
+	}
+	s.locked = true
+	if err := SaveCredentials("bridge-2", BridgeCredentials{Username: "u2"}); err != nil {
+		t.Errorf("SaveCredentials: %v", err)
+	}
+	if got, found, err := LoadCredentials("bridge-2"); err != nil || !found || got.Username != "u2" {
+		t.Errorf("LoadCredentials: got %+v, %v, %v", got, found, err)
+	}
+	if s.prompted != 4 {
+		t.Errorf("expected two more prompts, got %d in all", s.prompted)
+	}
+	if s.matches != 0 || len(s.signals) != 0 {
+		t.Errorf("expected the signal subscriptions removed, got %d match rules and %d channels", s.matches, len(s.signals))
+	}
+}
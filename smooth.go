@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// smoother applies exponential smoothing to per-channel colors. The response
+// is defined by a time constant rather than a per-frame factor, so it behaves
+// the same whatever the capture delay.
+type smoother struct {
+	state [][3]float64
+	last  time.Time
+}
+
+// Apply blends colors into the smoothed state and returns the result. With
+// timeConstant ≤ 0 colors pass through unchanged. If the mean distance between
+// the new colors and the current state exceeds snapThreshold (in RGB units,
+// 0 disables) the change is treated as a scene cut and applied instantly.
+func (s *smoother) Apply(colors []RGB, now time.Time, timeConstant time.Duration, snapThreshold float64) []RGB {
+	dt := now.Sub(s.last)
+	s.last = now
+
+	if len(s.state) != len(colors) || timeConstant <= 0 || dt <= 0 {
+		s.reset(colors)
+		return colors
+	}
+
+	if snapThreshold > 0 {
+		var dist float64
+		for i, c := range colors {
+			dist += math.Sqrt(sqDist(s.state[i], rgbToFloat(c)))
+		}
+		if dist/float64(len(colors)) > snapThreshold {
+			s.reset(colors)
+			return colors
+		}
+	}
+
+	alpha := 1 - math.Exp(-dt.Seconds()/timeConstant.Seconds())
+	out := make([]RGB, len(colors))
+	for i, c := range colors {
+		target := rgbToFloat(c)
+		for j := range target {
+			s.state[i][j] += alpha * (target[j] - s.state[i][j])
+		}
+		out[i] = floatToRGB(s.state[i])
+	}
+	return out
+}
+
+func (s *smoother) reset(colors []RGB) {
+	s.state = make([][3]float64, len(colors))
+	for i, c := range colors {
+		s.state[i] = rgbToFloat(c)
+	}
+}
+
+func rgbToFloat(c RGB) [3]float64 {
+	return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+}
+
+func floatToRGB(v [3]float64) RGB {
+	return RGB{
+		R: uint8(min(max(v[0], 0), 255) + 0.5),
+		G: uint8(min(max(v[1], 0), 255) + 0.5),
+		B: uint8(min(max(v[2], 0), 255) + 0.5),
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmoother_FirstFramePassesThrough(t *testing.T) {
+	var s smoother
+	got := s.Apply([]RGB{{R: 200}}, time.Unix(0, 0), 200*time.Millisecond, 0)
+	if got[0] != (RGB{R: 200}) {
+		t.Errorf("expected RGB{200, 0, 0}, got %v", got[0])
+	}
+}
+
+func TestSmoother_OneTimeConstant(t *testing.T) {
+	var s smoother
+	t0 := time.Unix(0, 0)
+	tau := 100 * time.Millisecond
+	s.Apply([]RGB{{}}, t0, tau, 0)
+
+	// After one time constant the output covers 1 - 1/e ≈ 63% of the step.
+	got := s.Apply([]RGB{{R: 200, G: 200, B: 200}}, t0.Add(tau), tau, 0)
+	if got[0].R != 126 {
+		t.Errorf("expected R=126 after one time constant, got %v", got[0])
+	}
+}
+
+func TestSmoother_IndependentOfFrameRate(t *testing.T) {
+	tau := 200 * time.Millisecond
+	t0 := time.Unix(0, 0)
+	target := []RGB{{R: 255}}
+
+	var slow smoother
+	slow.Apply([]RGB{{}}, t0, tau, 0)
+	slowOut := slow.Apply(target, t0.Add(100*time.Millisecond), tau, 0)
+
+	var fast smoother
+	fast.Apply([]RGB{{}}, t0, tau, 0)
+	var fastOut []RGB
+	for i := 1; i <= 10; i++ {
+		fastOut = fast.Apply(target, t0.Add(time.Duration(i)*10*time.Millisecond), tau, 0)
+	}
+
+	diff := int(slowOut[0].R) - int(fastOut[0].R)
+	if diff < -1 || diff > 1 {
+		t.Errorf("expected same response at 10 and 100 fps, got %v and %v", slowOut[0], fastOut[0])
+	}
+}
+
+func TestSmoother_SnapsOnSceneCut(t *testing.T) {
+	var s smoother
+	t0 := time.Unix(0, 0)
+	tau := time.Second
+	s.Apply([]RGB{{}, {}}, t0, tau, 100)
+
+	got := s.Apply([]RGB{{R: 255, G: 255, B: 255}, {R: 255}}, t0.Add(10*time.Millisecond), tau, 100)
+	if got[0] != (RGB{R: 255, G: 255, B: 255}) || got[1] != (RGB{R: 255}) {
+		t.Errorf("expected instant change on scene cut, got %v", got)
+	}
+}
+
+func TestSmoother_SmallChangeDoesNotSnap(t *testing.T) {
+	var s smoother
+	t0 := time.Unix(0, 0)
+	tau := time.Second
+	s.Apply([]RGB{{R: 100}}, t0, tau, 100)
+
+	got := s.Apply([]RGB{{R: 150}}, t0.Add(100*time.Millisecond), tau, 100)
+	if got[0].R >= 150 || got[0].R <= 100 {
+		t.Errorf("expected a smoothed step between 100 and 150, got %v", got[0])
+	}
+}
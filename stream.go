@@ -59,12 +59,49 @@ func DeactivateArea(ip net.IP, username, areaID string) error {
 	return nil
 }
 
+// ColorSpace is the color encoding of a HueStream message.
+type ColorSpace uint8
+
+const (
+	// ColorSpaceRGB sends 16-bit RGB and leaves gamut mapping to the bridge.
+	ColorSpaceRGB ColorSpace = 0x00
+	// ColorSpaceXY sends CIE xy plus brightness, already clamped to each
+	// light's gamut.
+	ColorSpaceXY ColorSpace = 0x01
+)
+
+func (cs ColorSpace) String() string {
+	switch cs {
+	case ColorSpaceRGB:
+		return "rgb"
+	case ColorSpaceXY:
+		return "xy"
+	}
+	return fmt.Sprintf("ColorSpace(%d)", uint8(cs))
+}
+
+// parseColorSpace parses a color space name.
+func parseColorSpace(s string) (ColorSpace, error) {
+	switch s {
+	case "rgb":
+		return ColorSpaceRGB, nil
+	case "xy":
+		return ColorSpaceXY, nil
+	}
+	return 0, fmt.Errorf("unknown color space %q (want rgb, xy)", s)
+}
+
 // Streamer sends color data to the Hue bridge over DTLS.
 type Streamer struct {
 	conn       net.Conn
 	areaID     string
 	channelIDs []uint8
 	seq        uint8
+
+	colorSpace ColorSpace
+	gamuts     []Gamut
+
+	recorder *Recorder
 }
 
 // NewStreamer establishes a DTLS connection to the Hue bridge for entertainment streaming.
@@ -74,7 +111,7 @@ func NewStreamer(ip net.IP, username, clientkey, areaID string, channelIDs []uin
 		return nil, fmt.Errorf("decoding clientkey: %w", err)
 	}
 
-	addr := &net.UDPAddr{IP: ip, Port: 2100}
+	addr := &net.UDPAddr{IP: ip, Port: bridgeStreamPort}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -83,8 +120,8 @@ func NewStreamer(ip net.IP, username, clientkey, areaID string, channelIDs []uin
 		PSK: func(hint []byte) ([]byte, error) {
 			return psk, nil
 		},
-		PSKIdentityHint: []byte(username),
-		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
+		PSKIdentityHint:    []byte(username),
+		CipherSuites:       []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
 		InsecureSkipVerify: true,
 	})
 	if err != nil {
@@ -98,26 +135,141 @@ func NewStreamer(ip net.IP, username, clientkey, areaID string, channelIDs []uin
 	}, nil
 }
 
-// SendColor sends the given color to all channels.
-func (s *Streamer) SendColor(c RGB) error {
-	msg := BuildHueStreamMessage(s.areaID, s.channelIDs, c, s.seq)
+// SendFrame sends one color per channel, in the order of the streamer's
+// channel IDs.
+func (s *Streamer) SendFrame(colors []RGB) error {
+	if len(colors) != len(s.channelIDs) {
+		return fmt.Errorf("got %d colors for %d channels", len(colors), len(s.channelIDs))
+	}
+	var msg []byte
+	if s.colorSpace == ColorSpaceXY {
+		msg = BuildHueStreamXYMessage(s.areaID, s.channelIDs, colors, s.gamuts, s.seq)
+	} else {
+		msg = BuildHueStreamMessage(s.areaID, s.channelIDs, colors, s.seq)
+	}
 	s.seq++
-	_, err := s.conn.Write(msg)
-	if err != nil {
+	return s.sendMessage(msg)
+}
+
+// sendMessage writes a HueStream message and records it if recording.
+func (s *Streamer) sendMessage(msg []byte) error {
+	if _, err := s.conn.Write(msg); err != nil {
 		return fmt.Errorf("writing to DTLS: %w", err)
 	}
+	if s.recorder != nil {
+		// Write errors are kept by the recorder and reported by its Close.
+		_ = s.recorder.Record(time.Now(), msg)
+	}
 	return nil
 }
 
+// SetRecorder records the messages sent from now on to r, or stops
+// recording if r is nil.
+func (s *Streamer) SetRecorder(r *Recorder) {
+	s.recorder = r
+}
+
+// SetColorSpace selects how SendFrame encodes colors. In ColorSpaceXY each
+// channel's color is clamped to gamuts[i]; a missing or zero gamut leaves the
+// coordinates to the bridge.
+func (s *Streamer) SetColorSpace(cs ColorSpace, gamuts []Gamut) {
+	s.colorSpace = cs
+	s.gamuts = gamuts
+}
+
 // Close closes the DTLS connection.
 func (s *Streamer) Close() error {
 	return s.conn.Close()
 }
 
-// BuildHueStreamMessage constructs a HueStream v2 binary message.
-func BuildHueStreamMessage(areaID string, channelIDs []uint8, c RGB, seq uint8) []byte {
+// BuildHueStreamMessage constructs a HueStream v2 binary message. colors[i] is
+// the color for channelIDs[i].
+func BuildHueStreamMessage(areaID string, channelIDs []uint8, colors []RGB, seq uint8) []byte {
+	msg := hueStreamHeader(areaID, ColorSpaceRGB, len(channelIDs), seq)
+
+	// Per-channel data (7 bytes each)
+	offset := 52
+	for i, ch := range channelIDs {
+		// 8-bit to 16-bit color conversion
+		c := colors[i]
+		putChannel(msg[offset:], ch, uint16(c.R)*257, uint16(c.G)*257, uint16(c.B)*257)
+		offset += 7
+	}
+
+	return msg
+}
+
+// BuildHueStreamXYMessage constructs a HueStream v2 binary message in the
+// xy+brightness color space. colors[i] is converted to CIE xy, clamped to
+// gamuts[i] when known, and sent to channelIDs[i].
+func BuildHueStreamXYMessage(areaID string, channelIDs []uint8, colors []RGB, gamuts []Gamut, seq uint8) []byte {
+	msg := hueStreamHeader(areaID, ColorSpaceXY, len(channelIDs), seq)
+
+	// Per-channel data (7 bytes each)
+	offset := 52
+	for i, ch := range channelIDs {
+		xy, bri := rgbToXYBrightness(colors[i])
+		if i < len(gamuts) {
+			xy = gamuts[i].Clamp(xy)
+		}
+		putChannel(msg[offset:], ch, unitToUint16(xy.X), unitToUint16(xy.Y), unitToUint16(bri))
+		offset += 7
+	}
+
+	return msg
+}
+
+// HueStreamFrame is a decoded HueStream v2 message.
+type HueStreamFrame struct {
+	Seq        uint8
+	ColorSpace ColorSpace
+	AreaID     string
+	Channels   []HueStreamChannel
+}
+
+// HueStreamChannel is one channel entry of a HueStream message. Values are
+// R, G, B or x, y, brightness, depending on the color space.
+type HueStreamChannel struct {
+	ID     uint8
+	Values [3]uint16
+}
+
+// ParseHueStreamMessage decodes a message built by BuildHueStreamMessage or
+// BuildHueStreamXYMessage.
+func ParseHueStreamMessage(msg []byte) (HueStreamFrame, error) {
+	if len(msg) < 52 || string(msg[0:9]) != "HueStream" {
+		return HueStreamFrame{}, fmt.Errorf("not a HueStream message")
+	}
+	if msg[9] != 0x02 {
+		return HueStreamFrame{}, fmt.Errorf("unsupported HueStream version %d.%d", msg[9], msg[10])
+	}
+	if (len(msg)-52)%7 != 0 {
+		return HueStreamFrame{}, fmt.Errorf("truncated HueStream message (%d bytes)", len(msg))
+	}
+
+	f := HueStreamFrame{
+		Seq:        msg[11],
+		ColorSpace: ColorSpace(msg[14]),
+		AreaID:     strings.TrimRight(string(msg[16:52]), "\x00"),
+	}
+	for b := msg[52:]; len(b) > 0; b = b[7:] {
+		f.Channels = append(f.Channels, HueStreamChannel{
+			ID: b[0],
+			Values: [3]uint16{
+				uint16(b[1])<<8 | uint16(b[2]),
+				uint16(b[3])<<8 | uint16(b[4]),
+				uint16(b[5])<<8 | uint16(b[6]),
+			},
+		})
+	}
+	return f, nil
+}
+
+// hueStreamHeader allocates a message for n channels and fills in the
+// 52-byte header.
+func hueStreamHeader(areaID string, cs ColorSpace, n int, seq uint8) []byte {
 	// Header: 52 bytes + 7 bytes per channel
-	msg := make([]byte, 52+7*len(channelIDs))
+	msg := make([]byte, 52+7*n)
 
 	// "HueStream" magic (9 bytes)
 	copy(msg[0:9], "HueStream")
@@ -133,8 +285,8 @@ func BuildHueStreamMessage(areaID string, channelIDs []uint8, c RGB, seq uint8)
 	msg[12] = 0x00
 	msg[13] = 0x00
 
-	// Color space: 0x00 = RGB
-	msg[14] = 0x00
+	// Color space: 0x00 = RGB, 0x01 = xy + brightness
+	msg[14] = byte(cs)
 
 	// Reserved
 	msg[15] = 0x00
@@ -142,25 +294,24 @@ func BuildHueStreamMessage(areaID string, channelIDs []uint8, c RGB, seq uint8)
 	// Entertainment configuration ID (36 ASCII chars, UUID format)
 	copy(msg[16:52], padOrTruncate(areaID, 36))
 
-	// 8-bit to 16-bit color conversion
-	r16 := uint16(c.R) * 257
-	g16 := uint16(c.G) * 257
-	b16 := uint16(c.B) * 257
+	return msg
+}
 
-	// Per-channel data (7 bytes each)
-	offset := 52
-	for _, ch := range channelIDs {
-		msg[offset] = ch
-		msg[offset+1] = byte(r16 >> 8)
-		msg[offset+2] = byte(r16)
-		msg[offset+3] = byte(g16 >> 8)
-		msg[offset+4] = byte(g16)
-		msg[offset+5] = byte(b16 >> 8)
-		msg[offset+6] = byte(b16)
-		offset += 7
-	}
+// putChannel writes one 7-byte channel entry: the channel ID followed by
+// three big-endian 16-bit values.
+func putChannel(b []byte, ch uint8, v1, v2, v3 uint16) {
+	b[0] = ch
+	b[1] = byte(v1 >> 8)
+	b[2] = byte(v1)
+	b[3] = byte(v2 >> 8)
+	b[4] = byte(v2)
+	b[5] = byte(v3 >> 8)
+	b[6] = byte(v3)
+}
 
-	return msg
+// unitToUint16 scales a value in 0..1 to the full 16-bit range.
+func unitToUint16(v float64) uint16 {
+	return uint16(min(max(v, 0), 1)*0xffff + 0.5)
 }
 
 func padOrTruncate(s string, n int) []byte {
@@ -7,9 +7,9 @@ import (
 func TestBuildHueStreamMessage_Header(t *testing.T) {
 	areaID := "abcdefgh-1234-5678-9abc-def012345678"
 	channels := []uint8{0, 1}
-	color := RGB{R: 255, G: 128, B: 0}
+	colors := []RGB{{R: 255, G: 128, B: 0}, {R: 255, G: 128, B: 0}}
 
-	msg := BuildHueStreamMessage(areaID, channels, color, 42)
+	msg := BuildHueStreamMessage(areaID, channels, colors, 42)
 
 	// Total length: 52 header + 7*2 channels = 66
 	if len(msg) != 66 {
@@ -48,9 +48,9 @@ func TestBuildHueStreamMessage_Header(t *testing.T) {
 func TestBuildHueStreamMessage_ChannelData(t *testing.T) {
 	areaID := "abcdefgh-1234-5678-9abc-def012345678"
 	channels := []uint8{0, 3}
-	color := RGB{R: 255, G: 0, B: 128}
+	colors := []RGB{{R: 255, G: 0, B: 128}, {R: 0, G: 1, B: 255}}
 
-	msg := BuildHueStreamMessage(areaID, channels, color, 0)
+	msg := BuildHueStreamMessage(areaID, channels, colors, 0)
 
 	// Channel 0 starts at offset 52
 	if msg[52] != 0 {
@@ -79,14 +79,22 @@ func TestBuildHueStreamMessage_ChannelData(t *testing.T) {
 	if msg[59] != 3 {
 		t.Errorf("expected channel ID 3, got %d", msg[59])
 	}
+
+	// Channel 3 carries its own color: R=0, G=1 → 257, B=255 → 65535
+	r16 = uint16(msg[60])<<8 | uint16(msg[61])
+	g16 = uint16(msg[62])<<8 | uint16(msg[63])
+	b16 = uint16(msg[64])<<8 | uint16(msg[65])
+	if r16 != 0 || g16 != 257 || b16 != 65535 {
+		t.Errorf("expected channel 3 RGB16={0, 257, 65535}, got {%d, %d, %d}", r16, g16, b16)
+	}
 }
 
 func TestBuildHueStreamMessage_SingleChannel(t *testing.T) {
 	areaID := "12345678-1234-1234-1234-123456789012"
 	channels := []uint8{5}
-	color := RGB{R: 0, G: 0, B: 0}
+	colors := []RGB{{R: 0, G: 0, B: 0}}
 
-	msg := BuildHueStreamMessage(areaID, channels, color, 255)
+	msg := BuildHueStreamMessage(areaID, channels, colors, 255)
 
 	// Total length: 52 + 7 = 59
 	if len(msg) != 59 {
@@ -110,3 +118,91 @@ func TestBuildHueStreamMessage_SingleChannel(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildHueStreamXYMessage(t *testing.T) {
+	areaID := "abcdefgh-1234-5678-9abc-def012345678"
+	channels := []uint8{2, 4}
+	colors := []RGB{{R: 255, G: 255, B: 255}, {G: 255}}
+	gamuts := []Gamut{gamutC, gamutB}
+
+	msg := BuildHueStreamXYMessage(areaID, channels, colors, gamuts, 7)
+
+	if len(msg) != 66 {
+		t.Fatalf("expected length 66, got %d", len(msg))
+	}
+
+	// Color space
+	if msg[14] != 0x01 {
+		t.Errorf("expected color space 0x01, got 0x%02x", msg[14])
+	}
+
+	// Channel 2: white → D65 at full brightness
+	if msg[52] != 2 {
+		t.Errorf("expected channel ID 2, got %d", msg[52])
+	}
+	x16 := uint16(msg[53])<<8 | uint16(msg[54])
+	y16 := uint16(msg[55])<<8 | uint16(msg[56])
+	bri16 := uint16(msg[57])<<8 | uint16(msg[58])
+	if x16 < 20400 || x16 > 20600 || y16 < 21500 || y16 > 21650 {
+		t.Errorf("expected x16≈20493 y16≈21561 (D65), got %d, %d", x16, y16)
+	}
+	if bri16 != 0xffff {
+		t.Errorf("expected full brightness, got %d", bri16)
+	}
+
+	// Channel 4: sRGB green is outside gamut B and is clamped onto it
+	if msg[59] != 4 {
+		t.Errorf("expected channel ID 4, got %d", msg[59])
+	}
+	x16 = uint16(msg[60])<<8 | uint16(msg[61])
+	y16 = uint16(msg[62])<<8 | uint16(msg[63])
+	clamped := XY{float64(x16) / 0xffff, float64(y16) / 0xffff}
+	if xyDist(clamped, gamutB.Green) > 1e-6 {
+		t.Errorf("expected green clamped to gamut B's green corner %v, got %v", gamutB.Green, clamped)
+	}
+}
+
+func TestParseColorSpace(t *testing.T) {
+	for _, cs := range []ColorSpace{ColorSpaceRGB, ColorSpaceXY} {
+		got, err := parseColorSpace(cs.String())
+		if err != nil || got != cs {
+			t.Errorf("parseColorSpace(%q) = %v, %v", cs.String(), got, err)
+		}
+	}
+	if _, err := parseColorSpace("hsv"); err == nil {
+		t.Error("expected error for unknown color space")
+	}
+}
+
+func TestParseHueStreamMessage_RoundTrip(t *testing.T) {
+	areaID := "abcdefgh-1234-5678-9abc-def012345678"
+	msg := BuildHueStreamMessage(areaID, []uint8{2, 5}, []RGB{{R: 255, G: 128}, {B: 1}}, 7)
+
+	f, err := ParseHueStreamMessage(msg)
+	if err != nil {
+		t.Fatalf("ParseHueStreamMessage: %v", err)
+	}
+	if f.Seq != 7 || f.ColorSpace != ColorSpaceRGB || f.AreaID != areaID {
+		t.Errorf("unexpected header %+v", f)
+	}
+	want := []HueStreamChannel{
+		{ID: 2, Values: [3]uint16{0xffff, 128 * 257, 0}},
+		{ID: 5, Values: [3]uint16{0, 0, 257}},
+	}
+	if len(f.Channels) != len(want) || f.Channels[0] != want[0] || f.Channels[1] != want[1] {
+		t.Errorf("expected channels %v, got %v", want, f.Channels)
+	}
+}
+
+func TestParseHueStreamMessage_Invalid(t *testing.T) {
+	valid := BuildHueStreamMessage("area", []uint8{0}, []RGB{{}}, 0)
+	for name, msg := range map[string][]byte{
+		"short":     valid[:20],
+		"magic":     append([]byte("HueStreem"), valid[9:]...),
+		"truncated": valid[:len(valid)-1],
+	} {
+		if _, err := ParseHueStreamMessage(msg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
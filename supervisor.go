@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+const (
+	// failureThreshold is the number of consecutive send errors after which
+	// the connection is considered dead.
+	failureThreshold = 3
+
+	reconnectInitialBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff     = 30 * time.Second
+)
+
+// ErrReconnectFailed is returned by streamSupervisor.SendFrame once all
+// reconnect attempts have failed.
+var ErrReconnectFailed = errors.New("giving up reconnecting")
+
+// errStreamClosed is returned when sending on a closed supervisor.
+var errStreamClosed = errors.New("stream closed")
+
+// linkStatus describes the state of a supervised stream for display.
+type linkStatus struct {
+	// Attempt is the number of failed reconnect attempts since the
+	// connection was lost; 0 while connected.
+	Attempt     int
+	MaxAttempts int
+	// Reconnecting is true while the connection is down.
+	Reconnecting bool
+	RetryAt      time.Time
+	// Reconnects counts the successful reconnects of the session.
+	Reconnects int
+	// Held is the reason the stream is on hold, e.g. a *TakeoverError.
+	Held error
+}
+
+// streamSupervisor wraps a Streamer and replaces it when the connection dies:
+// after failureThreshold consecutive write errors, or at once when the bridge
+// closed the DTLS connection. Reconnecting re-activates the area and dials
+// again, backing off exponentially between failed attempts. When another
+// application has taken over the area, the stream is put on hold instead
+// until Resume, so that huesync does not fight over it.
+type streamSupervisor struct {
+	connect     func() (*Streamer, error)
+	maxAttempts int
+	recorder    *Recorder
+	channels    int
+
+	mu         sync.Mutex
+	streamer   *Streamer
+	failures   int
+	attempt    int
+	reconnects int
+	retryAt    time.Time
+	lastErr    error
+	held       error
+	holds      int  // counts the calls of Hold
+	dialing    bool // whether a reconnect is dialing
+	closed     bool
+}
+
+// newStreamSupervisor supervises s. connect re-activates the area and returns
+// a new Streamer; it is tried up to maxAttempts times per outage, and never
+// when maxAttempts is 0.
+func newStreamSupervisor(s *Streamer, connect func() (*Streamer, error), maxAttempts int) *streamSupervisor {
+	return &streamSupervisor{connect: connect, maxAttempts: maxAttempts, streamer: s, channels: len(s.channelIDs)}
+}
+
+// Channels returns the number of colors SendFrame takes.
+func (sv *streamSupervisor) Channels() int { return sv.channels }
+
+// SendFrame sends colors on the current connection. While the connection is
+// down it instead attempts to reconnect once the backoff has passed, and
+// returns an error describing the outage. Once the attempts are used up, the
+// error wraps ErrReconnectFailed.
+func (sv *streamSupervisor) SendFrame(colors []RGB, now time.Time) error {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.closed {
+		return errStreamClosed
+	}
+	if sv.held != nil {
+		return sv.held
+	}
+	if sv.streamer == nil {
+		if err := sv.reconnect(now); err != nil {
+			return err
+		}
+	}
+
+	err := sv.streamer.SendFrame(colors)
+	if err == nil {
+		sv.failures = 0
+		return nil
+	}
+	sv.failures++
+	if sv.failures >= failureThreshold || connClosed(err) {
+		_ = sv.streamer.Close()
+		sv.streamer = nil
+		sv.failures = 0
+		sv.lastErr = err
+		sv.retryAt = now
+	}
+	return err
+}
+
+// reconnect makes one reconnect attempt if the backoff has passed. sv.mu must
+// be held; it is released while dialing, which can take seconds, so that
+// Hold, Resume, Status and Close do not wait for the bridge.
+func (sv *streamSupervisor) reconnect(now time.Time) error {
+	if sv.attempt >= sv.maxAttempts {
+		return fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, sv.attempt, sv.lastErr)
+	}
+	if now.Before(sv.retryAt) || sv.dialing {
+		return fmt.Errorf("connection lost, waiting to reconnect: %w", sv.lastErr)
+	}
+
+	sv.attempt++
+	holds := sv.holds
+	sv.dialing = true
+	sv.mu.Unlock()
+	s, err := sv.connect()
+	sv.mu.Lock()
+	sv.dialing = false
+
+	// The stream may have been closed or put on hold meanwhile; then the
+	// new connection is not wanted.
+	if sv.closed || sv.held != nil || sv.holds != holds {
+		if s != nil {
+			_ = s.Close()
+		}
+		switch {
+		case sv.closed:
+			return errStreamClosed
+		case sv.held != nil:
+			return sv.held
+		}
+		return errors.New("stream was on hold while reconnecting")
+	}
+	var takeover *TakeoverError
+	if errors.As(err, &takeover) {
+		sv.attempt = 0
+		sv.held = takeover
+		return takeover
+	}
+	if err != nil {
+		sv.lastErr = err
+		sv.retryAt = now.Add(reconnectBackoff(sv.attempt))
+		if sv.attempt >= sv.maxAttempts {
+			return fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, sv.attempt, err)
+		}
+		return fmt.Errorf("reconnect attempt %d/%d: %w", sv.attempt, sv.maxAttempts, err)
+	}
+	s.SetRecorder(sv.recorder)
+	sv.streamer = s
+	sv.attempt = 0
+	sv.reconnects++
+	sv.lastErr = nil
+	return nil
+}
+
+// SetRecorder records the messages sent on this and later connections to r.
+// The supervisor closes r when it is closed.
+func (sv *streamSupervisor) SetRecorder(r *Recorder) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.recorder = r
+	if sv.streamer != nil {
+		sv.streamer.SetRecorder(r)
+	}
+}
+
+// Hold closes the connection and stops sending and reconnecting until
+// Resume. SendFrame returns err meanwhile.
+func (sv *streamSupervisor) Hold(err error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if sv.closed {
+		return
+	}
+	if sv.streamer != nil {
+		_ = sv.streamer.Close()
+		sv.streamer = nil
+	}
+	sv.failures = 0
+	sv.held = err
+	sv.holds++
+}
+
+// Resume ends a hold: the next SendFrame connects again at once. The caller
+// re-activates the area first if another application took it over.
+func (sv *streamSupervisor) Resume() {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.held = nil
+	sv.attempt = 0
+	sv.retryAt = time.Time{}
+}
+
+// Status reports the connection state.
+func (sv *streamSupervisor) Status() linkStatus {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return linkStatus{
+		Attempt:      sv.attempt,
+		MaxAttempts:  sv.maxAttempts,
+		Reconnecting: sv.streamer == nil && !sv.closed && sv.held == nil,
+		RetryAt:      sv.retryAt,
+		Reconnects:   sv.reconnects,
+		Held:         sv.held,
+	}
+}
+
+// Close closes the current connection and the recorder, and stops
+// reconnecting.
+func (sv *streamSupervisor) Close() error {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.closed = true
+	var err error
+	if sv.streamer != nil {
+		err = sv.streamer.Close()
+		sv.streamer = nil
+	}
+	if sv.recorder != nil {
+		err = errors.Join(err, sv.recorder.Close())
+		sv.recorder = nil
+	}
+	return err
+}
+
+// reconnectBackoff returns the wait after the given failed attempt.
+func reconnectBackoff(attempt int) time.Duration {
+	d := reconnectInitialBackoff
+	for i := 1; i < attempt && d < reconnectMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, reconnectMaxBackoff)
+}
+
+// connClosed reports whether err means the connection is gone for good,
+// e.g. because the bridge sent a close_notify alert.
+func connClosed(err error) bool {
+	return errors.Is(err, dtls.ErrConnClosed) || errors.Is(err, net.ErrClosed)
+}
+
+// dialArea activates the area and opens a stream to it. If appID is not
+// empty and another application streams to the area, it returns a
+// *TakeoverError instead of taking the area back.
+func dialArea(ip net.IP, username, clientkey, appID string, area EntertainmentArea, cs ColorSpace, gamuts []Gamut) (*Streamer, error) {
+	if appID != "" {
+		if err := checkStreamer(ip, username, appID, area.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := ActivateArea(ip, username, area.ID); err != nil {
+		return nil, err
+	}
+	s, err := NewStreamer(ip, username, clientkey, area.ID, area.ChannelIDs())
+	if err != nil {
+		return nil, err
+	}
+	s.SetColorSpace(cs, gamuts)
+	return s, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// fakeConn is a net.Conn whose writes fail with err.
+type fakeConn struct {
+	net.Conn
+	err    error
+	writes int
+	closed bool
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.writes++
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func fakeStreamer(conn *fakeConn) *Streamer {
+	return &Streamer{conn: conn, areaID: "area", channelIDs: []uint8{0}}
+}
+
+var oneColor = []RGB{{R: 255}}
+
+func TestStreamSupervisor_ReconnectsAfterPersistentFailures(t *testing.T) {
+	dead := &fakeConn{err: errors.New("connection refused")}
+	fresh := &fakeConn{}
+	dials := 0
+	sv := newStreamSupervisor(fakeStreamer(dead), func() (*Streamer, error) {
+		dials++
+		return fakeStreamer(fresh), nil
+	}, 3)
+
+	now := time.Now()
+	for i := range failureThreshold {
+		if err := sv.SendFrame(oneColor, now); err == nil {
+			t.Fatalf("send %d: expected error", i)
+		}
+	}
+	if !dead.closed || !sv.Status().Reconnecting {
+		t.Fatal("expected the dead connection to be dropped")
+	}
+
+	if err := sv.SendFrame(oneColor, now); err != nil {
+		t.Fatalf("send after reconnect: %v", err)
+	}
+	if dials != 1 || fresh.writes != 1 {
+		t.Errorf("expected 1 dial and 1 write, got %d and %d", dials, fresh.writes)
+	}
+	if st := sv.Status(); st.Reconnecting || st.Reconnects != 1 {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestStreamSupervisor_ClosedConnection(t *testing.T) {
+	sv := newStreamSupervisor(fakeStreamer(&fakeConn{err: dtls.ErrConnClosed}), func() (*Streamer, error) {
+		return fakeStreamer(&fakeConn{}), nil
+	}, 3)
+
+	// A connection closed by the bridge is replaced without waiting for
+	// further failures.
+	now := time.Now()
+	if err := sv.SendFrame(oneColor, now); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := sv.SendFrame(oneColor, now); err != nil {
+		t.Fatalf("send after reconnect: %v", err)
+	}
+}
+
+func TestStreamSupervisor_BackoffAndGiveUp(t *testing.T) {
+	dials := 0
+	sv := newStreamSupervisor(fakeStreamer(&fakeConn{err: dtls.ErrConnClosed}), func() (*Streamer, error) {
+		dials++
+		return nil, errors.New("bridge unreachable")
+	}, 2)
+
+	now := time.Now()
+	_ = sv.SendFrame(oneColor, now)
+
+	if err := sv.SendFrame(oneColor, now); err == nil || errors.Is(err, ErrReconnectFailed) {
+		t.Fatalf("expected a failed attempt, got %v", err)
+	}
+	// Within the backoff no attempt is made.
+	_ = sv.SendFrame(oneColor, now.Add(reconnectInitialBackoff/2))
+	if dials != 1 {
+		t.Fatalf("expected 1 dial during backoff, got %d", dials)
+	}
+
+	err := sv.SendFrame(oneColor, now.Add(reconnectInitialBackoff))
+	if !errors.Is(err, ErrReconnectFailed) {
+		t.Fatalf("expected ErrReconnectFailed, got %v", err)
+	}
+	if dials != 2 {
+		t.Errorf("expected 2 dials, got %d", dials)
+	}
+}
+
+func TestStreamSupervisor_NoReconnect(t *testing.T) {
+	sv := newStreamSupervisor(fakeStreamer(&fakeConn{err: dtls.ErrConnClosed}), func() (*Streamer, error) {
+		t.Fatal("unexpected reconnect")
+		return nil, nil
+	}, 0)
+
+	now := time.Now()
+	_ = sv.SendFrame(oneColor, now)
+	if err := sv.SendFrame(oneColor, now); !errors.Is(err, ErrReconnectFailed) {
+		t.Errorf("expected ErrReconnectFailed, got %v", err)
+	}
+}
+
+func TestStreamSupervisor_HoldResume(t *testing.T) {
+	first := &fakeConn{}
+	fresh := &fakeConn{}
+	dials := 0
+	sv := newStreamSupervisor(fakeStreamer(first), func() (*Streamer, error) {
+		dials++
+		return fakeStreamer(fresh), nil
+	}, 3)
+
+	takeover := &TakeoverError{Owner: "Hue Sync (DESKTOP)"}
+	sv.Hold(takeover)
+	if !first.closed {
+		t.Error("expected the hold to close the connection")
+	}
+	now := time.Now()
+	for range 5 {
+		if err := sv.SendFrame(oneColor, now); err != takeover {
+			t.Fatalf("expected the takeover while on hold, got %v", err)
+		}
+	}
+	if st := sv.Status(); st.Held != takeover || st.Reconnecting || dials != 0 {
+		t.Errorf("expected a hold without reconnecting, got %+v after %d dials", st, dials)
+	}
+
+	sv.Resume()
+	if err := sv.SendFrame(oneColor, now); err != nil {
+		t.Fatalf("send after resume: %v", err)
+	}
+	if dials != 1 || fresh.writes != 1 || sv.Status().Held != nil {
+		t.Errorf("expected 1 dial and 1 write after resume, got %d and %d", dials, fresh.writes)
+	}
+}
+
+func TestStreamSupervisor_TakeoverOnReconnect(t *testing.T) {
+	dials := 0
+	sv := newStreamSupervisor(fakeStreamer(&fakeConn{err: dtls.ErrConnClosed}), func() (*Streamer, error) {
+		dials++
+		return nil, &TakeoverError{Owner: "another app"}
+	}, 3)
+
+	// The area having been taken over puts the stream on hold instead of
+	// using up the attempts.
+	now := time.Now()
+	_ = sv.SendFrame(oneColor, now)
+	var takeover *TakeoverError
+	if err := sv.SendFrame(oneColor, now); !errors.As(err, &takeover) {
+		t.Fatalf("expected a TakeoverError, got %v", err)
+	}
+	_ = sv.SendFrame(oneColor, now.Add(time.Minute))
+	if st := sv.Status(); st.Held == nil || st.Attempt != 0 || dials != 1 {
+		t.Errorf("expected a hold after 1 dial, got %+v after %d dials", st, dials)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{4, 4 * time.Second},
+		{20, reconnectMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := reconnectBackoff(tt.attempt); got != tt.want {
+			t.Errorf("reconnectBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestStreamSupervisor_HoldWhileDialing(t *testing.T) {
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	fresh := &fakeConn{}
+	sv := newStreamSupervisor(fakeStreamer(&fakeConn{err: dtls.ErrConnClosed}), func() (*Streamer, error) {
+		close(dialing)
+		<-release
+		return fakeStreamer(fresh), nil
+	}, 3)
+
+	now := time.Now()
+	_ = sv.SendFrame(oneColor, now)
+	sent := make(chan error)
+	go func() { sent <- sv.SendFrame(oneColor, now) }()
+	<-dialing
+
+	// Holding does not wait for the bridge to answer.
+	held := make(chan struct{})
+	go func() {
+		sv.Hold(&TakeoverError{Owner: "another app"})
+		close(held)
+	}()
+	select {
+	case <-held:
+	case <-time.After(time.Second):
+		t.Fatal("Hold blocked while dialing")
+	}
+	close(release)
+
+	var takeover *TakeoverError
+	if err := <-sent; !errors.As(err, &takeover) {
+		t.Errorf("expected the hold to win over the new connection, got %v", err)
+	}
+	if !fresh.closed || fresh.writes != 0 || sv.Status().Held == nil {
+		t.Errorf("expected the new connection closed unused, got %+v", fresh)
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
+	"io"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
@@ -15,17 +20,42 @@ import (
 
 const scanTimeout = 5 * time.Second
 
+const (
+	smoothingStep = 50 * time.Millisecond
+	snapStep      = 10
+)
+
 type state int
 
 const (
-	stateScanning      state = iota
+	stateSelectingProfile state = iota
+	stateScanning
 	stateSelecting
+	stateBridgeEntry
+	stateProbing
+	stateCertChanged
+	stateCredentials
+	stateCredsForget
+	stateCredsFile
+	stateCredsPassphrase
+	stateCredsWorking
+	stateLoadingCreds
 	statePairing
 	statePairingWait
 	stateFetchingAreas
 	stateSelectingArea
+	stateAreaLoading
+	stateAreaName
+	stateAreaType
+	stateAreaLights
+	stateAreaPlace
+	stateAreaDelete
+	stateAreaSaving
+	stateSelectingSource
 	stateInputDelay
+	stateSelectingDisplay
 	stateInitCapture
+	stateInitAudio
 	stateActivating
 	stateConnecting
 	stateStreaming
@@ -33,9 +63,49 @@ const (
 	stateDone
 )
 
+type knownBridgeMsg struct {
+	bridge Bridge
+	err    error
+}
+
+type bridgeFoundMsg struct {
+	scan   *bridgeScan
+	bridge Bridge
+}
+
 type scanDoneMsg struct {
-	bridges []Bridge
-	err     error
+	scan *bridgeScan
+	err  error
+}
+
+type probeResultMsg struct {
+	bridge Bridge
+	err    error
+}
+
+type credsDoneMsg struct {
+	note string
+	err  error
+}
+
+type credsWarningMsg struct {
+	err error
+}
+
+// certPinnedMsg reports that a bridge's certificate was pinned on first use.
+type certPinnedMsg struct {
+	bridgeID string
+	fp       string
+}
+
+// credsLoadedMsg carries the stored credentials of the selected bridge. back
+// is set when they were loaded again on leaving the credentials screen.
+type credsLoadedMsg struct {
+	bridgeID string
+	creds    BridgeCredentials
+	found    bool
+	back     bool
+	err      error
 }
 
 type pairResultMsg struct {
@@ -49,21 +119,43 @@ type areasFetchedMsg struct {
 	err   error
 }
 
-type activateResultMsg struct {
+type areaLightsMsg struct {
+	lights []EntertainmentLight
+	err    error
+}
+
+type areaSavedMsg struct {
 	err error
 }
 
+type activateResultMsg struct {
+	lights     *lightSession
+	restoreErr error
+	err        error
+}
+
 type connectResultMsg struct {
-	streamer *Streamer
-	err      error
+	stream *streamSupervisor
+	// appID is huesync's application ID, or empty if the bridge did not
+	// tell it; then takeovers are not watched for.
+	appID string
+	err   error
 }
 
-type streamTickMsg struct{}
+type areaEventMsg struct {
+	event areaEvent
+}
 
-type frameSentMsg struct {
-	color     RGB
-	err       error
-	startedAt time.Time
+type reclaimResultMsg struct {
+	err error
+}
+
+type engineStatusMsg struct {
+	status engineStatus
+}
+
+type engineDoneMsg struct {
+	err error
 }
 
 type captureInitMsg struct {
@@ -72,11 +164,22 @@ type captureInitMsg struct {
 	err      error
 }
 
+type audioInitMsg struct {
+	capture audioCapture
+	method  string
+	err     error
+}
+
 type stopDoneMsg struct {
-	err error
+	skipped []string
+	err     error
 }
 
 type model struct {
+	parser        *optionParser
+	profiles      []string
+	profileCursor int
+
 	state    state
 	spinner  spinner.Model
 	bridges  []Bridge
@@ -84,6 +187,34 @@ type model struct {
 	selected *Bridge
 	err      error
 
+	// scan is the running discovery, whose bridges are added to bridges as
+	// they are found.
+	scan *bridgeScan
+
+	// bridgeInput is the address entered for a bridge that discovery did
+	// not find; bridgeNote tells why it is asked for.
+	bridgeInput string
+	bridgeNote  string
+
+	// certChanged is set when the selected bridge presented another
+	// certificate than the one pinned for it.
+	certChanged *CertificateChangedError
+	// certPinned tells which certificate was pinned on first use.
+	certPinned string
+
+	// paired lists the bridges with stored credentials on the credentials
+	// screen, which returns to credsBack when left. credsForm is the export
+	// or import being entered, and credsNote tells how the last one went.
+	paired      []pairedBridge
+	credsCursor int
+	credsBack   state
+	credsForm   *credsForm
+	credsNote   string
+	credsErr    error
+	// credsWarning tells why credentials are kept in the plaintext file,
+	// or that a damaged one was moved aside.
+	credsWarning error
+
 	username     string
 	clientkey    string
 	pairErr      string
@@ -91,15 +222,50 @@ type model struct {
 	areaCursor   int
 	selectedArea *EntertainmentArea
 
+	// draft is the area being created or changed. areasEdited keeps the
+	// area list up after a change, even with a single area left.
+	draft       *areaDraft
+	areaErr     error
+	areasEdited bool
+
+	// effect is the chosen effect, effectScreen for screen sync or
+	// effectAudio for the audio-reactive mode.
+	effect       string
+	sourceCursor int
+
 	delayInput   string
 	captureDelay time.Duration
 
+	display       int
+	displaySet    bool
+	displays      []Display
+	displayCursor int
+
+	opts      options
+	colorMode string
+
 	capturer      Capturer
 	captureMethod string
-
-	streamer  *Streamer
-	lastColor RGB
-	streamErr error
+	audio         audioCapture
+	audioMethod   string
+
+	stream     *streamSupervisor
+	pipeline   *colorPipeline
+	engine     *streamEngine
+	updates    chan engineStatus
+	lastColors []RGB
+	lastCrop   image.Rectangle
+	link       linkStatus
+	streamErr  error
+
+	watcher   *areaWatcher
+	takeover  *TakeoverError
+	lightNote string
+
+	lights        *lightSession
+	lightsMarked  bool
+	restoreErr    error
+	skippedLights []string
 }
 
 var (
@@ -110,37 +276,159 @@ var (
 	errStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
 )
 
-func newModel() model {
+// newModel creates the TUI model. parser may be nil; otherwise, when the
+// config defines profiles and none was chosen, the TUI starts by asking for one.
+func newModel(opts options, parser *optionParser) model {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("63"))
-	return model{
+	m := model{
+		parser:  parser,
 		state:   stateScanning,
 		spinner: s,
+	}.withOptions(opts)
+	if opts.bridgeIP != "" {
+		m.bridgeInput = opts.bridgeIP
+		m.state = stateProbing
 	}
+	if parser != nil && opts.profile == "" {
+		if names := parser.config.ProfileNames(); len(names) > 0 {
+			m.profiles = names
+			m.state = stateSelectingProfile
+		}
+	}
+	return m
+}
+
+// withOptions replaces the model's settings with opts.
+func (m model) withOptions(opts options) model {
+	m.opts = opts
+	m.display = opts.display
+	m.displaySet = opts.displaySet
+	m.colorMode = opts.colorMode
+	return m
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(m.spinner.Tick, scanCmd())
+	switch m.state {
+	case stateSelectingProfile:
+		return tea.Batch(m.spinner.Tick, credsWarningCmd)
+	case stateProbing:
+		return tea.Batch(m.spinner.Tick, credsWarningCmd, probeCmd(m.bridgeInput))
+	}
+	return tea.Batch(m.spinner.Tick, credsWarningCmd, knownBridgeCmd(m.opts.bridge))
+}
+
+func credsWarningCmd() tea.Msg {
+	return credsWarningMsg{err: credentialStoreWarning()}
+}
+
+// loadCredsCmd remembers b for the next start, unless back is set, and
+// loads its credentials.
+func loadCredsCmd(b Bridge, back bool) tea.Cmd {
+	return func() tea.Msg {
+		if !back {
+			_ = RememberBridge(b)
+		}
+		creds, found, err := LoadCredentials(b.ID)
+		return credsLoadedMsg{bridgeID: b.ID, creds: creds, found: found, back: back, err: err}
+	}
+}
+
+func saveCredsCmd(bridgeID string, creds BridgeCredentials) tea.Cmd {
+	return func() tea.Msg {
+		if err := SaveCredentials(bridgeID, creds); err != nil {
+			return credsWarningMsg{err: fmt.Errorf("saving the credentials: %w", err)}
+		}
+		return nil
+	}
+}
+
+func deleteCredsCmd(bridgeID string) tea.Cmd {
+	return func() tea.Msg {
+		if err := DeleteCredentials(bridgeID); err != nil {
+			return credsWarningMsg{err: fmt.Errorf("deleting the rejected credentials: %w", err)}
+		}
+		return nil
+	}
+}
+
+func knownBridgeCmd(query string) tea.Cmd {
+	return func() tea.Msg {
+		b, err := probeKnownBridge(context.Background(), query)
+		return knownBridgeMsg{bridge: b, err: err}
+	}
+}
+
+// bridgeScan is a running discovery. Its bridges arrive as bridgeFoundMsgs,
+// followed by a scanDoneMsg.
+type bridgeScan struct {
+	bridges <-chan Bridge
+	errs    <-chan error
+	cancel  context.CancelFunc
+}
+
+func startScan(ssdp bool) *bridgeScan {
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	bridges, errs := discoverAll(ctx, ssdp)
+	return &bridgeScan{bridges: bridges, errs: errs, cancel: cancel}
 }
 
-func scanCmd() tea.Cmd {
+// next waits for the next bridge.
+func (s *bridgeScan) next() tea.Cmd {
 	return func() tea.Msg {
-		ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
-		defer cancel()
+		if b, ok := <-s.bridges; ok {
+			expectBridge(b)
+			return bridgeFoundMsg{scan: s, bridge: b}
+		}
+		s.cancel()
+		return scanDoneMsg{scan: s, err: <-s.errs}
+	}
+}
 
-		bridgeCh, errCh := DiscoverBridges(ctx)
+func probeCmd(host string) tea.Cmd {
+	return func() tea.Msg {
+		b, err := ProbeBridge(context.Background(), host)
+		return probeResultMsg{bridge: b, err: err}
+	}
+}
 
-		var bridges []Bridge
-		for b := range bridgeCh {
-			bridges = append(bridges, b)
+// forgetCmd forgets the credentials of p, first removing huesync's key from
+// the bridge unless local is set.
+func forgetCmd(p pairedBridge, opts options, local bool) tea.Cmd {
+	return func() tea.Msg {
+		if !local {
+			if err := revokeCredentials(context.Background(), p, opts); err != nil {
+				return credsDoneMsg{err: err}
+			}
+		}
+		if err := DeleteCredentials(p.ID); err != nil {
+			return credsDoneMsg{err: err}
 		}
+		if local {
+			return credsDoneMsg{note: fmt.Sprintf("Forgot %s on this computer; huesync's key stays on the bridge.", p.ID)}
+		}
+		return credsDoneMsg{note: fmt.Sprintf("Forgot %s and removed huesync's key from it.", p.ID)}
+	}
+}
 
-		if err := <-errCh; err != nil {
-			return scanDoneMsg{err: err}
+func exportCmd(path, passphrase string) tea.Cmd {
+	return func() tea.Msg {
+		ids, err := exportCredentials(path, passphrase, nil)
+		if err != nil {
+			return credsDoneMsg{err: err}
 		}
+		return credsDoneMsg{note: fmt.Sprintf("Exported the credentials of %s to %s.", strings.Join(ids, ", "), path)}
+	}
+}
 
-		return scanDoneMsg{bridges: bridges}
+func importCmd(path, passphrase string) tea.Cmd {
+	return func() tea.Msg {
+		ids, err := importCredentials(path, passphrase)
+		if err != nil {
+			return credsDoneMsg{err: err}
+		}
+		return credsDoneMsg{note: fmt.Sprintf("Imported the credentials of %s.", strings.Join(ids, ", "))}
 	}
 }
 
@@ -158,50 +446,148 @@ func fetchAreasCmd(ip net.IP, username string) tea.Cmd {
 	}
 }
 
-func activateCmd(ip net.IP, username, areaID string) tea.Cmd {
+func fetchAreaLightsCmd(ip net.IP, username string) tea.Cmd {
 	return func() tea.Msg {
-		err := ActivateArea(ip, username, areaID)
-		return activateResultMsg{err: err}
+		lights, err := FetchEntertainmentLights(ip, username)
+		return areaLightsMsg{lights: lights, err: err}
 	}
 }
 
-func connectCmd(ip net.IP, username, clientkey, areaID string, channelIDs []uint8) tea.Cmd {
+// saveAreaCmd creates the drafted area, or applies the draft to its area.
+func saveAreaCmd(ip net.IP, username string, d areaDraft) tea.Cmd {
 	return func() tea.Msg {
-		streamer, err := NewStreamer(ip, username, clientkey, areaID, channelIDs)
-		return connectResultMsg{streamer: streamer, err: err}
+		if d.id == "" {
+			_, err := CreateArea(ip, username, d.spec())
+			return areaSavedMsg{err: err}
+		}
+		return areaSavedMsg{err: UpdateArea(ip, username, d.id, d.spec())}
 	}
 }
 
-func initCaptureCmd() tea.Cmd {
+func deleteAreaCmd(ip net.IP, username, id string) tea.Cmd {
 	return func() tea.Msg {
-		c, method, err := NewCapturer()
-		return captureInitMsg{capturer: c, method: method, err: err}
+		return areaSavedMsg{err: DeleteArea(ip, username, id)}
+	}
+}
+
+// activateCmd activates the area, first taking a snapshot of its lights
+// when they are to be restored afterwards.
+func activateCmd(ip net.IP, username string, area EntertainmentArea, opts options) tea.Cmd {
+	return func() tea.Msg {
+		var msg activateResultMsg
+		if opts.restore {
+			msg.lights, msg.restoreErr = snapshotLights(ip, username, area, opts.restoreFade)
+		}
+		msg.err = ActivateArea(ip, username, area.ID)
+		return msg
+	}
+}
+
+func markLightsCmd(ls *lightSession) tea.Cmd {
+	return func() tea.Msg {
+		_ = ls.markStreaming()
+		return nil
 	}
 }
 
-func captureAndSendCmd(s *Streamer, c Capturer) tea.Cmd {
+func connectCmd(ip net.IP, username, clientkey string, area EntertainmentArea, opts options) tea.Cmd {
 	return func() tea.Msg {
-		start := time.Now()
-		color, err := c.CaptureColor()
+		cs := opts.colorSpace
+		var gamuts []Gamut
+		if cs == ColorSpaceXY {
+			var err error
+			gamuts, err = FetchChannelGamuts(ip, username, area)
+			if err != nil {
+				return connectResultMsg{err: fmt.Errorf("fetching light gamuts: %w", err)}
+			}
+		}
+		// Without the application ID, takeovers go unnoticed.
+		appID, _ := FetchApplicationID(ip, username)
+		streamer, err := NewStreamer(ip, username, clientkey, area.ID, area.ChannelIDs())
 		if err != nil {
-			return frameSentMsg{err: err, startedAt: start}
+			return connectResultMsg{err: err}
+		}
+		streamer.SetColorSpace(cs, gamuts)
+		reconnect := func() (*Streamer, error) {
+			return dialArea(ip, username, clientkey, appID, area, cs, gamuts)
 		}
-		err = s.SendColor(color)
-		return frameSentMsg{color: color, err: err, startedAt: start}
+		stream := newStreamSupervisor(streamer, reconnect, opts.reconnect)
+		if opts.record != "" {
+			rec, err := CreateRecording(opts.record)
+			if err != nil {
+				// The area is active, so the result must carry the stream
+				// for stop to close it.
+				return connectResultMsg{stream: stream, err: err}
+			}
+			stream.SetRecorder(rec)
+		}
+		return connectResultMsg{stream: stream, appID: appID}
+	}
+}
+
+// waitAreaCmd waits for the next change w notices.
+func waitAreaCmd(w *areaWatcher) tea.Cmd {
+	return func() tea.Msg {
+		e, ok := <-w.Events()
+		if !ok {
+			return nil
+		}
+		return areaEventMsg{event: e}
+	}
+}
+
+// reclaimCmd takes the area back from the application that took it over
+// and resumes streaming.
+func reclaimCmd(ip net.IP, username, areaID string, s *streamSupervisor) tea.Cmd {
+	return func() tea.Msg {
+		if err := ActivateArea(ip, username, areaID); err != nil {
+			return reclaimResultMsg{err: err}
+		}
+		s.Resume()
+		return reclaimResultMsg{}
+	}
+}
+
+func initCaptureCmd(backend string, display int) tea.Cmd {
+	return func() tea.Msg {
+		c, method, err := openCapturer(backend, display)
+		return captureInitMsg{capturer: c, method: method, err: err}
+	}
+}
+
+func initAudioCmd(backend, source string) tea.Cmd {
+	return func() tea.Msg {
+		c, method, err := openAudioCapture(backend, source)
+		return audioInitMsg{capture: c, method: method, err: err}
 	}
 }
 
-func streamTickCmd(d time.Duration) tea.Cmd {
-	return tea.Tick(d, func(time.Time) tea.Msg {
-		return streamTickMsg{}
-	})
+// waitEngineCmd waits for the engine's next status update, or for it to stop.
+func waitEngineCmd(e *streamEngine, updates <-chan engineStatus) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case st := <-updates:
+			return engineStatusMsg{status: st}
+		case <-e.Done():
+			return engineDoneMsg{err: e.Err()}
+		}
+	}
 }
 
-func stopCmd(s *Streamer, c Capturer, ip net.IP, username, areaID string) tea.Cmd {
+// stopCmd releases what the session holds. An empty areaID leaves the area
+// active, for the application that took it over.
+func stopCmd(e *streamEngine, s *streamSupervisor, w *areaWatcher, inputs []io.Closer, ip net.IP, username, areaID string, ls *lightSession) tea.Cmd {
 	return func() tea.Msg {
 		var firstErr error
-		if c != nil {
-			if err := c.Close(); err != nil {
+		var skipped []string
+		if w != nil {
+			w.Stop()
+		}
+		if e != nil {
+			e.Stop()
+		}
+		for _, c := range inputs {
+			if err := c.Close(); err != nil && firstErr == nil {
 				firstErr = err
 			}
 		}
@@ -210,20 +596,305 @@ func stopCmd(s *Streamer, c Capturer, ip net.IP, username, areaID string) tea.Cm
 				firstErr = err
 			}
 		}
+		if areaID == "" {
+			return stopDoneMsg{}
+		}
+		if ls != nil {
+			_ = ls.detectChanges()
+		}
 		if err := DeactivateArea(ip, username, areaID); err != nil && firstErr == nil {
 			firstErr = err
 		}
-		return stopDoneMsg{err: firstErr}
+		if ls != nil {
+			var err error
+			skipped, err = ls.restore()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return stopDoneMsg{skipped: skipped, err: firstErr}
+	}
+}
+
+// stop ends the session: it stops the engine, closes the connection and the
+// captures, deactivates the area and restores the lights. An area taken over
+// by another application is left to it, together with its lights.
+func (m model) stop() (model, tea.Cmd) {
+	m.state = stateStopping
+	if m.takeover != nil {
+		return m, stopCmd(m.engine, m.stream, m.watcher, m.inputs(), m.selected.IP, m.username, "", nil)
+	}
+	return m, stopCmd(m.engine, m.stream, m.watcher, m.inputs(), m.selected.IP, m.username, m.selectedArea.ID, m.lights)
+}
+
+// inputs returns the open screen and audio captures.
+func (m model) inputs() []io.Closer {
+	var inputs []io.Closer
+	if m.capturer != nil {
+		inputs = append(inputs, m.capturer)
+	}
+	if m.audio != nil {
+		inputs = append(inputs, m.audio)
 	}
+	return inputs
+}
+
+func (m model) startAudio() (model, tea.Cmd) {
+	m.state = stateInitAudio
+	return m, initAudioCmd(m.opts.audioCapture, m.opts.audioSource)
 }
 
 func (m model) startStreaming() (model, tea.Cmd) {
 	m.state = stateInitCapture
-	return m, initCaptureCmd()
+	return m, initCaptureCmd(m.opts.capture, m.display)
+}
+
+// selectDisplay asks which display to capture, unless it was given on the
+// command line or there is only one.
+func (m model) selectDisplay() (model, tea.Cmd) {
+	if m.displaySet {
+		return m.startStreaming()
+	}
+	m.displays = ListDisplays()
+	if len(m.displays) <= 1 {
+		m.display = 0
+		return m.startStreaming()
+	}
+	m.displayCursor = 0
+	m.state = stateSelectingDisplay
+	return m, nil
+}
+
+// discover finds the bridge: it probes the address given with --bridge-ip,
+// or else the bridge's last known address, or else scans the network.
+func (m model) discover() (model, tea.Cmd) {
+	if m.opts.bridgeIP != "" {
+		return m.probe(m.opts.bridgeIP)
+	}
+	m.state = stateScanning
+	return m, knownBridgeCmd(m.opts.bridge)
+}
+
+// scanBridges starts discovering bridges, listing them as they are found.
+func (m model) scanBridges(ssdp bool) (model, tea.Cmd) {
+	if m.scan != nil {
+		m.scan.cancel()
+	}
+	m.bridges = nil
+	m.cursor = 0
+	m.state = stateScanning
+	m.scan = startScan(ssdp)
+	return m, m.scan.next()
+}
+
+// probe asks the device at host for its bridge ID.
+func (m model) probe(host string) (model, tea.Cmd) {
+	m.bridgeInput = host
+	m.state = stateProbing
+	return m, probeCmd(host)
+}
+
+// enterBridge asks for the address of the bridge; note tells why.
+func (m model) enterBridge(note string) (model, tea.Cmd) {
+	m.bridgeNote = note
+	m.state = stateBridgeEntry
+	return m, nil
+}
+
+// selectBridge loads the stored credentials for b; credsLoaded goes on from
+// there. The bridge's address is remembered for the next start.
+func (m model) selectBridge(b Bridge) (model, tea.Cmd) {
+	if m.scan != nil {
+		m.scan.cancel()
+	}
+	m.selected = &b
+	m.state = stateLoadingCreds
+	return m, loadCredsCmd(b, false)
+}
+
+// credsLoaded uses the stored credentials of the selected bridge if there
+// are any, otherwise it starts pairing. Back on the area list, unchanged
+// credentials keep the areas shown.
+func (m model) credsLoaded(msg credsLoadedMsg) (model, tea.Cmd) {
+	if m.state != stateLoadingCreds || m.selected == nil || msg.bridgeID != m.selected.ID {
+		return m, nil
+	}
+	switch {
+	case msg.err != nil:
+		m.pairErr = fmt.Sprintf("Loading the stored credentials failed: %v.", msg.err)
+		m.state = statePairing
+		return m, nil
+	case !msg.found:
+		m.state = statePairing
+		return m, nil
+	case msg.back && msg.creds == (BridgeCredentials{Username: m.username, Clientkey: m.clientkey}):
+		m.state = stateSelectingArea
+		return m, nil
+	}
+	m.username = msg.creds.Username
+	m.clientkey = msg.creds.Clientkey
+	m.state = stateFetchingAreas
+	return m, fetchAreasCmd(m.selected.IP, m.username)
+}
+
+// certificateChanged warns about err if it reports that the selected bridge
+// presented another certificate than the pinned one.
+func (m model) certificateChanged(err error) (model, bool) {
+	var changed *CertificateChangedError
+	if !errors.As(err, &changed) {
+		return m, false
+	}
+	m.certChanged = changed
+	m.state = stateCertChanged
+	return m, true
+}
+
+// trustCertificate pins the bridge's new certificate and carries on where
+// the warning interrupted.
+func (m model) trustCertificate() (model, tea.Cmd) {
+	if err := TrustCertificate(m.certChanged.BridgeID, m.certChanged.Presented); err != nil {
+		m.err = fmt.Errorf("trusting the new certificate: %w", err)
+		m.state = stateDone
+		return m, tea.Quit
+	}
+	m.certChanged = nil
+	expectBridge(*m.selected)
+	return m.selectBridge(*m.selected)
+}
+
+// submitCredsForm goes on once a passphrase was entered: an export asks for
+// it a second time, then runs.
+func (m model) submitCredsForm() (model, tea.Cmd) {
+	f := m.credsForm
+	m.credsErr = nil
+	switch {
+	case !f.export:
+		if f.passphrase == "" {
+			return m, nil
+		}
+		m.state = stateCredsWorking
+		return m, importCmd(f.path, f.passphrase)
+	case !f.repeating:
+		if err := checkPassphrase(f.passphrase); err != nil {
+			m.credsErr = err
+			f.passphrase = ""
+			return m, nil
+		}
+		f.repeating = true
+		return m, nil
+	case f.repeat != f.passphrase:
+		m.credsErr = errors.New("the passphrases do not match")
+		f.passphrase, f.repeat, f.repeating = "", "", false
+		return m, nil
+	}
+	m.state = stateCredsWorking
+	return m, exportCmd(f.path, f.passphrase)
+}
+
+// showCredentials lists the paired bridges; leaving the list returns to
+// back.
+func (m model) showCredentials(back state) (model, tea.Cmd) {
+	paired, err := listPairedBridges()
+	if err != nil {
+		m.credsErr = err
+	}
+	m.paired = paired
+	m.credsCursor = min(m.credsCursor, max(len(paired)-1, 0))
+	m.credsBack = back
+	m.credsForm = nil
+	m.state = stateCredentials
+	return m, nil
+}
+
+// leaveCredentials returns from the credentials screen. If the selected
+// bridge's credentials were forgotten or replaced, it is selected again, to
+// pair or to use the new ones.
+func (m model) leaveCredentials() (model, tea.Cmd) {
+	if m.credsBack == stateSelectingArea {
+		// The credentials may have been forgotten or imported meanwhile.
+		m.state = stateLoadingCreds
+		return m, loadCredsCmd(*m.selected, true)
+	}
+	m.state = m.credsBack
+	return m, nil
+}
+
+// textEntry reports whether the current screen takes typed text, so that q
+// does not quit.
+func (m model) textEntry() bool {
+	switch m.state {
+	case stateAreaName, stateBridgeEntry, stateCredsFile, stateCredsPassphrase:
+		return true
+	}
+	return false
+}
+
+// newArea starts creating an entertainment area.
+func (m model) newArea() (model, tea.Cmd) {
+	m.draft = &areaDraft{typ: areaTypes[0]}
+	m.state = stateAreaLoading
+	return m, fetchAreaLightsCmd(m.selected.IP, m.username)
+}
+
+// editArea starts changing the type and lights of a.
+func (m model) editArea(a EntertainmentArea) (model, tea.Cmd) {
+	m.draft = &areaDraft{id: a.ID, name: a.Name, typ: a.Type, current: areaLights(a)}
+	m.state = stateAreaLoading
+	return m, fetchAreaLightsCmd(m.selected.IP, m.username)
+}
+
+// leaveAreaEditor returns to the area list, or quits if there are no areas
+// to choose from.
+func (m model) leaveAreaEditor() (model, tea.Cmd) {
+	m.draft = nil
+	if len(m.areas) == 0 {
+		m.err = fmt.Errorf("no entertainment areas configured on this bridge")
+		m.state = stateDone
+		return m, tea.Quit
+	}
+	m.state = stateSelectingArea
+	return m, nil
+}
+
+// saveArea saves the draft and then fetches the areas again.
+func (m model) saveArea() (model, tea.Cmd) {
+	m.state = stateAreaSaving
+	return m, saveAreaCmd(m.selected.IP, m.username, *m.draft)
+}
+
+// selectSource asks whether to sync to the screen or the audio or show an
+// effect, unless it was configured.
+func (m model) selectSource() (model, tea.Cmd) {
+	if m.opts.effect != "" {
+		return m.useSource(m.opts.effect)
+	}
+	m.sourceCursor = 0
+	m.state = stateSelectingSource
+	return m, nil
+}
+
+// useSource continues with screen capture, audio capture or the given
+// effect. Effects need no capture, so they go straight to activating the
+// area. The audio mode captures the screen too when it blends in its colors.
+func (m model) useSource(effect string) (model, tea.Cmd) {
+	m.effect = effect
+	switch {
+	case effect == effectScreen, effect == effectAudio && m.opts.audioBlend > 0:
+		return m.enterDelayInput()
+	case effect == effectAudio:
+		return m.startAudio()
+	}
+	m.state = stateActivating
+	return m, activateCmd(m.selected.IP, m.username, *m.selectedArea, m.opts)
 }
 
+// enterDelayInput asks for the capture delay, unless it was configured.
 func (m model) enterDelayInput() (model, tea.Cmd) {
-	m.delayInput = "100"
+	if m.opts.delaySet {
+		m.captureDelay = m.opts.delay
+		return m.selectDisplay()
+	}
+	m.delayInput = strconv.FormatInt(m.opts.delay.Milliseconds(), 10)
 	m.state = stateInputDelay
 	return m, nil
 }
@@ -233,9 +904,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
+			if m.textEntry() && msg.String() == "q" {
+				break
+			}
 			if m.state == stateStreaming {
-				m.state = stateStopping
-				return m, stopCmd(m.streamer, m.capturer, m.selected.IP, m.username, m.selectedArea.ID)
+				return m.stop()
 			}
 			return m, tea.Quit
 		}
@@ -245,86 +918,170 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
 
-	case scanDoneMsg:
-		if msg.err != nil {
-			m.err = msg.err
-			m.state = stateDone
-			return m, tea.Quit
+	case knownBridgeMsg:
+		if msg.err == nil {
+			return m.selectBridge(msg.bridge)
+		}
+		if msg.bridge.ID != "" {
+			m.selected = &msg.bridge
+			if m, ok := m.certificateChanged(msg.err); ok {
+				return m, nil
+			}
 		}
+		return m.scanBridges(m.opts.ssdp)
 
-		if len(msg.bridges) == 0 {
-			m.err = fmt.Errorf("no Hue bridges found on the network")
-			m.state = stateDone
-			return m, tea.Quit
+	case bridgeFoundMsg:
+		// The scan goes on until it ends, even when it is no longer needed.
+		next := msg.scan.next()
+		if msg.scan != m.scan {
+			return m, next
 		}
+		m.bridges = append(m.bridges, msg.bridge)
+		switch m.state {
+		case stateScanning:
+			m.state = stateSelecting
+		case stateSelecting:
+		default:
+			return m, next
+		}
+		if m.opts.bridge != "" && bridgeMatches(msg.bridge, m.opts.bridge) {
+			m, cmd := m.selectBridge(msg.bridge)
+			return m, tea.Batch(next, cmd)
+		}
+		return m, next
 
-		if len(msg.bridges) == 1 {
-			m.selected = &msg.bridges[0]
-			if creds, found, _ := LoadCredentials(m.selected.ID); found {
-				m.username = creds.Username
-				m.clientkey = creds.Clientkey
-				m.state = stateFetchingAreas
-				return m, fetchAreasCmd(m.selected.IP, m.username)
-			}
-			m.state = statePairing
+	case scanDoneMsg:
+		if msg.scan != m.scan {
 			return m, nil
 		}
-
-		m.bridges = msg.bridges
-		m.state = stateSelecting
+		m.scan = nil
+		if m.state != stateScanning && m.state != stateSelecting {
+			return m, nil
+		}
+		switch {
+		case len(m.bridges) == 0 && msg.err != nil:
+			return m.enterBridge(fmt.Sprintf("Discovery failed: %v.", msg.err))
+		case len(m.bridges) == 0:
+			return m.enterBridge("No Hue bridges found on the network.")
+		case len(m.bridges) == 1:
+			return m.selectBridge(m.bridges[0])
+		}
 		return m, nil
 
-	case pairResultMsg:
+	case probeResultMsg:
 		if msg.err != nil {
-			if errors.Is(msg.err, ErrLinkButtonNotPressed) {
-				m.pairErr = "Link button not pressed."
-				m.state = statePairing
-				return m, nil
+			if msg.bridge.ID != "" {
+				m.selected = &msg.bridge
+				if m, ok := m.certificateChanged(msg.err); ok {
+					return m, nil
+				}
 			}
-			m.err = fmt.Errorf("pairing failed: %w", msg.err)
-			m.state = stateDone
+			return m.enterBridge(fmt.Sprintf("No Hue bridge at %s: %v.", m.bridgeInput, msg.err))
+		}
+		return m.selectBridge(msg.bridge)
+
+	case credsWarningMsg:
+		m.credsWarning = errors.Join(m.credsWarning, msg.err)
+		return m, nil
+
+	case certPinnedMsg:
+		m.certPinned = fmt.Sprintf("Pinned the certificate of bridge %s on first use.\nSHA-256 %s", msg.bridgeID, msg.fp)
+		return m, nil
+
+	case credsLoadedMsg:
+		return m.credsLoaded(msg)
+
+	case credsDoneMsg:
+		m.credsNote = msg.note
+		m.credsErr = msg.err
+		return m.showCredentials(m.credsBack)
+
+	case pairResultMsg:
+		if msg.err != nil {
+			if m, ok := m.certificateChanged(msg.err); ok {
+				return m, nil
+			}
+			if errors.Is(msg.err, ErrLinkButtonNotPressed) {
+				m.pairErr = "Link button not pressed."
+				m.state = statePairing
+				return m, nil
+			}
+			m.err = fmt.Errorf("pairing failed: %w", msg.err)
+			m.state = stateDone
 			return m, tea.Quit
 		}
 		m.username = msg.username
 		m.clientkey = msg.clientkey
 		m.pairErr = ""
-		_ = SaveCredentials(m.selected.ID, BridgeCredentials{
-			Username:  msg.username,
-			Clientkey: msg.clientkey,
-		})
 		m.state = stateFetchingAreas
-		return m, fetchAreasCmd(m.selected.IP, m.username)
+		return m, tea.Batch(
+			saveCredsCmd(m.selected.ID, BridgeCredentials{Username: msg.username, Clientkey: msg.clientkey}),
+			fetchAreasCmd(m.selected.IP, m.username),
+		)
 
 	case areasFetchedMsg:
 		if msg.err != nil {
+			if m, ok := m.certificateChanged(msg.err); ok {
+				return m, nil
+			}
 			if errors.Is(msg.err, ErrUnauthorized) {
-				_ = DeleteCredentials(m.selected.ID)
 				m.username = ""
 				m.clientkey = ""
 				m.pairErr = "Stored credentials were rejected by the bridge."
 				m.state = statePairing
-				return m, nil
+				return m, deleteCredsCmd(m.selected.ID)
 			}
 			m.err = fmt.Errorf("fetching entertainment areas: %w", msg.err)
 			m.state = stateDone
 			return m, tea.Quit
 		}
 
+		m.areas = msg.areas
 		if len(msg.areas) == 0 {
-			m.err = fmt.Errorf("no entertainment areas configured on this bridge")
-			m.state = stateDone
-			return m, tea.Quit
+			return m.newArea()
 		}
 
-		if len(msg.areas) == 1 {
-			m.selectedArea = &msg.areas[0]
-			return m.enterDelayInput()
+		if !m.areasEdited {
+			if len(msg.areas) == 1 {
+				m.selectedArea = &msg.areas[0]
+				return m.selectSource()
+			}
+			if m.opts.area != "" {
+				if a, err := findArea(msg.areas, m.opts.area); err == nil {
+					m.selectedArea = &a
+					return m.selectSource()
+				}
+			}
 		}
 
-		m.areas = msg.areas
+		m.areaCursor = min(m.areaCursor, len(m.areas)-1)
 		m.state = stateSelectingArea
 		return m, nil
 
+	case areaLightsMsg:
+		if msg.err == nil && len(msg.lights) == 0 {
+			msg.err = errors.New("no lights on this bridge can take part in entertainment areas")
+		}
+		if msg.err != nil {
+			m.areaErr = msg.err
+			return m.leaveAreaEditor()
+		}
+		m.draft.setLights(msg.lights)
+		if m.draft.id == "" {
+			m.state = stateAreaName
+			return m, nil
+		}
+		m.draft.cursor = max(slices.Index(areaTypes, m.draft.typ), 0)
+		m.state = stateAreaType
+		return m, nil
+
+	case areaSavedMsg:
+		m.draft = nil
+		m.areaErr = msg.err
+		m.areasEdited = true
+		m.state = stateFetchingAreas
+		return m, fetchAreasCmd(m.selected.IP, m.username)
+
 	case captureInitMsg:
 		if msg.err != nil {
 			m.err = fmt.Errorf("initializing screen capture: %w", msg.err)
@@ -333,8 +1090,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		m.capturer = msg.capturer
 		m.captureMethod = msg.method
+		if m.effect == effectAudio {
+			return m.startAudio()
+		}
 		m.state = stateActivating
-		return m, activateCmd(m.selected.IP, m.username, m.selectedArea.ID)
+		return m, activateCmd(m.selected.IP, m.username, *m.selectedArea, m.opts)
+
+	case audioInitMsg:
+		if msg.err != nil {
+			m.err = fmt.Errorf("initializing audio capture: %w", msg.err)
+			if m.capturer != nil {
+				_ = m.capturer.Close()
+			}
+			m.state = stateDone
+			return m, tea.Quit
+		}
+		m.audio = msg.capture
+		m.audioMethod = msg.method
+		m.state = stateActivating
+		return m, activateCmd(m.selected.IP, m.username, *m.selectedArea, m.opts)
 
 	case activateResultMsg:
 		if msg.err != nil {
@@ -342,35 +1116,114 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.state = stateDone
 			return m, tea.Quit
 		}
+		m.lights = msg.lights
+		m.restoreErr = msg.restoreErr
 		m.state = stateConnecting
-		return m, connectCmd(m.selected.IP, m.username, m.clientkey, m.selectedArea.ID, m.selectedArea.ChannelIDs)
+		return m, connectCmd(m.selected.IP, m.username, m.clientkey, *m.selectedArea, m.opts)
 
 	case connectResultMsg:
+		m.stream = msg.stream
 		if msg.err != nil {
 			m.err = fmt.Errorf("connecting: %w", msg.err)
-			m.state = stateStopping
-			return m, stopCmd(nil, m.capturer, m.selected.IP, m.username, m.selectedArea.ID)
+			return m.stop()
+		}
+		var src colorSource
+		delay := m.captureDelay
+		switch m.effect {
+		case effectScreen:
+			pipeline, err := newColorPipeline(m.selectedArea.Channels, m.opts)
+			if err != nil {
+				m.err = err
+				return m.stop()
+			}
+			m.pipeline = pipeline
+			src = screenSource{capturer: m.capturer, pipeline: pipeline}
+		case effectAudio:
+			audio := newAudioSource(m.audio, m.selectedArea.Channels, m.opts.audioSensitivity)
+			if m.capturer != nil {
+				pipeline, err := newColorPipeline(m.selectedArea.Channels, m.opts)
+				if err != nil {
+					m.err = err
+					return m.stop()
+				}
+				audio.withScreen(screenSource{capturer: m.capturer, pipeline: pipeline}, m.captureDelay, m.opts.audioBlend)
+			}
+			src = audio
+			delay = time.Second / time.Duration(m.opts.sendRate)
+		default:
+			effect, err := newEffect(m.effect, m.selectedArea.Channels, m.opts.effectParams)
+			if err != nil {
+				m.err = err
+				return m.stop()
+			}
+			src = effectSource{effect: effect, start: time.Now()}
+			delay = time.Second / time.Duration(m.opts.sendRate)
 		}
-		m.streamer = msg.streamer
+		updates := make(chan engineStatus, 1)
+		m.updates = updates
+		m.engine = startStreamEngine(m.stream, src, delay, m.opts.sendRate, func(st engineStatus) {
+			// Keep only the latest status.
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- st
+		})
 		m.state = stateStreaming
-		return m, captureAndSendCmd(m.streamer, m.capturer)
+		if msg.appID == "" {
+			return m, waitEngineCmd(m.engine, m.updates)
+		}
+		m.watcher = watchArea(m.selected.IP, m.username, msg.appID, *m.selectedArea)
+		return m, tea.Batch(waitEngineCmd(m.engine, m.updates), waitAreaCmd(m.watcher))
 
-	case frameSentMsg:
+	case areaEventMsg:
+		e := msg.event
+		switch {
+		case e.Takeover != nil:
+			if m.state == stateStreaming {
+				m.stream.Hold(e.Takeover)
+				m.takeover = e.Takeover
+			}
+		case e.Err == nil:
+			m.lightNote = fmt.Sprintf("%s was switched %s", e.Light, onOff(e.On))
+		}
+		return m, waitAreaCmd(m.watcher)
+
+	case reclaimResultMsg:
 		if msg.err != nil {
-			m.streamErr = msg.err
-		} else {
-			m.lastColor = msg.color
+			m.streamErr = fmt.Errorf("reclaiming the area: %w", msg.err)
+			return m, nil
+		}
+		m.takeover = nil
+		m.streamErr = nil
+		return m, nil
+
+	case engineStatusMsg:
+		st := msg.status
+		m.lastColors = st.Colors
+		m.lastCrop = st.Crop
+		m.link = st.Link
+		m.streamErr = st.CaptureErr
+		if st.SendErr != nil {
+			m.streamErr = st.SendErr
+		}
+		// A reconnect attempt may notice a takeover before the watcher.
+		if t, ok := st.Link.Held.(*TakeoverError); ok {
+			m.takeover = t
 			m.streamErr = nil
 		}
-		remaining := m.captureDelay - time.Since(msg.startedAt)
-		if remaining < 0 {
-			remaining = 0
+		if st.Sent > 0 && m.lights != nil && !m.lightsMarked {
+			m.lightsMarked = true
+			return m, tea.Batch(waitEngineCmd(m.engine, m.updates), markLightsCmd(m.lights))
 		}
-		return m, streamTickCmd(remaining)
+		return m, waitEngineCmd(m.engine, m.updates)
 
-	case streamTickMsg:
+	case engineDoneMsg:
 		if m.state == stateStreaming {
-			return m, captureAndSendCmd(m.streamer, m.capturer)
+			if msg.err != nil {
+				m.err = fmt.Errorf("streaming: %w", msg.err)
+			}
+			return m.stop()
 		}
 		return m, nil
 
@@ -378,11 +1231,40 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.err != nil {
 			m.err = msg.err
 		}
+		m.skippedLights = msg.skipped
 		m.state = stateDone
 		return m, tea.Quit
 	}
 
 	switch m.state {
+	case stateSelectingProfile:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.profileCursor > 0 {
+					m.profileCursor--
+				}
+			case "down", "j":
+				// The first entry uses the settings without a profile.
+				if m.profileCursor < len(m.profiles) {
+					m.profileCursor++
+				}
+			case "enter":
+				profile := ""
+				if m.profileCursor > 0 {
+					profile = m.profiles[m.profileCursor-1]
+				}
+				opts, err := m.parser.withProfile(profile)
+				if err != nil {
+					m.err = err
+					m.state = stateDone
+					return m, tea.Quit
+				}
+				m = m.withOptions(opts)
+				return m.discover()
+			}
+		}
+
 	case stateSelecting:
 		if msg, ok := msg.(tea.KeyMsg); ok {
 			switch msg.String() {
@@ -395,14 +1277,125 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.cursor++
 				}
 			case "enter":
-				m.selected = &m.bridges[m.cursor]
-				if creds, found, _ := LoadCredentials(m.selected.ID); found {
-					m.username = creds.Username
-					m.clientkey = creds.Clientkey
-					m.state = stateFetchingAreas
-					return m, fetchAreasCmd(m.selected.IP, m.username)
+				return m.selectBridge(m.bridges[m.cursor])
+			case "m":
+				return m.enterBridge("")
+			case "c":
+				m.credsNote, m.credsErr = "", nil
+				return m.showCredentials(stateSelecting)
+			}
+		}
+
+	case stateBridgeEntry:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.Type {
+			case tea.KeyRunes:
+				m.bridgeInput += string(msg.Runes)
+			case tea.KeyBackspace:
+				if r := []rune(m.bridgeInput); len(r) > 0 {
+					m.bridgeInput = string(r[:len(r)-1])
+				}
+			case tea.KeyEnter:
+				if host := strings.TrimSpace(m.bridgeInput); host != "" {
+					return m.probe(host)
+				}
+			case tea.KeyTab:
+				// Search again, with SSDP too.
+				return m.scanBridges(true)
+			case tea.KeyEsc:
+				if len(m.bridges) > 0 {
+					m.state = stateSelecting
 				}
-				m.state = statePairing
+			}
+		}
+
+	case stateCertChanged:
+		if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "t" {
+			return m.trustCertificate()
+		}
+
+	case stateCredentials:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.credsCursor > 0 {
+					m.credsCursor--
+				}
+			case "down", "j":
+				if m.credsCursor < len(m.paired)-1 {
+					m.credsCursor++
+				}
+			case "f":
+				if len(m.paired) > 0 {
+					m.credsNote, m.credsErr = "", nil
+					m.state = stateCredsForget
+				}
+			case "e":
+				if len(m.paired) > 0 {
+					m.credsNote, m.credsErr = "", nil
+					m.credsForm = &credsForm{export: true}
+					m.state = stateCredsFile
+				}
+			case "i":
+				m.credsNote, m.credsErr = "", nil
+				m.credsForm = &credsForm{}
+				m.state = stateCredsFile
+			case "esc":
+				return m.leaveCredentials()
+			}
+		}
+
+	case stateCredsForget:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "y", "l":
+				m.state = stateCredsWorking
+				return m, forgetCmd(m.paired[m.credsCursor], m.opts, msg.String() == "l")
+			case "n", "esc":
+				m.state = stateCredentials
+			}
+		}
+
+	case stateCredsFile:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			f := m.credsForm
+			switch msg.Type {
+			case tea.KeyRunes, tea.KeySpace:
+				f.path += string(msg.Runes)
+			case tea.KeyBackspace:
+				if r := []rune(f.path); len(r) > 0 {
+					f.path = string(r[:len(r)-1])
+				}
+			case tea.KeyEsc:
+				m.credsForm = nil
+				m.state = stateCredentials
+			case tea.KeyEnter:
+				if f.path = strings.TrimSpace(f.path); f.path != "" {
+					m.state = stateCredsPassphrase
+				}
+			}
+		}
+
+	case stateCredsPassphrase:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			f := m.credsForm
+			entry := &f.passphrase
+			if f.repeating {
+				entry = &f.repeat
+			}
+			switch msg.Type {
+			case tea.KeyRunes, tea.KeySpace:
+				*entry += string(msg.Runes)
+			case tea.KeyBackspace:
+				if r := []rune(*entry); len(r) > 0 {
+					*entry = string(r[:len(r)-1])
+				}
+			case tea.KeyEsc:
+				m.credsForm = nil
+				m.credsErr = nil
+				m.state = stateCredentials
+			case tea.KeyEnter:
+				return m.submitCredsForm()
 			}
 		}
 
@@ -428,7 +1421,154 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case "enter":
 				m.selectedArea = &m.areas[m.areaCursor]
-				return m.enterDelayInput()
+				return m.selectSource()
+			case "n":
+				m.areaErr = nil
+				return m.newArea()
+			case "e":
+				m.areaErr = nil
+				return m.editArea(m.areas[m.areaCursor])
+			case "r":
+				a := m.areas[m.areaCursor]
+				m.areaErr = nil
+				m.draft = &areaDraft{id: a.ID, name: a.Name, rename: true}
+				m.state = stateAreaName
+			case "d":
+				a := m.areas[m.areaCursor]
+				m.areaErr = nil
+				m.draft = &areaDraft{id: a.ID, name: a.Name}
+				m.state = stateAreaDelete
+			case "c":
+				m.credsNote, m.credsErr = "", nil
+				return m.showCredentials(stateSelectingArea)
+			}
+		}
+
+	case stateAreaName:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.Type {
+			case tea.KeyRunes, tea.KeySpace:
+				if utf8.RuneCountInString(m.draft.name)+len(msg.Runes) <= maxAreaNameLength {
+					m.draft.name += string(msg.Runes)
+				}
+			case tea.KeyBackspace:
+				if r := []rune(m.draft.name); len(r) > 0 {
+					m.draft.name = string(r[:len(r)-1])
+				}
+			case tea.KeyEsc:
+				return m.leaveAreaEditor()
+			case tea.KeyEnter:
+				m.draft.name = strings.TrimSpace(m.draft.name)
+				if m.draft.name == "" {
+					break
+				}
+				if m.draft.rename {
+					return m.saveArea()
+				}
+				m.draft.cursor = max(slices.Index(areaTypes, m.draft.typ), 0)
+				m.state = stateAreaType
+			}
+		}
+
+	case stateAreaType:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.draft.cursor > 0 {
+					m.draft.cursor--
+				}
+			case "down", "j":
+				if m.draft.cursor < len(areaTypes)-1 {
+					m.draft.cursor++
+				}
+			case "esc":
+				return m.leaveAreaEditor()
+			case "enter":
+				m.draft.typ = areaTypes[m.draft.cursor]
+				m.draft.cursor = 0
+				m.state = stateAreaLights
+			}
+		}
+
+	case stateAreaLights:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.draft.cursor > 0 {
+					m.draft.cursor--
+				}
+			case "down", "j":
+				if m.draft.cursor < len(m.draft.lights)-1 {
+					m.draft.cursor++
+				}
+			case " ", "x":
+				m.draft.chosen[m.draft.cursor] = !m.draft.chosen[m.draft.cursor]
+			case "esc":
+				return m.leaveAreaEditor()
+			case "enter":
+				if len(m.draft.placed()) > 0 {
+					m.draft.placeNew()
+					m.draft.cursor = 0
+					m.state = stateAreaPlace
+				}
+			}
+		}
+
+	case stateAreaPlace:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			n := len(m.draft.placed())
+			switch msg.String() {
+			case "tab":
+				m.draft.cursor = (m.draft.cursor + 1) % n
+			case "shift+tab":
+				m.draft.cursor = (m.draft.cursor + n - 1) % n
+			case "left", "h":
+				m.draft.move(-1, 0)
+			case "right", "l":
+				m.draft.move(1, 0)
+			case "up", "k":
+				m.draft.move(0, -1)
+			case "down", "j":
+				m.draft.move(0, 1)
+			case "esc":
+				return m.leaveAreaEditor()
+			case "enter":
+				return m.saveArea()
+			}
+		}
+
+	case stateAreaDelete:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "y":
+				m.state = stateAreaSaving
+				return m, deleteAreaCmd(m.selected.IP, m.username, m.draft.id)
+			case "n", "esc":
+				return m.leaveAreaEditor()
+			}
+		}
+
+	case stateSelectingSource:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.sourceCursor > 0 {
+					m.sourceCursor--
+				}
+			case "down", "j":
+				// The first entries are screen sync and the audio mode,
+				// the others the effects.
+				if m.sourceCursor < len(effectNames)+1 {
+					m.sourceCursor++
+				}
+			case "enter":
+				switch m.sourceCursor {
+				case 0:
+					return m.useSource(effectScreen)
+				case 1:
+					return m.useSource(effectAudio)
+				}
+				return m.useSource(effectNames[m.sourceCursor-2])
 			}
 		}
 
@@ -444,19 +1584,105 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "enter":
 				ms, err := strconv.Atoi(m.delayInput)
 				if err != nil || ms <= 0 {
-					ms = 100
+					m.captureDelay = m.opts.delay
+				} else {
+					m.captureDelay = time.Duration(ms) * time.Millisecond
+				}
+				return m.selectDisplay()
+			}
+		}
+
+	case stateSelectingDisplay:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "up", "k":
+				if m.displayCursor > 0 {
+					m.displayCursor--
+				}
+			case "down", "j":
+				// The last entry spans all displays.
+				if m.displayCursor < len(m.displays) {
+					m.displayCursor++
+				}
+			case "enter":
+				if m.displayCursor == len(m.displays) {
+					m.display = spanDisplays
+				} else {
+					m.display = m.displays[m.displayCursor].Index
 				}
-				m.captureDelay = time.Duration(ms) * time.Millisecond
 				return m.startStreaming()
 			}
 		}
+
+	case stateStreaming:
+		if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "r" && m.takeover != nil {
+			return m, reclaimCmd(m.selected.IP, m.username, m.selectedArea.ID, m.stream)
+		}
+		// The other keys tune screen sync.
+		if msg, ok := msg.(tea.KeyMsg); ok && m.pipeline != nil {
+			switch msg.String() {
+			case "c":
+				m.colorMode = nextExtractorName(m.colorMode)
+				_ = m.pipeline.SetColorMode(m.colorMode)
+			case "+", "=":
+				tau, snap := m.pipeline.Smoothing()
+				m.pipeline.SetSmoothing(tau+smoothingStep, snap)
+			case "-":
+				tau, snap := m.pipeline.Smoothing()
+				m.pipeline.SetSmoothing(tau-smoothingStep, snap)
+			case "]":
+				tau, snap := m.pipeline.Smoothing()
+				m.pipeline.SetSmoothing(tau, snap+snapStep)
+			case "[":
+				tau, snap := m.pipeline.Smoothing()
+				m.pipeline.SetSmoothing(tau, snap-snapStep)
+			}
+		}
 	}
 
 	return m, nil
 }
 
+// credsWarningView shows the credential store's warning, if any, on the
+// screens that deal with credentials.
+func (m model) credsWarningView() string {
+	if m.credsWarning == nil {
+		return ""
+	}
+	s := "\n"
+	for line := range strings.Lines(m.credsWarning.Error()) {
+		s += errStyle.Render("  ⚠ "+strings.TrimSuffix(line, "\n")) + "\n"
+	}
+	return s
+}
+
+// certPinnedView shows which certificate was pinned on first use, if one was.
+func (m model) certPinnedView() string {
+	if m.certPinned == "" {
+		return ""
+	}
+	s := "\n"
+	for line := range strings.Lines(m.certPinned) {
+		s += helpStyle.Render("  "+strings.TrimSuffix(line, "\n")) + "\n"
+	}
+	return s
+}
+
 func (m model) View() string {
 	switch m.state {
+	case stateSelectingProfile:
+		s := "\n" + titleStyle.Render("  Select a profile:") + "\n\n"
+		labels := append([]string{"(no profile)"}, m.profiles...)
+		for i, label := range labels {
+			if i == m.profileCursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · q quit") + "\n"
+		return s
+
 	case stateScanning:
 		return fmt.Sprintf("\n %s %s\n\n",
 			m.spinner.View(),
@@ -472,11 +1698,116 @@ func (m model) View() string {
 				s += itemStyle.Render(label) + "\n"
 			}
 		}
-		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · q quit") + "\n"
+		if m.scan != nil {
+			s += fmt.Sprintf("\n %s %s\n", m.spinner.View(), helpStyle.Render("Searching for more bridges..."))
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · m enter an address · c credentials · q quit") + "\n"
 		return s
 
-	case statePairing:
+	case stateBridgeEntry:
 		s := "\n"
+		if m.bridgeNote != "" {
+			s += errStyle.Render("  "+m.bridgeNote) + "\n\n"
+		}
+		s += titleStyle.Render("  IP address or host name of your Hue bridge:") + "\n\n"
+		s += fmt.Sprintf("  > %s\n", m.bridgeInput)
+		help := "  enter connect · tab search again, with SSDP · "
+		if len(m.bridges) > 0 {
+			help += "esc back · "
+		}
+		s += "\n" + helpStyle.Render(help+"ctrl+c quit") + "\n"
+		return s
+
+	case stateProbing:
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render("Connecting to "+m.bridgeInput+"..."))
+
+	case stateCertChanged:
+		c := m.certChanged
+		s := "\n" + errStyle.Render(fmt.Sprintf("  ⚠ The certificate of bridge %s (%s) has changed.", m.selected.Name, c.BridgeID)) + "\n\n"
+		s += fmt.Sprintf("  Pinned:    %s\n  Presented: %s\n\n", c.Pinned, c.Presented)
+		s += "  If you reset or replaced the bridge, trust its new certificate.\n"
+		s += "  Otherwise another device may be impersonating the bridge: quit\n"
+		s += "  and check your network before sending it your credentials.\n\n"
+		s += helpStyle.Render("  t trust the new certificate · q quit") + "\n"
+		return s
+
+	case stateCredentials:
+		s := m.credsWarningView() + "\n" + titleStyle.Render("  Paired bridges:") + "\n\n"
+		if len(m.paired) == 0 {
+			s += itemStyle.Render("None yet.") + "\n"
+		}
+		for i, p := range m.paired {
+			label := p.String()
+			if i == m.credsCursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		if m.credsNote != "" {
+			s += "\n" + helpStyle.Render("  "+m.credsNote) + "\n"
+		}
+		if m.credsErr != nil {
+			s += "\n" + errStyle.Render("  Error: "+m.credsErr.Error()) + "\n"
+		}
+		help := "  ↑/k up · ↓/j down · f forget · e export · i import · esc back · q quit"
+		if len(m.paired) == 0 {
+			help = "  i import · esc back · q quit"
+		}
+		s += "\n" + helpStyle.Render(help) + "\n"
+		return s
+
+	case stateCredsForget:
+		s := "\n" + titleStyle.Render(fmt.Sprintf("  Forget %s?", m.paired[m.credsCursor].ID)) + "\n\n"
+		s += "  huesync's key is removed from the bridge too, so pairing again\n"
+		s += "  takes the link button.\n\n"
+		s += helpStyle.Render("  y forget · l forget only on this computer · n keep") + "\n"
+		return s
+
+	case stateCredsFile:
+		title := "  Bundle to import the credentials from:"
+		if m.credsForm.export {
+			title = "  File to export the credentials to:"
+		}
+		s := "\n" + titleStyle.Render(title) + "\n\n"
+		s += fmt.Sprintf("  > %s\n", m.credsForm.path)
+		s += "\n" + helpStyle.Render("  type a path · enter continue · esc cancel") + "\n"
+		return s
+
+	case stateCredsPassphrase:
+		f := m.credsForm
+		title, entry := "  Passphrase of the bundle:", f.passphrase
+		switch {
+		case f.repeating:
+			title, entry = "  Repeat the passphrase:", f.repeat
+		case f.export:
+			title = fmt.Sprintf("  Passphrase to encrypt the bundle with (at least %d characters):", minPassphraseLength)
+		}
+		s := "\n" + titleStyle.Render(title) + "\n\n"
+		s += fmt.Sprintf("  > %s\n", strings.Repeat("•", len([]rune(entry))))
+		if m.credsErr != nil {
+			s += "\n" + errStyle.Render("  Error: "+m.credsErr.Error()) + "\n"
+		}
+		s += "\n" + helpStyle.Render("  type the passphrase · enter continue · esc cancel") + "\n"
+		return s
+
+	case stateCredsWorking:
+		label := "Forgetting the bridge..."
+		switch {
+		case m.credsForm == nil:
+		case m.credsForm.export:
+			label = "Exporting credentials..."
+		default:
+			label = "Importing credentials..."
+		}
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render(label))
+
+	case statePairing:
+		s := m.credsWarningView() + m.certPinnedView() + "\n"
 		if m.pairErr != "" {
 			s += errStyle.Render("  "+m.pairErr) + "\n\n"
 		}
@@ -489,13 +1820,18 @@ func (m model) View() string {
 			m.spinner.View(),
 			titleStyle.Render("Pairing with bridge..."))
 
+	case stateLoadingCreds:
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render("Loading credentials..."))
+
 	case stateFetchingAreas:
 		return fmt.Sprintf("\n %s %s\n\n",
 			m.spinner.View(),
 			titleStyle.Render("Fetching entertainment areas..."))
 
 	case stateSelectingArea:
-		s := "\n" + titleStyle.Render("  Select an Entertainment Area:") + "\n\n"
+		s := m.credsWarningView() + m.certPinnedView() + "\n" + titleStyle.Render("  Select an Entertainment Area:") + "\n\n"
 		for i, a := range m.areas {
 			label := a.String()
 			if i == m.areaCursor {
@@ -504,6 +1840,99 @@ func (m model) View() string {
 				s += itemStyle.Render(label) + "\n"
 			}
 		}
+		if m.areaErr != nil {
+			s += "\n" + errStyle.Render("  Error: "+m.areaErr.Error()) + "\n"
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · n new · e edit · r rename · d delete · c credentials · q quit") + "\n"
+		return s
+
+	case stateAreaLoading:
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render("Fetching lights..."))
+
+	case stateAreaName:
+		s := "\n"
+		switch {
+		case m.draft.rename:
+			s += titleStyle.Render("  New name of the area:") + "\n\n"
+		case len(m.areas) == 0:
+			s += titleStyle.Render("  This bridge has no entertainment areas yet. Name the new area:") + "\n\n"
+		default:
+			s += titleStyle.Render("  Name of the new area:") + "\n\n"
+		}
+		s += fmt.Sprintf("  > %s\n", m.draft.name)
+		s += "\n" + helpStyle.Render("  type a name · enter confirm · esc cancel") + "\n"
+		return s
+
+	case stateAreaType:
+		s := "\n" + titleStyle.Render(fmt.Sprintf("  What is %s for?", m.draft.name)) + "\n\n"
+		for i, t := range areaTypes {
+			label := describeAreaType(t)
+			if i == m.draft.cursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · esc cancel") + "\n"
+		return s
+
+	case stateAreaLights:
+		s := "\n" + titleStyle.Render(fmt.Sprintf("  Lights in %s:", m.draft.name)) + "\n\n"
+		for i, l := range m.draft.lights {
+			box := "[ ]"
+			if m.draft.chosen[i] {
+				box = "[x]"
+			}
+			label := box + " " + l.Name
+			if i == m.draft.cursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · space toggle · enter continue · esc cancel") + "\n"
+		return s
+
+	case stateAreaPlace:
+		s := "\n" + titleStyle.Render(fmt.Sprintf("  Place the lights of %s:", m.draft.name)) + "\n\n"
+		s += renderAreaGrid(*m.draft) + "\n"
+		for i, j := range m.draft.placed() {
+			h, v := planePosition(m.draft.typ, m.draft.positions[j])
+			label := fmt.Sprintf("%d %s (%+.1f, %+.1f)", i+1, m.draft.lights[j].Name, h, v)
+			if i == m.draft.cursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		s += "\n" + helpStyle.Render("  ←↑↓→/hjkl move · tab next light · enter save · esc cancel") + "\n"
+		return s
+
+	case stateAreaDelete:
+		s := "\n" + titleStyle.Render(fmt.Sprintf("  Delete %s?", m.draft.name)) + "\n\n"
+		s += helpStyle.Render("  y delete · n keep") + "\n"
+		return s
+
+	case stateAreaSaving:
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render("Saving entertainment area..."))
+
+	case stateSelectingSource:
+		s := "\n" + titleStyle.Render("  What should the lights show?") + "\n\n"
+		labels := []string{"Screen — sync to the screen content", "Audio — react to the music playing"}
+		for _, name := range effectNames {
+			labels = append(labels, name+" — "+effectDescriptions[name])
+		}
+		for i, label := range labels {
+			if i == m.sourceCursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
 		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · q quit") + "\n"
 		return s
 
@@ -513,11 +1942,33 @@ func (m model) View() string {
 		s += "\n" + helpStyle.Render("  type a number · enter confirm · q quit") + "\n"
 		return s
 
+	case stateSelectingDisplay:
+		s := "\n" + titleStyle.Render("  Select a display to capture:") + "\n\n"
+		labels := make([]string, 0, len(m.displays)+1)
+		for _, d := range m.displays {
+			labels = append(labels, d.String())
+		}
+		labels = append(labels, "All displays (span)")
+		for i, label := range labels {
+			if i == m.displayCursor {
+				s += selectedStyle.Render("▸ "+label) + "\n"
+			} else {
+				s += itemStyle.Render(label) + "\n"
+			}
+		}
+		s += "\n" + helpStyle.Render("  ↑/k up · ↓/j down · enter select · q quit") + "\n"
+		return s
+
 	case stateInitCapture:
 		return fmt.Sprintf("\n %s %s\n\n",
 			m.spinner.View(),
 			titleStyle.Render("Initializing screen capture..."))
 
+	case stateInitAudio:
+		return fmt.Sprintf("\n %s %s\n\n",
+			m.spinner.View(),
+			titleStyle.Render("Initializing audio capture..."))
+
 	case stateActivating:
 		return fmt.Sprintf("\n %s %s\n\n",
 			m.spinner.View(),
@@ -530,15 +1981,53 @@ func (m model) View() string {
 
 	case stateStreaming:
 		s := "\n" + titleStyle.Render("  Streaming") + "\n\n"
+		if m.opts.profile != "" {
+			s += fmt.Sprintf("  Profile: %s\n", m.opts.profile)
+		}
 		s += fmt.Sprintf("  Bridge:  %s\n", m.selected)
 		s += fmt.Sprintf("  Area:    %s\n", m.selectedArea)
-		s += fmt.Sprintf("  Capture: %s\n", m.captureMethod)
-		s += fmt.Sprintf("  Delay:   %dms\n", m.captureDelay.Milliseconds())
-		s += fmt.Sprintf("  Color:   %s\n", m.lastColor)
+		switch m.effect {
+		case effectAudio:
+			s += fmt.Sprintf("  Audio:   %s (%s), sensitivity %.0f%%\n", m.audioMethod, m.opts.audioSource, m.opts.audioSensitivity*100)
+			if m.capturer != nil {
+				s += fmt.Sprintf("  Blend:   %.0f%% %s (%s), every %dms\n", m.opts.audioBlend*100, m.captureMethod, displayLabel(m.display), m.captureDelay.Milliseconds())
+			}
+			s += fmt.Sprintf("  Send:    %d fps, %s\n", m.opts.sendRate, m.opts.colorSpace)
+		case effectScreen:
+			s += fmt.Sprintf("  Capture: %s (%s)\n", m.captureMethod, displayLabel(m.display))
+			s += fmt.Sprintf("  Delay:   %dms capture, %d fps send\n", m.captureDelay.Milliseconds(), m.opts.sendRate)
+			s += fmt.Sprintf("  Mode:    %s, %s\n", m.colorMode, m.opts.colorSpace)
+			tau, snap := m.pipeline.Smoothing()
+			s += fmt.Sprintf("  Smooth:  %s\n", describeSmoothing(tau, snap))
+		default:
+			s += fmt.Sprintf("  Effect:  %s\n", describeEffect(m.effect, m.opts.effectParams))
+			s += fmt.Sprintf("  Send:    %d fps, %s\n", m.opts.sendRate, m.opts.colorSpace)
+		}
+		s += fmt.Sprintf("  Restore: %s\n", describeRestore(m.opts, m.restoreErr))
+		if m.opts.record != "" {
+			s += fmt.Sprintf("  Record:  %s\n", m.opts.record)
+		}
+		if m.pipeline != nil {
+			s += fmt.Sprintf("  Crop:    %s\n", describeCrop(m.lastCrop))
+		}
+		s += fmt.Sprintf("  Link:    %s\n", describeLink(m.link, time.Now()))
+		s += fmt.Sprintf("  Colors:  %s\n", renderSwatches(m.lastColors))
+		if m.lightNote != "" {
+			s += fmt.Sprintf("  Lights:  %s\n", m.lightNote)
+		}
 		if m.streamErr != nil {
 			s += errStyle.Render(fmt.Sprintf("  Error:  %s", m.streamErr)) + "\n"
 		}
-		s += "\n" + helpStyle.Render("  q quit") + "\n"
+		if m.takeover != nil {
+			s += "\n" + errStyle.Render(fmt.Sprintf("  Area taken over by %s. Streaming is paused.", m.takeover.Owner)) + "\n"
+			s += "\n" + helpStyle.Render(fmt.Sprintf("  r reclaim the area · q stop and leave it to %s", m.takeover.Owner)) + "\n"
+			return s
+		}
+		if m.pipeline == nil {
+			s += "\n" + helpStyle.Render("  q quit") + "\n"
+		} else {
+			s += "\n" + helpStyle.Render("  c color mode · +/- smoothing · [/] scene-cut threshold · q quit") + "\n"
+		}
 		return s
 
 	case stateStopping:
@@ -550,7 +2039,236 @@ func (m model) View() string {
 		if m.err != nil {
 			return "\n" + errStyle.Render("  Error: "+m.err.Error()) + "\n\n"
 		}
+		if m.takeover != nil {
+			return "\n" + helpStyle.Render(fmt.Sprintf("  Left the area and its lights to %s.", m.takeover.Owner)) + "\n\n"
+		}
+		if len(m.skippedLights) > 0 {
+			return "\n" + helpStyle.Render("  Not restored, changed during the session: "+strings.Join(m.skippedLights, ", ")) + "\n\n"
+		}
 	}
 
 	return ""
 }
+
+// credsForm is an export or import of credentials being entered in the TUI.
+type credsForm struct {
+	export     bool
+	path       string
+	passphrase string
+	// An export asks for the passphrase twice; repeat holds the second
+	// entry once repeating.
+	repeat    string
+	repeating bool
+}
+
+// areaDraft is an entertainment area being created or changed in the TUI.
+type areaDraft struct {
+	id     string // empty for a new area
+	rename bool   // only the name changes
+	name   string
+	typ    string
+	// current holds the lights of the area being edited.
+	current []AreaLight
+
+	// lights are all lights that can take part; chosen and positions are
+	// indexed like them, and positioned tells which have a position yet.
+	lights     []EntertainmentLight
+	chosen     []bool
+	positions  []Position
+	positioned []bool
+	// cursor is the selected entry of the type or light list, or while
+	// placing, the index into placed.
+	cursor int
+}
+
+// setLights offers lights, choosing all for a new area and the area's own
+// for one being edited.
+func (d *areaDraft) setLights(lights []EntertainmentLight) {
+	d.lights = lights
+	d.chosen = make([]bool, len(lights))
+	d.positions = make([]Position, len(lights))
+	d.positioned = make([]bool, len(lights))
+	for i, l := range lights {
+		d.chosen[i] = d.id == ""
+		for _, c := range d.current {
+			if c.ServiceID == l.ServiceID {
+				d.chosen[i], d.positions[i], d.positioned[i] = true, c.Position, true
+			}
+		}
+	}
+}
+
+// placed returns the indices of the chosen lights.
+func (d *areaDraft) placed() []int {
+	var out []int
+	for i, c := range d.chosen {
+		if c {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// placeNew spreads the chosen lights that have no position yet from left to
+// right.
+func (d *areaDraft) placeNew() {
+	var unplaced []int
+	for _, i := range d.placed() {
+		if !d.positioned[i] {
+			unplaced = append(unplaced, i)
+		}
+	}
+	for k, p := range defaultPositions(len(unplaced)) {
+		i := unplaced[k]
+		d.positions[i], d.positioned[i] = p, true
+	}
+}
+
+// move moves the selected light by one grid cell.
+func (d *areaDraft) move(dcol, drow int) {
+	i := d.placed()[d.cursor]
+	col, row := gridCell(d.typ, d.positions[i])
+	col = min(max(col+dcol, 0), areaGridSize-1)
+	row = min(max(row+drow, 0), areaGridSize-1)
+	d.positions[i] = cellPosition(d.typ, d.positions[i], col, row)
+}
+
+func (d *areaDraft) spec() AreaSpec {
+	if d.rename {
+		return AreaSpec{Name: d.name}
+	}
+	spec := AreaSpec{Name: d.name, Type: d.typ}
+	for _, i := range d.placed() {
+		spec.Lights = append(spec.Lights, AreaLight{ServiceID: d.lights[i].ServiceID, Position: d.positions[i]})
+	}
+	return spec
+}
+
+// renderAreaGrid draws the editing plane with the number of the light in
+// each cell; the selected light is highlighted, and a + marks a cell shared
+// by several lights.
+func renderAreaGrid(d areaDraft) string {
+	var cells [areaGridSize][areaGridSize][]int
+	for k, i := range d.placed() {
+		col, row := gridCell(d.typ, d.positions[i])
+		cells[row][col] = append(cells[row][col], k)
+	}
+
+	caption := "  Seen from above, the front of the room at the top"
+	if d.typ == "screen" {
+		caption = "  Seen from the front, the top of the screen at the top"
+	}
+	s := helpStyle.Render(caption) + "\n\n"
+	for _, row := range cells {
+		s += "  "
+		for _, cell := range row {
+			if len(cell) == 0 {
+				s += " ·  "
+				continue
+			}
+			shown := cell[0]
+			if slices.Contains(cell, d.cursor) {
+				shown = d.cursor
+			}
+			label := strconv.Itoa(shown + 1)
+			if len(cell) > 1 {
+				label += "+"
+			}
+			label = fmt.Sprintf("%-3s", label)
+			if shown == d.cursor {
+				label = selectedStyle.Render(label)
+			}
+			s += " " + label
+		}
+		s += "\n"
+	}
+	return s
+}
+
+// displayLabel describes a display selection for the streaming view.
+func displayLabel(display int) string {
+	if display == spanDisplays {
+		return "all displays"
+	}
+	return fmt.Sprintf("display %d", display)
+}
+
+// describeCrop describes the black bars excluded from a capture frame.
+func describeCrop(crop image.Rectangle) string {
+	if crop.Empty() {
+		return "—"
+	}
+	v := crop.Min.Y
+	h := crop.Min.X
+	switch {
+	case v == 0 && h == 0:
+		return "none"
+	case h == 0:
+		return fmt.Sprintf("letterbox, %dx%d of %dx%d", crop.Dx(), crop.Dy(), captureWidth, captureHeight)
+	case v == 0:
+		return fmt.Sprintf("pillarbox, %dx%d of %dx%d", crop.Dx(), crop.Dy(), captureWidth, captureHeight)
+	default:
+		return fmt.Sprintf("windowbox, %dx%d of %dx%d", crop.Dx(), crop.Dy(), captureWidth, captureHeight)
+	}
+}
+
+// describeLink describes the state of the stream connection.
+func describeLink(l linkStatus, now time.Time) string {
+	if l.Held != nil {
+		return "on hold"
+	}
+	if l.Reconnecting {
+		s := "reconnecting"
+		if l.Attempt > 0 {
+			s += fmt.Sprintf(", attempt %d/%d failed", l.Attempt, l.MaxAttempts)
+		}
+		if wait := l.RetryAt.Sub(now); wait > 0 {
+			s += fmt.Sprintf(", next in %s", wait.Round(time.Second))
+		}
+		return s
+	}
+	if l.Reconnects > 0 {
+		return fmt.Sprintf("connected (reconnected %d×)", l.Reconnects)
+	}
+	return "connected"
+}
+
+// describeRestore describes whether the lights are restored after streaming.
+func describeRestore(opts options, err error) string {
+	switch {
+	case !opts.restore:
+		return "off"
+	case err != nil:
+		return fmt.Sprintf("unavailable (%v)", err)
+	case opts.restoreFade > 0:
+		return fmt.Sprintf("on, %dms fade", opts.restoreFade.Milliseconds())
+	}
+	return "on"
+}
+
+// describeSmoothing describes the smoothing settings for the streaming view.
+func describeSmoothing(tau time.Duration, snap float64) string {
+	s := "off"
+	if tau > 0 {
+		s = fmt.Sprintf("%dms", tau.Milliseconds())
+	}
+	if snap > 0 {
+		s += fmt.Sprintf(", snap above %.0f", snap)
+	} else {
+		s += ", snap off"
+	}
+	return s
+}
+
+// renderSwatches renders one colored block per channel followed by the hex
+// value of each color.
+func renderSwatches(colors []RGB) string {
+	var s string
+	for i, c := range colors {
+		if i > 0 {
+			s += " "
+		}
+		s += lipgloss.NewStyle().Foreground(lipgloss.Color(c.String())).Render("██") + " " + c.String()
+	}
+	return s
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// useCapturer makes the commands capture from c.
+func useCapturer(t *testing.T, c Capturer) {
+	t.Helper()
+	orig := openCapturer
+	t.Cleanup(func() { openCapturer = orig })
+	openCapturer = func(string, int) (Capturer, string, error) {
+		return c, "fake", nil
+	}
+}
+
+// modelDriver feeds a model under test the messages of its commands. Like
+// Bubble Tea, it runs the commands concurrently, and commands still running
+// when run returns keep delivering to later calls.
+type modelDriver struct {
+	t       *testing.T
+	msgs    chan tea.Msg
+	pending int
+}
+
+func newModelDriver(t *testing.T) *modelDriver {
+	return &modelDriver{t: t, msgs: make(chan tea.Msg, 64)}
+}
+
+func (d *modelDriver) start(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	d.pending++
+	go func() { d.msgs <- cmd() }()
+}
+
+// run runs cmd and feeds the resulting messages to m, running the commands
+// they return, until done reports true. Spinner ticks are dropped.
+func (d *modelDriver) run(m model, cmd tea.Cmd, done func(model) bool) model {
+	d.t.Helper()
+	deadline := time.After(10 * time.Second)
+	d.start(cmd)
+	for !done(m) {
+		if d.pending == 0 {
+			d.t.Fatalf("no pending commands in state %d (err %v)", m.state, m.err)
+		}
+		var msg tea.Msg
+		select {
+		case msg = <-d.msgs:
+			d.pending--
+		case <-deadline:
+			d.t.Fatalf("timed out in state %d", m.state)
+		}
+		switch msg := msg.(type) {
+		case nil, spinner.TickMsg, tea.QuitMsg:
+		case tea.BatchMsg:
+			for _, c := range msg {
+				d.start(c)
+			}
+		default:
+			next, cmd := m.Update(msg)
+			m = next.(model)
+			d.start(cmd)
+		}
+	}
+	return m
+}
+
+func pressKey(m model, key tea.KeyMsg) (model, tea.Cmd) {
+	next, cmd := m.Update(key)
+	return next.(model), cmd
+}
+
+// typeText presses the keys of text one by one.
+func typeText(m model, text string) model {
+	for _, r := range text {
+		m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	return m
+}
+
+func TestModel_PairStreamStop(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 3)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	initial := bridge.Lights()
+
+	opts := defaultOptions()
+	opts.delay = 20 * time.Millisecond
+	opts.delaySet = true
+	opts.displaySet = true
+	opts.effect = effectScreen
+	d := newModelDriver(t)
+	m := newModel(opts, nil)
+
+	// Discovery finds the single bridge and, without credentials, asks to pair.
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == statePairing })
+
+	// Pairing fails until the link button is pressed.
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == statePairing })
+	if m.pairErr == "" {
+		t.Fatal("expected a link button error")
+	}
+	// The mock bridge's certificate was pinned on the way; the note says so.
+	next, _ := m.Update(certPinnedMsg{bridgeID: bridge.ID, fp: "00:11"})
+	if v := next.(model).View(); !strings.Contains(v, "Pinned the certificate of bridge "+bridge.ID) || !strings.Contains(v, "SHA-256 00:11") {
+		t.Errorf("expected a note about the pinned certificate, got %q", v)
+	}
+
+	bridge.PressLinkButton()
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool {
+		_, received := bridge.Frames()
+		return m.state == stateStreaming && len(m.lastColors) == 3 && received > 0
+	})
+
+	if _, found, _ := LoadCredentials(bridge.ID); !found {
+		t.Error("expected credentials to be saved")
+	}
+	if got := bridge.Area().Status; got != "active" {
+		t.Errorf("expected the area to be active, got %q", got)
+	}
+	frames, _ := bridge.Frames()
+	for _, ch := range frames[len(frames)-1].Channels {
+		if ch.Values[0] == 0 || ch.Values[1] != 0 || ch.Values[2] != 0 {
+			t.Errorf("expected red on channel %d, got %v", ch.ID, ch.Values)
+		}
+	}
+
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateDone })
+
+	if m.err != nil {
+		t.Fatalf("unexpected error: %v", m.err)
+	}
+	if got := bridge.Area().Status; got != "inactive" {
+		t.Errorf("expected the area to be inactive, got %q", got)
+	}
+	if len(m.skippedLights) > 0 {
+		t.Errorf("expected all lights to be restored, skipped %v", m.skippedLights)
+	}
+	if got := bridge.Lights(); !reflect.DeepEqual(got, initial) {
+		t.Errorf("expected the lights to be restored to %+v, got %+v", initial, got)
+	}
+}
+
+func TestModel_Effect(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, nil)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateSelectingSource })
+
+	// The first effect, candle, follows screen sync and audio in the list.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool {
+		_, received := bridge.Frames()
+		return m.state == stateStreaming && len(m.lastColors) == 2 && received > 0
+	})
+	if m.effect != "candle" || m.capturer != nil {
+		t.Errorf("expected the candle effect without capture, got %q, %v", m.effect, m.capturer)
+	}
+	for _, c := range m.lastColors {
+		if c.R == 0 || c.B > c.R {
+			t.Errorf("expected a warm color, got %v", c)
+		}
+	}
+
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateDone })
+	if m.err != nil {
+		t.Fatalf("unexpected error: %v", m.err)
+	}
+}
+
+func TestModel_Audio(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, nil)
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	audio := &fakeAudioCapture{samples: samples, rate: rate, hop: rate / 50}
+	useAudioCapture(t, audio)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateSelectingSource })
+
+	// Without blending, the audio mode needs no screen capture.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool {
+		_, received := bridge.Frames()
+		return m.state == stateStreaming && len(m.lastColors) == 2 && received > 0
+	})
+	if m.effect != effectAudio || m.capturer != nil || m.audio == nil {
+		t.Errorf("expected audio without screen capture, got %q, %v, %v", m.effect, m.capturer, m.audio)
+	}
+	if view := m.View(); !strings.Contains(view, "Audio:   fake") {
+		t.Errorf("expected the audio capture in the view, got %q", view)
+	}
+
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateDone })
+	if m.err != nil {
+		t.Fatalf("unexpected error: %v", m.err)
+	}
+	if !audio.Closed() {
+		t.Error("expected the audio capture to be closed")
+	}
+}
+
+func TestModel_Takeover(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, &scriptedCapturer{frames: -1})
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	streamer := func() string {
+		bridge.mu.Lock()
+		defer bridge.mu.Unlock()
+		return bridge.streamers[bridge.areas[0].ID]
+	}
+
+	opts := defaultOptions()
+	opts.delay = 20 * time.Millisecond
+	opts.delaySet = true
+	opts.displaySet = true
+	opts.effect = effectScreen
+	d := newModelDriver(t)
+	m := newModel(opts, nil)
+	m = d.run(m, m.Init(), func(m model) bool {
+		_, received := bridge.Frames()
+		return m.state == stateStreaming && m.watcher != nil && received > 0
+	})
+
+	bridge.TakeOver("Hue Sync#DESKTOP")
+	m = d.run(m, nil, func(m model) bool { return m.takeover != nil })
+	if m.takeover.Owner != "Hue Sync (DESKTOP)" {
+		t.Errorf("expected Hue Sync (DESKTOP) to own the area, got %q", m.takeover.Owner)
+	}
+	if view := m.View(); !strings.Contains(view, "r reclaim the area") {
+		t.Errorf("expected the reclaim hint in the view, got %q", view)
+	}
+
+	// r takes the area back and streaming resumes.
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = d.run(m, cmd, func(m model) bool { return m.takeover == nil })
+	_, before := bridge.Frames()
+	m = d.run(m, nil, func(m model) bool {
+		_, received := bridge.Frames()
+		return received > before && m.link.Held == nil
+	})
+	if got := streamer(); got != "user" {
+		t.Errorf("expected huesync to stream to the area again, got %q", got)
+	}
+
+	// After a second takeover, q leaves the area and its lights alone.
+	bridge.TakeOver("Hue#iPhone")
+	m = d.run(m, nil, func(m model) bool { return m.takeover != nil })
+	light := bridge.Lights()[0]
+	bridge.SetLightOn(light.ID, false)
+	m = d.run(m, nil, func(m model) bool { return strings.Contains(m.lightNote, light.Name) })
+	if view := m.View(); !strings.Contains(view, light.Name+" was switched off") {
+		t.Errorf("expected the light change in the view, got %q", view)
+	}
+
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateDone })
+	if m.err != nil {
+		t.Fatalf("unexpected error: %v", m.err)
+	}
+	if got := bridge.Area().Status; got != "active" || streamer() == "user" {
+		t.Errorf("expected the area to stay with the other app, got %q streamed by %q", got, streamer())
+	}
+	if bridge.Lights()[0].On {
+		t.Error("expected the lights not to be restored")
+	}
+	if view := m.View(); !strings.Contains(view, "Left the area and its lights to Hue (iPhone).") {
+		t.Errorf("expected the takeover in the done view, got %q", view)
+	}
+}
+
+func TestModel_Areas(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 2)
+	useCapturer(t, nil)
+	ip := net.IPv4(127, 0, 0, 1)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if err := DeleteArea(ip, "user", bridge.Area().ID); err != nil {
+		t.Fatalf("DeleteArea: %v", err)
+	}
+	// Without areas, huesync offers to create one.
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateAreaName })
+	if view := m.View(); !strings.Contains(view, "no entertainment areas yet") {
+		t.Errorf("expected the empty bridge to be explained, got %q", view)
+	}
+	// Names are limited to 32 characters, not bytes.
+	m = typeText(m, strings.Repeat("ü", maxAreaNameLength+1))
+	if n := utf8.RuneCountInString(m.draft.name); n != maxAreaNameLength {
+		t.Errorf("expected the name cut at %d characters, got %d", maxAreaNameLength, n)
+	}
+	for range maxAreaNameLength {
+		m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	// q is part of the name.
+	m = typeText(m, "Quiet den")
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.state != stateAreaType {
+		t.Fatalf("expected the type list, got state %d", m.state)
+	}
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	// All lights are chosen; leave out the second.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.state != stateAreaPlace || len(m.draft.placed()) != 1 {
+		t.Fatalf("expected to place 1 light, got state %d", m.state)
+	}
+	// Move the light from the center to the front left corner.
+	for _, key := range []tea.KeyType{tea.KeyLeft, tea.KeyLeft, tea.KeyUp, tea.KeyUp, tea.KeyUp} {
+		m, _ = pressKey(m, tea.KeyMsg{Type: key})
+	}
+	if view := m.View(); !strings.Contains(view, "Mock light 1 (-1.0, +1.0)") {
+		t.Errorf("expected the light's position in the view, got %q", view)
+	}
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateSelectingArea })
+	if m.areaErr != nil || len(m.areas) != 1 {
+		t.Fatalf("expected the new area in the list, got %v, %+v", m.areaErr, m.areas)
+	}
+	area := m.areas[0]
+	if area.Name != "Quiet den" || area.Type != "music" || len(area.Channels) != 1 || area.Channels[0].Position != (Position{X: -1, Y: 1}) {
+		t.Errorf("unexpected area %+v", area)
+	}
+
+	// Rename it.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	for range len("Quiet den") {
+		m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	m = typeText(m, "Den")
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateSelectingArea })
+	if got := m.areas[0]; got.Name != "Den" || len(got.Channels) != 1 {
+		t.Errorf("expected the area renamed to Den, got %+v", got)
+	}
+
+	// Edit it: the area's own light stays where it was, the added one is
+	// spread.
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateAreaType })
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if got := m.draft.chosen; len(got) != 2 || !got[0] || got[1] {
+		t.Fatalf("expected only the area's own light chosen, got %v", got)
+	}
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateSelectingArea })
+	if got := m.areas[0]; len(got.Channels) != 2 || got.Channels[0].Position != (Position{X: -1, Y: 1}) {
+		t.Errorf("expected 2 channels, the first unmoved, got %+v", got)
+	}
+
+	// Deleting the last area starts over with creating one; esc gives up.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	if view := m.View(); !strings.Contains(view, "Delete Den?") {
+		t.Errorf("expected a confirmation, got %q", view)
+	}
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateAreaName })
+	if areas, _ := FetchEntertainmentAreas(ip, "user"); len(areas) != 0 {
+		t.Errorf("expected the area to be deleted, got %+v", areas)
+	}
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEsc})
+	if m.state != stateDone || m.err == nil {
+		t.Errorf("expected to quit with an error, got state %d, %v", m.state, m.err)
+	}
+}
+
+func TestModel_CertificateChanged(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 1)
+	useCapturer(t, nil)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if err := TrustCertificate(bridge.ID, "00:11"); err != nil {
+		t.Fatalf("TrustCertificate: %v", err)
+	}
+	hueClient.CloseIdleConnections()
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateCertChanged })
+	view := m.View()
+	if !strings.Contains(view, "certificate of bridge Mock ("+bridge.ID+") has changed") || !strings.Contains(view, "Pinned:    00:11") {
+		t.Errorf("expected the warning in the view, got %q", view)
+	}
+
+	// t trusts the new certificate and carries on to the area.
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateSelectingSource })
+	if m.selectedArea == nil || m.selectedArea.ID != bridge.Area().ID {
+		t.Errorf("expected the area to be selected, got %+v", m.selectedArea)
+	}
+	path, _ := pinsPath()
+	if data, err := os.ReadFile(path); err != nil || strings.Contains(string(data), "00:11") {
+		t.Errorf("expected the new certificate to be pinned, got %q, %v", data, err)
+	}
+}
+
+func TestModel_BridgeEntry(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 1)
+	hideBridges(t)
+	useCapturer(t, nil)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	if err := SaveCredentials(bridge.ID, BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	typeText := func(m model, text string) model {
+		m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)})
+		return m
+	}
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateBridgeEntry })
+	if view := m.View(); !strings.Contains(view, "No Hue bridges found on the network.") {
+		t.Errorf("expected the reason in the view, got %q", view)
+	}
+
+	// q is part of an address; a wrong one is asked for again.
+	m = typeText(m, "q.invalid")
+	if m.state != stateBridgeEntry || m.bridgeInput != "q.invalid" {
+		t.Fatalf("expected q to be typed, got state %d and input %q", m.state, m.bridgeInput)
+	}
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateBridgeEntry })
+	if !strings.Contains(m.bridgeNote, "No Hue bridge at q.invalid") {
+		t.Errorf("expected the probe error, got %q", m.bridgeNote)
+	}
+
+	// Backspace removes whole characters, not bytes.
+	m = typeText(m, "ü")
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyBackspace})
+	if m.bridgeInput != "q.invalid" {
+		t.Errorf("expected the ü removed, got %q", m.bridgeInput)
+	}
+	for range len("q.invalid") {
+		m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	m = typeText(m, "127.0.0.1")
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateSelectingSource })
+	if m.selected.Name != "Mock Hue Bridge" || m.selectedArea == nil {
+		t.Errorf("expected the probed bridge's area, got %+v, %+v", m.selected, m.selectedArea)
+	}
+
+	// --bridge-ip skips discovery.
+	opts := defaultOptions()
+	opts.bridgeIP = "127.0.0.1"
+	m = newModel(opts, nil)
+	if m.state != stateProbing {
+		t.Fatalf("expected to probe at once, got state %d", m.state)
+	}
+	d.run(m, m.Init(), func(m model) bool { return m.state == stateSelectingSource })
+}
+
+func TestModel_KnownBridge(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 1)
+	hideBridges(t)
+	useCapturer(t, nil)
+	if err := RememberBridge(Bridge{ID: bridge.ID, IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatalf("RememberBridge: %v", err)
+	}
+
+	// The bridge is reached at its last address without discovery.
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == statePairing })
+	if m.selected.ID != bridge.ID {
+		t.Errorf("expected the known bridge, got %+v", m.selected)
+	}
+}
+
+func TestModel_BridgesListedAsFound(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	bridge := startMockBridge(t, 1)
+	found := make(chan Bridge)
+	discover := discoverBridges
+	t.Cleanup(func() { discoverBridges = discover })
+	discoverBridges = func(ctx context.Context) (<-chan Bridge, <-chan error) {
+		errs := make(chan error)
+		close(errs)
+		return found, errs
+	}
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.scan != nil })
+	found <- Bridge{ID: "001788fffe000002", Name: "Other", IP: net.IPv4(127, 0, 0, 2), Port: 443}
+	m = d.run(m, nil, func(m model) bool { return len(m.bridges) == 1 })
+	if view := m.View(); m.state != stateSelecting || !strings.Contains(view, "Other (001788fffe000002)") || !strings.Contains(view, "Searching for more bridges") {
+		t.Errorf("expected the first bridge listed while searching, got state %d and %q", m.state, view)
+	}
+
+	found <- Bridge{ID: bridge.ID, Name: "Mock", IP: net.IPv4(127, 0, 0, 1), Port: bridge.HTTPSPort()}
+	m = d.run(m, nil, func(m model) bool { return len(m.bridges) == 2 })
+	close(found)
+	m = d.run(m, nil, func(m model) bool { return m.scan == nil })
+	if m.state != stateSelecting {
+		t.Fatalf("expected the choice to stay with two bridges, got state %d", m.state)
+	}
+
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyDown})
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.state != stateLoadingCreds {
+		t.Errorf("expected the credentials loaded in the background, got state %d", m.state)
+	}
+	m = d.run(m, cmd, func(m model) bool { return m.state != stateLoadingCreds })
+	if m.state != statePairing || m.selected.ID != bridge.ID {
+		t.Errorf("expected to pair with the mock bridge, got state %d", m.state)
+	}
+	if known, _ := lastKnownBridge(""); known.ID != bridge.ID {
+		t.Errorf("expected the selected bridge to be remembered, got %+v", known)
+	}
+}
+
+func TestModel_Credentials(t *testing.T) {
+	setupCredentialsDir(t)
+	setupConfigDir(t, "")
+	fastBundles(t)
+	bridge := startMockBridge(t, 1)
+	ip := net.IPv4(127, 0, 0, 1)
+	bridge.AddUser("user", "0123456789abcdef0123456789abcdef")
+	creds := BridgeCredentials{Username: "user", Clientkey: "0123456789abcdef0123456789abcdef"}
+	if err := SaveCredentials(bridge.ID, creds); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if err := SaveCredentials("001788fffe000002", BridgeCredentials{Username: "gone", Clientkey: "key"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if _, err := CreateArea(ip, "user", AreaSpec{Name: "Desk", Type: "music", Lights: []AreaLight{{ServiceID: bridge.Area().Channels[0].ServiceIDs[0]}}}); err != nil {
+		t.Fatalf("CreateArea: %v", err)
+	}
+
+	d := newModelDriver(t)
+	m := newModel(defaultOptions(), nil)
+	m = d.run(m, m.Init(), func(m model) bool { return m.state == stateSelectingArea && m.credsWarning != nil })
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	if m.state != stateCredentials || len(m.paired) != 2 || m.paired[0].ID != bridge.ID {
+		t.Fatalf("expected the bridge used last first, got state %d, %+v", m.state, m.paired)
+	}
+	// Tests have no keyring, so the credentials are in the plaintext file.
+	if view := m.View(); !strings.Contains(view, "keyring is not available") {
+		t.Errorf("expected a warning about the plaintext file, got %q", view)
+	}
+
+	// Exporting takes a passphrase long enough, twice.
+	bundle := filepath.Join(t.TempDir(), "creds.huesync")
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	m = typeText(m, bundle)
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(m, "short")
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.state != stateCredsPassphrase || m.credsErr == nil {
+		t.Fatalf("expected a short passphrase to be refused, got state %d, %v", m.state, m.credsErr)
+	}
+	m = typeText(m, "quite long")
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if view := m.View(); !strings.Contains(view, "Repeat") || strings.Contains(view, "quite") {
+		t.Errorf("expected the passphrase asked for again, hidden, got %q", view)
+	}
+	m = typeText(m, "quite long")
+	m, cmd := pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	if m.credsErr != nil || !strings.Contains(m.credsNote, "Exported") || !fileExists(bundle) {
+		t.Fatalf("export: %v, %q", m.credsErr, m.credsNote)
+	}
+
+	// With the credentials unchanged, the areas are shown again as they were.
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEsc})
+	m = d.run(m, cmd, func(m model) bool { return m.state != stateLoadingCreds })
+	if m.state != stateSelectingArea {
+		t.Fatalf("expected the area list, got state %d", m.state)
+	}
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+
+	// Forgetting removes the key from the bridge too, if the bridge lets it.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	if !errors.Is(m.credsErr, ErrUnpairRefused) || len(m.paired) != 2 || !strings.Contains(m.View(), "account.meethue.com") {
+		t.Fatalf("expected the refusal shown, got %v, %+v", m.credsErr, m.paired)
+	}
+	bridge.AllowUnpair()
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	if m.credsErr != nil || len(m.paired) != 1 {
+		t.Fatalf("forget: %v, %+v", m.credsErr, m.paired)
+	}
+	if _, err := FetchApplicationID(ip, "user"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected the key to be removed from the bridge, got %v", err)
+	}
+
+	// The bundle brings the credentials back.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	m = typeText(m, bundle)
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(m, "wrong")
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	if !errors.Is(m.credsErr, errWrongPassphrase) {
+		t.Errorf("expected errWrongPassphrase, got %v", m.credsErr)
+	}
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	m = typeText(m, bundle)
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(m, "quite long")
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	if m.credsErr != nil || len(m.paired) != 2 {
+		t.Fatalf("import: %v, %+v", m.credsErr, m.paired)
+	}
+
+	// Forgotten here, the selected bridge has to be paired again.
+	m, _ = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+	m = d.run(m, cmd, func(m model) bool { return m.state == stateCredentials })
+	m, cmd = pressKey(m, tea.KeyMsg{Type: tea.KeyEsc})
+	m = d.run(m, cmd, func(m model) bool { return m.state != stateLoadingCreds })
+	if m.state != statePairing {
+		t.Errorf("expected to pair again, got state %d", m.state)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// readWAV decodes a RIFF WAVE file with 16-bit integer or 32-bit float PCM
+// samples. Channels are mixed down to mono; samples are in -1..1.
+func readWAV(r io.Reader) (samples []float32, rate int, err error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil || string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var format, channels, bits int
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return nil, 0, errors.New("WAV file has no data chunk")
+		}
+		id, size := string(head[0:4]), int64(binary.LittleEndian.Uint32(head[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, fmt.Errorf("WAV fmt chunk too short (%d bytes)", size)
+			}
+			// Chunks are padded to an even size.
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, 0, fmt.Errorf("reading WAV fmt chunk: %w", err)
+			}
+			format = int(binary.LittleEndian.Uint16(fmtChunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			rate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			bits = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE stores the actual format in the
+			// first two bytes of the sub-format GUID.
+			if format == 0xfffe && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(fmtChunk[24:26]))
+			}
+
+		case "data":
+			if channels == 0 {
+				return nil, 0, errors.New("WAV data chunk before fmt chunk")
+			}
+			var decode func([]byte) float32
+			switch {
+			case format == 1 && bits == 16:
+				decode = func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+			case format == 3 && bits == 32:
+				decode = func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+			default:
+				return nil, 0, fmt.Errorf("unsupported WAV format %d with %d bits (want 16-bit PCM or 32-bit float)", format, bits)
+			}
+			data, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, 0, fmt.Errorf("reading WAV data: %w", err)
+			}
+			return mixDown(data, channels, bits/8, decode), rate, nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, 0, errors.New("WAV file has no data chunk")
+			}
+		}
+	}
+}
+
+// mixDown decodes interleaved frames of the given channel count and averages
+// the channels. A trailing partial frame is dropped.
+func mixDown(data []byte, channels, width int, decode func([]byte) float32) []float32 {
+	frame := channels * width
+	out := make([]float32, len(data)/frame)
+	for i := range out {
+		var sum float32
+		for c := 0; c < channels; c++ {
+			off := i*frame + c*width
+			sum += decode(data[off : off+width])
+		}
+		out[i] = sum / float32(channels)
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadWAV_PCM16(t *testing.T) {
+	samples, rate := loadWAV(t, "kick-120bpm.wav")
+	if rate != 22050 || len(samples) != 3*22050 {
+		t.Fatalf("expected 3 s at 22050 Hz, got %d samples at %d Hz", len(samples), rate)
+	}
+	var peak float32
+	for _, s := range samples {
+		peak = max(peak, s, -s)
+	}
+	// The sine has decayed a little by its first crest.
+	if peak < 0.7 || peak > 0.8 {
+		t.Errorf("expected a peak below 0.8, got %g", peak)
+	}
+}
+
+func TestReadWAV_FloatStereo(t *testing.T) {
+	samples, rate := loadWAV(t, "treble-8khz.wav")
+	if rate != 22050 || len(samples) != 22050/2 {
+		t.Fatalf("expected 0.5 s at 22050 Hz, got %d samples at %d Hz", len(samples), rate)
+	}
+	var peak float32
+	for _, s := range samples {
+		peak = max(peak, s, -s)
+	}
+	if peak < 0.45 || peak > 0.5 {
+		t.Errorf("expected a peak just below 0.5, got %g", peak)
+	}
+}
+
+func TestReadWAV_OddChunks(t *testing.T) {
+	// A 17-byte fmt chunk and a 3-byte LIST chunk, each followed by a pad
+	// byte, before the data.
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	for _, v := range []any{uint32(17), uint16(1), uint16(1), uint32(8000), uint32(16000), uint16(2), uint16(16), uint8(0), uint8(0)} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("LIST\x03\x00\x00\x00abc\x00")
+	b.WriteString("data\x04\x00\x00\x00")
+	for _, v := range []int16{16384, -16384} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	samples, rate, err := readWAV(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if rate != 8000 || len(samples) != 2 || samples[0] != 0.5 || samples[1] != -0.5 {
+		t.Errorf("expected [0.5 -0.5] at 8000 Hz, got %v at %d Hz", samples, rate)
+	}
+}
+
+func TestReadWAV_Invalid(t *testing.T) {
+	header := func(format, bits uint16) []byte {
+		var b bytes.Buffer
+		b.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+		for _, v := range []any{uint32(16), format, uint16(1), uint32(8000), uint32(16000), uint16(2), bits} {
+			_ = binary.Write(&b, binary.LittleEndian, v)
+		}
+		return b.Bytes()
+	}
+	for name, data := range map[string][]byte{
+		"empty":      nil,
+		"not riff":   []byte("RIFX\x00\x00\x00\x00WAVE"),
+		"no data":    header(1, 16),
+		"8-bit":      append(header(1, 8), "data\x02\x00\x00\x00\x00\x00"...),
+		"data first": []byte("RIFF\x00\x00\x00\x00WAVEdata\x02\x00\x00\x00\x00\x00"),
+	} {
+		if _, _, err := readWAV(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
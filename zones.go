@@ -0,0 +1,49 @@
+package main
+
+import "image"
+
+// channelRegion maps a channel position onto the part of bounds that the
+// channel samples. The region spans a third of bounds in each direction and
+// is centred on the projected position, so lights on the left of the room
+// follow the left edge of the screen and lights up high follow the top.
+func channelRegion(p Position, bounds image.Rectangle) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	rw := max(w/3, 1)
+	rh := max(h/3, 1)
+
+	cx := int((clampUnit(p.X) + 1) / 2 * float64(w))
+	cy := int((1 - clampUnit(p.Z)) / 2 * float64(h))
+
+	x0 := bounds.Min.X + min(max(cx-rw/2, 0), w-rw)
+	y0 := bounds.Min.Y + min(max(cy-rh/2, 0), h-rh)
+	return image.Rect(x0, y0, x0+rw, y0+rh)
+}
+
+// sampleChannels returns one color per channel, each extracted from the
+// channel's region of the visible part of the frame.
+func sampleChannels(f Frame, visible image.Rectangle, channels []Channel, ex ColorExtractor) []RGB {
+	colors := make([]RGB, len(channels))
+	for i, ch := range channels {
+		colors[i] = ex.Extract(f, channelRegion(ch.Position, visible))
+	}
+	return colors
+}
+
+// averageRegion computes the mean color of the pixels of f inside r,
+// averaged in the given space.
+func averageRegion(f Frame, r image.Rectangle, space averagingSpace) RGB {
+	r = r.Intersect(image.Rect(0, 0, f.Width, f.Height))
+	acc := colorAccumulator{space: space}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := (y*f.Width + r.Min.X) * 3
+		for x := r.Min.X; x < r.Max.X; x++ {
+			acc.Add(f.Pix[off], f.Pix[off+1], f.Pix[off+2])
+			off += 3
+		}
+	}
+	return acc.Mean()
+}
+
+func clampUnit(v float64) float64 {
+	return min(max(v, -1), 1)
+}
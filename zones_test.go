@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestChannelRegion_Edges(t *testing.T) {
+	w, h := captureWidth, captureHeight
+	bounds := image.Rect(0, 0, w, h)
+
+	left := channelRegion(Position{X: -1, Z: 0}, bounds)
+	if left.Min.X != 0 {
+		t.Errorf("left channel: expected region at x=0, got %v", left)
+	}
+
+	right := channelRegion(Position{X: 1, Z: 0}, bounds)
+	if right.Max.X != w {
+		t.Errorf("right channel: expected region ending at x=%d, got %v", w, right)
+	}
+
+	top := channelRegion(Position{X: 0, Z: 1}, bounds)
+	if top.Min.Y != 0 {
+		t.Errorf("top channel: expected region at y=0, got %v", top)
+	}
+
+	bottom := channelRegion(Position{X: 0, Z: -1}, bounds)
+	if bottom.Max.Y != h {
+		t.Errorf("bottom channel: expected region ending at y=%d, got %v", h, bottom)
+	}
+}
+
+func TestChannelRegion_InsideFrame(t *testing.T) {
+	bounds := image.Rect(0, 0, captureWidth, captureHeight)
+	for _, p := range []Position{{X: -5, Z: 5}, {X: 5, Z: -5}, {X: 0.3, Z: -0.7}} {
+		r := channelRegion(p, bounds)
+		if !r.In(bounds) || r.Empty() {
+			t.Errorf("position %+v: region %v not inside %v", p, r, bounds)
+		}
+	}
+}
+
+func TestSampleChannels_LeftRight(t *testing.T) {
+	// Left half red, right half blue.
+	f := Frame{Pix: make([]byte, frameSize), Width: captureWidth, Height: captureHeight}
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			off := (y*f.Width + x) * 3
+			if x < f.Width/2 {
+				f.Pix[off] = 255
+			} else {
+				f.Pix[off+2] = 255
+			}
+		}
+	}
+
+	channels := []Channel{
+		{ID: 0, Position: Position{X: -1}},
+		{ID: 1, Position: Position{X: 1}},
+	}
+	got := sampleChannels(f, image.Rect(0, 0, f.Width, f.Height), channels, meanExtractor{space: spaceLinear})
+	if got[0] != (RGB{R: 255}) {
+		t.Errorf("left channel: expected RGB{255, 0, 0}, got %v", got[0])
+	}
+	if got[1] != (RGB{B: 255}) {
+		t.Errorf("right channel: expected RGB{0, 0, 255}, got %v", got[1])
+	}
+}
+
+func TestChannelRegion_WithinCrop(t *testing.T) {
+	crop := image.Rect(0, 5, captureWidth, captureHeight-5)
+	top := channelRegion(Position{Z: 1}, crop)
+	if top.Min.Y != 5 {
+		t.Errorf("top channel: expected region starting below the bar at y=5, got %v", top)
+	}
+	if !top.In(crop) {
+		t.Errorf("region %v not inside crop %v", top, crop)
+	}
+}
+
+func TestAverageRegion_Empty(t *testing.T) {
+	f := Frame{Pix: make([]byte, frameSize), Width: captureWidth, Height: captureHeight}
+	got := averageRegion(f, image.Rectangle{}, spaceLinear)
+	if got != (RGB{}) {
+		t.Errorf("expected RGB{0, 0, 0}, got %v", got)
+	}
+}